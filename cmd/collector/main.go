@@ -0,0 +1,21 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/vclip"
+	"github.com/bassosimone/vflag"
+)
+
+func main() {
+	disp := vclip.NewDispatcherCommand("collector", vflag.ExitOnError)
+	disp.Exit = failure.FlagExit
+
+	disp.AddCommand("serve", vclip.CommandFunc(serveMain), "Collect result documents.")
+
+	vclip.Main(context.Background(), disp, os.Args[1:])
+}
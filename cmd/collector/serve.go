@@ -0,0 +1,240 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/privacy"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/2026-02-provlima/internal/slogging"
+	"github.com/bassosimone/2026-02-provlima/internal/systemd"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+)
+
+const (
+	// maxSubmissionSize is the maximum size of a submission body.
+	maxSubmissionSize = 64 << 20
+
+	// defaultLimit is the default number of records a query returns.
+	defaultLimit = 100
+
+	// maxLimit is the maximum number of records a query returns.
+	maxLimit = 1000
+)
+
+func serveMain(ctx context.Context, args []string) error {
+	var (
+		addressFlag       = "127.0.0.1"
+		anonymizeIPsFlag  = "none"
+		certFlag          = "testdata/cert.pem"
+		configFlag        = ""
+		dataFlag          = "collector-data"
+		errorFormatFlag   = "text"
+		formatFlag        = "text"
+		keyFlag           = "testdata/key.pem"
+		omitHostnamesFlag = false
+		portFlag          = "4445"
+		printUnitFlag     = false
+	)
+
+	fset := vflag.NewFlagSet("collector serve", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&addressFlag, 'A', "address", "Use the given IP `ADDRESS`.")
+	fset.StringVar(&anonymizeIPsFlag, 0, "anonymize-ips", "Store the IP addresses in the results and logs using `MODE` (none, truncate to /24 or /48, or omit).")
+	fset.StringVar(&certFlag, 0, "cert", "Use `FILE` as the TLS certificate.")
+	fset.StringVar(&configFlag, 0, "config", "Load flag defaults from TOML `FILE`.")
+	fset.StringVar(&dataFlag, 'd', "data", "Store the result documents inside `DIR`.")
+	fset.StringVar(&errorFormatFlag, 0, "error-format", "Use `FORMAT` for the final error, if any (text or json).")
+	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&keyFlag, 0, "key", "Use `FILE` as the TLS private key.")
+	fset.BoolVar(&omitHostnamesFlag, 0, "omit-hostnames", "Omit the hostnames from the results and logs.")
+	fset.StringVar(&portFlag, 'p', "port", "Use the given TCP `PORT`.")
+	fset.BoolVar(&printUnitFlag, 0, "print-systemd-unit", "Print a systemd unit running the server with the current flags and exit.")
+	failure.OnError(failure.Usage, config.Setup(fset, "COLLECTOR", "serve", args))
+	runtimex.PanicOnError0(fset.Parse(args))
+	failure.Setup(errorFormatFlag)
+
+	anon, err := privacy.New(anonymizeIPsFlag, omitHostnamesFlag)
+	failure.OnError(failure.Usage, err)
+
+	dataDir := runtimex.LogFatalOnError1(filepath.Abs(dataFlag))
+	st, err := newStore(dataDir, anon)
+	failure.OnError(failure.Generic, err)
+
+	if printUnitFlag {
+		env := config.Environ(fset, "COLLECTOR", "config", "print-systemd-unit")
+		unit := runtimex.LogFatalOnError1(systemd.NewUnit("result collector", []string{"serve"}, env))
+		unit.ReadWritePaths = append(unit.ReadWritePaths, dataDir)
+		fmt.Print(unit.String())
+		return nil
+	}
+
+	slogging.Setup(formatFlag)
+	anon.Setup()
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /collector/v1/submit", http.HandlerFunc(st.handleSubmit))
+	mux.Handle("GET /collector/v1/results", http.HandlerFunc(st.handleList))
+	mux.Handle("GET /collector/v1/results/{id}", http.HandlerFunc(st.handleGet))
+
+	endpoint := net.JoinHostPort(addressFlag, portFlag)
+	srv := &http.Server{Addr: endpoint, Handler: mux}
+	go func() {
+		defer srv.Close()
+		<-ctx.Done()
+	}()
+
+	slog.Info("storing results inside", slog.String("dir", dataDir))
+	slog.Info("serving at", slog.String("addr", endpoint))
+	err = srv.ListenAndServeTLS(certFlag, keyFlag)
+	slog.Info("interrupted", slog.Any("err", err))
+
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	failure.OnError(failure.Generic, err)
+	return nil
+}
+
+// writeJSON writes value as the JSON response body using the given status.
+func writeJSON(rw http.ResponseWriter, status int, value any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(value)
+}
+
+// handleSubmit stores a JSON array of result documents, rejecting the
+// whole batch when any of them is invalid.
+func (st *store) handleSubmit(rw http.ResponseWriter, req *http.Request) {
+	dec := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxSubmissionSize))
+	dec.DisallowUnknownFields()
+	var docs []*results.Document
+	if err := dec.Decode(&docs); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(docs) <= 0 {
+		http.Error(rw, "empty submission", http.StatusBadRequest)
+		return
+	}
+	for idx, doc := range docs {
+		if doc == nil {
+			http.Error(rw, fmt.Sprintf("documents[%d]: null document", idx), http.StatusBadRequest)
+			return
+		}
+		// Note: clients predating schemaVersion submit version 1 documents
+		if err := doc.Migrate(); err != nil {
+			http.Error(rw, fmt.Sprintf("documents[%d]: %s", idx, err), http.StatusBadRequest)
+			return
+		}
+		if err := doc.Validate(); err != nil {
+			http.Error(rw, fmt.Sprintf("documents[%d]: %s", idx, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ids := []string{}
+	for _, doc := range docs {
+		rec, err := st.put(doc, req.RemoteAddr)
+		if err != nil {
+			slog.Warn("cannot store document", slog.Any("err", err))
+			http.Error(rw, "cannot store document", http.StatusInternalServerError)
+			return
+		}
+		ids = append(ids, rec.ID)
+	}
+	slog.Info("submission",
+		slog.String("remote", req.RemoteAddr),
+		slog.Any("ids", ids),
+	)
+	writeJSON(rw, http.StatusCreated, map[string]any{"ids": ids})
+}
+
+// entry is a record as returned by the list endpoint, which omits
+// the samples and probes to keep the response small.
+type entry struct {
+	ID         string           `json:"id"`
+	Received   time.Time        `json:"received"`
+	RemoteAddr string           `json:"remoteAddr"`
+	Protocol   string           `json:"protocol"`
+	Status     string           `json:"status"`
+	SessionID  string           `json:"sessionID,omitempty"`
+	Summary    *results.Summary `json:"summary,omitempty"`
+}
+
+// handleList returns the most recent records, optionally filtered using
+// the protocol, status, and since (an RFC 3339 time) query parameters.
+func (st *store) handleList(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	protocol, status := query.Get("protocol"), query.Get("status")
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxLimit {
+			http.Error(rw, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, err := st.list(limit, func(rec *record) bool {
+		return (protocol == "" || rec.Document.Protocol == protocol) &&
+			(status == "" || rec.Document.Status == status) &&
+			!rec.Received.Before(since)
+	})
+	if err != nil {
+		slog.Warn("cannot list records", slog.Any("err", err))
+		http.Error(rw, "cannot list records", http.StatusInternalServerError)
+		return
+	}
+	entries := []entry{}
+	for _, rec := range records {
+		entries = append(entries, entry{
+			ID:         rec.ID,
+			Received:   rec.Received,
+			RemoteAddr: rec.RemoteAddr,
+			Protocol:   rec.Document.Protocol,
+			Status:     rec.Document.Status,
+			SessionID:  rec.Document.SessionID,
+			Summary:    rec.Document.Summary,
+		})
+	}
+	writeJSON(rw, http.StatusOK, entries)
+}
+
+// handleGet returns a single record including the whole document.
+func (st *store) handleGet(rw http.ResponseWriter, req *http.Request) {
+	rec, err := st.get(req.PathValue("id"))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(rw, "no such record", http.StatusNotFound)
+	case err != nil:
+		slog.Warn("cannot read record", slog.Any("err", err))
+		http.Error(rw, "cannot read record", http.StatusInternalServerError)
+	default:
+		writeJSON(rw, http.StatusOK, rec)
+	}
+}
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/privacy"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/google/uuid"
+)
+
+// record is a stored result document along with submission metadata.
+type record struct {
+	// ID is the time-ordered record ID.
+	ID string `json:"id"`
+
+	// Received is the time when we received the document.
+	Received time.Time `json:"received"`
+
+	// RemoteAddr is the address of the submitting client.
+	RemoteAddr string `json:"remoteAddr"`
+
+	// Document is the submitted document.
+	Document *results.Document `json:"document"`
+}
+
+// store stores each record as a JSON file inside a directory.
+//
+// Construct using [newStore].
+type store struct {
+	anon *privacy.Policy
+	dir  string
+}
+
+// newStore constructs a new [*store] creating dir if needed, which
+// anonymizes the documents and the remote addresses using anon.
+func newStore(dir string, anon *privacy.Policy) (*store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &store{anon: anon, dir: dir}, nil
+}
+
+// put anonymizes and stores doc and returns the new record.
+func (st *store) put(doc *results.Document, remoteAddr string) (*record, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	rec := &record{
+		ID:         id.String(),
+		Received:   time.Now(),
+		RemoteAddr: st.anon.Addr(remoteAddr),
+		Document:   doc,
+	}
+	st.anon.Document(doc)
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write and rename, so readers never observe partial files.
+	tmp := filepath.Join(st.dir, rec.ID+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, filepath.Join(st.dir, rec.ID+".json")); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return rec, nil
+}
+
+// get returns the record with the given ID.
+func (st *store) get(id string) (*record, error) {
+	// Parsing the ID prevents path traversal.
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(filepath.Join(st.dir, parsed.String()+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	// Note: records stored by previous versions contain older documents
+	if rec.Document != nil {
+		if err := rec.Document.Migrate(); err != nil {
+			return nil, err
+		}
+	}
+	return &rec, nil
+}
+
+// list returns up to limit records, newest first, for which match
+// returns true. Since IDs are time-ordered, we can stop reading
+// files as soon as we have enough records.
+func (st *store) list(limit int, match func(*record) bool) ([]*record, error) {
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(entries) // ReadDir sorts by name
+	var out []*record
+	for _, entry := range entries {
+		if len(out) >= limit {
+			break
+		}
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		rec, err := st.get(id)
+		if err != nil {
+			return nil, err
+		}
+		if match(rec) {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bassosimone/runtimex"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// certMetadata is the JSON representation of a certificate.
+type certMetadata struct {
+	// Subject is the certificate subject.
+	Subject string `json:"subject"`
+
+	// Issuer is the certificate issuer.
+	Issuer string `json:"issuer"`
+
+	// SerialNumber is the serial number in decimal.
+	SerialNumber string `json:"serialNumber"`
+
+	// SHA256 is the hex-encoded SHA-256 fingerprint of the DER encoding.
+	SHA256 string `json:"sha256"`
+
+	// DNSNames contains the DNS SANs.
+	DNSNames []string `json:"dnsNames,omitempty"`
+
+	// IPAddresses contains the IP SANs.
+	IPAddresses []string `json:"ipAddresses,omitempty"`
+
+	// IsCA indicates whether this is a CA certificate.
+	IsCA bool `json:"isCA"`
+
+	// NotBefore is the start of the validity period.
+	NotBefore time.Time `json:"notBefore"`
+
+	// NotAfter is the end of the validity period.
+	NotAfter time.Time `json:"notAfter"`
+
+	// Expired indicates that NotAfter is in the past.
+	Expired bool `json:"expired"`
+}
+
+// newCertMetadata constructs a [*certMetadata] from cert.
+func newCertMetadata(cert *x509.Certificate) *certMetadata {
+	digest := sha256.Sum256(cert.Raw)
+	md := &certMetadata{
+		Subject:      cert.Subject.String(),
+		Issuer:       cert.Issuer.String(),
+		SerialNumber: cert.SerialNumber.String(),
+		SHA256:       hex.EncodeToString(digest[:]),
+		DNSNames:     cert.DNSNames,
+		IsCA:         cert.IsCA,
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		Expired:      time.Now().After(cert.NotAfter),
+	}
+	for _, ip := range cert.IPAddresses {
+		md.IPAddresses = append(md.IPAddresses, ip.String())
+	}
+	return md
+}
+
+// parseCerts parses all the certificates in data, which contains either
+// PEM encoded certificates or a single DER encoded certificate.
+func parseCerts(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) > 0 {
+		return certs, nil
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, errors.New("neither PEM certificates nor a DER certificate")
+	}
+	return []*x509.Certificate{cert}, nil
+}
+
+// parseKey parses the PEM encoded private key in data.
+func parseKey(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+}
+
+// writeExtraFormats reads cert.pem and key.pem from outputDir and writes
+// the optional formats selected by the corresponding command line flags.
+func writeExtraFormats(outputDir string, fullchain, pkcs12Output bool, p12Password string, jsonOutput bool) {
+	if !fullchain && !pkcs12Output && !jsonOutput {
+		return
+	}
+	certPEM := runtimex.LogFatalOnError1(os.ReadFile(filepath.Join(outputDir, "cert.pem")))
+	keyPEM := runtimex.LogFatalOnError1(os.ReadFile(filepath.Join(outputDir, "key.pem")))
+	certs := runtimex.LogFatalOnError1(parseCerts(certPEM))
+
+	// The certificate is self-signed, so the chain is the certificate itself
+	// and we append the key for servers expecting a single combined file.
+	if fullchain {
+		path := filepath.Join(outputDir, "fullchain.pem")
+		runtimex.LogFatalOnError0(os.WriteFile(path, append(certPEM, keyPEM...), 0600))
+		log.Printf("gencert: wrote %s", path)
+	}
+
+	if pkcs12Output {
+		key := runtimex.LogFatalOnError1(parseKey(keyPEM))
+		data := runtimex.LogFatalOnError1(pkcs12.Modern.Encode(key, certs[0], nil, p12Password))
+		path := filepath.Join(outputDir, "bundle.p12")
+		runtimex.LogFatalOnError0(os.WriteFile(path, data, 0600))
+		log.Printf("gencert: wrote %s", path)
+	}
+
+	if jsonOutput {
+		printMetadata(certs)
+	}
+}
+
+// printMetadata prints the metadata of certs as indented JSON.
+func printMetadata(certs []*x509.Certificate) {
+	var mds []*certMetadata
+	for _, cert := range certs {
+		mds = append(mds, newCertMetadata(cert))
+	}
+	data := runtimex.LogFatalOnError1(json.MarshalIndent(mds, "", "  "))
+	fmt.Printf("%s\n", data)
+}
@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+)
+
+// inspectMain is the main of the `gencert inspect` command.
+func inspectMain(ctx context.Context, args []string) error {
+	fset := vflag.NewFlagSet("gencert inspect", vflag.ExitOnError)
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.MinPositionalArgs = 1
+	fset.MaxPositionalArgs = 1
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "GENCERT"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	path := fset.Args()[0]
+
+	data := runtimex.LogFatalOnError1(os.ReadFile(path))
+	certs, err := parseCerts(data)
+	if err != nil {
+		log.Fatalf("gencert inspect: %s: %s", path, err)
+	}
+	printMetadata(certs)
+	return nil
+}
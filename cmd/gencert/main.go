@@ -10,28 +10,58 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
 	"github.com/bassosimone/pkitest"
 	"github.com/bassosimone/runtimex"
 	"github.com/bassosimone/vclip"
 	"github.com/bassosimone/vflag"
 )
 
+// subcommands contains the commands other than the default one.
+var subcommands = map[string]vclip.Command{
+	"inspect": vclip.CommandFunc(inspectMain),
+	"trust":   vclip.CommandFunc(trustMain),
+	"untrust": vclip.CommandFunc(untrustMain),
+}
+
 func main() {
+	// Keep `gencert [flags]` working by dispatching subcommands by hand.
+	if len(os.Args) > 1 {
+		if cmd, found := subcommands[os.Args[1]]; found {
+			vclip.Main(context.Background(), cmd, os.Args[2:])
+			return
+		}
+	}
 	vclip.Main(context.Background(), vclip.CommandFunc(run), os.Args[1:])
 }
 
 func run(ctx context.Context, args []string) error {
 	var (
-		outputDir = "./testdata"
-		ipAddr    = "127.0.0.1"
+		dnsName      = ""
+		fullchain    = false
+		jsonOutput   = false
+		outputDir    = "./testdata"
+		ipAddr       = "127.0.0.1"
+		pkcs12Output = false
+		p12Password  = ""
 	)
 
 	fset := vflag.NewFlagSet("gencert", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&dnsName, 0, "dns-name", "Also use `NAME` as a DNS SAN.")
+	fset.BoolVar(&fullchain, 0, "fullchain", "Also write fullchain.pem containing the certificate and the key.")
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
 	fset.StringVar(&ipAddr, 0, "ip-addr", "Use `ADDR` as an IP SAN.")
+	fset.BoolVar(&jsonOutput, 0, "json", "Print the certificate metadata as JSON to the stdout.")
 	fset.StringVar(&outputDir, 'o', "output-dir", "Write certificates to `DIR`.")
+	fset.BoolVar(&pkcs12Output, 0, "pkcs12", "Also write a bundle.p12 PKCS#12 bundle.")
+	fset.StringVar(&p12Password, 0, "p12-password", "Protect the PKCS#12 bundle using `PASSWORD`.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "GENCERT"))
 	runtimex.PanicOnError0(fset.Parse(args))
 
 	ip := net.ParseIP(ipAddr)
@@ -39,16 +69,21 @@ func run(ctx context.Context, args []string) error {
 		log.Fatalf("gencert: invalid IP address: %s", ipAddr)
 	}
 
-	// Check whether existing certificates are still valid for this IP.
+	// Check whether existing certificates are still valid for this IP and name.
 	certPath := filepath.Join(outputDir, "cert.pem")
-	if existingCertIsValid(certPath, ip) {
+	if existingCertIsValid(certPath, ip, dnsName) {
 		log.Printf("gencert: certificates are valid, nothing to do")
+		writeExtraFormats(outputDir, fullchain, pkcs12Output, p12Password, jsonOutput)
 		return nil
 	}
 
+	dnsNames := []string{ipAddr}
+	if dnsName != "" {
+		dnsNames = append(dnsNames, dnsName)
+	}
 	config := &pkitest.SelfSignedCertConfig{
 		CommonName:   ipAddr,
-		DNSNames:     []string{ipAddr},
+		DNSNames:     dnsNames,
 		IPAddrs:      []net.IP{ip},
 		Organization: []string{"ocho"},
 	}
@@ -58,12 +93,14 @@ func run(ctx context.Context, args []string) error {
 
 	log.Printf("gencert: wrote %s", filepath.Join(outputDir, "cert.pem"))
 	log.Printf("gencert: wrote %s", filepath.Join(outputDir, "key.pem"))
+	writeExtraFormats(outputDir, fullchain, pkcs12Output, p12Password, jsonOutput)
 	return nil
 }
 
 // existingCertIsValid returns true if the cert at certPath exists,
-// does not expire within 30 days, and contains the given IP SAN.
-func existingCertIsValid(certPath string, wantIP net.IP) bool {
+// does not expire within 30 days, and contains the given IP SAN as
+// well as the given DNS SAN, unless the latter is empty.
+func existingCertIsValid(certPath string, wantIP net.IP, wantName string) bool {
 	data, err := os.ReadFile(certPath)
 	if err != nil {
 		return false
@@ -79,6 +116,9 @@ func existingCertIsValid(certPath string, wantIP net.IP) bool {
 	if time.Until(cert.NotAfter) < 30*24*time.Hour {
 		return false
 	}
+	if wantName != "" && !slices.Contains(cert.DNSNames, wantName) {
+		return false
+	}
 	for _, ip := range cert.IPAddresses {
 		if ip.Equal(wantIP) {
 			return true
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+)
+
+// trustPrefix prefixes the names under which we install certificates, which
+// allows `gencert untrust` to only remove the certificates we installed.
+const trustPrefix = "provlima"
+
+// systemTrustDir is where Debian's update-ca-certificates looks for
+// locally installed certificates.
+const systemTrustDir = "/usr/local/share/ca-certificates"
+
+// nssDB returns the path of the user NSS database used by Chrome and
+// Chromium on Linux (Firefox uses per-profile databases instead).
+func nssDB() string {
+	home := runtimex.LogFatalOnError1(os.UserHomeDir())
+	return "sql:" + filepath.Join(home, ".pki", "nssdb")
+}
+
+// mustExec runs the given command, connecting its standard streams.
+func mustExec(argv ...string) {
+	log.Printf("gencert: + %s", strings.Join(argv, " "))
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	runtimex.LogFatalOnError0(cmd.Run())
+}
+
+// trustMain is the main of the `gencert trust` command.
+func trustMain(ctx context.Context, args []string) error {
+	var (
+		certPath = "./testdata/cert.pem"
+		nss      = false
+	)
+
+	fset := vflag.NewFlagSet("gencert trust", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&certPath, 0, "cert", "Install the certificate in `FILE`.")
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.BoolVar(&nss, 0, "nss", "Install into the user NSS database rather than into the system store.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "GENCERT"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	data := runtimex.LogFatalOnError1(os.ReadFile(certPath))
+	certs, err := parseCerts(data)
+	if err != nil {
+		log.Fatalf("gencert trust: %s: %s", certPath, err)
+	}
+	md := newCertMetadata(certs[0])
+
+	// Name the entry after the fingerprint, so we can trust several
+	// certificates at once (e.g., one per testbed) without clashes.
+	name := fmt.Sprintf("%s-%s", trustPrefix, md.SHA256[:16])
+
+	if nss {
+		mustExec("certutil", "-d", nssDB(), "-A", "-t", "P,,", "-n", name, "-i", certPath)
+		log.Printf("gencert: trusted %s in %s as %s", md.Subject, nssDB(), name)
+		return nil
+	}
+
+	if os.Geteuid() != 0 {
+		log.Fatal("gencert trust: modifying the system store requires root (try sudo)")
+	}
+	dest := filepath.Join(systemTrustDir, name+".crt")
+	runtimex.LogFatalOnError0(os.WriteFile(dest, data, 0644))
+	log.Printf("gencert: wrote %s", dest)
+	mustExec("update-ca-certificates")
+	return nil
+}
+
+// untrustMain is the main of the `gencert untrust` command.
+func untrustMain(ctx context.Context, args []string) error {
+	var (
+		nss = false
+	)
+
+	fset := vflag.NewFlagSet("gencert untrust", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.BoolVar(&nss, 0, "nss", "Remove from the user NSS database rather than from the system store.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "GENCERT"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	if nss {
+		out := runtimex.LogFatalOnError1(exec.Command("certutil", "-d", nssDB(), "-L").Output())
+		for line := range strings.Lines(string(out)) {
+			name, _, _ := strings.Cut(line, " ")
+			if strings.HasPrefix(name, trustPrefix+"-") {
+				mustExec("certutil", "-d", nssDB(), "-D", "-n", name)
+			}
+		}
+		return nil
+	}
+
+	if os.Geteuid() != 0 {
+		log.Fatal("gencert untrust: modifying the system store requires root (try sudo)")
+	}
+	paths := runtimex.LogFatalOnError1(filepath.Glob(filepath.Join(systemTrustDir, trustPrefix+"-*.crt")))
+	for _, path := range paths {
+		runtimex.LogFatalOnError0(os.Remove(path))
+		log.Printf("gencert: removed %s", path)
+	}
+	mustExec("update-ca-certificates", "--fresh")
+	return nil
+}
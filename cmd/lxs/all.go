@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+	"github.com/kballard/go-shellquote"
+)
+
+// verdictRow is a row of the table printed by `lxs measure all`.
+type verdictRow struct {
+	// protocol is the protocol (e.g., "ndt8/h2").
+	protocol string
+
+	// download is the download throughput in bit/s.
+	download float64
+
+	// upload is the upload throughput in bit/s.
+	upload float64
+}
+
+// labelArgv returns the client flags tagging the result document with the
+// given KEY=VALUE labels, exiting with a usage error when they are invalid.
+func labelArgv(labels []string) []string {
+	_, err := results.ParseLabels(labels)
+	failure.OnError(failure.Usage, err)
+	var argv []string
+	for _, label := range labels {
+		argv = append(argv, "--label", label)
+	}
+	return argv
+}
+
+// clientDocument runs the given client command inside the client container,
+// appending the given flags, writing the result document to file, and returns
+// the document, which records the policy applied using `lxs netem apply`, if any.
+func clientDocument(name, file, command string, argv []string) *results.Document {
+	argv = append(netemArgv(name), argv...)
+	mustRun("lxc exec %s-client -- %s -o %s %s", name, command, file, shellquote.Join(argv...))
+	data := runtimex.LogFatalOnError1(output("lxc exec %s-client -- cat /root/%s", name, file))
+	return runtimex.LogFatalOnError1(results.Decode(data))
+}
+
+// documentRow returns the [verdictRow] of the given protocol and document.
+func documentRow(protocol string, doc *results.Document) verdictRow {
+	row := verdictRow{protocol: protocol}
+	if doc.Summary != nil && doc.Summary.Download != nil {
+		row.download = doc.Summary.Download.Throughput
+	}
+	if doc.Summary != nil && doc.Summary.Upload != nil {
+		row.upload = doc.Summary.Upload.Throughput
+	}
+	return row
+}
+
+// divergence returns the relative difference between value and reference,
+// which is infinite when we could not measure the reference.
+func divergence(value, reference float64) float64 {
+	if reference <= 0 {
+		return math.Inf(1)
+	}
+	return math.Abs(value-reference) / reference
+}
+
+func measureAllMain(ctx context.Context, args []string) error {
+	var (
+		labelFlag     = []string{}
+		nameFlag      = "ocho"
+		toleranceFlag = 0.25
+	)
+
+	fset := vflag.NewFlagSet("lxs measure all", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringSliceVar(&labelFlag, 0, "label", "Tag the result documents with `KEY=VALUE` (repeatable).")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.Float64Var(&toleranceFlag, 0, "tolerance", "Flag protocols diverging from iperf3 by more than `FRACTION` (e.g., 0.25).")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+	if toleranceFlag < 0 {
+		failure.Exit(failure.Usage, errors.New("--tolerance must be non-negative"))
+	}
+	labels := labelArgv(labelFlag)
+	collectDiagnosticsOnFailure(nameFlag)
+
+	// Unlike `lxs calibrate`, we keep the current network emulation.
+	iperf := verdictRow{
+		protocol: "iperf3",
+		download: iperfThroughput(nameFlag, true),
+		upload:   iperfThroughput(nameFlag, false),
+	}
+
+	// Note: this requires `lxs serve ndt7 --detach` and `lxs serve ndt8 --detach`
+	mustRun("go build -v ./cmd/ndt7")
+	mustRun("go build -v ./cmd/ndt8")
+	mustRun("lxc file push testdata/cert.pem %s-client/root/", nameFlag)
+	mustRun("lxc file push ndt7 %s-client/root/", nameFlag)
+	mustRun("lxc file push ndt8 %s-client/root/", nameFlag)
+
+	rows := []verdictRow{
+		documentRow("ndt7", clientDocument(nameFlag, "ndt7.json",
+			fmt.Sprintf("/root/ndt7 measure -A %s", serverAddr), labels)),
+		documentRow("ndt8/h1", clientDocument(nameFlag, "ndt8-h1.json",
+			fmt.Sprintf("/root/ndt8 measure -A %s --cert cert.pem", serverAddr), labels)),
+		documentRow("ndt8/h2", clientDocument(nameFlag, "ndt8-h2.json",
+			fmt.Sprintf("/root/ndt8 measure -A %s --cert cert.pem -2", serverAddr), labels)),
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "\nPROTOCOL\tDOWNLOAD\tUPLOAD\tVERDICT\n")
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", iperf.protocol,
+		humanize.SI(iperf.download, "bit/s"), humanize.SI(iperf.upload, "bit/s"), "reference")
+	diverging := 0
+	for _, row := range rows {
+		verdict := "ok"
+		if divergence(row.download, iperf.download) > toleranceFlag ||
+			divergence(row.upload, iperf.upload) > toleranceFlag {
+			verdict = "diverges"
+			diverging++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", row.protocol,
+			humanize.SI(row.download, "bit/s"), humanize.SI(row.upload, "bit/s"), verdict)
+	}
+	runtimex.LogFatalOnError0(tw.Flush())
+
+	if diverging > 0 {
+		failure.Exit(failure.Threshold, fmt.Errorf("%d protocols diverge from iperf3 by more than %.0f%%",
+			diverging, toleranceFlag*100))
+	}
+	return nil
+}
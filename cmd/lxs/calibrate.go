@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+)
+
+// ceilingFraction is the fraction of the calibrated ceiling above which
+// we warn that a shaped rate approaches the host capability limits.
+const ceilingFraction = 0.8
+
+// calibration is the achievable ceiling of the unshaped veth path.
+type calibration struct {
+	// Time is when we measured the ceiling.
+	Time time.Time `json:"time"`
+
+	// IperfDownload is the iperf3 download throughput in bit/s.
+	IperfDownload float64 `json:"iperfDownload"`
+
+	// IperfUpload is the iperf3 upload throughput in bit/s.
+	IperfUpload float64 `json:"iperfUpload"`
+
+	// NDT8Download is the ndt8 download throughput in bit/s.
+	NDT8Download float64 `json:"ndt8Download"`
+
+	// NDT8Upload is the ndt8 upload throughput in bit/s.
+	NDT8Upload float64 `json:"ndt8Upload"`
+}
+
+// calibrationPath returns the path where we store the calibration of
+// the topology with the given name.
+func calibrationPath(name string) string {
+	return filepath.Join("testdata", fmt.Sprintf("calibration-%s.json", name))
+}
+
+// loadCalibration loads the calibration of the topology with the given
+// name, returning nil when we have not calibrated it yet.
+func loadCalibration(name string) *calibration {
+	data, err := os.ReadFile(calibrationPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	runtimex.LogFatalOnError0(err)
+	var cal calibration
+	runtimex.LogFatalOnError0(json.Unmarshal(data, &cal))
+	return &cal
+}
+
+// downloadCeiling returns the lowest download throughput we measured.
+func (cal *calibration) downloadCeiling() float64 {
+	return min(cal.IperfDownload, cal.NDT8Download)
+}
+
+// uploadCeiling returns the lowest upload throughput we measured.
+func (cal *calibration) uploadCeiling() float64 {
+	return min(cal.IperfUpload, cal.NDT8Upload)
+}
+
+// checkCeiling warns when rate approaches the ceiling for direction.
+func checkCeiling(direction, rate string, ceiling float64) {
+	if rate == "" || ceiling <= 0 {
+		return
+	}
+	bps := runtimex.LogFatalOnError1(rateToBPS(rate))
+	if float64(bps) < ceilingFraction*ceiling {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "warning: %s rate %s approaches the host ceiling (%s): "+
+		"results may reflect host limits rather than the emulated link\n",
+		direction, rate, humanize.SI(ceiling, "bit/s"))
+}
+
+// iperfThroughput runs iperf3 from the client in JSON mode and returns
+// the throughput measured by the receiver in bit/s.
+func iperfThroughput(name string, reverse bool) float64 {
+	cmdline := fmt.Sprintf("lxc exec %s-client -- iperf3 -J -c %s", name, serverAddr)
+	if reverse {
+		cmdline += " -R"
+	}
+	data := runtimex.LogFatalOnError1(output("%s", cmdline))
+	var report struct {
+		End struct {
+			SumReceived struct {
+				BitsPerSecond float64 `json:"bits_per_second"`
+			} `json:"sum_received"`
+		} `json:"end"`
+	}
+	runtimex.LogFatalOnError0(json.Unmarshal(data, &report))
+	return report.End.SumReceived.BitsPerSecond
+}
+
+func calibrateMain(ctx context.Context, args []string) error {
+	var (
+		nameFlag = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs calibrate", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+	collectDiagnosticsOnFailure(nameFlag)
+
+	// Measure the bare veth path, without any shaping.
+	clearNetem(nameFlag)
+
+	cal := &calibration{Time: time.Now()}
+	cal.IperfDownload = iperfThroughput(nameFlag, true)
+	cal.IperfUpload = iperfThroughput(nameFlag, false)
+
+	// Note: this requires `lxs serve ndt8 --detach` to be running
+	mustRun("go build -v ./cmd/ndt8")
+	mustRun("lxc file push testdata/cert.pem %s-client/root/", nameFlag)
+	mustRun("lxc file push ndt8 %s-client/root/", nameFlag)
+	mustRun("lxc exec %s-client -- /root/ndt8 measure -A %s --cert cert.pem -o calibration.json",
+		nameFlag, serverAddr)
+	data := runtimex.LogFatalOnError1(output("lxc exec %s-client -- cat /root/calibration.json", nameFlag))
+	doc := runtimex.LogFatalOnError1(results.Decode(data))
+	if doc.Summary != nil && doc.Summary.Download != nil {
+		cal.NDT8Download = doc.Summary.Download.Throughput
+	}
+	if doc.Summary != nil && doc.Summary.Upload != nil {
+		cal.NDT8Upload = doc.Summary.Upload.Throughput
+	}
+
+	fmt.Fprintf(os.Stderr, "\niperf3: download %s, upload %s\n",
+		humanize.SI(cal.IperfDownload, "bit/s"), humanize.SI(cal.IperfUpload, "bit/s"))
+	fmt.Fprintf(os.Stderr, "ndt8: download %s, upload %s\n",
+		humanize.SI(cal.NDT8Download, "bit/s"), humanize.SI(cal.NDT8Upload, "bit/s"))
+
+	data = runtimex.LogFatalOnError1(json.MarshalIndent(cal, "", "  "))
+	runtimex.LogFatalOnError0(os.MkdirAll("testdata", 0700))
+	runtimex.LogFatalOnError0(os.WriteFile(calibrationPath(nameFlag), append(data, '\n'), 0600))
+	fmt.Fprintf(os.Stderr, "calibration written to %s\n", calibrationPath(nameFlag))
+	return nil
+}
@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+)
+
+// cpeChain is the iptables nat chain masquerading the client network.
+const cpeChain = "lxs-cpe"
+
+// defaultTxqueuelen is the txqueuelen the router interfaces start with.
+const defaultTxqueuelen = 1000
+
+// cpeQueueParent returns the parent to which we attach the CPE queue given
+// the qdiscs of a router interface as `tc qdisc show` prints them. We attach
+// it below the qdiscs installed by [applyNetem], if any, so that it becomes
+// the queue packets wait in.
+func cpeQueueParent(qdiscs string) string {
+	switch {
+	case strings.Contains(qdiscs, "qdisc tbf 10:"):
+		return "parent 10:1"
+	case strings.Contains(qdiscs, "qdisc netem 1:"):
+		return "parent 1:1"
+	default:
+		return "root"
+	}
+}
+
+// cpeQueueLocation returns where the CPE queue is attached given the qdiscs
+// of a router interface, or an empty string if it is not attached.
+func cpeQueueLocation(qdiscs string) string {
+	for line := range strings.Lines(qdiscs) {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "qdisc" || fields[2] != "100:" {
+			continue
+		}
+		if fields[3] == "root" {
+			return "root"
+		}
+		if fields[3] == "parent" && len(fields) >= 5 {
+			return "parent " + fields[4]
+		}
+	}
+	return ""
+}
+
+// applyCPE makes the router behave like a consumer home router (CPE): it
+// masquerades the client network behind its server-side address, shortens
+// the interface transmit queues, and queues packets in a small pfifo, or
+// in fq_codel when emulating smart queue management (SQM).
+//
+// The queue replaces the one of the TBF shaper installed by [applyNetem],
+// including the flow queue selected by --queue, if any, so that the queue
+// limit, rather than the TBF latency, bounds the queueing delay. Since `lxs netem apply` replaces all the qdiscs, apply
+// the network profile first.
+func applyCPE(name string, limit, txqueuelen int, sqm bool) {
+	clearCPE(name)
+
+	mustRun("lxc exec %s-router -- apt update", name)
+	mustRun("lxc exec %s-router --env DEBIAN_FRONTEND=noninteractive -- apt install -y iptables", name)
+
+	fmt.Fprintf(os.Stderr, "router: masquerading 192.168.0.0/24 behind eth2\n")
+	mustRun("lxc exec %s-router -- iptables -t nat -N %s", name, cpeChain)
+	mustRun("lxc exec %s-router -- iptables -t nat -A POSTROUTING -j %s", name, cpeChain)
+	mustRun("lxc exec %s-router -- iptables -t nat -A %s -s 192.168.0.0/24 -o eth2 -j MASQUERADE", name, cpeChain)
+
+	queue := fmt.Sprintf("pfifo limit %d", limit)
+	if sqm {
+		queue = fmt.Sprintf("fq_codel limit %d", limit)
+	}
+	for _, dev := range []string{"eth1", "eth2"} {
+		fmt.Fprintf(os.Stderr, "router %s: txqueuelen %d, %s\n", dev, txqueuelen, queue)
+		mustRun("lxc exec %s-router -- ip link set dev %s txqueuelen %d", name, dev, txqueuelen)
+		qdiscs := runtimex.LogFatalOnError1(output("lxc exec %s-router -- tc qdisc show dev %s", name, dev))
+		mustRun("lxc exec %s-router -- tc qdisc replace dev %s %s handle 100: %s",
+			name, dev, cpeQueueParent(string(qdiscs)), queue)
+	}
+}
+
+// clearCPE undoes [applyCPE], ignoring errors.
+func clearCPE(name string) {
+	fmt.Fprintf(os.Stderr, "clearing: %s-router CPE emulation\n", name)
+	// Note: commands may fail if the router never emulated a CPE
+	run("lxc exec %s-router -- iptables -t nat -D POSTROUTING -j %s", name, cpeChain)
+	run("lxc exec %s-router -- iptables -t nat -F %s", name, cpeChain)
+	run("lxc exec %s-router -- iptables -t nat -X %s", name, cpeChain)
+	for _, dev := range []string{"eth1", "eth2"} {
+		run("lxc exec %s-router -- ip link set dev %s txqueuelen %d", name, dev, defaultTxqueuelen)
+		qdiscs, err := output("lxc exec %s-router -- tc qdisc show dev %s", name, dev)
+		if location := cpeQueueLocation(string(qdiscs)); err == nil && location != "" {
+			run("lxc exec %s-router -- tc qdisc del dev %s %s", name, dev, location)
+		}
+	}
+}
+
+// netemCPEMain is the main of the `lxs netem cpe` command.
+func netemCPEMain(ctx context.Context, args []string) error {
+	var (
+		limitFlag      = 64
+		nameFlag       = "ocho"
+		sqmFlag        = false
+		txqueuelenFlag = 100
+	)
+
+	fset := vflag.NewFlagSet("lxs netem cpe", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.IntVar(&limitFlag, 0, "limit", "Queue at most `PACKETS` on each router interface.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.BoolVar(&sqmFlag, 0, "sqm", "Emulate smart queue management using fq_codel rather than pfifo.")
+	fset.IntVar(&txqueuelenFlag, 0, "txqueuelen", "Set the router interfaces txqueuelen to `PACKETS`.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	if limitFlag <= 0 || txqueuelenFlag <= 0 {
+		failure.Exit(failure.Usage, errors.New("--limit and --txqueuelen must be positive"))
+	}
+
+	applyCPE(nameFlag, limitFlag, txqueuelenFlag, sqmFlag)
+	return nil
+}
+
+// netemUncpeMain is the main of the `lxs netem uncpe` command.
+func netemUncpeMain(ctx context.Context, args []string) error {
+	var (
+		nameFlag = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs netem uncpe", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	clearCPE(nameFlag)
+	return nil
+}
@@ -4,9 +4,14 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
 	"github.com/bassosimone/runtimex"
 	"github.com/bassosimone/vflag"
+	"github.com/kballard/go-shellquote"
 )
 
 const (
@@ -14,15 +19,78 @@ const (
 	serverAddr = "192.168.1.2"
 )
 
+// These are the IPv6 addresses of the endpoints when we create the
+// topology using --dual-stack, taken from the fd00::/8 ULA range.
+const (
+	clientAddr6 = "fd00::2"
+	serverAddr6 = "fd00:1::2"
+)
+
+// serverHostname returns the hostname resolving to [serverAddr] inside the
+// topology with the given name, served by dnsmasq running on the router.
+func serverHostname(name string) string {
+	return fmt.Sprintf("server.%s.test", name)
+}
+
+// limitContainer sets the CPU and memory limits of the container with the
+// given role, if any, to emulate endpoints with constrained resources.
+//
+// The cpus value is either a number of CPUs (e.g., 1) or a CPU list (e.g.,
+// 2-3), and memory is a size (e.g., 256MiB) or a percentage of the host
+// memory (e.g., 10%), as lxc expects for limits.cpu and limits.memory.
+func limitContainer(name, role, cpus, memory string) {
+	if cpus != "" {
+		mustRun("lxc config set %s-%s limits.cpu %s", name, role, cpus)
+	}
+	if memory != "" {
+		mustRun("lxc config set %s-%s limits.memory %s", name, role, memory)
+	}
+}
+
+// createDualStack adds IPv6 addresses and routes to the topology with
+// the given name. We disable duplicate address detection (DAD), so the
+// addresses are usable as soon as we add them.
+func createDualStack(name string) {
+	mustRun("lxc exec %s-client -- ip -6 addr add %s/64 dev eth1 nodad", name, clientAddr6)
+	mustRun("lxc exec %s-client -- ip -6 route add fd00:1::/64 via fd00::1", name)
+
+	mustRun("lxc exec %s-router -- ip -6 addr add fd00::1/64 dev eth1 nodad", name)
+	mustRun("lxc exec %s-router -- ip -6 addr add fd00:1::1/64 dev eth2 nodad", name)
+	mustRun("lxc exec %s-router -- sysctl net.ipv6.conf.all.forwarding=1", name)
+
+	mustRun("lxc exec %s-server -- ip -6 addr add %s/64 dev eth1 nodad", name, serverAddr6)
+	mustRun("lxc exec %s-server -- ip -6 route add fd00::/64 via fd00:1::1", name)
+}
+
 func createMain(ctx context.Context, args []string) error {
 	var (
-		nameFlag = "ocho"
+		clientCPUsFlag   = ""
+		clientMemoryFlag = ""
+		dualStackFlag    = false
+		nameFlag         = "ocho"
+		routerCPUsFlag   = ""
+		routerMemoryFlag = ""
+		serverCPUsFlag   = ""
+		serverMemoryFlag = ""
+		withProxyFlag    = false
 	)
 
 	fset := vflag.NewFlagSet("lxs create", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&clientCPUsFlag, 0, "client-cpus", "Limit the client container to `CPUS` (e.g., 1 or 2-3).")
+	fset.StringVar(&clientMemoryFlag, 0, "client-memory", "Limit the client container memory to `SIZE` (e.g., 256MiB).")
+	fset.BoolVar(&dualStackFlag, 0, "dual-stack", "Also give IPv6 addresses to the containers and route IPv6 through the router.")
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
 	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.StringVar(&routerCPUsFlag, 0, "router-cpus", "Limit the router container to `CPUS` (e.g., 1 or 2-3).")
+	fset.StringVar(&routerMemoryFlag, 0, "router-memory", "Limit the router container memory to `SIZE` (e.g., 256MiB).")
+	fset.StringVar(&serverCPUsFlag, 0, "server-cpus", "Limit the server container to `CPUS` (e.g., 1 or 2-3).")
+	fset.StringVar(&serverMemoryFlag, 0, "server-memory", "Limit the server container memory to `SIZE` (e.g., 256MiB).")
+	fset.BoolVar(&withProxyFlag, 0, "with-proxy", "Also create a container running an nginx reverse proxy in front of the server.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
 	runtimex.PanicOnError0(fset.Parse(args))
+	collectDiagnosticsOnFailure(nameFlag)
 
 	mustRun("lxc network create %s-left ipv4.address=none ipv6.address=none", nameFlag)
 	mustRun("lxc network create %s-right ipv4.address=none ipv6.address=none", nameFlag)
@@ -31,6 +99,10 @@ func createMain(ctx context.Context, args []string) error {
 	mustRun("lxc launch images:debian/bookworm %s-router", nameFlag)
 	mustRun("lxc launch images:debian/bookworm %s-server", nameFlag)
 
+	limitContainer(nameFlag, "client", clientCPUsFlag, clientMemoryFlag)
+	limitContainer(nameFlag, "router", routerCPUsFlag, routerMemoryFlag)
+	limitContainer(nameFlag, "server", serverCPUsFlag, serverMemoryFlag)
+
 	mustRun("lxc network attach %s-left %s-client eth1", nameFlag, nameFlag)
 	mustRun("lxc network attach %s-left %s-router eth1", nameFlag, nameFlag)
 	mustRun("lxc network attach %s-right %s-router eth2", nameFlag, nameFlag)
@@ -50,6 +122,30 @@ func createMain(ctx context.Context, args []string) error {
 	mustRun("lxc exec %s-server -- ip link set eth1 up", nameFlag)
 	mustRun("lxc exec %s-server -- ip route add 192.168.0.0/24 via 192.168.1.1", nameFlag)
 
+	if dualStackFlag {
+		createDualStack(nameFlag)
+	}
+
+	mustRun("lxc exec %s-router -- apt update", nameFlag)
+	mustRun("lxc exec %s-router --env DEBIAN_FRONTEND=noninteractive -- apt install -y dnsmasq", nameFlag)
+	dnsmasqLines := []string{
+		"bind-interfaces",
+		"listen-address=192.168.0.1",
+		"listen-address=192.168.1.1",
+		fmt.Sprintf("address=/%s/%s", serverHostname(nameFlag), serverAddr),
+		fmt.Sprintf("address=/client.%s.test/%s", nameFlag, clientAddr),
+	}
+	if withProxyFlag {
+		dnsmasqLines = append(dnsmasqLines, fmt.Sprintf("address=/%s/%s", proxyHostname(nameFlag), proxyAddr))
+	}
+	dnsmasqConf := strings.Join(dnsmasqLines, "\n")
+	mustRun("%s", shellquote.Join("lxc", "exec", nameFlag+"-router", "--", "sh", "-c",
+		fmt.Sprintf("printf '%s\\n' > /etc/dnsmasq.d/%s.conf", dnsmasqConf, nameFlag)))
+	mustRun("lxc exec %s-router -- systemctl restart dnsmasq", nameFlag)
+
+	mustRun("%s", shellquote.Join("lxc", "exec", nameFlag+"-client", "--", "sh", "-c",
+		"rm -f /etc/resolv.conf && echo 'nameserver 192.168.0.1' > /etc/resolv.conf"))
+
 	mustRun("lxc exec %s-client -- apt update", nameFlag)
 	mustRun("lxc exec %s-client --env DEBIAN_FRONTEND=noninteractive -- apt install -y iperf3", nameFlag)
 
@@ -58,5 +154,8 @@ func createMain(ctx context.Context, args []string) error {
 	mustRun("lxc exec %s-server -- systemctl enable iperf3", nameFlag)
 	mustRun("lxc exec %s-server -- service iperf3 start", nameFlag)
 
+	if withProxyFlag {
+		createProxy(nameFlag)
+	}
 	return nil
 }
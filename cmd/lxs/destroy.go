@@ -5,6 +5,8 @@ package main
 import (
 	"context"
 
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
 	"github.com/bassosimone/runtimex"
 	"github.com/bassosimone/vflag"
 )
@@ -15,8 +17,11 @@ func destroyMain(ctx context.Context, args []string) error {
 	)
 
 	fset := vflag.NewFlagSet("lxs destroy", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
 	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
 	runtimex.PanicOnError0(fset.Parse(args))
 
 	run("lxc stop %s-client", nameFlag)
@@ -26,6 +31,10 @@ func destroyMain(ctx context.Context, args []string) error {
 	run("lxc stop %s-server", nameFlag)
 	run("lxc delete %s-server", nameFlag)
 
+	// The proxy container is optional, so these may fail.
+	run("lxc stop %s-proxy", nameFlag)
+	run("lxc delete %s-proxy", nameFlag)
+
 	run("lxc network delete %s-left", nameFlag)
 	run("lxc network delete %s-right", nameFlag)
 
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// diagnosticsTopology is the name of the topology for which [mustRun]
+// collects a diagnostics bundle on failure, or empty to disable it.
+//
+// Set using [collectDiagnosticsOnFailure].
+var diagnosticsTopology string
+
+// collectDiagnosticsOnFailure arranges for [mustRun] to collect a diagnostics
+// bundle from the containers of the topology with the given name on failure.
+func collectDiagnosticsOnFailure(name string) {
+	diagnosticsTopology = name
+}
+
+// diagnosticsServices are the systemd services whose logs we collect from
+// the server, since detached servers do not log to our stderr.
+var diagnosticsServices = []string{"iperf3", "ndt7", "ndt8"}
+
+// maxStderrTail is the amount of stderr of the failed command we keep.
+const maxStderrTail = 1 << 20
+
+// stderrTail is an [io.Writer] retaining the last [maxStderrTail] bytes
+// written to it, which are the most useful ones when a command fails.
+type stderrTail struct {
+	data []byte
+}
+
+// Write implements [io.Writer].
+func (st *stderrTail) Write(data []byte) (int, error) {
+	st.data = append(st.data, data...)
+	if excess := len(st.data) - maxStderrTail; excess > 0 {
+		st.data = st.data[excess:]
+	}
+	return len(data), nil
+}
+
+// collectDiagnostics writes a diagnostics bundle describing the failure of
+// cmdline with the given error and stderr, along with the recent system and
+// service logs of the containers, when enabled (see [diagnosticsTopology]).
+//
+// We are already failing, so we report problems collecting the bundle
+// and collect as much as we can rather than exiting.
+func collectDiagnostics(cmdline string, err error, stderr []byte) {
+	name := diagnosticsTopology
+	if name == "" {
+		return
+	}
+	diagnosticsTopology = "" // avoid collecting twice
+
+	dir := filepath.Join("diagnostics", fmt.Sprintf("%s-%s", name, time.Now().UTC().Format("20060102T150405Z")))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot create diagnostics bundle: %s\n", err)
+		return
+	}
+
+	failed := fmt.Sprintf("command: %s\nerror: %s\n\n%s", cmdline, err, stderr)
+	writeDiagnostic(dir, "failure.txt", []byte(failed), nil)
+
+	for _, role := range []string{"client", "router", "server"} {
+		container := fmt.Sprintf("%s-%s", name, role)
+		data, err := output("lxc exec %s -- journalctl --no-pager -n 1000", container)
+		if err != nil {
+			// Fallback for containers without a systemd journal.
+			data, err = output("lxc exec %s -- tail -n 1000 /var/log/syslog", container)
+		}
+		writeDiagnostic(dir, role+"-journal.txt", data, err)
+	}
+
+	for _, service := range diagnosticsServices {
+		data, err := output("lxc exec %s-server -- journalctl --no-pager -n 5000 -u %s", name, service)
+		writeDiagnostic(dir, "server-"+service+".txt", data, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "diagnostics written to %s\n", dir)
+}
+
+// writeDiagnostic writes data to the given file of the bundle in dir,
+// followed by err, if any, so that the bundle records missing logs.
+func writeDiagnostic(dir, file string, data []byte, err error) {
+	if err != nil {
+		data = fmt.Appendf(data, "\ncannot collect: %s\n", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, file), data, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot write diagnostics: %s\n", err)
+	}
+}
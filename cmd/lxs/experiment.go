@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+)
+
+// resultFiles returns the JSON files below path, or path itself when it
+// is a file, along with the name identifying each run in the export. We
+// skip the manifests, which describe the runs (see [manifest]), and the
+// checkpoints of the experiments (see [checkpoint]).
+func resultFiles(path string) ([][2]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return [][2]string{{path, path}}, nil
+	}
+	var files [][2]string
+	err = filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(file, ".json") || entry.Name() == manifestFile || entry.Name() == checkpointFile {
+			return err
+		}
+		run := runtimex.PanicOnError1(filepath.Rel(path, file))
+		files = append(files, [2]string{file, filepath.ToSlash(run)})
+		return nil
+	})
+	return files, err
+}
+
+// experimentExportMain is the main of the `lxs experiment export` command.
+func experimentExportMain(ctx context.Context, args []string) error {
+	var (
+		formatFlag = "csv"
+		outputFlag = ""
+	)
+
+	fset := vflag.NewFlagSet("lxs experiment export", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&formatFlag, 'f', "format", "Export using the given `FORMAT` (only csv).")
+	fset.StringVar(&outputFlag, 'o', "output", "Write to `FILE` rather than to the stdout.")
+	fset.MinPositionalArgs = 1
+	fset.MaxPositionalArgs = math.MaxInt
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	if formatFlag != "csv" {
+		failure.OnError(failure.Usage, fmt.Errorf("unsupported export format: %q", formatFlag))
+	}
+
+	var w io.Writer = os.Stdout
+	if outputFlag != "" {
+		filep := runtimex.LogFatalOnError1(os.Create(outputFlag))
+		defer filep.Close()
+		w = filep
+	}
+
+	tw := results.NewTidyWriter(w)
+	for _, path := range fset.Args() {
+		files := runtimex.LogFatalOnError1(resultFiles(path))
+		for _, file := range files {
+			doc, err := results.ReadFile(file[0])
+			if err != nil {
+				// Note: directories may contain other JSON files (e.g., calibrations)
+				fmt.Fprintf(os.Stderr, "skipping %s: %s\n", file[0], err.Error())
+				continue
+			}
+			runtimex.LogFatalOnError0(tw.Write(file[1], doc))
+		}
+	}
+	runtimex.LogFatalOnError0(tw.Flush())
+	return nil
+}
@@ -4,30 +4,144 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
 	"github.com/bassosimone/runtimex"
 	"github.com/bassosimone/vflag"
 	"github.com/kballard/go-shellquote"
 )
 
+// sweepPoint is the result of a UDP test at an offered load.
+type sweepPoint struct {
+	offered  float64 // offered load in bit/s
+	received float64 // received throughput in bit/s
+	loss     float64 // lost datagrams in percent
+	jitter   float64 // jitter in milliseconds
+}
+
+// parseSweepRate parses a rate using the tc units (e.g., "10mbit") or the
+// iperf3 units (e.g., "10M") and returns it in bit/s.
+func parseSweepRate(value string) (float64, error) {
+	if rate, err := humanize.ParseRate(value); err == nil {
+		return rate, nil
+	}
+	multiplier := 1.0
+	number := strings.TrimSpace(value)
+	switch {
+	case strings.HasSuffix(strings.ToLower(number), "k"):
+		multiplier = 1e3
+	case strings.HasSuffix(strings.ToLower(number), "m"):
+		multiplier = 1e6
+	case strings.HasSuffix(strings.ToLower(number), "g"):
+		multiplier = 1e9
+	}
+	if multiplier != 1 {
+		number = number[:len(number)-1]
+	}
+	rate, err := strconv.ParseFloat(number, 64)
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	return rate * multiplier, nil
+}
+
+// iperfUDP runs a UDP iperf3 test in JSON mode offering the given load and
+// returns the loss and jitter the receiver measured.
+func iperfUDP(iperfArgv []string, offered float64) sweepPoint {
+	argv := append(slices.Clone(iperfArgv), "-J", "-b", strconv.FormatFloat(offered, 'f', 0, 64))
+	data := runtimex.LogFatalOnError1(output("%s", shellquote.Join(argv...)))
+	var report struct {
+		End struct {
+			Sum struct {
+				BitsPerSecond float64 `json:"bits_per_second"`
+				JitterMs      float64 `json:"jitter_ms"`
+				LostPercent   float64 `json:"lost_percent"`
+			} `json:"sum"`
+		} `json:"end"`
+	}
+	runtimex.LogFatalOnError0(json.Unmarshal(data, &report))
+	sum := report.End.Sum
+	return sweepPoint{
+		offered:  offered,
+		received: sum.BitsPerSecond * (1 - sum.LostPercent/100),
+		loss:     sum.LostPercent,
+		jitter:   sum.JitterMs,
+	}
+}
+
+// printSweep prints the sweep points and the knee, which is where the loss
+// first exceeds maxLoss percent, along with the largest throughput the
+// receiver measured, which estimates the capacity of the path regardless
+// of the TCP dynamics.
+func printSweep(points []sweepPoint, maxLoss float64) {
+	fmt.Printf("\n%-14s %-14s %8s %10s\n", "offered", "received", "loss", "jitter")
+	var capacity float64
+	for _, p := range points {
+		fmt.Printf("%-14s %-14s %7.2f%% %7.3f ms\n",
+			humanize.SI(p.offered, "bit/s"), humanize.SI(p.received, "bit/s"), p.loss, p.jitter)
+		capacity = max(capacity, p.received)
+	}
+	fmt.Println()
+	knee := slices.IndexFunc(points, func(p sweepPoint) bool { return p.loss > maxLoss })
+	switch {
+	case knee < 0:
+		fmt.Printf("loss stayed below %g%%: offer more load to find the knee\n", maxLoss)
+	case knee == 0:
+		fmt.Printf("loss exceeded %g%% already at %s: offer less load to find the knee\n",
+			maxLoss, humanize.SI(points[0].offered, "bit/s"))
+	default:
+		fmt.Printf("loss exceeds %g%% between %s and %s offered\n", maxLoss,
+			humanize.SI(points[knee-1].offered, "bit/s"), humanize.SI(points[knee].offered, "bit/s"))
+	}
+	fmt.Printf("estimated capacity: %s\n", humanize.SI(capacity, "bit/s"))
+}
+
 func iperfMain(ctx context.Context, args []string) error {
 	var (
 		congestionFlag = ""
+		maxLossFlag    = 1.0
 		nameFlag       = "ocho"
 		reverseFlag    = false
+		sweepFlag      = ""
 		udpFlag        = false
 	)
 
 	fset := vflag.NewFlagSet("lxs iperf", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
 	fset.StringVar(&congestionFlag, 'C', "congestion", "Set congestion control algorithm.")
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.Float64Var(&maxLossFlag, 0, "max-loss", "Place the --sweep knee where the loss exceeds `PERCENT`.")
 	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
 	fset.BoolVar(&reverseFlag, 'R', "reverse", "Run an upload test.")
+	fset.StringVar(&sweepFlag, 0, "sweep", "Run a UDP test for each of the comma-separated `RATES` (e.g., 1M,5M,10M) and find where the loss starts.")
 	fset.BoolVar(&udpFlag, 'u', "udp", "Use UDP instead of TCP.")
 	fset.DisablePermute = true
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
 	runtimex.PanicOnError0(fset.Parse(args))
 
+	var rates []float64
+	if sweepFlag != "" {
+		if !udpFlag {
+			failure.Exit(failure.Usage, errors.New("--sweep requires --udp"))
+		}
+		for value := range strings.SplitSeq(sweepFlag, ",") {
+			rate, err := parseSweepRate(value)
+			failure.OnError(failure.Usage, err)
+			rates = append(rates, rate)
+		}
+		slices.Sort(rates)
+	}
+	collectDiagnosticsOnFailure(nameFlag)
+
 	iperfArgv := []string{"lxc", "exec", fmt.Sprintf("%s-client", nameFlag), "--", "iperf3", "-c", serverAddr}
 	if congestionFlag != "" {
 		iperfArgv = append(iperfArgv, "-C", congestionFlag)
@@ -39,6 +153,15 @@ func iperfMain(ctx context.Context, args []string) error {
 		iperfArgv = append(iperfArgv, "-u")
 	}
 
+	if len(rates) > 0 {
+		var points []sweepPoint
+		for _, rate := range rates {
+			points = append(points, iperfUDP(iperfArgv, rate))
+		}
+		printSweep(points, maxLossFlag)
+		return nil
+	}
+
 	mustRun("%s", shellquote.Join(iperfArgv...))
 	return nil
 }
@@ -0,0 +1,333 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+	"github.com/kballard/go-shellquote"
+)
+
+const (
+	// kindCertDir is the directory containing the kind server certificate.
+	kindCertDir = "testdata/kind"
+
+	// kindServerHostname is the DNS name of the server service.
+	kindServerHostname = "server.default.svc.cluster.local"
+)
+
+// kindProtocols contains the protocols `lxs kind serve` and `lxs kind measure` support.
+var kindProtocols = []string{"ndt7", "ndt8"}
+
+// kindManifest contains the client and server pods and the server service.
+//
+// Pods share a flat network, so there is no router to shape traffic on.
+// Instead, each pod has a privileged shaper sidecar, which shares the pod
+// network namespace and shapes the pod egress: the server egress is the
+// download and the client egress is the upload. The main containers run
+// the tools without any privilege.
+const kindManifest = `apiVersion: v1
+kind: Pod
+metadata:
+  name: client
+  labels: {app: client}
+spec:
+  containers:
+  - {name: main, image: "debian:bookworm", command: [sleep, infinity]}
+  - name: shaper
+    image: "debian:bookworm"
+    command: [sleep, infinity]
+    securityContext: {capabilities: {add: [NET_ADMIN]}}
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: server
+  labels: {app: server}
+spec:
+  containers:
+  - {name: main, image: "debian:bookworm", command: [sleep, infinity]}
+  - name: shaper
+    image: "debian:bookworm"
+    command: [sleep, infinity]
+    securityContext: {capabilities: {add: [NET_ADMIN]}}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: server
+spec:
+  selector: {app: server}
+  ports:
+  - {name: ndt8, port: 4443}
+  - {name: ndt7, port: 4567}
+  - {name: iperf3, port: 5201}
+`
+
+// kindCluster returns the name of the kind cluster for the given name.
+func kindCluster(name string) string {
+	return "lxs-" + name
+}
+
+// kubectl returns the kubectl command line prefix for the given name.
+func kubectl(name string) string {
+	return "kubectl --context kind-" + kindCluster(name)
+}
+
+// kindProtocol returns the protocol in the positional arguments.
+func kindProtocol(fset *vflag.FlagSet) string {
+	proto := fset.Args()[0]
+	if !slices.Contains(kindProtocols, proto) {
+		failure.Exit(failure.Usage, fmt.Errorf("unsupported protocol: %q", proto))
+	}
+	return proto
+}
+
+// kindCreateMain is the main of the `lxs kind create` command.
+func kindCreateMain(ctx context.Context, args []string) error {
+	var (
+		nameFlag = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs kind create", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name the kind cluster.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	mustRun("kind create cluster --name %s", kindCluster(nameFlag))
+
+	runtimex.LogFatalOnError0(os.MkdirAll(kindCertDir, 0700))
+	manifestPath := filepath.Join(kindCertDir, "manifest.yaml")
+	runtimex.LogFatalOnError0(os.WriteFile(manifestPath, []byte(kindManifest), 0600))
+	mustRun("%s apply -f %s", kubectl(nameFlag), manifestPath)
+	mustRun("%s wait --for=condition=Ready --timeout=300s pod/client pod/server", kubectl(nameFlag))
+
+	for _, pod := range []string{"client", "server"} {
+		mustRun("%s exec %s -c shaper -- apt update", kubectl(nameFlag), pod)
+		mustRun("%s exec %s -c shaper -- env DEBIAN_FRONTEND=noninteractive apt install -y iproute2", kubectl(nameFlag), pod)
+		mustRun("%s exec %s -c main -- apt update", kubectl(nameFlag), pod)
+		mustRun("%s exec %s -c main -- env DEBIAN_FRONTEND=noninteractive apt install -y iperf3", kubectl(nameFlag), pod)
+	}
+	return nil
+}
+
+// kindDestroyMain is the main of the `lxs kind destroy` command.
+func kindDestroyMain(ctx context.Context, args []string) error {
+	var (
+		nameFlag = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs kind destroy", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name the kind cluster.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	run("kind delete cluster --name %s", kindCluster(nameFlag))
+	return nil
+}
+
+// applyKindNetem is like [applyNetem] but shapes the egress of the pods,
+// which is equivalent since each direction crosses exactly one shaper.
+func applyKindNetem(name string, p policy) {
+	clearKindNetem(name)
+
+	for _, entry := range []struct {
+		pod       string
+		direction string
+		rate      string
+	}{
+		{"server", "download", p.download},
+		{"client", "upload", p.upload},
+	} {
+		shaper := fmt.Sprintf("%s exec %s -c shaper --", kubectl(name), entry.pod)
+		if p.download != "" && p.upload != "" {
+			burst := computeBurst(entry.rate)
+			fmt.Fprintf(os.Stderr, "%s eth0 (%s): %s delay, %s rate, %dB burst, %s tbf-latency\n",
+				entry.pod, entry.direction, p.delay, entry.rate, burst, p.tbfLatency)
+			mustRun("%s tc qdisc add dev eth0 root handle 1: netem %s", shaper, p.netemArgs())
+			mustRun("%s tc qdisc add dev eth0 parent 1:1 handle 10: tbf rate %s burst %d latency %s",
+				shaper, entry.rate, burst, p.tbfLatency)
+			if p.queue != "fifo" {
+				mustRun("%s tc qdisc add dev eth0 parent 10:1 handle 20: %s", shaper, p.queueArgs(entry.rate))
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "%s eth0 (%s): %s delay, no rate shaping\n", entry.pod, entry.direction, p.delay)
+			mustRun("%s tc qdisc add dev eth0 root handle 1: netem %s", shaper, p.netemArgs())
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "\neffective RTT: 2 x %s\n", p.delay)
+}
+
+// clearKindNetem removes the tc qdisc rules from the pods, ignoring errors.
+func clearKindNetem(name string) {
+	fmt.Fprintf(os.Stderr, "clearing: client and server eth0\n")
+	// Note: commands may fail if no previous policy had been set
+	run("%s exec client -c shaper -- tc qdisc del dev eth0 root", kubectl(name))
+	run("%s exec server -c shaper -- tc qdisc del dev eth0 root", kubectl(name))
+}
+
+// kindNetemApplyMain is the main of the `lxs kind netem apply` command.
+func kindNetemApplyMain(ctx context.Context, args []string) error {
+	var (
+		nameFlag = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs kind netem apply", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name the kind cluster.")
+	pf := newPolicyFlags(fset)
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	applyKindNetem(nameFlag, pf.policy())
+	return nil
+}
+
+// kindNetemClearMain is the main of the `lxs kind netem clear` command.
+func kindNetemClearMain(ctx context.Context, args []string) error {
+	var (
+		nameFlag = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs kind netem clear", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name the kind cluster.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	clearKindNetem(nameFlag)
+	return nil
+}
+
+// kindServeMain is the main of the `lxs kind serve` command.
+func kindServeMain(ctx context.Context, args []string) error {
+	var (
+		formatFlag = "text"
+		nameFlag   = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs kind serve", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name the kind cluster.")
+	fset.MinPositionalArgs = 1
+	fset.MaxPositionalArgs = 1
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+	proto := kindProtocol(fset)
+
+	mustRun("go build -v ./cmd/gencert")
+	mustRun("go build -v ./cmd/%s", proto)
+
+	mustRun("./gencert -o %s --dns-name %s", kindCertDir, kindServerHostname)
+
+	mustRun("%s cp %s/cert.pem server:/root/cert.pem -c main", kubectl(nameFlag), kindCertDir)
+	mustRun("%s cp %s/key.pem server:/root/key.pem -c main", kubectl(nameFlag), kindCertDir)
+	mustRun("%s cp %s server:/root/%s -c main", kubectl(nameFlag), proto, proto)
+
+	// Pods get their address when they start, so we listen on all of them.
+	cmdArgv := []string{
+		"kubectl",
+		"--context",
+		"kind-" + kindCluster(nameFlag),
+		"exec",
+		"server",
+		"-c",
+		"main",
+		"--",
+		"/root/" + proto,
+		"serve",
+		"-A",
+		"0.0.0.0",
+		"--cert",
+		"/root/cert.pem",
+		"--key",
+		"/root/key.pem",
+		"--format",
+		formatFlag,
+	}
+	if proto == "ndt8" {
+		cmdArgv = append(cmdArgv, "-s", "")
+	}
+	mustRun("%s", shellquote.Join(cmdArgv...))
+
+	return nil
+}
+
+// kindMeasureMain is the main of the `lxs kind measure` command.
+func kindMeasureMain(ctx context.Context, args []string) error {
+	var (
+		errorFormatFlag = "text"
+		formatFlag      = "text"
+		nameFlag        = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs kind measure", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&errorFormatFlag, 0, "error-format", "Use `FORMAT` for the final error, if any (text or json).")
+	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name the kind cluster.")
+	fset.MinPositionalArgs = 1
+	fset.MaxPositionalArgs = 1
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+	failure.Setup(errorFormatFlag)
+	proto := kindProtocol(fset)
+
+	mustRun("go build -v ./cmd/%s", proto)
+
+	if _, err := os.Stat(filepath.Join(kindCertDir, "cert.pem")); errors.Is(err, os.ErrNotExist) {
+		failure.Exit(failure.Usage, errors.New("run `lxs kind serve` first to generate the certificate"))
+	}
+	mustRun("%s cp %s/cert.pem client:/root/cert.pem -c main", kubectl(nameFlag), kindCertDir)
+	mustRun("%s cp %s client:/root/%s -c main", kubectl(nameFlag), proto, proto)
+
+	cmdArgv := []string{
+		"kubectl",
+		"--context",
+		"kind-" + kindCluster(nameFlag),
+		"exec",
+		"client",
+		"-c",
+		"main",
+		"--",
+		"/root/" + proto,
+		"measure",
+		"-A",
+		kindServerHostname,
+		"--format",
+		formatFlag,
+		"--error-format",
+		errorFormatFlag,
+	}
+	if proto == "ndt8" {
+		cmdArgv = append(cmdArgv, "--cert", "/root/cert.pem")
+	}
+	mustRun("%s", shellquote.Join(cmdArgv...))
+
+	return nil
+}
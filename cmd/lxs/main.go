@@ -6,31 +6,76 @@ import (
 	"context"
 	"os"
 
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
 	"github.com/bassosimone/vclip"
 	"github.com/bassosimone/vflag"
 )
 
 func main() {
 	serveDisp := vclip.NewDispatcherCommand("lxs serve", vflag.ExitOnError)
+	serveDisp.Exit = failure.FlagExit
 	serveDisp.AddCommand("ndt7", vclip.CommandFunc(serveNDT7Main), "Run ndt7 service")
 	serveDisp.AddCommand("ndt8", vclip.CommandFunc(serveNDT8Main), "Run ndt8 service")
 
 	measureDisp := vclip.NewDispatcherCommand("lxs measure", vflag.ExitOnError)
+
+	measureDisp.Exit = failure.FlagExit
+	measureDisp.AddCommand("all", vclip.CommandFunc(measureAllMain), "Measure with all protocols and compare with iperf3")
+	measureDisp.AddCommand("matrix", vclip.CommandFunc(measureMatrixMain), "Measure across every network emulation profile")
 	measureDisp.AddCommand("ndt7", vclip.CommandFunc(measureNDT7Main), "Measure with ndt7")
 	measureDisp.AddCommand("ndt8", vclip.CommandFunc(measureNDT8Main), "Measure with ndt8")
+	measureDisp.AddCommand("reference", vclip.CommandFunc(measureReferenceMain), "Compare with an official reference client")
 
 	netemDisp := vclip.NewDispatcherCommand("lxs netem", vflag.ExitOnError)
+
+	netemDisp.Exit = failure.FlagExit
 	netemDisp.AddCommand("apply", vclip.CommandFunc(netemApplyMain), "Apply network emulation.")
 	netemDisp.AddCommand("clear", vclip.CommandFunc(netemClearMain), "Clear network emulation.")
+	netemDisp.AddCommand("cpe", vclip.CommandFunc(netemCPEMain), "Make the router behave like a home router.")
+	netemDisp.AddCommand("outage", vclip.CommandFunc(netemOutageMain), "Periodically blackhole the traffic.")
+	netemDisp.AddCommand("pin", vclip.CommandFunc(netemPinMain), "Pin containers and IRQs to CPUs.")
+	netemDisp.AddCommand("split", vclip.CommandFunc(netemSplitMain), "Split TCP connections on the router.")
+	netemDisp.AddCommand("uncpe", vclip.CommandFunc(netemUncpeMain), "Stop behaving like a home router.")
+	netemDisp.AddCommand("unpin", vclip.CommandFunc(netemUnpinMain), "Undo CPU and IRQ pinning.")
+	netemDisp.AddCommand("unsplit", vclip.CommandFunc(netemUnsplitMain), "Stop splitting TCP connections.")
+
+	experimentDisp := vclip.NewDispatcherCommand("lxs experiment", vflag.ExitOnError)
+
+	experimentDisp.Exit = failure.FlagExit
+	experimentDisp.AddCommand("export", vclip.CommandFunc(experimentExportMain), "Export result documents.")
+	experimentDisp.AddCommand("manifest", vclip.CommandFunc(experimentManifestMain), "Describe the configuration of the results.")
+	experimentDisp.AddCommand("resume", vclip.CommandFunc(experimentResumeMain), "Resume an interrupted experiment.")
+	experimentDisp.AddCommand("run", vclip.CommandFunc(experimentRunMain), "Sweep profiles, protocols, and repetitions.")
+
+	kindNetemDisp := vclip.NewDispatcherCommand("lxs kind netem", vflag.ExitOnError)
+
+	kindNetemDisp.Exit = failure.FlagExit
+	kindNetemDisp.AddCommand("apply", vclip.CommandFunc(kindNetemApplyMain), "Apply network emulation.")
+	kindNetemDisp.AddCommand("clear", vclip.CommandFunc(kindNetemClearMain), "Clear network emulation.")
+
+	kindDisp := vclip.NewDispatcherCommand("lxs kind", vflag.ExitOnError)
+
+	kindDisp.Exit = failure.FlagExit
+	kindDisp.AddCommand("create", vclip.CommandFunc(kindCreateMain), "Create the kind cluster and pods.")
+	kindDisp.AddCommand("destroy", vclip.CommandFunc(kindDestroyMain), "Destroy the kind cluster.")
+	kindDisp.AddCommand("measure", vclip.CommandFunc(kindMeasureMain), "Measure with ndt7 or ndt8.")
+	kindDisp.AddCommand("netem", kindNetemDisp, "Manage network emulation.")
+	kindDisp.AddCommand("serve", vclip.CommandFunc(kindServeMain), "Run the ndt7 or ndt8 service.")
 
 	disp := vclip.NewDispatcherCommand("lxs", vflag.ExitOnError)
 
+	disp.Exit = failure.FlagExit
+
+	disp.AddCommand("calibrate", vclip.CommandFunc(calibrateMain), "Measure the unshaped path ceiling.")
 	disp.AddCommand("create", vclip.CommandFunc(createMain), "Create containers.")
 	disp.AddCommand("destroy", vclip.CommandFunc(destroyMain), "Destroy containers.")
+	disp.AddCommand("experiment", experimentDisp, "Run experiments and manage their results.")
 	disp.AddCommand("iperf", vclip.CommandFunc(iperfMain), "Run iperf3.")
+	disp.AddCommand("kind", kindDisp, "Run the topology in a kind cluster.")
 	disp.AddCommand("measure", measureDisp, "Run measurements.")
 	disp.AddCommand("netem", netemDisp, "Manage network emulation.")
 	disp.AddCommand("serve", serveDisp, "Run servers.")
+	disp.AddCommand("soak", vclip.CommandFunc(soakMain), "Measure continuously to detect server leaks.")
 
 	vclip.Main(context.Background(), disp, os.Args[1:])
 }
@@ -0,0 +1,276 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+)
+
+// manifestFile is the name of the manifest within the results directory.
+const manifestFile = "manifest.json"
+
+// manifest describes the configuration an experiment ran with, so that
+// the plots made from its results point back to a configuration that
+// can be reproduced.
+type manifest struct {
+	// Time is when we wrote the manifest.
+	Time time.Time `json:"time"`
+
+	// Name is the name of the LXC resources.
+	Name string `json:"name"`
+
+	// Labels contains the KEY=VALUE labels tagging the whole experiment, if
+	// any, while each run records its own labels (see [runInfo]).
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Git is the commit of the working tree we built from.
+	Git gitInfo `json:"git"`
+
+	// Binaries contains the binaries we built and pushed.
+	Binaries []binaryInfo `json:"binaries"`
+
+	// Kernel is the kernel release, which the containers share.
+	Kernel string `json:"kernel"`
+
+	// Containers contains the containers of the topology.
+	Containers []containerInfo `json:"containers"`
+
+	// Qdiscs maps the router interfaces to their qdiscs as tc shows them,
+	// which is the network emulation profile actually applied, including
+	// the netem seed, if any.
+	Qdiscs map[string]string `json:"qdiscs"`
+
+	// Calibration is the calibration of the topology, if any.
+	Calibration *calibration `json:"calibration,omitempty"`
+
+	// Runs contains the runs in the results directory.
+	Runs []runInfo `json:"runs"`
+}
+
+// gitInfo describes the git working tree.
+type gitInfo struct {
+	// Commit is the commit hash.
+	Commit string `json:"commit"`
+
+	// Dirty indicates uncommitted changes, with which the commit does
+	// not tell the exact source code.
+	Dirty bool `json:"dirty"`
+}
+
+// binaryInfo describes a binary.
+type binaryInfo struct {
+	// Path is the path of the binary.
+	Path string `json:"path"`
+
+	// SHA256 is the hash of the binary, which tells whether the containers
+	// run the same binary (see [containerInfo]).
+	SHA256 string `json:"sha256"`
+
+	// GoVersion is the Go version that built the binary.
+	GoVersion string `json:"goVersion,omitempty"`
+
+	// Revision is the commit the binary was built from, if known.
+	Revision string `json:"revision,omitempty"`
+
+	// Modified indicates that the working tree had uncommitted changes.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// containerInfo describes a container.
+type containerInfo struct {
+	// Name is the container name.
+	Name string `json:"name"`
+
+	// Image is the description of the image the container started from.
+	Image string `json:"image"`
+
+	// ImageFingerprint is the fingerprint of the image.
+	ImageFingerprint string `json:"imageFingerprint"`
+
+	// LimitsCPU is the CPU limit of the container, if any.
+	LimitsCPU string `json:"limitsCPU,omitempty"`
+
+	// LimitsMemory is the memory limit of the container, if any.
+	LimitsMemory string `json:"limitsMemory,omitempty"`
+
+	// Binaries maps the binaries in /root to their SHA256 hash.
+	Binaries map[string]string `json:"binaries,omitempty"`
+}
+
+// runInfo describes a run in the results directory.
+type runInfo struct {
+	// Run is the path of the result document within the directory.
+	Run string `json:"run"`
+
+	// Protocol is the measurement protocol.
+	Protocol string `json:"protocol"`
+
+	// Pattern is the ndt8 pattern, if any.
+	Pattern string `json:"pattern,omitempty"`
+
+	// Seed is the seed of the ndt8 client, if any.
+	Seed int64 `json:"seed,omitempty"`
+
+	// Labels contains the labels of the run, if any.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// describeBinary returns the [binaryInfo] of the binary at path.
+func describeBinary(path string) (binaryInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return binaryInfo{}, err
+	}
+	sum := sha256.Sum256(data)
+	bi := binaryInfo{Path: path, SHA256: hex.EncodeToString(sum[:])}
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return bi, nil
+	}
+	bi.GoVersion = info.GoVersion
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			bi.Revision = setting.Value
+		case "vcs.modified":
+			bi.Modified = setting.Value == "true"
+		}
+	}
+	return bi, nil
+}
+
+// describeContainer returns the [containerInfo] of the given container,
+// including the hashes of the given binaries in /root, when present.
+func describeContainer(container string, binaries []string) containerInfo {
+	ci := containerInfo{Name: container, Binaries: map[string]string{}}
+	ci.Image = lxcConfig(container, "image.description")
+	ci.ImageFingerprint = lxcConfig(container, "volatile.base_image")
+	ci.LimitsCPU = lxcConfig(container, "limits.cpu")
+	ci.LimitsMemory = lxcConfig(container, "limits.memory")
+	for _, binary := range binaries {
+		// Note: this fails when we did not push the binary to the container
+		data, err := output("lxc exec %s -- sha256sum /root/%s", container, binary)
+		if err != nil {
+			continue
+		}
+		if sum, _, found := strings.Cut(string(data), " "); found {
+			ci.Binaries[binary] = sum
+		}
+	}
+	return ci
+}
+
+// lxcConfig returns the value of the given container configuration key.
+func lxcConfig(container, key string) string {
+	data := runtimex.LogFatalOnError1(output("lxc config get %s %s", container, key))
+	return strings.TrimSpace(string(data))
+}
+
+// describeRuns returns the [runInfo] of the result documents in dir.
+func describeRuns(dir string) []runInfo {
+	runs := []runInfo{}
+	for _, file := range runtimex.LogFatalOnError1(resultFiles(dir)) {
+		doc, err := results.ReadFile(file[0])
+		if err != nil {
+			// Note: directories may contain other JSON files (e.g., calibrations)
+			continue
+		}
+		runs = append(runs, runInfo{
+			Run:      file[1],
+			Protocol: doc.Protocol,
+			Pattern:  doc.Pattern,
+			Seed:     doc.Seed,
+			Labels:   doc.Labels,
+		})
+	}
+	return runs
+}
+
+// experimentManifestMain is the main of the `lxs experiment manifest` command.
+func experimentManifestMain(ctx context.Context, args []string) error {
+	var (
+		labelFlag = []string{}
+		nameFlag  = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs experiment manifest", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringSliceVar(&labelFlag, 0, "label", "Tag the experiment with `KEY=VALUE` (repeatable).")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.MinPositionalArgs = 1
+	fset.MaxPositionalArgs = 1
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+	dir := fset.Args()[0]
+	labels, err := results.ParseLabels(labelFlag)
+	failure.OnError(failure.Usage, err)
+
+	m := &manifest{
+		Time:        time.Now(),
+		Name:        nameFlag,
+		Labels:      labels,
+		Qdiscs:      map[string]string{},
+		Calibration: loadCalibration(nameFlag),
+		Runs:        describeRuns(dir),
+	}
+
+	commit := runtimex.LogFatalOnError1(output("git rev-parse HEAD"))
+	m.Git.Commit = strings.TrimSpace(string(commit))
+	status := runtimex.LogFatalOnError1(output("git status --porcelain"))
+	m.Git.Dirty = len(status) > 0
+
+	// We describe ourselves and the binaries we push, which we build in
+	// the current directory, if we built them.
+	binaries := []string{"ndt7", "ndt8"}
+	paths := binaries
+	if exe, err := os.Executable(); err == nil {
+		paths = append([]string{exe}, binaries...)
+	}
+	for _, path := range paths {
+		if bi, err := describeBinary(path); err == nil {
+			m.Binaries = append(m.Binaries, bi)
+		}
+	}
+
+	kernel := runtimex.LogFatalOnError1(output("uname -r"))
+	m.Kernel = strings.TrimSpace(string(kernel))
+
+	for _, role := range []string{"client", "router", "server", "proxy"} {
+		container := fmt.Sprintf("%s-%s", nameFlag, role)
+		if role == "proxy" {
+			// Note: this fails unless created using --with-proxy
+			if _, err := output("lxc info %s", container); err != nil {
+				continue
+			}
+		}
+		m.Containers = append(m.Containers, describeContainer(container, binaries))
+	}
+
+	for _, dev := range []string{"eth1", "eth2"} {
+		data := runtimex.LogFatalOnError1(output("lxc exec %s-router -- tc qdisc show dev %s", nameFlag, dev))
+		m.Qdiscs[dev] = strings.TrimSpace(string(data))
+	}
+
+	data := runtimex.LogFatalOnError1(json.MarshalIndent(m, "", "  "))
+	path := filepath.Join(dir, manifestFile)
+	runtimex.LogFatalOnError0(os.WriteFile(path, append(data, '\n'), 0600))
+	fmt.Fprintf(os.Stderr, "manifest written to %s\n", path)
+	return nil
+}
@@ -0,0 +1,279 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+	"github.com/kballard/go-shellquote"
+)
+
+// matrixFile is the name of the matrix within the output directory.
+const matrixFile = "matrix.csv"
+
+// matrixColumns are the columns of [matrixFile]. The delay and the rates
+// are those of the profile, while the others come from the measurement.
+var matrixColumns = []string{
+	"profile",
+	"delay",
+	"downloadRate",
+	"uploadRate",
+	"tbfLatency",
+	"status",
+	"download",
+	"upload",
+	"downloadUtilization",
+	"uploadUtilization",
+	"idleLatency",
+	"downloadLatency",
+	"uploadLatency",
+	"downloadRPM",
+	"uploadRPM",
+	"downloadRetransmitRate",
+	"uploadRetransmitRate",
+	"failure",
+}
+
+// matrixRow is a row of [matrixFile], i.e., a profile and its metrics.
+type matrixRow struct {
+	profile string
+	policy  policy
+	doc     *results.Document // nil when the client wrote no document
+	failure string
+}
+
+// matrixDocument is like [clientDocument] but returns the error, along with
+// the document the client wrote anyway, if any, so that a profile where the
+// measurement fails does not stop the sweep.
+func matrixDocument(name, file, command string, argv []string) (*results.Document, error) {
+	mustRun("lxc exec %s-client -- rm -f /root/%s", name, file)
+	argv = append(netemArgv(name), argv...)
+	err := run("lxc exec %s-client -- %s -o %s %s", name, command, file, shellquote.Join(argv...))
+	data, readErr := output("lxc exec %s-client -- cat /root/%s", name, file)
+	if readErr != nil {
+		return nil, err
+	}
+	doc, decodeErr := results.Decode(data)
+	if decodeErr != nil && err == nil {
+		err = decodeErr
+	}
+	return doc, err
+}
+
+// protocolCommand returns the command measuring with the given protocol
+// (ndt7 or ndt8) inside the client container.
+func protocolCommand(protocol string) (string, error) {
+	switch protocol {
+	case "ndt7":
+		return fmt.Sprintf("/root/ndt7 measure -A %s", serverAddr), nil
+	case "ndt8":
+		return fmt.Sprintf("/root/ndt8 measure -A %s --cert cert.pem", serverAddr), nil
+	default:
+		return "", fmt.Errorf("unknown protocol: %s", protocol)
+	}
+}
+
+// utilization returns the fraction of the given profile rate (e.g., 30mbit)
+// that throughput used, or NaN when the profile does not shape the rate.
+func utilization(throughput float64, rate string) float64 {
+	bps, err := rateToBPS(rate)
+	if rate == "" || err != nil || bps <= 0 {
+		return math.NaN()
+	}
+	return throughput / float64(bps)
+}
+
+// record returns the [matrixColumns] of row.
+func (row matrixRow) record() []string {
+	var summary results.Summary
+	status := "failed"
+	if row.doc != nil && row.doc.Summary != nil {
+		summary = *row.doc.Summary
+		status = row.doc.Status
+	}
+	var download, upload results.DirectionSummary
+	if summary.Download != nil {
+		download = *summary.Download
+	}
+	if summary.Upload != nil {
+		upload = *summary.Upload
+	}
+	number := func(value float64, prec int) string {
+		if value == 0 || math.IsNaN(value) {
+			return ""
+		}
+		return strconv.FormatFloat(value, 'f', prec, 64)
+	}
+	millis := func(value time.Duration) string {
+		return number(float64(value)/float64(time.Millisecond), 3)
+	}
+	return []string{
+		row.profile,
+		row.policy.delay,
+		row.policy.download,
+		row.policy.upload,
+		row.policy.tbfLatency,
+		status,
+		number(download.Throughput, 0),
+		number(upload.Throughput, 0),
+		number(utilization(download.Throughput, row.policy.download), 3),
+		number(utilization(upload.Throughput, row.policy.upload), 3),
+		millis(summary.IdleLatency),
+		millis(download.LoadedLatency),
+		millis(upload.LoadedLatency),
+		number(download.RPM, 0),
+		number(upload.RPM, 0),
+		number(download.RetransmitRate, 4),
+		number(upload.RetransmitRate, 4),
+		row.failure,
+	}
+}
+
+// writeMatrix writes rows to path as CSV using [matrixColumns].
+func writeMatrix(path string, rows []matrixRow) error {
+	filep, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer filep.Close()
+	w := csv.NewWriter(filep)
+	w.Write(matrixColumns)
+	for _, row := range rows {
+		w.Write(row.record())
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return filep.Close()
+}
+
+// printMatrix prints the main metrics of rows as a table.
+func printMatrix(rows []matrixRow) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "\nPROFILE\tDOWNLOAD\tUPLOAD\tIDLE\tDL LATENCY\tUL LATENCY\tDL RPM\tUL RPM\tSTATUS\n")
+	for _, row := range rows {
+		record := row.record()
+		if row.doc == nil || row.doc.Summary == nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\t-\t-\t%s\n", row.profile, record[5])
+			continue
+		}
+		summary := row.doc.Summary
+		var download, upload results.DirectionSummary
+		if summary.Download != nil {
+			download = *summary.Download
+		}
+		if summary.Upload != nil {
+			upload = *summary.Upload
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%.0f\t%.0f\t%s\n", row.profile,
+			humanize.SI(download.Throughput, "bit/s"), humanize.SI(upload.Throughput, "bit/s"),
+			summary.IdleLatency.Round(time.Millisecond), download.LoadedLatency.Round(time.Millisecond),
+			upload.LoadedLatency.Round(time.Millisecond), download.RPM, upload.RPM, record[5])
+	}
+	runtimex.LogFatalOnError0(tw.Flush())
+}
+
+// measureMatrixMain is the main of the `lxs measure matrix` command.
+func measureMatrixMain(ctx context.Context, args []string) error {
+	var (
+		labelFlag    = []string{}
+		nameFlag     = "ocho"
+		outputFlag   = ""
+		profilesFlag = ""
+		protocolFlag = "ndt8"
+	)
+
+	fset := vflag.NewFlagSet("lxs measure matrix", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringSliceVar(&labelFlag, 0, "label", "Tag the result documents with `KEY=VALUE` (repeatable).")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.StringVar(&outputFlag, 'o', "output", "Write the result documents and the matrix to `DIR` (default: testdata/matrix-NAME).")
+	fset.StringVar(&profilesFlag, 0, "profiles", "Only measure the comma-separated `PROFILES` (default: all the templates).")
+	fset.StringVar(&protocolFlag, 0, "protocol", "Measure using `PROTOCOL` (ndt7 or ndt8).")
+	fset.MaxPositionalArgs = math.MaxInt
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	command, err := protocolCommand(protocolFlag)
+	failure.OnError(failure.Usage, err)
+	profiles := slices.Sorted(maps.Keys(policies))
+	if profilesFlag != "" {
+		profiles = strings.Split(profilesFlag, ",")
+	}
+	// Check all the profiles before spending hours measuring.
+	selected := make([]policy, 0, len(profiles))
+	for _, profile := range profiles {
+		selected = append(selected, (&policyFlags{template: profile}).policy())
+	}
+	if outputFlag == "" {
+		outputFlag = filepath.Join("testdata", fmt.Sprintf("matrix-%s", nameFlag))
+	}
+	argv := append(labelArgv(labelFlag), fset.Args()...)
+	collectDiagnosticsOnFailure(nameFlag)
+
+	// Note: this requires `lxs serve ndt7 --detach` or `lxs serve ndt8 --detach`
+	mustRun("go build -v ./cmd/%s", protocolFlag)
+	mustRun("lxc file push testdata/cert.pem %s-client/root/", nameFlag)
+	mustRun("lxc file push %s %s-client/root/", protocolFlag, nameFlag)
+	runtimex.LogFatalOnError0(os.MkdirAll(outputFlag, 0700))
+
+	// Note: the profiles with a schedule (e.g., starlink) keep their
+	// initial delay, as if we did not pass --follow to `lxs netem apply`.
+	var rows []matrixRow
+	for idx, profile := range profiles {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "\n[%d/%d] profile %s\n", idx+1, len(profiles), profile)
+		applyNetem(nameFlag, selected[idx])
+		saveNetem(nameFlag, profile, selected[idx])
+		file := fmt.Sprintf("matrix-%s.json", profile)
+		doc, err := matrixDocument(nameFlag, file, command, argv)
+		row := matrixRow{profile: profile, policy: selected[idx], doc: doc}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "profile %s: %s\n", profile, err.Error())
+			row.failure = err.Error()
+		}
+		if doc != nil {
+			runtimex.LogFatalOnError0(results.WriteFile(filepath.Join(outputFlag, profile+".json"), doc))
+		}
+		rows = append(rows, row)
+	}
+	clearNetem(nameFlag)
+
+	path := filepath.Join(outputFlag, matrixFile)
+	runtimex.LogFatalOnError0(writeMatrix(path, rows))
+	printMatrix(rows)
+	fmt.Fprintf(os.Stderr, "\nwrote %s\n", path)
+
+	failed := 0
+	for _, row := range rows {
+		if row.failure != "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		failure.Exit(failure.Generic, fmt.Errorf("%d of %d profiles failed", failed, len(rows)))
+	}
+	return nil
+}
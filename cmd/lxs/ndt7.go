@@ -6,6 +6,8 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
 	"github.com/bassosimone/runtimex"
 	"github.com/bassosimone/vflag"
 	"github.com/kballard/go-shellquote"
@@ -13,30 +15,32 @@ import (
 
 func serveNDT7Main(ctx context.Context, args []string) error {
 	var (
+		detachFlag = false
 		formatFlag = "text"
 		nameFlag   = "ocho"
 	)
 
 	fset := vflag.NewFlagSet("lxs serve ndt7", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.BoolVar(&detachFlag, 'd', "detach", "Install and start the server as a systemd service.")
 	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
 	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
 	runtimex.PanicOnError0(fset.Parse(args))
+	collectDiagnosticsOnFailure(nameFlag)
 
 	mustRun("go build -v ./cmd/gencert")
 	mustRun("go build -v ./cmd/ndt7")
 
-	mustRun("./gencert --ip-addr %s", serverAddr)
+	mustRun("./gencert --ip-addr %s --dns-name %s", serverAddr, serverHostname(nameFlag))
 
 	mustRun("lxc file push testdata/cert.pem %s-server/root/", nameFlag)
 	mustRun("lxc file push testdata/key.pem %s-server/root/", nameFlag)
 	mustRun("lxc file push ndt7 %s-server/root/", nameFlag)
 
-	cmdArgv := []string{
-		"lxc",
-		"exec",
-		fmt.Sprintf("%s-server", nameFlag),
-		"--",
+	serveArgv := []string{
 		"/root/ndt7",
 		"serve",
 		"-A",
@@ -44,6 +48,12 @@ func serveNDT7Main(ctx context.Context, args []string) error {
 		"--format",
 		formatFlag,
 	}
+	if detachFlag {
+		installService(nameFlag, "ndt7", serveArgv)
+		return nil
+	}
+
+	cmdArgv := append([]string{"lxc", "exec", fmt.Sprintf("%s-server", nameFlag), "--"}, serveArgv...)
 	mustRun("%s", shellquote.Join(cmdArgv...))
 
 	return nil
@@ -51,20 +61,45 @@ func serveNDT7Main(ctx context.Context, args []string) error {
 
 func measureNDT7Main(ctx context.Context, args []string) error {
 	var (
-		formatFlag = "text"
-		nameFlag   = "ocho"
+		errorFormatFlag = "text"
+		formatFlag      = "text"
+		hostnameFlag    = false
+		labelFlag       = []string{}
+		nameFlag        = "ocho"
+		withProxyFlag   = false
 	)
 
 	fset := vflag.NewFlagSet("lxs measure ndt7", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&errorFormatFlag, 0, "error-format", "Use `FORMAT` for the final error, if any (text or json).")
 	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.BoolVar(&hostnameFlag, 'H', "hostname", "Connect to the server hostname rather than to its IP address.")
+	fset.StringSliceVar(&labelFlag, 0, "label", "Tag the result document with `KEY=VALUE` (repeatable).")
 	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.BoolVar(&withProxyFlag, 0, "with-proxy", "Connect through the proxy container rather than directly to the server.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
 	runtimex.PanicOnError0(fset.Parse(args))
+	failure.Setup(errorFormatFlag)
+	labels := labelArgv(labelFlag)
+	collectDiagnosticsOnFailure(nameFlag)
 
 	mustRun("go build -v ./cmd/ndt7")
 
 	mustRun("lxc file push ndt7 %s-client/root/", nameFlag)
 
+	serverEndpoint := serverAddr
+	if hostnameFlag {
+		serverEndpoint = serverHostname(nameFlag)
+	}
+	if withProxyFlag {
+		serverEndpoint = proxyAddr
+		if hostnameFlag {
+			serverEndpoint = proxyHostname(nameFlag)
+		}
+	}
+
 	cmdArgv := []string{
 		"lxc",
 		"exec",
@@ -73,10 +108,14 @@ func measureNDT7Main(ctx context.Context, args []string) error {
 		"/root/ndt7",
 		"measure",
 		"-A",
-		serverAddr,
+		serverEndpoint,
 		"--format",
 		formatFlag,
+		"--error-format",
+		errorFormatFlag,
 	}
+	cmdArgv = append(cmdArgv, netemArgv(nameFlag)...)
+	cmdArgv = append(cmdArgv, labels...)
 	mustRun("%s", shellquote.Join(cmdArgv...))
 
 	return nil
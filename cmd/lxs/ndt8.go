@@ -5,7 +5,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"strconv"
 
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
 	"github.com/bassosimone/runtimex"
 	"github.com/bassosimone/vflag"
 	"github.com/kballard/go-shellquote"
@@ -13,20 +16,28 @@ import (
 
 func serveNDT8Main(ctx context.Context, args []string) error {
 	var (
-		formatFlag = "text"
-		nameFlag   = "ocho"
+		detachFlag    = false
+		formatFlag    = "text"
+		nameFlag      = "ocho"
+		withProxyFlag = false
 	)
 
 	fset := vflag.NewFlagSet("lxs serve ndt8", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.BoolVar(&detachFlag, 'd', "detach", "Install and start the server as a systemd service.")
 	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
 	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.BoolVar(&withProxyFlag, 0, "with-proxy", "Take the client address from the proxy container headers.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
 	runtimex.PanicOnError0(fset.Parse(args))
+	collectDiagnosticsOnFailure(nameFlag)
 
 	mustRun("go build -v ./cmd/gencert")
 	mustRun("go build -v ./cmd/ndt8")
 
-	mustRun("./gencert --ip-addr %s", serverAddr)
+	mustRun("./gencert --ip-addr %s --dns-name %s", serverAddr, serverHostname(nameFlag))
 
 	mustRun("lxc exec %s-server -- mkdir -p /root/static", nameFlag)
 
@@ -36,11 +47,7 @@ func serveNDT8Main(ctx context.Context, args []string) error {
 	mustRun("lxc file push static/index.html %s-server/root/static/", nameFlag)
 	mustRun("lxc file push static/ndt8.js %s-server/root/static/", nameFlag)
 
-	cmdArgv := []string{
-		"lxc",
-		"exec",
-		fmt.Sprintf("%s-server", nameFlag),
-		"--",
+	serveArgv := []string{
 		"/root/ndt8",
 		"serve",
 		"-A",
@@ -53,7 +60,18 @@ func serveNDT8Main(ctx context.Context, args []string) error {
 		formatFlag,
 		"-s",
 		"static",
+		"--debug-addr",
+		debugAddr,
+	}
+	if withProxyFlag {
+		serveArgv = append(serveArgv, "--trusted-proxy", proxyAddr)
 	}
+	if detachFlag {
+		installService(nameFlag, "ndt8", serveArgv)
+		return nil
+	}
+
+	cmdArgv := append([]string{"lxc", "exec", fmt.Sprintf("%s-server", nameFlag), "--"}, serveArgv...)
 	mustRun("%s", shellquote.Join(cmdArgv...))
 
 	return nil
@@ -61,23 +79,55 @@ func serveNDT8Main(ctx context.Context, args []string) error {
 
 func measureNDT8Main(ctx context.Context, args []string) error {
 	var (
-		formatFlag = "text"
-		http2Flag  = false
-		nameFlag   = "ocho"
+		errorFormatFlag = "text"
+		formatFlag      = "text"
+		hostnameFlag    = false
+		http2Flag       = false
+		labelFlag       = []string{}
+		nameFlag        = "ocho"
+		seedFlag        = int64(0)
+		withProxyFlag   = false
 	)
 
 	fset := vflag.NewFlagSet("lxs measure ndt8", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&errorFormatFlag, 0, "error-format", "Use `FORMAT` for the final error, if any (text or json).")
 	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.BoolVar(&hostnameFlag, 'H', "hostname", "Connect to the server hostname rather than to its IP address.")
 	fset.BoolVar(&http2Flag, '2', "http2", "Force HTTP/2 (default is HTTP/1.1).")
+	fset.StringSliceVar(&labelFlag, 0, "label", "Tag the result document with `KEY=VALUE` (repeatable).")
 	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.Int64Var(&seedFlag, 0, "seed", "Pass `SEED` to the client to replay its random choices (0 for a random seed).")
+	fset.BoolVar(&withProxyFlag, 0, "with-proxy", "Connect through the proxy container rather than directly to the server.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
 	runtimex.PanicOnError0(fset.Parse(args))
+	failure.Setup(errorFormatFlag)
+	labels := labelArgv(labelFlag)
+	collectDiagnosticsOnFailure(nameFlag)
 
 	mustRun("go build -v ./cmd/ndt8")
 
-	mustRun("lxc file push testdata/cert.pem %s-client/root/", nameFlag)
+	// The proxy terminates TLS using its own certificate.
+	certDir := "testdata"
+	if withProxyFlag {
+		certDir = proxyCertDir
+	}
+	mustRun("lxc file push %s/cert.pem %s-client/root/", certDir, nameFlag)
 	mustRun("lxc file push ndt8 %s-client/root/", nameFlag)
 
+	serverEndpoint := serverAddr
+	if hostnameFlag {
+		serverEndpoint = serverHostname(nameFlag)
+	}
+	if withProxyFlag {
+		serverEndpoint = proxyAddr
+		if hostnameFlag {
+			serverEndpoint = proxyHostname(nameFlag)
+		}
+	}
+
 	cmdArgv := []string{
 		"lxc",
 		"exec",
@@ -86,15 +136,22 @@ func measureNDT8Main(ctx context.Context, args []string) error {
 		"/root/ndt8",
 		"measure",
 		"-A",
-		serverAddr,
+		serverEndpoint,
 		"--cert",
 		"cert.pem",
 		"--format",
 		formatFlag,
+		"--error-format",
+		errorFormatFlag,
 	}
+	cmdArgv = append(cmdArgv, netemArgv(nameFlag)...)
+	cmdArgv = append(cmdArgv, labels...)
 	if http2Flag {
 		cmdArgv = append(cmdArgv, "-2")
 	}
+	if seedFlag != 0 {
+		cmdArgv = append(cmdArgv, "--seed", strconv.FormatInt(seedFlag, 10))
+	}
 	mustRun("%s", shellquote.Join(cmdArgv...))
 
 	return nil
@@ -4,12 +4,19 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
 	"github.com/bassosimone/runtimex"
 	"github.com/bassosimone/vflag"
 )
@@ -20,6 +27,10 @@ type policy struct {
 	download   string
 	upload     string
 	tbfLatency string
+	loss       string
+	slot       string
+	seed       uint64
+	queue      string
 }
 
 // policies maps named profiles to their [policy] definitions.
@@ -51,6 +62,23 @@ type policy struct {
 //     delay only). Real DC links run at 10–100 Gbps, which is
 //     beyond what tc can meaningfully shape on a veth pair, so
 //     this profile only adds delay without rate limiting.
+//   - starlink: LEO satellite access (40ms RTT, 150/15 Mbps), whose
+//     latency changes at every satellite handover (see [schedules]).
+//   - wifi: good home Wi-Fi behind a fast line (4ms RTT, 150/50
+//     Mbps), where frame aggregation delivers packets in bursts.
+//   - wifi-congested: Wi-Fi in a crowded apartment building (8ms
+//     RTT, 30/10 Mbps), where contention makes bursts rarer and
+//     interference causes more losses.
+//
+// Unlike the other profiles, the Wi-Fi profiles use two netem features
+// modeling the radio link. The slot field (see "slot" in tc-netem(8))
+// holds packets and releases them in bursts at random intervals between
+// the given bounds, like the transmit opportunities of a contended
+// medium, which makes the rate vary over short timescales. The loss
+// field uses the Gilbert-Elliott model ("loss gemodel P R"), which
+// enters a lossy state with probability P and leaves it with probability
+// R, so losses come in bursts, as they do during interference, rather
+// than independently of each other.
 //
 // The tbfLatency field controls the maximum time a packet may sit in
 // the TBF queue before being dropped. Low values (e.g., 50ms) model
@@ -58,24 +86,73 @@ type policy struct {
 // bufferbloat — the condition where oversized router/modem buffers
 // cause latency to spike under load, which is exactly what the
 // "responsiveness" metric is designed to detect.
+//
+// The queue field selects how the rate limiter queues packets (see
+// [queues]). The profiles leave it empty, which means a single FIFO.
 var policies = map[string]policy{
-	"2g":                  {"300ms", "200kbit", "50kbit", "50ms"},
-	"2g-bloated":          {"300ms", "200kbit", "50kbit", "1000ms"},
-	"3g":                  {"100ms", "3mbit", "1mbit", "50ms"},
-	"3g-bloated":          {"100ms", "3mbit", "1mbit", "500ms"},
-	"4g":                  {"50ms", "30mbit", "10mbit", "50ms"},
-	"4g-bloated":          {"50ms", "30mbit", "10mbit", "500ms"},
-	"5g":                  {"10ms", "100mbit", "30mbit", "50ms"},
-	"5g-bloated":          {"10ms", "100mbit", "30mbit", "500ms"},
-	"poor-mobile":         {"75ms", "5mbit", "1mbit", "50ms"},
-	"poor-mobile-bloated": {"75ms", "5mbit", "1mbit", "500ms"},
-	"broadband":           {"25ms", "100mbit", "20mbit", "50ms"},
-	"broadband-bloated":   {"25ms", "100mbit", "20mbit", "1000ms"},
-	"ftth-100":            {"5ms", "100mbit", "50mbit", "50ms"},
-	"ftth-100-bloated":    {"5ms", "100mbit", "50mbit", "500ms"},
-	"ftth-1g":             {"5ms", "1gbit", "500mbit", "50ms"},
-	"ftth-1g-bloated":     {"5ms", "1gbit", "500mbit", "500ms"},
-	"server":              {"1ms", "", "", ""},
+	"2g":                     {"300ms", "200kbit", "50kbit", "50ms", "", "", 0, ""},
+	"2g-bloated":             {"300ms", "200kbit", "50kbit", "1000ms", "", "", 0, ""},
+	"3g":                     {"100ms", "3mbit", "1mbit", "50ms", "", "", 0, ""},
+	"3g-bloated":             {"100ms", "3mbit", "1mbit", "500ms", "", "", 0, ""},
+	"4g":                     {"50ms", "30mbit", "10mbit", "50ms", "", "", 0, ""},
+	"4g-bloated":             {"50ms", "30mbit", "10mbit", "500ms", "", "", 0, ""},
+	"5g":                     {"10ms", "100mbit", "30mbit", "50ms", "", "", 0, ""},
+	"5g-bloated":             {"10ms", "100mbit", "30mbit", "500ms", "", "", 0, ""},
+	"poor-mobile":            {"75ms", "5mbit", "1mbit", "50ms", "", "", 0, ""},
+	"poor-mobile-bloated":    {"75ms", "5mbit", "1mbit", "500ms", "", "", 0, ""},
+	"broadband":              {"25ms", "100mbit", "20mbit", "50ms", "", "", 0, ""},
+	"broadband-bloated":      {"25ms", "100mbit", "20mbit", "1000ms", "", "", 0, ""},
+	"ftth-100":               {"5ms", "100mbit", "50mbit", "50ms", "", "", 0, ""},
+	"ftth-100-bloated":       {"5ms", "100mbit", "50mbit", "500ms", "", "", 0, ""},
+	"ftth-1g":                {"5ms", "1gbit", "500mbit", "50ms", "", "", 0, ""},
+	"ftth-1g-bloated":        {"5ms", "1gbit", "500mbit", "500ms", "", "", 0, ""},
+	"server":                 {"1ms", "", "", "", "", "", 0, ""},
+	"starlink":               {"20ms", "150mbit", "15mbit", "50ms", "", "", 0, ""},
+	"starlink-bloated":       {"20ms", "150mbit", "15mbit", "500ms", "", "", 0, ""},
+	"wifi":                   {"2ms", "150mbit", "50mbit", "50ms", "gemodel 0.2% 25%", "1ms 4ms packets 32", 0, ""},
+	"wifi-bloated":           {"2ms", "150mbit", "50mbit", "500ms", "gemodel 0.2% 25%", "1ms 4ms packets 32", 0, ""},
+	"wifi-congested":         {"4ms", "30mbit", "10mbit", "50ms", "gemodel 1% 20%", "2ms 20ms packets 16", 0, ""},
+	"wifi-congested-bloated": {"4ms", "30mbit", "10mbit", "500ms", "gemodel 1% 20%", "2ms 20ms packets 16", 0, ""},
+}
+
+// netemArgs returns the arguments of the netem qdisc implementing the
+// delay, loss, and slot of p, which draw from the seed of p, if any.
+func (p policy) netemArgs() string {
+	args := "delay " + p.delay
+	if p.loss != "" {
+		args += " loss " + p.loss
+	}
+	if p.slot != "" {
+		args += " slot " + p.slot
+	}
+	if p.seed != 0 {
+		args += " seed " + strconv.FormatUint(p.seed, 10)
+	}
+	return args
+}
+
+// queues contains the queues we can install under the rate limiter.
+//
+// A single FIFO, the default, is what most bottlenecks use: the probes
+// wait behind the bulk traffic, so their RTT shows the queueing delay.
+// With flow queueing ("fq" or "fq_codel"), each flow has its own queue,
+// so the probes skip the queue the bulk traffic builds, and fq_codel also
+// keeps that queue short by dropping packets that waited too long.
+var queues = []string{"fifo", "fq", "fq_codel"}
+
+// queueArgs returns the arguments of the qdisc implementing the queue
+// of p, holding as many packets as the TBF latency allows at rate, like
+// the FIFO of the TBF, so that we only change how we schedule flows.
+func (p policy) queueArgs(rate string) string {
+	bps := runtimex.LogFatalOnError1(rateToBPS(rate))
+	latency := runtimex.LogFatalOnError1(time.ParseDuration(p.tbfLatency))
+	limit := max(int(float64(bps)*latency.Seconds()/8/1500), 10)
+	switch p.queue {
+	case "fq":
+		return fmt.Sprintf("fq limit %d flow_limit %d", limit, limit)
+	default:
+		return fmt.Sprintf("%s limit %d", p.queue, limit)
+	}
 }
 
 // rateToBPS converts a tc rate string (e.g., "100mbit") to bits per second.
@@ -153,113 +230,392 @@ func computeBurst(rate string) int {
 // segmented and queued as they would be on a real network link.
 func applyNetem(name string, p policy) {
 	clearNetem(name)
+	for _, link := range routerLinks {
+		label := fmt.Sprintf("router %s (toward %s)", link.dev, link.toward)
+		installPolicy(name, link.dev, label, "root", anyHandles, p, link.rate(p))
+	}
+	describePolicy(p)
+}
 
-	rateShaping := p.download != "" && p.upload != ""
+// These are the address families `lxs netem apply --family` accepts.
+const (
+	familyAny   = "any"
+	familyInet  = "inet"
+	familyInet6 = "inet6"
+)
 
-	// Router eth1 (toward client): delay + optional download rate shaping
-	if rateShaping {
-		dlBurst := computeBurst(p.download)
-		fmt.Fprintf(os.Stderr, "router eth1 (toward client): %s delay, %s rate, %dB burst, %s tbf-latency\n",
-			p.delay, p.download, dlBurst, p.tbfLatency)
-		mustRun("lxc exec %s-router -- tc qdisc add dev eth1 root handle 1: netem delay %s",
-			name, p.delay)
-		mustRun("lxc exec %s-router -- tc qdisc add dev eth1 parent 1:1 handle 10: tbf rate %s burst %d latency %s",
-			name, p.download, dlBurst, p.tbfLatency)
-	} else {
-		fmt.Fprintf(os.Stderr, "router eth1 (toward client): %s delay, no rate shaping\n", p.delay)
-		mustRun("lxc exec %s-router -- tc qdisc add dev eth1 root handle 1: netem delay %s",
-			name, p.delay)
+// familyBands maps the address families to the bands of the prio qdisc
+// installed by [applyFamilyNetem] and to the handles of the qdiscs below.
+var familyBands = map[string]struct {
+	band    int
+	handles qdiscHandles
+}{
+	familyInet:  {1, qdiscHandles{netem: 10, tbf: 11, queue: 12}},
+	familyInet6: {2, qdiscHandles{netem: 20, tbf: 21, queue: 22}},
+}
+
+// applyFamilyNetem is like [applyNetem] but only impairs the packets of
+// the given family (inet or inet6) using p, keeping the policy previously
+// applied to the other family, if any, which allows, e.g., to give IPv6
+// more loss than IPv4, as it happens when IPv6 goes through a tunnel.
+//
+// A prio qdisc at the root classifies the packets: a filter sends the IPv6
+// packets to its second band, while the others, including IPv4, go to its
+// first band. Each band gets its own netem and TBF qdiscs, so the families
+// have separate queues and rate limiters, as if they followed distinct
+// paths. A band without a policy does not impair the packets.
+func applyFamilyNetem(name, family string, p policy) {
+	active := map[string]policy{family: p}
+	for other := range familyBands {
+		if other == family {
+			continue
+		}
+		if op, found := loadFamilyNetem(name, other); found {
+			active[other] = op
+		}
 	}
+	clearQdiscs(name)
+	removeNetem(netemPath(name))
+	for _, link := range routerLinks {
+		mustRun("lxc exec %s-router -- tc qdisc add dev %s root handle 1: prio bands 2 priomap%s",
+			name, link.dev, strings.Repeat(" 0", 16))
+		mustRun("lxc exec %s-router -- tc filter add dev %s parent 1: protocol ipv6 prio 1 matchall classid 1:%d",
+			name, link.dev, familyBands[familyInet6].band)
+		for _, fam := range []string{familyInet, familyInet6} {
+			fp, found := active[fam]
+			if !found {
+				fmt.Fprintf(os.Stderr, "router %s (toward %s, %s): no impairment\n", link.dev, link.toward, fam)
+				continue
+			}
+			fb := familyBands[fam]
+			label := fmt.Sprintf("router %s (toward %s, %s)", link.dev, link.toward, fam)
+			installPolicy(name, link.dev, label, fmt.Sprintf("parent 1:%d", fb.band), fb.handles, fp, link.rate(fp))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\n%s:\n", family)
+	describePolicy(p)
+}
 
-	// Router eth2 (toward server): delay + optional upload rate shaping
-	if rateShaping {
-		ulBurst := computeBurst(p.upload)
-		fmt.Fprintf(os.Stderr, "router eth2 (toward server): %s delay, %s rate, %dB burst, %s tbf-latency\n",
-			p.delay, p.upload, ulBurst, p.tbfLatency)
-		mustRun("lxc exec %s-router -- tc qdisc add dev eth2 root handle 1: netem delay %s",
-			name, p.delay)
-		mustRun("lxc exec %s-router -- tc qdisc add dev eth2 parent 1:1 handle 10: tbf rate %s burst %d latency %s",
-			name, p.upload, ulBurst, p.tbfLatency)
-	} else {
-		fmt.Fprintf(os.Stderr, "router eth2 (toward server): %s delay, no rate shaping\n", p.delay)
-		mustRun("lxc exec %s-router -- tc qdisc add dev eth2 root handle 1: netem delay %s",
-			name, p.delay)
+// routerLinks contains the router interfaces we shape, along with the
+// rate of the policy that applies to the packets they send.
+var routerLinks = []struct {
+	dev    string
+	toward string
+	rate   func(p policy) string
+}{
+	{"eth1", "client", func(p policy) string { return p.download }},
+	{"eth2", "server", func(p policy) string { return p.upload }},
+}
+
+// qdiscHandles contains the major numbers of the qdiscs implementing
+// a policy (see [installPolicy]).
+type qdiscHandles struct {
+	netem int
+	tbf   int
+	queue int
+}
+
+// anyHandles are the [qdiscHandles] used by [applyNetem].
+var anyHandles = qdiscHandles{netem: 1, tbf: 10, queue: 20}
+
+// installPolicy installs below parent (e.g., "root") on the given router
+// interface the netem qdisc implementing the delay of p and, when p shapes
+// both directions, the TBF qdisc limiting the rate, followed by the queue
+// of p, describing them using label.
+func installPolicy(name, dev, label, parent string, h qdiscHandles, p policy, rate string) {
+	mustRun("lxc exec %s-router -- tc qdisc add dev %s %s handle %d: netem %s", name, dev, parent, h.netem, p.netemArgs())
+	if p.download == "" || p.upload == "" {
+		fmt.Fprintf(os.Stderr, "%s: %s delay, no rate shaping\n", label, p.delay)
+		return
 	}
+	burst := computeBurst(rate)
+	fmt.Fprintf(os.Stderr, "%s: %s delay, %s rate, %dB burst, %s tbf-latency\n",
+		label, p.delay, rate, burst, p.tbfLatency)
+	mustRun("lxc exec %s-router -- tc qdisc add dev %s parent %d:1 handle %d: tbf rate %s burst %d latency %s",
+		name, dev, h.netem, h.tbf, rate, burst, p.tbfLatency)
+	if p.queue != "fifo" {
+		mustRun("lxc exec %s-router -- tc qdisc add dev %s parent %d:1 handle %d: %s", name, dev, h.tbf, h.queue, p.queueArgs(rate))
+	}
+}
 
+// describePolicy prints the main properties of p.
+func describePolicy(p policy) {
+	rateShaping := p.download != "" && p.upload != ""
 	fmt.Fprintf(os.Stderr, "\neffective RTT: 2 x %s\n", p.delay)
+	if p.loss != "" {
+		fmt.Fprintf(os.Stderr, "loss: %s\n", p.loss)
+	}
+	if p.slot != "" {
+		fmt.Fprintf(os.Stderr, "slot: %s (bursty delivery)\n", p.slot)
+	}
+	if p.seed != 0 {
+		fmt.Fprintf(os.Stderr, "seed: %d\n", p.seed)
+	}
 	if rateShaping {
 		fmt.Fprintf(os.Stderr, "download: %s, upload: %s\n", p.download, p.upload)
 		fmt.Fprintf(os.Stderr, "tbf-latency: %s (bufferbloat simulation)\n", p.tbfLatency)
+		fmt.Fprintf(os.Stderr, "queue: %s\n", p.queue)
 	} else {
 		fmt.Fprintf(os.Stderr, "rate shaping: none (unlimited)\n")
 	}
 }
 
-// clearNetem removes all tc qdisc rules from the router, ignoring errors.
+// clearNetem removes all tc qdisc rules from the router, ignoring errors,
+// along with the policies we saved.
 func clearNetem(name string) {
+	clearQdiscs(name)
+	removeNetem(netemPath(name))
+	removeNetem(familyNetemPath(name, familyInet))
+	removeNetem(familyNetemPath(name, familyInet6))
+}
+
+// clearQdiscs removes all tc qdisc rules from the router, ignoring errors.
+func clearQdiscs(name string) {
 	fmt.Fprintf(os.Stderr, "clearing: %s-router eth1 and eth2\n", name)
 	// Note: commands may fail if no previous policy had been set
 	run("lxc exec %s-router -- tc qdisc del dev eth1 root", name)
 	run("lxc exec %s-router -- tc qdisc del dev eth2 root", name)
 }
 
-// netemApplyMain is the main of the `lxs netem apply` command.
-func netemApplyMain(ctx context.Context, args []string) error {
-	var (
-		nameFlag       = "ocho"
-		templateFlag   = ""
-		delayFlag      = ""
-		downloadFlag   = ""
-		uploadFlag     = ""
-		tbfLatencyFlag = ""
-	)
+// removeNetem removes the policy saved at path, if any.
+func removeNetem(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "cannot remove %s: %s\n", path, err)
+	}
+}
 
-	fset := vflag.NewFlagSet("lxs netem apply", vflag.ExitOnError)
-	fset.AutoHelp('h', "help", "Print this help text and exit.")
-	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
-	fset.StringVar(&templateFlag, 't', "template", "Load named `TEMPLATE` as a starting point (overridable by other flags). "+
-		"Available: 2g, 3g, 4g, 5g, poor-mobile, broadband, ftth-100, ftth-1g, server "+
+// netemPath returns the path where we store the policy applied to the
+// topology with the given name, which `lxs measure` records.
+func netemPath(name string) string {
+	return filepath.Join("testdata", fmt.Sprintf("netem-%s.json", name))
+}
+
+// familyNetemPath is like [netemPath] but for the policy applied to the
+// given family using [applyFamilyNetem].
+func familyNetemPath(name, family string) string {
+	return filepath.Join("testdata", fmt.Sprintf("netem-%s-%s.json", name, family))
+}
+
+// netemDocument returns p, loaded from the given template, if any, and
+// applied to the given family, if any, as recorded in the documents.
+func netemDocument(template, family string, p policy) *results.Netem {
+	return &results.Netem{
+		Template:   template,
+		Delay:      p.delay,
+		Download:   p.download,
+		Upload:     p.upload,
+		TBFLatency: p.tbfLatency,
+		Loss:       p.loss,
+		Slot:       p.slot,
+		Seed:       p.seed,
+		Queue:      p.queue,
+		Family:     family,
+	}
+}
+
+// writeNetem writes netem to path.
+func writeNetem(path string, netem *results.Netem) {
+	data := runtimex.LogFatalOnError1(json.MarshalIndent(netem, "", "  "))
+	runtimex.LogFatalOnError0(os.MkdirAll("testdata", 0700))
+	runtimex.LogFatalOnError0(os.WriteFile(path, append(data, '\n'), 0600))
+}
+
+// readNetem reads the policy stored at path, if any.
+func readNetem(path string) (*results.Netem, bool) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false
+	}
+	runtimex.LogFatalOnError0(err)
+	var netem results.Netem
+	runtimex.LogFatalOnError0(json.Unmarshal(data, &netem))
+	return &netem, true
+}
+
+// saveNetem stores p, loaded from the given template, if any, as the policy
+// applied to the topology with the given name.
+func saveNetem(name, template string, p policy) {
+	writeNetem(netemPath(name), netemDocument(template, "", p))
+}
+
+// saveFamilyNetem is like [saveNetem] but for the policy applied to the
+// given family using [applyFamilyNetem].
+func saveFamilyNetem(name, family, template string, p policy) {
+	writeNetem(familyNetemPath(name, family), netemDocument(template, family, p))
+}
+
+// loadFamilyNetem returns the policy saved by [saveFamilyNetem], if any.
+func loadFamilyNetem(name, family string) (policy, bool) {
+	netem, found := readNetem(familyNetemPath(name, family))
+	if !found {
+		return policy{}, false
+	}
+	p := policy{
+		delay:      netem.Delay,
+		download:   netem.Download,
+		upload:     netem.Upload,
+		tbfLatency: netem.TBFLatency,
+		loss:       netem.Loss,
+		slot:       netem.Slot,
+		seed:       netem.Seed,
+		queue:      netem.Queue,
+	}
+	return p, true
+}
+
+// netemArgv returns the client flags recording the policy applied to the
+// topology with the given name, which are empty when we do not know it.
+// Since the clients connect to [serverAddr], we record the policy applied
+// to IPv4, if the router treats the families differently.
+func netemArgv(name string) []string {
+	netem, found := readNetem(netemPath(name))
+	if !found {
+		netem, found = readNetem(familyNetemPath(name, familyInet))
+	}
+	if !found {
+		return nil
+	}
+	return []string{"--netem", netem.String()}
+}
+
+// policyFlags contains the flags selecting a network emulation [policy].
+type policyFlags struct {
+	template   string
+	delay      string
+	download   string
+	upload     string
+	tbfLatency string
+	loss       string
+	slot       string
+	seed       uint64
+	queue      string
+}
+
+// newPolicyFlags adds the policy flags to fset.
+func newPolicyFlags(fset *vflag.FlagSet) *policyFlags {
+	pf := &policyFlags{}
+	fset.StringVar(&pf.template, 't', "template", "Load named `TEMPLATE` as a starting point (overridable by other flags). "+
+		"Available: 2g, 3g, 4g, 5g, poor-mobile, broadband, ftth-100, ftth-1g, starlink, wifi, wifi-congested, server "+
 		"(all except server also have a -bloated variant).")
-	fset.StringVar(&delayFlag, 0, "delay", "One-way `DELAY` (e.g., 25ms).")
-	fset.StringVar(&downloadFlag, 0, "download", "Download `RATE` (e.g., 100mbit).")
-	fset.StringVar(&uploadFlag, 0, "upload", "Upload `RATE` (e.g., 20mbit).")
-	fset.StringVar(&tbfLatencyFlag, 0, "tbf-latency", "TBF queue `LATENCY` for bufferbloat simulation (e.g., 50ms, 1000ms).")
-	runtimex.PanicOnError0(fset.Parse(args))
+	fset.StringVar(&pf.delay, 0, "delay", "One-way `DELAY` (e.g., 25ms).")
+	fset.StringVar(&pf.download, 0, "download", "Download `RATE` (e.g., 100mbit).")
+	fset.StringVar(&pf.loss, 0, "loss", "netem `LOSS` model (e.g., 1% or \"gemodel 1% 20%\" for bursty loss).")
+	fset.StringVar(&pf.queue, 0, "queue", "Queue packets in the rate limiter using `QUEUE` (fifo, fq, or fq_codel).")
+	fset.Uint64Var(&pf.seed, 0, "seed", "Draw the netem random choices (e.g., which packets to lose) from `SEED` (0 for a random seed, needs Linux >= 6.7).")
+	fset.StringVar(&pf.slot, 0, "slot", "netem `SLOT` model delivering packets in bursts (e.g., \"1ms 4ms packets 32\").")
+	fset.StringVar(&pf.upload, 0, "upload", "Upload `RATE` (e.g., 20mbit).")
+	fset.StringVar(&pf.tbfLatency, 0, "tbf-latency", "TBF queue `LATENCY` for bufferbloat simulation (e.g., 50ms, 1000ms).")
+	return pf
+}
 
+// policy returns the [policy] selected by the flags, exiting on failure.
+func (pf *policyFlags) policy() policy {
 	var p policy
-	if templateFlag != "" {
+	if pf.template != "" {
 		var ok bool
-		p, ok = policies[templateFlag]
+		p, ok = policies[pf.template]
 		if !ok {
-			log.Fatalf("unknown template: %s", templateFlag)
+			failure.Exit(failure.Usage, fmt.Errorf("unknown template: %s", pf.template))
 		}
 	}
 
 	// Let explicit flags override the (possibly template-loaded) policy.
-	if delayFlag != "" {
-		p.delay = delayFlag
+	if pf.delay != "" {
+		p.delay = pf.delay
+	}
+	if pf.download != "" {
+		p.download = pf.download
+	}
+	if pf.upload != "" {
+		p.upload = pf.upload
+	}
+	if pf.tbfLatency != "" {
+		p.tbfLatency = pf.tbfLatency
 	}
-	if downloadFlag != "" {
-		p.download = downloadFlag
+	if pf.loss != "" {
+		p.loss = pf.loss
 	}
-	if uploadFlag != "" {
-		p.upload = uploadFlag
+	if pf.slot != "" {
+		p.slot = pf.slot
 	}
-	if tbfLatencyFlag != "" {
-		p.tbfLatency = tbfLatencyFlag
+	p.seed = pf.seed
+	if pf.queue != "" {
+		p.queue = pf.queue
 	}
 
 	// Require at least something to be configured.
 	if p.delay == "" {
-		log.Fatal("specify --template or at least --delay")
+		failure.Exit(failure.Usage, errors.New("specify --template or at least --delay"))
 	}
 
-	// Apply default tbfLatency if still empty.
+	// Apply default tbfLatency and queue if still empty.
 	if p.tbfLatency == "" {
 		p.tbfLatency = "50ms"
 	}
+	if p.queue == "" {
+		p.queue = "fifo"
+	}
+	if !slices.Contains(queues, p.queue) {
+		failure.Exit(failure.Usage, fmt.Errorf("unknown queue: %s", p.queue))
+	}
+	if p.queue != "fifo" && (p.download == "" || p.upload == "") {
+		failure.Exit(failure.Usage, errors.New("--queue requires rate shaping"))
+	}
+	return p
+}
 
-	applyNetem(nameFlag, p)
+// netemApplyMain is the main of the `lxs netem apply` command.
+func netemApplyMain(ctx context.Context, args []string) error {
+	var (
+		familyFlag = familyAny
+		followFlag = false
+		nameFlag   = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs netem apply", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&familyFlag, 0, "family", "Only impair the packets of `FAMILY` (any, inet, or inet6), keeping the "+
+		"policy applied to the other family (useful with topologies created using --dual-stack).")
+	fset.BoolVar(&followFlag, 0, "follow", "Keep running to follow the template schedule (e.g., starlink handovers) until interrupted.")
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	pf := newPolicyFlags(fset)
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	p := pf.policy()
+	sched, hasSchedule := schedules[pf.template]
+	if followFlag && !hasSchedule {
+		failure.Exit(failure.Usage, fmt.Errorf("template %q has no schedule to follow", pf.template))
+	}
+	switch familyFlag {
+	case familyAny:
+		applyNetem(nameFlag, p)
+		saveNetem(nameFlag, pf.template, p)
+	case familyInet, familyInet6:
+		if followFlag {
+			failure.Exit(failure.Usage, errors.New("--follow requires --family any"))
+		}
+		applyFamilyNetem(nameFlag, familyFlag, p)
+		saveFamilyNetem(nameFlag, familyFlag, pf.template, p)
+	default:
+		failure.Exit(failure.Usage, fmt.Errorf("unknown family: %s", familyFlag))
+	}
+
+	// Warn when the host may not sustain the configured rates.
+	if cal := loadCalibration(nameFlag); cal != nil {
+		checkCeiling("download", p.download, cal.downloadCeiling())
+		checkCeiling("upload", p.upload, cal.uploadCeiling())
+	}
+
+	// Follow the schedule of the template, if any.
+	switch {
+	case followFlag:
+		followSchedule(ctx, nameFlag, p, sched)
+	case hasSchedule:
+		fmt.Fprintf(os.Stderr, "note: %s changes over time, pass --follow to emulate this\n", pf.template)
+	}
 	return nil
 }
 
@@ -270,8 +626,11 @@ func netemClearMain(ctx context.Context, args []string) error {
 	)
 
 	fset := vflag.NewFlagSet("lxs netem clear", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
 	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
 	runtimex.PanicOnError0(fset.Parse(args))
 
 	clearNetem(nameFlag)
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+)
+
+// outageChain is the iptables filter chain blackholing the forwarded traffic.
+const outageChain = "lxs-outage"
+
+// injectOutages blackholes the traffic the router forwards for duration
+// every interval until ctx is done, to test how the clients and the servers
+// handle losing connectivity mid-test and whether the results show it.
+//
+// We drop packets using iptables rather than netem, so the outages do not
+// depend on, and do not disturb, the netem policy currently applied. Since
+// the router drops the packets silently, the endpoints only notice through
+// their timeouts, as they would when a real link goes down.
+func injectOutages(ctx context.Context, name string, duration, interval time.Duration) {
+	clearOutages(name)
+
+	mustRun("lxc exec %s-router -- apt update", name)
+	mustRun("lxc exec %s-router --env DEBIAN_FRONTEND=noninteractive -- apt install -y iptables", name)
+
+	mustRun("lxc exec %s-router -- iptables -N %s", name, outageChain)
+	mustRun("lxc exec %s-router -- iptables -I FORWARD -j %s", name, outageChain)
+	defer clearOutages(name)
+
+	// We print the wall clock time of each outage so that they can be
+	// matched with the timestamps in the results.
+	fmt.Fprintf(os.Stderr, "\ninjecting %s outages every %s, press Ctrl-C to stop\n", duration, interval)
+	for {
+		if !sleepContext(ctx, interval-duration) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s: outage for %s\n", time.Now().Format(time.RFC3339Nano), duration)
+		mustRun("lxc exec %s-router -- iptables -A %s -j DROP", name, outageChain)
+		ok := sleepContext(ctx, duration)
+		mustRun("lxc exec %s-router -- iptables -F %s", name, outageChain)
+		fmt.Fprintf(os.Stderr, "%s: connectivity restored\n", time.Now().Format(time.RFC3339Nano))
+		if !ok {
+			return
+		}
+	}
+}
+
+// clearOutages removes the outage chain from the router, ignoring errors.
+func clearOutages(name string) {
+	fmt.Fprintf(os.Stderr, "clearing: %s-router outages\n", name)
+	// Note: commands may fail if outages had never been injected
+	run("lxc exec %s-router -- iptables -D FORWARD -j %s", name, outageChain)
+	run("lxc exec %s-router -- iptables -F %s", name, outageChain)
+	run("lxc exec %s-router -- iptables -X %s", name, outageChain)
+}
+
+// netemOutageMain is the main of the `lxs netem outage` command.
+func netemOutageMain(ctx context.Context, args []string) error {
+	var (
+		durationFlag = 2 * time.Second
+		everyFlag    = 30 * time.Second
+		nameFlag     = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs netem outage", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.DurationVar(&durationFlag, 'd', "duration", "Blackhole the traffic for `DURATION` at each outage.")
+	fset.DurationVar(&everyFlag, 'e', "every", "Start an outage every `INTERVAL`.")
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	if durationFlag <= 0 || everyFlag <= durationFlag {
+		failure.Exit(failure.Usage, fmt.Errorf("--every (%s) must exceed a positive --duration (%s)", everyFlag, durationFlag))
+	}
+
+	injectOutages(ctx, nameFlag, durationFlag, everyFlag)
+	return nil
+}
@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+	"github.com/kballard/go-shellquote"
+)
+
+// cpuMask converts a CPU list (e.g., "0-1,4") into the hexadecimal mask
+// format used by /sys/class/net/DEV/queues/rx-N/rps_cpus (e.g., "13").
+func cpuMask(list string) (string, error) {
+	mask := new(big.Int)
+	for part := range strings.SplitSeq(list, ",") {
+		lo, hi, found := strings.Cut(strings.TrimSpace(part), "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return "", fmt.Errorf("invalid CPU list %q: %w", list, err)
+		}
+		last := first
+		if found {
+			if last, err = strconv.Atoi(hi); err != nil {
+				return "", fmt.Errorf("invalid CPU list %q: %w", list, err)
+			}
+		}
+		if first < 0 || last < first {
+			return "", fmt.Errorf("invalid CPU range %q", part)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			mask.SetBit(mask, cpu, 1)
+		}
+	}
+	return mask.Text(16), nil
+}
+
+// pinContainer restricts the container with the given role to cpus.
+func pinContainer(name, role, cpus string) {
+	if cpus == "" {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s-%s: pinned to CPUs %s\n", name, role, cpus)
+	mustRun("lxc config set %s-%s limits.cpu %s", name, role, cpus)
+}
+
+// isolateIRQs steers the host hardware IRQs and the router receive packet
+// steering (RPS, which governs where softirq processing happens) to cpus.
+//
+// We stop irqbalance, which would otherwise undo our changes. Some IRQs
+// cannot be moved, and an unprivileged router cannot write RPS masks, so
+// we warn and continue rather than failing in these cases.
+func isolateIRQs(name, cpus string) {
+	mask := runtimex.LogFatalOnError1(cpuMask(cpus))
+	fmt.Fprintf(os.Stderr, "host IRQs and router softirqs: CPUs %s (mask %s)\n", cpus, mask)
+
+	run("sudo systemctl stop irqbalance")
+	script := fmt.Sprintf("for f in /proc/irq/*/smp_affinity_list; do echo %s > $f 2>/dev/null; done; true", cpus)
+	if err := run("%s", shellquote.Join("sudo", "sh", "-c", script)); err != nil {
+		log.Printf("warning: cannot set the host IRQ affinity: %s", err)
+	}
+
+	for _, dev := range []string{"eth1", "eth2"} {
+		script := fmt.Sprintf("for f in /sys/class/net/%s/queues/rx-*/rps_cpus; do echo %s > $f; done", dev, mask)
+		if err := run("%s", shellquote.Join("lxc", "exec", name+"-router", "--", "sh", "-c", script)); err != nil {
+			log.Printf("warning: cannot set the router %s RPS mask: %s", dev, err)
+		}
+	}
+}
+
+// netemPinMain is the main of the `lxs netem pin` command.
+func netemPinMain(ctx context.Context, args []string) error {
+	var (
+		clientFlag = ""
+		irqFlag    = ""
+		nameFlag   = "ocho"
+		routerFlag = ""
+		serverFlag = ""
+	)
+
+	fset := vflag.NewFlagSet("lxs netem pin", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&clientFlag, 0, "client", "Pin the client container to `CPUS` (e.g., 2-3).")
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&irqFlag, 0, "irq", "Move host IRQs and router softirqs to `CPUS` (e.g., 0).")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.StringVar(&routerFlag, 0, "router", "Pin the router container to `CPUS` (e.g., 1).")
+	fset.StringVar(&serverFlag, 0, "server", "Pin the server container to `CPUS` (e.g., 4-5).")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	if clientFlag == "" && irqFlag == "" && routerFlag == "" && serverFlag == "" {
+		failure.Exit(failure.Usage, errors.New("specify at least one of --client, --irq, --router, and --server"))
+	}
+
+	pinContainer(nameFlag, "client", clientFlag)
+	pinContainer(nameFlag, "router", routerFlag)
+	pinContainer(nameFlag, "server", serverFlag)
+	if irqFlag != "" {
+		isolateIRQs(nameFlag, irqFlag)
+	}
+	return nil
+}
+
+// netemUnpinMain is the main of the `lxs netem unpin` command.
+func netemUnpinMain(ctx context.Context, args []string) error {
+	var (
+		nameFlag = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs netem unpin", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	// Note: commands may fail if the containers were never pinned
+	for _, role := range []string{"client", "router", "server"} {
+		run("lxc config unset %s-%s limits.cpu", nameFlag, role)
+	}
+	isolateIRQs(nameFlag, fmt.Sprintf("0-%d", runtime.NumCPU()-1))
+	run("sudo systemctl start irqbalance")
+	return nil
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bassosimone/runtimex"
+)
+
+const (
+	// proxyAddr is the address of the optional proxy container, which sits
+	// on the same network as the server, behind the router.
+	proxyAddr = "192.168.1.3"
+
+	// proxyCertDir is the directory containing the proxy certificate.
+	proxyCertDir = "testdata/proxy"
+)
+
+// proxyHostname returns the hostname resolving to [proxyAddr] inside the
+// topology with the given name, like [serverHostname] does for the server.
+func proxyHostname(name string) string {
+	return fmt.Sprintf("proxy.%s.test", name)
+}
+
+// nginxConfig returns the nginx configuration of the proxy container.
+//
+// The proxy terminates TLS, offering HTTP/2 as well, and forwards to the
+// same port on the server over a new TLS connection, as common reverse
+// proxy deployments do. We disable buffering in both directions, which
+// would otherwise make the proxy absorb whole transfers, and we forward
+// WebSocket upgrades for ndt7 as well as the client address for ndt8.
+func nginxConfig() string {
+	return fmt.Sprintf(`map $http_upgrade $connection_upgrade {
+    default upgrade;
+    '' close;
+}
+
+server {
+    listen %[1]s:4443 ssl http2;
+    listen %[1]s:4567 ssl http2;
+
+    ssl_certificate /etc/nginx/provlima/cert.pem;
+    ssl_certificate_key /etc/nginx/provlima/key.pem;
+
+    client_max_body_size 0;
+    proxy_buffering off;
+    proxy_request_buffering off;
+    proxy_read_timeout 1h;
+    proxy_send_timeout 1h;
+
+    location / {
+        proxy_pass https://%[2]s:$server_port;
+        proxy_http_version 1.1;
+        proxy_ssl_verify off;
+        proxy_set_header Host $host;
+        proxy_set_header Upgrade $http_upgrade;
+        proxy_set_header Connection $connection_upgrade;
+        proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+    }
+}
+`, proxyAddr, serverAddr)
+}
+
+// createProxy creates the proxy container and provisions nginx using
+// its own certificate, which the clients use to verify the proxy.
+func createProxy(name string) {
+	mustRun("lxc launch images:debian/bookworm %s-proxy", name)
+	mustRun("lxc network attach %s-right %s-proxy eth1", name, name)
+
+	mustRun("lxc exec %s-proxy -- ip addr add %s/24 dev eth1", name, proxyAddr)
+	mustRun("lxc exec %s-proxy -- ip link set eth1 up", name)
+	mustRun("lxc exec %s-proxy -- ip route add 192.168.0.0/24 via 192.168.1.1", name)
+
+	mustRun("lxc exec %s-proxy -- apt update", name)
+	mustRun("lxc exec %s-proxy --env DEBIAN_FRONTEND=noninteractive -- apt install -y nginx", name)
+
+	mustRun("go build -v ./cmd/gencert")
+	mustRun("./gencert -o %s --ip-addr %s --dns-name %s", proxyCertDir, proxyAddr, proxyHostname(name))
+
+	confPath := filepath.Join(proxyCertDir, "nginx.conf")
+	runtimex.LogFatalOnError0(os.WriteFile(confPath, []byte(nginxConfig()), 0600))
+
+	mustRun("lxc exec %s-proxy -- mkdir -p /etc/nginx/provlima", name)
+	mustRun("lxc file push %s/cert.pem %s-proxy/etc/nginx/provlima/", proxyCertDir, name)
+	mustRun("lxc file push %s/key.pem %s-proxy/etc/nginx/provlima/", proxyCertDir, name)
+	mustRun("lxc file push %s %s-proxy/etc/nginx/conf.d/%s.conf", confPath, name, name)
+	mustRun("lxc exec %s-proxy -- nginx -t", name)
+	mustRun("lxc exec %s-proxy -- systemctl restart nginx", name)
+}
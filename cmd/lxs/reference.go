@@ -0,0 +1,331 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+	"github.com/kballard/go-shellquote"
+)
+
+// msakPort is the port where the msak-server listens for wss connections,
+// which differs from the ndt8 one (4443), the msak-server default.
+const msakPort = "4444"
+
+// referenceTool is an official client we compare our clients with.
+type referenceTool struct {
+	// packages contains the Go packages to install, the first of which
+	// is the client, while the others are servers we need.
+	packages []string
+
+	// protocol is our protocol to compare with (e.g., "ndt7").
+	protocol string
+
+	// command is the command measuring inside the client container.
+	command string
+
+	// start starts the servers we need, if any.
+	start func(name string)
+
+	// stop stops the servers started by start, if any.
+	stop func(name string)
+
+	// parse extracts the summary from the standard output of command.
+	parse func(data []byte) (*results.Summary, error)
+}
+
+// referenceTools contains the [referenceTool] by name.
+var referenceTools = map[string]referenceTool{
+	// The ndt7 reference client speaks our ndt7 server protocol.
+	"ndt7-client": {
+		packages: []string{"github.com/m-lab/ndt7-client-go/cmd/ndt7-client"},
+		protocol: "ndt7",
+		command:  fmt.Sprintf("/root/ndt7-client -server %s:4567 -format json", serverAddr),
+		parse:    parseNDT7Client,
+	},
+
+	// Our servers do not speak the msak throughput1 protocol, so we run the
+	// reference msak-server alongside them and compare with ndt8, which,
+	// like msak, is meant to replace ndt7.
+	"msak": {
+		packages: []string{
+			"github.com/m-lab/msak/cmd/msak-client",
+			"github.com/m-lab/msak/cmd/msak-server",
+		},
+		protocol: "ndt8",
+		command:  fmt.Sprintf("/root/msak-client -server %s:%s", serverAddr, msakPort),
+		start:    startMSAKServer,
+		stop:     stopMSAKServer,
+		parse:    parseMSAKClient,
+	},
+}
+
+// startMSAKServer starts the msak-server in the background inside the server
+// container, using the certificate installed by `lxs serve`.
+func startMSAKServer(name string) {
+	mustRun("lxc file push msak-server %s-server/root/", name)
+	stopMSAKServer(name)
+	script := fmt.Sprintf("cd /root && nohup /root/msak-server -wss_addr %s:%s -ws_addr %s:8081 "+
+		"-cert /root/cert.pem -key /root/key.pem </dev/null >/root/msak-server.log 2>&1 & sleep 1",
+		serverAddr, msakPort, serverAddr)
+	mustRun("%s", shellquote.Join("lxc", "exec", fmt.Sprintf("%s-server", name), "--", "sh", "-c", script))
+}
+
+// stopMSAKServer stops the msak-server, ignoring errors, since it may not
+// be running.
+func stopMSAKServer(name string) {
+	run("lxc exec %s-server -- pkill -x msak-server", name)
+}
+
+// bitsPerSecond converts a value expressed using unit (e.g., "Mbit/s")
+// to bit/s, returning an error for unknown units.
+func bitsPerSecond(value float64, unit string) (float64, error) {
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "bit/s":
+		return value, nil
+	case "kbit/s":
+		return value * 1e3, nil
+	case "mbit/s", "mb/s":
+		return value * 1e6, nil
+	case "gbit/s", "gb/s":
+		return value * 1e9, nil
+	default:
+		return 0, fmt.Errorf("unknown throughput unit %q", unit)
+	}
+}
+
+// ndt7ClientValue is a value along with its unit in the ndt7-client output.
+type ndt7ClientValue struct {
+	Value float64
+	Unit  string
+}
+
+// ndt7ClientSubtest is the summary of a direction in the ndt7-client output.
+type ndt7ClientSubtest struct {
+	Throughput     ndt7ClientValue
+	Latency        ndt7ClientValue
+	Retransmission ndt7ClientValue
+}
+
+// direction converts s to a [*results.DirectionSummary].
+func (s *ndt7ClientSubtest) direction() (*results.DirectionSummary, error) {
+	if s == nil {
+		return nil, nil
+	}
+	throughput, err := bitsPerSecond(s.Throughput.Value, s.Throughput.Unit)
+	if err != nil {
+		return nil, err
+	}
+	ds := &results.DirectionSummary{
+		Throughput:     throughput,
+		MinRTT:         time.Duration(s.Latency.Value * float64(time.Millisecond)),
+		RetransmitRate: s.Retransmission.Value / 100,
+	}
+	return ds, nil
+}
+
+// parseNDT7Client parses the summary the ndt7-client prints last when
+// using `-format json`, after one JSON object per event.
+func parseNDT7Client(data []byte) (*results.Summary, error) {
+	var summary *results.Summary
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var line struct {
+			Download *ndt7ClientSubtest
+			Upload   *ndt7ClientSubtest
+		}
+		if json.Unmarshal(scanner.Bytes(), &line) != nil || (line.Download == nil && line.Upload == nil) {
+			continue // an event rather than the summary
+		}
+		download, err := line.Download.direction()
+		if err != nil {
+			return nil, err
+		}
+		upload, err := line.Upload.direction()
+		if err != nil {
+			return nil, err
+		}
+		summary = &results.Summary{Download: download, Upload: upload}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if summary == nil {
+		return nil, errors.New("cannot find the summary in the ndt7-client output")
+	}
+	return summary, nil
+}
+
+// msakRate matches the rates in the human readable msak-client output,
+// e.g., "Download rate: 93.12 Mb/s", of which we use the last.
+var msakRate = regexp.MustCompile(`(?im)^\s*(download|upload)\b[^\n]*?([0-9.]+)\s*(mb/s|mbit/s)`)
+
+// parseMSAKClient parses the rates the msak-client prints.
+func parseMSAKClient(data []byte) (*results.Summary, error) {
+	summary := &results.Summary{}
+	for _, match := range msakRate.FindAllSubmatch(data, -1) {
+		value, err := strconv.ParseFloat(string(match[2]), 64)
+		if err != nil {
+			continue
+		}
+		throughput, err := bitsPerSecond(value, string(match[3]))
+		if err != nil {
+			return nil, err
+		}
+		ds := &results.DirectionSummary{Throughput: throughput}
+		switch strings.ToLower(string(match[1])) {
+		case "download":
+			summary.Download = ds
+		case "upload":
+			summary.Upload = ds
+		}
+	}
+	if summary.Download == nil && summary.Upload == nil {
+		return nil, errors.New("cannot find the rates in the msak-client output")
+	}
+	return summary, nil
+}
+
+// referenceDocument returns a result document containing the summary of
+// the given tool, so that `lxs experiment export` handles the reference
+// measurements like ours.
+func referenceDocument(name, tool string, labels []string, summary *results.Summary) *results.Document {
+	doc := &results.Document{
+		SchemaVersion: results.SchemaVersion,
+		Protocol:      tool,
+		Status:        results.StatusComplete,
+		Summary:       summary,
+		Samples:       []results.Sample{},
+	}
+	if argv := netemArgv(name); len(argv) == 2 {
+		doc.Netem = runtimex.LogFatalOnError1(results.ParseNetem(argv[1]))
+	}
+	if len(labels) > 0 {
+		doc.Labels = runtimex.LogFatalOnError1(results.ParseLabels(labels))
+	}
+	return doc
+}
+
+func measureReferenceMain(ctx context.Context, args []string) error {
+	var (
+		labelFlag     = []string{}
+		nameFlag      = "ocho"
+		outputFlag    = ""
+		toleranceFlag = 0.25
+		toolFlag      = "ndt7-client"
+		versionFlag   = "latest"
+	)
+
+	fset := vflag.NewFlagSet("lxs measure reference", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringSliceVar(&labelFlag, 0, "label", "Tag the result documents with `KEY=VALUE` (repeatable).")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.StringVar(&outputFlag, 'o', "output", "Write the result documents to `DIR` (default: testdata/reference-NAME).")
+	fset.Float64Var(&toleranceFlag, 0, "tolerance", "Flag our client diverging from the reference by more than `FRACTION` (e.g., 0.25).")
+	fset.StringVar(&toolFlag, 0, "tool", "Compare with the reference `TOOL` (ndt7-client or msak).")
+	fset.StringVar(&versionFlag, 0, "version", "Install `VERSION` of the reference tool (a module version, e.g., latest).")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+	tool, found := referenceTools[toolFlag]
+	if !found {
+		failure.Exit(failure.Usage, fmt.Errorf("unknown tool: %s", toolFlag))
+	}
+	if toleranceFlag < 0 {
+		failure.Exit(failure.Usage, errors.New("--tolerance must be non-negative"))
+	}
+	if outputFlag == "" {
+		outputFlag = filepath.Join("testdata", fmt.Sprintf("reference-%s", nameFlag))
+	}
+	labels := labelArgv(labelFlag)
+	collectDiagnosticsOnFailure(nameFlag)
+
+	// Build statically, since the containers may lack the host libc.
+	cwd := runtimex.LogFatalOnError1(os.Getwd())
+	for _, pkg := range tool.packages {
+		mustRun("env CGO_ENABLED=0 GOBIN=%s go install %s@%s", cwd, pkg, versionFlag)
+	}
+	client := filepath.Base(tool.packages[0])
+
+	// Note: this requires `lxs serve ndt7 --detach` or `lxs serve ndt8 --detach`
+	mustRun("go build -v ./cmd/%s", tool.protocol)
+	mustRun("lxc file push testdata/cert.pem %s-client/root/", nameFlag)
+	mustRun("lxc file push %s %s-client/root/", tool.protocol, nameFlag)
+	mustRun("lxc file push %s %s-client/root/", client, nameFlag)
+	runtimex.LogFatalOnError0(os.MkdirAll(outputFlag, 0700))
+
+	// Measure with our client first, then with the reference one, under
+	// the same network emulation.
+	command := fmt.Sprintf("/root/%s measure -A %s", tool.protocol, serverAddr)
+	if tool.protocol == "ndt8" {
+		command += " --cert cert.pem"
+	}
+	ours := clientDocument(nameFlag, tool.protocol+".json", command, labels)
+	runtimex.LogFatalOnError0(results.WriteFile(filepath.Join(outputFlag, tool.protocol+".json"), ours))
+
+	if tool.start != nil {
+		tool.start(nameFlag)
+	}
+	// The reference clients use the system roots, which we override to
+	// trust the certificate generated by `lxs serve`.
+	data, err := output("lxc exec %s-client --env SSL_CERT_FILE=/root/cert.pem -- %s", nameFlag, tool.command)
+	if tool.stop != nil {
+		tool.stop(nameFlag)
+	}
+	rawPath := filepath.Join(outputFlag, toolFlag+".out")
+	runtimex.LogFatalOnError0(os.WriteFile(rawPath, data, 0600))
+	failure.OnError(failure.Connectivity, err)
+	summary, err := tool.parse(data)
+	if err != nil {
+		failure.Exit(failure.Protocol, fmt.Errorf("%w (see %s)", err, rawPath))
+	}
+	reference := referenceDocument(nameFlag, toolFlag, labelFlag, summary)
+	runtimex.LogFatalOnError0(results.WriteFile(filepath.Join(outputFlag, toolFlag+".json"), reference))
+
+	ref, row := documentRow(toolFlag, reference), documentRow(tool.protocol, ours)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "\nCLIENT\tDOWNLOAD\tUPLOAD\tVERDICT\n")
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", ref.protocol,
+		humanize.SI(ref.download, "bit/s"), humanize.SI(ref.upload, "bit/s"), "reference")
+	// Only compare the directions the reference measured.
+	var diverging []string
+	if summary.Download != nil && divergence(row.download, ref.download) > toleranceFlag {
+		diverging = append(diverging, "download")
+	}
+	if summary.Upload != nil && divergence(row.upload, ref.upload) > toleranceFlag {
+		diverging = append(diverging, "upload")
+	}
+	verdict := "ok"
+	if len(diverging) > 0 {
+		verdict = "diverges"
+	}
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", row.protocol,
+		humanize.SI(row.download, "bit/s"), humanize.SI(row.upload, "bit/s"), verdict)
+	runtimex.LogFatalOnError0(tw.Flush())
+
+	if len(diverging) > 0 {
+		failure.Exit(failure.Threshold, fmt.Errorf("%s diverges from %s by more than %.0f%% (%s)",
+			tool.protocol, toolFlag, toleranceFlag*100, strings.Join(diverging, ", ")))
+	}
+	return nil
+}
@@ -3,16 +3,23 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
 	"github.com/bassosimone/runtimex"
 	"github.com/kballard/go-shellquote"
 )
 
 func run(format string, args ...any) error {
-	cmdline := fmt.Sprintf(format, args...)
+	return runCommand(fmt.Sprintf(format, args...), os.Stderr)
+}
+
+// runCommand runs cmdline writing its stderr to the given writer.
+func runCommand(cmdline string, stderr io.Writer) error {
 	argv, err := shellquote.Split(cmdline)
 	if err != nil {
 		return err
@@ -23,11 +30,43 @@ func run(format string, args ...any) error {
 	cmd := exec.Command(argv[0], argv[1:]...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stderr = stderr
 
 	return cmd.Run()
 }
 
+// mustRun is like [run] but exits on failure. When the command exits with
+// a nonzero code, we exit with the same code, such that the failure class
+// of the tools running inside the containers (see [failure.Class]) is
+// also visible to the scripts driving lxs. Before exiting, we collect a
+// diagnostics bundle, if enabled (see [collectDiagnosticsOnFailure]).
 func mustRun(format string, args ...any) {
-	runtimex.LogFatalOnError0(run(format, args...))
+	cmdline := fmt.Sprintf(format, args...)
+	var tail stderrTail
+	err := runCommand(cmdline, io.MultiWriter(os.Stderr, &tail))
+	if err != nil {
+		collectDiagnostics(cmdline, err, tail.data)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		failure.Exit(failure.Class(exitErr.ExitCode()), err)
+	}
+	failure.OnError(failure.Generic, err)
+}
+
+// output is like [run] but returns the standard output of the command.
+func output(format string, args ...any) ([]byte, error) {
+	cmdline := fmt.Sprintf(format, args...)
+	argv, err := shellquote.Split(cmdline)
+	if err != nil {
+		return nil, err
+	}
+	runtimex.Assert(len(argv) > 0)
+	fmt.Fprintf(os.Stderr, "+ %s\n", cmdline)
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	return cmd.Output()
 }
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// schedule describes how the policy of a template changes over time,
+// emulating links whose latency is not constant.
+//
+// The starlink schedule reproduces the LEO satellite handovers: Starlink
+// reassigns the serving satellite every 15 seconds, at 12, 27, 42, and
+// 57 seconds past the minute, and each handover changes the base latency
+// and briefly interrupts the traffic.
+type schedule struct {
+	// every is the interval between steps.
+	every time.Duration
+
+	// phase is the offset of the steps from the multiples of every,
+	// which aligns the steps with the wall clock.
+	phase time.Duration
+
+	// delays contains the one-way delays that the steps cycle through.
+	delays []string
+
+	// outage is how long each step blackholes the traffic, if at all.
+	outage time.Duration
+}
+
+// starlinkSchedule is the [schedule] of the starlink templates.
+var starlinkSchedule = schedule{
+	every:  15 * time.Second,
+	phase:  12 * time.Second,
+	delays: []string{"20ms", "27ms", "18ms", "33ms", "23ms", "25ms"},
+	outage: 100 * time.Millisecond,
+}
+
+// schedules maps templates to their [schedule], if any.
+var schedules = map[string]schedule{
+	"starlink":         starlinkSchedule,
+	"starlink-bloated": starlinkSchedule,
+}
+
+// next returns the time of the first step after now.
+func (s schedule) next(now time.Time) time.Time {
+	next := now.Truncate(s.every).Add(s.phase % s.every)
+	for !next.After(now) {
+		next = next.Add(s.every)
+	}
+	return next
+}
+
+// changeNetem changes in place the netem qdiscs that [applyNetem] installed,
+// which leaves the TBF qdiscs, and the packets they queued, untouched.
+func changeNetem(name string, p policy) {
+	mustRun("lxc exec %s-router -- tc qdisc change dev eth1 root handle 1: netem %s", name, p.netemArgs())
+	mustRun("lxc exec %s-router -- tc qdisc change dev eth2 root handle 1: netem %s", name, p.netemArgs())
+}
+
+// followSchedule applies the steps of s to p until ctx is done and then
+// restores p. During an outage, netem drops every packet.
+func followSchedule(ctx context.Context, name string, p policy, s schedule) {
+	defer changeNetem(name, p)
+	fmt.Fprintf(os.Stderr, "\nfollowing the schedule every %s, press Ctrl-C to stop\n", s.every)
+	for step := 0; ; step++ {
+		if !sleepContext(ctx, time.Until(s.next(time.Now()))) {
+			return
+		}
+		current := p
+		current.delay = s.delays[step%len(s.delays)]
+		if s.outage > 0 {
+			down := current
+			down.loss = "100%"
+			fmt.Fprintf(os.Stderr, "%s: outage for %s\n", time.Now().Format(time.TimeOnly), s.outage)
+			changeNetem(name, down)
+			if !sleepContext(ctx, s.outage) {
+				return
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s: one-way delay %s\n", time.Now().Format(time.TimeOnly), current.delay)
+		changeNetem(name, current)
+	}
+}
+
+// sleepContext sleeps for d and returns true, or returns false as soon as
+// ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,24 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// installService installs and (re)starts a server inside the server container
+// as a systemd service, using the unit generated by --print-systemd-unit with
+// the given serveArgv, which therefore become the service configuration.
+func installService(name, service string, serveArgv []string) {
+	unitPath := fmt.Sprintf("/etc/systemd/system/%s.service", service)
+	script := fmt.Sprintf("cd /root && %s --print-systemd-unit > %s",
+		shellquote.Join(serveArgv...), unitPath)
+	mustRun("%s", shellquote.Join("lxc", "exec", fmt.Sprintf("%s-server", name), "--", "sh", "-c", script))
+
+	mustRun("lxc exec %s-server -- systemctl daemon-reload", name)
+	mustRun("lxc exec %s-server -- systemctl enable %s", name, service)
+	mustRun("lxc exec %s-server -- systemctl restart %s", name, service)
+	mustRun("lxc exec %s-server -- systemctl --no-pager status %s", name, service)
+}
@@ -0,0 +1,317 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+	"github.com/kballard/go-shellquote"
+)
+
+// debugAddr is where `lxs serve ndt8` serves the debug endpoints inside
+// the server container, which is only reachable using `lxc exec`.
+const debugAddr = "127.0.0.1:6060"
+
+const (
+	// soakSettle is how long we wait after the clients stop before taking
+	// the final sample, to let the server close the connections.
+	soakSettle = 10 * time.Second
+
+	// soakGoroutineSlack is how many more goroutines than before the soak
+	// we tolerate at the end, which accounts for the idle connections and
+	// for the goroutines the runtime starts lazily.
+	soakGoroutineSlack = 20
+
+	// soakAbortWithin bounds when we interrupt the measurements we abort.
+	soakAbortWithin = 5 * time.Second
+
+	// soakFDSlack is how many more open file descriptors than before the
+	// soak we tolerate at the end, which accounts for the idle connections.
+	soakFDSlack = 20
+)
+
+// soakSample is a snapshot of the resources used by the ndt8 server during
+// a soak test, along with the progress of the clients.
+type soakSample struct {
+	// Time is when we took the sample.
+	Time time.Time `json:"time"`
+
+	// Goroutines is the number of goroutines of the server.
+	Goroutines int `json:"goroutines"`
+
+	// FDs is the number of open file descriptors of the server, or -1
+	// when the server cannot count them.
+	FDs int `json:"fds"`
+
+	// Sessions is the number of live sessions.
+	Sessions int `json:"sessions"`
+
+	// Tombstones is the number of deleted sessions the server remembers.
+	Tombstones int `json:"tombstones"`
+
+	// HeapAlloc is the size of the allocated heap objects in bytes.
+	HeapAlloc uint64 `json:"heapAlloc"`
+
+	// Sys is the memory obtained from the OS in bytes.
+	Sys uint64 `json:"sys"`
+
+	// NumGC is the number of completed GC cycles.
+	NumGC uint32 `json:"numGC"`
+
+	// Runs is the number of measurements the clients completed so far.
+	Runs int64 `json:"runs"`
+
+	// Failures is the number of measurements that failed so far.
+	Failures int64 `json:"failures"`
+
+	// Aborts is the number of measurements we interrupted so far.
+	Aborts int64 `json:"aborts"`
+}
+
+// soakCounters counts the measurements of the soak clients.
+type soakCounters struct {
+	runs     atomic.Int64
+	failures atomic.Int64
+	aborts   atomic.Int64
+}
+
+// fill copies the counters into s.
+func (sc *soakCounters) fill(s *soakSample) {
+	s.Runs, s.Failures, s.Aborts = sc.runs.Load(), sc.failures.Load(), sc.aborts.Load()
+}
+
+// soakPath returns the path of the NDJSON file where we append the samples
+// of the soak test of the topology with the given name.
+func soakPath(name string) string {
+	return filepath.Join("testdata", fmt.Sprintf("soak-%s.ndjson", name))
+}
+
+// fetchSoakSample fetches the expvar variables of the ndt8 server running in
+// the server container of the topology with the given name.
+func fetchSoakSample(name string) (*soakSample, error) {
+	data, err := output("lxc exec %s-server -- curl -fsS http://%s/debug/vars", name, debugAddr)
+	if err != nil {
+		return nil, err
+	}
+	var vars struct {
+		Memstats struct {
+			HeapAlloc uint64 `json:"HeapAlloc"`
+			Sys       uint64 `json:"Sys"`
+			NumGC     uint32 `json:"NumGC"`
+		} `json:"memstats"`
+		NDT8 *struct {
+			FDs        *int `json:"fds"`
+			Goroutines int  `json:"goroutines"`
+			Sessions   int  `json:"sessions"`
+			Tombstones int  `json:"tombstones"`
+		} `json:"ndt8"`
+	}
+	if err := json.Unmarshal(data, &vars); err != nil {
+		return nil, err
+	}
+	if vars.NDT8 == nil {
+		return nil, fmt.Errorf("no ndt8 variables at http://%s/debug/vars", debugAddr)
+	}
+	fds := -1
+	if vars.NDT8.FDs != nil {
+		fds = *vars.NDT8.FDs
+	}
+	return &soakSample{
+		Time:       time.Now(),
+		Goroutines: vars.NDT8.Goroutines,
+		FDs:        fds,
+		Sessions:   vars.NDT8.Sessions,
+		Tombstones: vars.NDT8.Tombstones,
+		HeapAlloc:  vars.Memstats.HeapAlloc,
+		Sys:        vars.Memstats.Sys,
+		NumGC:      vars.Memstats.NumGC,
+	}, nil
+}
+
+// print prints a one-line summary of s.
+func (s *soakSample) print() {
+	fmt.Fprintf(os.Stderr, "soak: goroutines=%d fds=%d sessions=%d tombstones=%d heap=%s sys=%s gc=%d runs=%d failures=%d aborts=%d\n",
+		s.Goroutines, s.FDs, s.Sessions, s.Tombstones, humanize.IEC(float64(s.HeapAlloc), "B"),
+		humanize.IEC(float64(s.Sys), "B"), s.NumGC, s.Runs, s.Failures, s.Aborts)
+}
+
+// appendSoakSample appends s as a line to the NDJSON file at path.
+func appendSoakSample(path string, s *soakSample) {
+	line := runtimex.LogFatalOnError1(json.Marshal(s))
+	fp := runtimex.LogFatalOnError1(os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600))
+	_, err := fp.Write(append(line, '\n'))
+	runtimex.LogFatalOnError0(err)
+	runtimex.LogFatalOnError0(fp.Close())
+}
+
+// soakClient measures from the client container in a loop until ctx is
+// done, counting the completed and the failed measurements. When abortEvery
+// is positive, we interrupt every abortEvery-th measurement at a random time
+// within [soakAbortWithin], which exercises the abort path of the server.
+//
+// Note: we discard the client logs, since many clients running at once
+// would make them unreadable, and only print the failures. We also let
+// the last measurement finish, since interrupting it could leave behind
+// a session, which we would mistake for a leak.
+func soakClient(ctx context.Context, id int, argv []string, abortEvery int, counters *soakCounters) {
+	for run := 1; ctx.Err() == nil; run++ {
+		cmd := exec.Command(argv[0], argv[1:]...)
+		if abortEvery > 0 && run%abortEvery == 0 {
+			if err := cmd.Start(); err != nil {
+				counters.failures.Add(1)
+				fmt.Fprintf(os.Stderr, "soak: client %d: cannot start measurement: %s\n", id, err)
+				continue
+			}
+			timer := time.AfterFunc(rand.N(soakAbortWithin), func() { cmd.Process.Signal(os.Interrupt) })
+			cmd.Wait() // the exit code depends on when we interrupted it
+			timer.Stop()
+			counters.aborts.Add(1)
+			continue
+		}
+		if err := cmd.Run(); err != nil {
+			counters.failures.Add(1)
+			fmt.Fprintf(os.Stderr, "soak: client %d: measurement failed: %s\n", id, err)
+			continue
+		}
+		counters.runs.Add(1)
+	}
+}
+
+// soakMain is the main of `lxs soak`, which measures continuously from
+// many concurrent clients and samples the resources of the ndt8 server
+// to detect session and goroutine leaks under sustained load.
+//
+// Note: this requires `lxs serve ndt8 --detach` to be running.
+func soakMain(ctx context.Context, args []string) error {
+	var (
+		abortEveryFlag  = 0
+		clientsFlag     = 8
+		durationFlag    = 24 * time.Hour
+		errorFormatFlag = "text"
+		intervalFlag    = time.Minute
+		nameFlag        = "ocho"
+		resumeTLSFlag   = false
+	)
+
+	fset := vflag.NewFlagSet("lxs soak", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.IntVar(&abortEveryFlag, 0, "abort-every", "Interrupt every `N`-th measurement of each client (0 to never interrupt).")
+	fset.IntVar(&clientsFlag, 'c', "clients", "Run `N` clients at once.")
+	fset.DurationVar(&durationFlag, 'd', "duration", "Keep measuring for `DURATION`.")
+	fset.StringVar(&errorFormatFlag, 0, "error-format", "Use `FORMAT` for the final error, if any (text or json).")
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.DurationVar(&intervalFlag, 'i', "interval", "Sample the server resources every `DURATION`.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.BoolVar(&resumeTLSFlag, 0, "resume-tls", "Let each client resume the TLS sessions of its previous measurements.")
+	fset.MaxPositionalArgs = math.MaxInt
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+	failure.Setup(errorFormatFlag)
+
+	if clientsFlag <= 0 || durationFlag <= 0 || intervalFlag <= 0 {
+		failure.Exit(failure.Usage, errors.New("--clients, --duration, and --interval must be positive"))
+	}
+	if abortEveryFlag < 0 {
+		failure.Exit(failure.Usage, errors.New("--abort-every must not be negative"))
+	}
+	collectDiagnosticsOnFailure(nameFlag)
+
+	mustRun("go build -v ./cmd/ndt8")
+	mustRun("lxc file push testdata/cert.pem %s-client/root/", nameFlag)
+	mustRun("lxc file push ndt8 %s-client/root/", nameFlag)
+	mustRun("lxc exec %s-server -- apt update", nameFlag)
+	mustRun("lxc exec %s-server --env DEBIAN_FRONTEND=noninteractive -- apt install -y curl", nameFlag)
+
+	path := soakPath(nameFlag)
+	runtimex.LogFatalOnError0(os.MkdirAll("testdata", 0700))
+	first, err := fetchSoakSample(nameFlag)
+	failure.OnError(failure.Generic, err)
+	appendSoakSample(path, first)
+	first.print()
+
+	// The positional arguments are additional `ndt8 measure` flags.
+	cmdArgv := []string{
+		"lxc",
+		"exec",
+		fmt.Sprintf("%s-client", nameFlag),
+		"--",
+		"/root/ndt8",
+		"measure",
+		"-A",
+		serverAddr,
+		"--cert",
+		"cert.pem",
+	}
+	cmdArgv = append(cmdArgv, fset.Args()...)
+	fmt.Fprintf(os.Stderr, "+ %s\n", shellquote.Join(cmdArgv...))
+
+	soakCtx, cancel := context.WithTimeout(ctx, durationFlag)
+	defer cancel()
+	var (
+		counters soakCounters
+		wg       sync.WaitGroup
+	)
+	for id := range clientsFlag {
+		argv := slices.Clone(cmdArgv)
+		if resumeTLSFlag {
+			argv = append(argv, "--tls-cache", fmt.Sprintf("soak-%d.tls.json", id))
+		}
+		wg.Go(func() { soakClient(soakCtx, id, argv, abortEveryFlag, &counters) })
+	}
+
+	ticker := time.NewTicker(intervalFlag)
+	defer ticker.Stop()
+	for soakCtx.Err() == nil {
+		select {
+		case <-soakCtx.Done():
+		case <-ticker.C:
+			sample, err := fetchSoakSample(nameFlag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "soak: cannot sample the server: %s\n", err)
+				continue
+			}
+			counters.fill(sample)
+			appendSoakSample(path, sample)
+			sample.print()
+		}
+	}
+	wg.Wait()
+
+	// Once the clients stopped, the server should be back where it started.
+	time.Sleep(soakSettle)
+	last, err := fetchSoakSample(nameFlag)
+	failure.OnError(failure.Generic, err)
+	counters.fill(last)
+	appendSoakSample(path, last)
+	last.print()
+	fmt.Fprintf(os.Stderr, "soak: samples written to %s\n", path)
+
+	if last.Sessions > 0 {
+		failure.Exit(failure.Threshold, fmt.Errorf("%d sessions still alive after the clients stopped", last.Sessions))
+	}
+	if last.Goroutines > first.Goroutines+soakGoroutineSlack {
+		failure.Exit(failure.Threshold, fmt.Errorf("goroutines grew from %d to %d", first.Goroutines, last.Goroutines))
+	}
+	if first.FDs >= 0 && last.FDs > first.FDs+soakFDSlack {
+		failure.Exit(failure.Threshold, fmt.Errorf("open file descriptors grew from %d to %d", first.FDs, last.FDs))
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+	"github.com/kballard/go-shellquote"
+)
+
+// splitChain is the iptables nat chain redirecting the split connections.
+const splitChain = "lxs-split"
+
+// splitUnit returns the name of the systemd unit running the router-side
+// proxy for the given port.
+func splitUnit(port int) string {
+	return fmt.Sprintf("lxs-split-%d.service", port)
+}
+
+// parsePorts parses a comma-separated list of TCP ports.
+func parsePorts(list string) ([]int, error) {
+	var ports []int
+	for part := range strings.SplitSeq(list, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q in %q", part, list)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// applySplit makes the router split the TCP connections from the client
+// to the given server ports, like performance enhancing proxies (PEPs) on
+// satellite links and enterprise middleboxes do.
+//
+// We redirect the connections entering from the client side to socat
+// listening on the router, which connects to the server on its own. Each
+// half has its own congestion control loop and the router acknowledges
+// data before the other end receives it, so the sender-side view of the
+// transfer may not reflect the end-to-end path. The server sees the router
+// as the client, and the netem rules still apply to both halves.
+func applySplit(name string, ports []int) {
+	clearSplit(name)
+
+	mustRun("lxc exec %s-router -- apt update", name)
+	mustRun("lxc exec %s-router --env DEBIAN_FRONTEND=noninteractive -- apt install -y iptables socat", name)
+
+	mustRun("lxc exec %s-router -- iptables -t nat -N %s", name, splitChain)
+	mustRun("lxc exec %s-router -- iptables -t nat -A PREROUTING -i eth1 -j %s", name, splitChain)
+	for _, port := range ports {
+		fmt.Fprintf(os.Stderr, "router: splitting connections to %s:%d\n", serverAddr, port)
+		mustRun("lxc exec %s-router -- iptables -t nat -A %s -p tcp -d %s --dport %d -j REDIRECT --to-ports %d",
+			name, splitChain, serverAddr, port, port)
+		mustRun("%s", shellquote.Join("lxc", "exec", name+"-router", "--",
+			"systemd-run", "--unit", splitUnit(port), "socat",
+			fmt.Sprintf("TCP-LISTEN:%d,fork,reuseaddr", port),
+			fmt.Sprintf("TCP:%s:%d", serverAddr, port)))
+	}
+}
+
+// clearSplit stops splitting TCP connections on the router, ignoring errors.
+func clearSplit(name string) {
+	fmt.Fprintf(os.Stderr, "clearing: %s-router split connections\n", name)
+	// Note: commands may fail if connections had never been split
+	run("lxc exec %s-router -- iptables -t nat -D PREROUTING -i eth1 -j %s", name, splitChain)
+	run("lxc exec %s-router -- iptables -t nat -F %s", name, splitChain)
+	run("lxc exec %s-router -- iptables -t nat -X %s", name, splitChain)
+	run("lxc exec %s-router -- systemctl stop lxs-split-*.service", name)
+}
+
+// netemSplitMain is the main of the `lxs netem split` command.
+func netemSplitMain(ctx context.Context, args []string) error {
+	var (
+		nameFlag  = "ocho"
+		portsFlag = "4443,4567,5201"
+	)
+
+	fset := vflag.NewFlagSet("lxs netem split", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.StringVar(&portsFlag, 'p', "ports", "Split connections to the comma-separated server `PORTS`.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	ports, err := parsePorts(portsFlag)
+	failure.OnError(failure.Usage, err)
+
+	applySplit(nameFlag, ports)
+	return nil
+}
+
+// netemUnsplitMain is the main of the `lxs netem unsplit` command.
+func netemUnsplitMain(ctx context.Context, args []string) error {
+	var (
+		nameFlag = "ocho"
+	)
+
+	fset := vflag.NewFlagSet("lxs netem unsplit", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	clearSplit(nameFlag)
+	return nil
+}
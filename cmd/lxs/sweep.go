@@ -0,0 +1,431 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+)
+
+// checkpointFile is the name of the checkpoint within the output directory.
+const checkpointFile = "checkpoint.json"
+
+// checkpoint describes an experiment sweeping profiles, protocols, and
+// repetitions, along with the cells we completed so far, which allows
+// `lxs experiment resume` to skip them.
+type checkpoint struct {
+	// Name is the name of the LXC resources.
+	Name string `json:"name"`
+
+	// Profiles contains the network emulation profiles.
+	Profiles []string `json:"profiles"`
+
+	// Protocols contains the protocols (ndt7 or ndt8).
+	Protocols []string `json:"protocols"`
+
+	// Repetitions is the number of measurements of each profile and protocol.
+	Repetitions int `json:"repetitions"`
+
+	// Argv contains the flags we pass to the clients.
+	Argv []string `json:"argv,omitempty"`
+
+	// Parallel is the number of topologies running cells in parallel
+	// (see [checkpoint.topologies]), where zero means one.
+	Parallel int `json:"parallel,omitempty"`
+
+	// Seed is the seed from which we derive the seed of each cell
+	// (see [cell.seed]), where zero means random choices.
+	Seed int64 `json:"seed,omitempty"`
+
+	// Cells maps the cells we ran (see [cell.id]) to their outcome.
+	Cells map[string]cellOutcome `json:"cells"`
+}
+
+// cellOutcome is the outcome of a cell of the [checkpoint].
+type cellOutcome struct {
+	// Time is when the cell completed.
+	Time time.Time `json:"time"`
+
+	// Failure is the error that occurred, if any, in which case we run
+	// the cell again when resuming.
+	Failure string `json:"failure,omitempty"`
+
+	// Topology is the name of the topology that ran the cell.
+	Topology string `json:"topology,omitempty"`
+}
+
+// cell is a measurement of the experiment.
+type cell struct {
+	profile    string
+	protocol   string
+	repetition int
+}
+
+// id returns the ID of the cell, which also names its result document.
+func (c cell) id() string {
+	return fmt.Sprintf("%s-%s-%d", c.profile, c.protocol, c.repetition)
+}
+
+// seed returns the seed of the cell derived from the seed of the
+// experiment, which is zero when the experiment seed is zero. Each cell
+// has its own seed, so that the repetitions are not identical, yet
+// resuming replays the same choices. The result is below 2^53, which
+// JSON consumers parsing numbers as doubles represent exactly.
+func (c cell) seed(experiment int64) int64 {
+	if experiment == 0 {
+		return 0
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "%d/%s", experiment, c.id()))
+	return int64(binary.BigEndian.Uint64(sum[:8])%(1<<53-1)) + 1
+}
+
+// cells returns the cells of the experiment in the order we run them, which
+// applies the network emulation profiles once each.
+func (cp *checkpoint) cells() []cell {
+	var out []cell
+	for _, profile := range cp.Profiles {
+		for _, protocol := range cp.Protocols {
+			for repetition := 1; repetition <= cp.Repetitions; repetition++ {
+				out = append(out, cell{profile: profile, protocol: protocol, repetition: repetition})
+			}
+		}
+	}
+	return out
+}
+
+// topologies returns the names of the topologies running the cells, which
+// are NAME-1, ..., NAME-N when running N cells in parallel.
+func (cp *checkpoint) topologies() []string {
+	if cp.Parallel <= 1 {
+		return []string{cp.Name}
+	}
+	var out []string
+	for idx := 1; idx <= cp.Parallel; idx++ {
+		out = append(out, fmt.Sprintf("%s-%d", cp.Name, idx))
+	}
+	return out
+}
+
+// partitionCPUs splits the host CPUs into n disjoint CPU lists (e.g., 0-3),
+// one for each topology, such that the topologies do not compete for CPU.
+func partitionCPUs(n int) ([]string, error) {
+	size := runtime.NumCPU() / n
+	if size < 1 {
+		return nil, fmt.Errorf("cannot run %d topologies in parallel using %d CPUs", n, runtime.NumCPU())
+	}
+	var out []string
+	for idx := range n {
+		first := idx * size
+		out = append(out, fmt.Sprintf("%d-%d", first, first+size-1))
+	}
+	return out, nil
+}
+
+// prepareTopology creates the topology with the given name, unless it
+// exists already, pins its containers to cpus, starts the servers of the
+// given protocols, and gives the client the server certificate.
+func prepareTopology(ctx context.Context, name, cpus string, protocols []string) {
+	if _, err := output("lxc info %s-client", name); err != nil {
+		runtimex.LogFatalOnError0(createMain(ctx, []string{"-n", name}))
+	}
+	for _, role := range []string{"client", "router", "server"} {
+		pinContainer(name, role, cpus)
+	}
+	for _, protocol := range protocols {
+		argv := []string{"-n", name, "--detach"}
+		switch protocol {
+		case "ndt7":
+			runtimex.LogFatalOnError0(serveNDT7Main(ctx, argv))
+		case "ndt8":
+			runtimex.LogFatalOnError0(serveNDT8Main(ctx, argv))
+		}
+	}
+	// Note: each server has its own certificate, which `lxs serve` generated
+	mustRun("lxc file push testdata/cert.pem %s-client/root/", name)
+}
+
+// completed returns whether we already ran c successfully.
+func (cp *checkpoint) completed(c cell) bool {
+	outcome, found := cp.Cells[c.id()]
+	return found && outcome.Failure == ""
+}
+
+// save atomically writes cp into dir.
+func (cp *checkpoint) save(dir string) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, checkpointFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadCheckpoint loads the [*checkpoint] from dir.
+func loadCheckpoint(dir string) (*checkpoint, error) {
+	data, err := os.ReadFile(filepath.Join(dir, checkpointFile))
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("%s: %w", checkpointFile, err)
+	}
+	if cp.Cells == nil {
+		cp.Cells = map[string]cellOutcome{}
+	}
+	return &cp, nil
+}
+
+// runExperiment runs the cells of cp we did not complete yet, writing the
+// result documents and the checkpoint into dir after each cell, prints the
+// results of all the cells, and exits with an error when some failed.
+//
+// When cp runs cells in parallel, we create the topologies, if needed, and
+// each topology takes the next pending cell as soon as it is idle.
+func runExperiment(ctx context.Context, dir string, cp *checkpoint) {
+	// Check all the profiles and protocols before spending hours measuring.
+	selected := map[string]policy{}
+	for _, profile := range cp.Profiles {
+		selected[profile] = (&policyFlags{template: profile}).policy()
+	}
+	commands := map[string]string{}
+	for _, protocol := range cp.Protocols {
+		command, err := protocolCommand(protocol)
+		failure.OnError(failure.Usage, err)
+		commands[protocol] = command
+	}
+	topologies := cp.topologies()
+	var cpus []string
+	if len(topologies) > 1 {
+		var err error
+		cpus, err = partitionCPUs(len(topologies))
+		failure.OnError(failure.Usage, err)
+	}
+	runtimex.LogFatalOnError0(os.MkdirAll(dir, 0700))
+	runtimex.LogFatalOnError0(cp.save(dir))
+
+	if len(topologies) > 1 {
+		for idx, name := range topologies {
+			prepareTopology(ctx, name, cpus[idx], cp.Protocols)
+		}
+		// Note: we cannot tell which topology a failed command belongs to
+		collectDiagnosticsOnFailure("")
+	} else {
+		// Note: this requires `lxs serve ndt7 --detach` and/or `lxs serve ndt8 --detach`
+		collectDiagnosticsOnFailure(cp.Name)
+		mustRun("lxc file push testdata/cert.pem %s-client/root/", cp.Name)
+	}
+	for _, protocol := range cp.Protocols {
+		mustRun("go build -v ./cmd/%s", protocol)
+		for _, name := range topologies {
+			mustRun("lxc file push %s %s-client/root/", protocol, name)
+		}
+	}
+
+	cells := cp.cells()
+	var incomplete []int
+	for idx, c := range cells {
+		if !cp.completed(c) {
+			incomplete = append(incomplete, idx)
+		}
+	}
+	var (
+		mu    sync.Mutex
+		queue = make(chan int)
+		wg    sync.WaitGroup
+	)
+	for _, name := range topologies {
+		wg.Go(func() {
+			var applied policy
+			for idx := range queue {
+				c := cells[idx]
+				fmt.Fprintf(os.Stderr, "\n[%d/%d] topology %s, profile %s, protocol %s, repetition %d\n",
+					idx+1, len(cells), name, c.profile, c.protocol, c.repetition)
+				// With a seed, the netem seed changes with each cell, so
+				// we apply the profile again rather than once.
+				p, argv, seed := selected[c.profile], cp.Argv, c.seed(cp.Seed)
+				if seed != 0 {
+					p.seed = uint64(seed)
+					if c.protocol == "ndt8" {
+						argv = append([]string{"--seed", strconv.FormatInt(seed, 10)}, argv...)
+					}
+				}
+				if applied != p {
+					applyNetem(name, p)
+					saveNetem(name, c.profile, p)
+					applied = p
+				}
+				doc, err := matrixDocument(name, c.id()+".json", commands[c.protocol], argv)
+				outcome := cellOutcome{Time: time.Now(), Topology: name}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "cell %s: %s\n", c.id(), err.Error())
+					outcome.Failure = err.Error()
+				}
+				if doc != nil {
+					runtimex.LogFatalOnError0(results.WriteFile(filepath.Join(dir, c.id()+".json"), doc))
+				}
+				mu.Lock()
+				cp.Cells[c.id()] = outcome
+				runtimex.LogFatalOnError0(cp.save(dir))
+				mu.Unlock()
+			}
+			clearNetem(name)
+		})
+	}
+dispatch:
+	for _, idx := range incomplete {
+		select {
+		case queue <- idx:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	var (
+		rows    []matrixRow
+		pending int
+	)
+	for _, c := range cells {
+		outcome, found := cp.Cells[c.id()]
+		if !found {
+			pending++
+			continue
+		}
+		row := matrixRow{profile: c.id(), policy: selected[c.profile], failure: outcome.Failure}
+		if doc, err := results.ReadFile(filepath.Join(dir, c.id()+".json")); err == nil {
+			row.doc = doc
+		}
+		rows = append(rows, row)
+	}
+	printMatrix(rows)
+
+	failed := 0
+	for _, row := range rows {
+		if row.failure != "" {
+			failed++
+		}
+	}
+	if failed > 0 || pending > 0 {
+		failure.Exit(failure.Generic, fmt.Errorf("%d of %d cells failed and %d did not run: resume using `lxs experiment resume --from %s`",
+			failed, len(cells), pending, dir))
+	}
+}
+
+// experimentRunMain is the main of the `lxs experiment run` command.
+func experimentRunMain(ctx context.Context, args []string) error {
+	var (
+		labelFlag       = []string{}
+		nameFlag        = "ocho"
+		outputFlag      = ""
+		parallelFlag    = 1
+		profilesFlag    = ""
+		protocolsFlag   = "ndt7,ndt8"
+		repetitionsFlag = 1
+		seedFlag        = int64(0)
+	)
+
+	fset := vflag.NewFlagSet("lxs experiment run", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringSliceVar(&labelFlag, 0, "label", "Tag the result documents with `KEY=VALUE` (repeatable).")
+	fset.StringVar(&nameFlag, 'n', "name", "Use `NAME` to name LXC resources.")
+	fset.StringVar(&outputFlag, 'o', "output", "Write the result documents and the checkpoint to `DIR` (default: testdata/experiment-NAME).")
+	fset.IntVar(&parallelFlag, 0, "parallel", "Run cells on `N` topologies at once, named NAME-1, ..., NAME-N, pinned to disjoint CPUs.")
+	fset.StringVar(&profilesFlag, 0, "profiles", "Only measure the comma-separated `PROFILES` (default: all the templates).")
+	fset.StringVar(&protocolsFlag, 0, "protocols", "Measure using the comma-separated `PROTOCOLS` (ndt7 and/or ndt8).")
+	fset.IntVar(&repetitionsFlag, 'r', "repetitions", "Measure each profile and protocol `N` times.")
+	fset.Int64Var(&seedFlag, 0, "seed", "Derive the seed of each cell, which we pass to netem and to ndt8, from `SEED` (0 for random choices).")
+	fset.MaxPositionalArgs = math.MaxInt
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	if repetitionsFlag < 1 {
+		failure.Exit(failure.Usage, errors.New("--repetitions must be positive"))
+	}
+	if parallelFlag < 1 {
+		failure.Exit(failure.Usage, errors.New("--parallel must be positive"))
+	}
+	if seedFlag < 0 {
+		failure.Exit(failure.Usage, errors.New("--seed must not be negative"))
+	}
+	profiles := slices.Sorted(maps.Keys(policies))
+	if profilesFlag != "" {
+		profiles = strings.Split(profilesFlag, ",")
+	}
+	if outputFlag == "" {
+		outputFlag = filepath.Join("testdata", fmt.Sprintf("experiment-%s", nameFlag))
+	}
+	if _, err := os.Stat(filepath.Join(outputFlag, checkpointFile)); err == nil {
+		failure.Exit(failure.Usage, fmt.Errorf("%s already contains an experiment: use `lxs experiment resume --from %s`",
+			outputFlag, outputFlag))
+	}
+	cp := &checkpoint{
+		Name:        nameFlag,
+		Profiles:    profiles,
+		Protocols:   strings.Split(protocolsFlag, ","),
+		Repetitions: repetitionsFlag,
+		Argv:        append(labelArgv(labelFlag), fset.Args()...),
+		Parallel:    parallelFlag,
+		Seed:        seedFlag,
+		Cells:       map[string]cellOutcome{},
+	}
+	runExperiment(ctx, outputFlag, cp)
+	return nil
+}
+
+// experimentResumeMain is the main of the `lxs experiment resume` command.
+func experimentResumeMain(ctx context.Context, args []string) error {
+	var (
+		fromFlag = ""
+	)
+
+	fset := vflag.NewFlagSet("lxs experiment resume", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&fromFlag, 0, "from", "Resume the experiment in `DIR`, skipping the cells it completed.")
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	runtimex.LogFatalOnError0(config.ApplyEnv(fset, "LXS"))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	if fromFlag == "" {
+		failure.Exit(failure.Usage, errors.New("--from is required"))
+	}
+	cp, err := loadCheckpoint(fromFlag)
+	failure.OnError(failure.Usage, err)
+	cells, completed := cp.cells(), 0
+	for _, c := range cells {
+		if cp.completed(c) {
+			completed++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "resuming %s: %d of %d cells completed\n", fromFlag, completed, len(cells))
+	runExperiment(ctx, fromFlag, cp)
+	return nil
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/bassosimone/2026-02-provlima/internal/conncount"
+	"github.com/bassosimone/2026-02-provlima/internal/debug"
+)
+
+// serveStats counts the tests the server is running and the bytes it
+// transferred, which we publish in /debug/vars (see [debug.Publish]).
+type serveStats struct {
+	active        atomic.Int64      // tests running
+	bytesReceived atomic.Int64      // bytes we received in the uploads
+	bytesSent     atomic.Int64      // bytes we sent in the downloads
+	wire          conncount.Counter // bytes on the wire, including framing
+}
+
+// track counts a test as running until the returned function, which
+// accounts for the count bytes it transferred, is called.
+func (ss *serveStats) track(testname string) func(count int64) {
+	ss.active.Add(1)
+	return func(count int64) {
+		ss.active.Add(-1)
+		switch testname {
+		case "download":
+			ss.bytesSent.Add(count)
+		case "upload":
+			ss.bytesReceived.Add(count)
+		}
+	}
+}
+
+// publish publishes the statistics under "ndt7" in /debug/vars.
+func (ss *serveStats) publish() {
+	debug.Publish("ndt7", func() map[string]int64 {
+		wireSent, wireReceived := ss.wire.Bytes()
+		return map[string]int64{
+			"active":        ss.active.Load(),
+			"bytesReceived": ss.bytesReceived.Load(),
+			"bytesSent":     ss.bytesSent.Load(),
+			"wireReceived":  wireReceived,
+			"wireSent":      wireSent,
+		}
+	})
+}
@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/bassosimone/2026-02-provlima/internal/conncount"
+)
+
+// tcpNotsentLowat is TCP_NOTSENT_LOWAT, which the syscall package lacks.
+const tcpNotsentLowat = 25
+
+// setNotsentLowat limits the unsent data the kernel buffers for conn to
+// about size bytes, so that writes block instead of filling the buffer.
+func setNotsentLowat(conn net.Conn, size int) error {
+	tcpConn, ok := conncount.Unwrap(conn).(*net.TCPConn)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rawConn.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpNotsentLowat, size)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
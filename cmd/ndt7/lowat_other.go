@@ -0,0 +1,15 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// setNotsentLowat fails, since we only know how to set it on Linux.
+func setNotsentLowat(conn net.Conn, size int) error {
+	return errors.ErrUnsupported
+}
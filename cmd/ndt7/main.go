@@ -6,14 +6,17 @@ import (
 	"context"
 	"os"
 
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
 	"github.com/bassosimone/vclip"
 	"github.com/bassosimone/vflag"
 )
 
 func main() {
 	disp := vclip.NewDispatcherCommand("lxs", vflag.ExitOnError)
+	disp.Exit = failure.FlagExit
 
 	disp.AddCommand("measure", vclip.CommandFunc(measureMain), "Measure performance.")
+	disp.AddCommand("monitor", vclip.CommandFunc(monitorMain), "Measure performance periodically.")
 	disp.AddCommand("serve", vclip.CommandFunc(serveMain), "Serve requests.")
 
 	vclip.Main(context.Background(), disp, os.Args[1:])
@@ -4,44 +4,496 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
+	"github.com/bassosimone/2026-02-provlima/internal/collector"
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/convergence"
+	"github.com/bassosimone/2026-02-provlima/internal/cputime"
+	"github.com/bassosimone/2026-02-provlima/internal/dialer"
+	"github.com/bassosimone/2026-02-provlima/internal/drain"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/flightrec"
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/middlebox"
+	"github.com/bassosimone/2026-02-provlima/internal/privacy"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
 	"github.com/bassosimone/2026-02-provlima/internal/slogging"
+	"github.com/bassosimone/2026-02-provlima/internal/tcpinfo"
 	"github.com/bassosimone/runtimex"
 	"github.com/bassosimone/vflag"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
 func measureMain(ctx context.Context, args []string) error {
 	var (
-		addressFlag = "127.0.0.1"
-		formatFlag  = "text"
-		portFlag    = "4567"
+		addressFlag           = "127.0.0.1"
+		anonymizeIPsFlag      = "none"
+		collectorFlag         = ""
+		collectorIntervalFlag = time.Duration(0)
+		configFlag            = ""
+		convergeFlag          = ""
+		errorFormatFlag       = "text"
+		flightRecorderFlag    = ""
+		flightWindowFlag      = 30 * time.Second
+		formatFlag            = "text"
+		gapFlag               = time.Second
+		labelFlag             = []string{}
+		lingerFlag            = defaultLinger
+		netemFlag             = ""
+		omitHostnamesFlag     = false
+		outputFlag            = ""
+		portFlag              = "4567"
+		rawOutputFlag         = ""
+		tlsCacheFlag          = ""
 	)
 
 	fset := vflag.NewFlagSet("ndt7 measure", vflag.ExitOnError)
-	fset.StringVar(&addressFlag, 'A', "address", "Use the given IP `ADDRESS`.")
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&addressFlag, 'A', "address", "Use the given IP `ADDRESS` or hostname.")
+	fset.StringVar(&anonymizeIPsFlag, 0, "anonymize-ips", "Store the IP addresses in the results and logs using `MODE` (none, truncate to /24 or /48, or omit).")
+	fset.StringVar(&collectorFlag, 0, "collector", "Submit the result document to the collector at HTTPS `URL`.")
+	fset.DurationVar(&collectorIntervalFlag, 0, "collector-interval", "Also submit intermediate documents every `DURATION` (0 to disable).")
+	fset.StringVar(&configFlag, 0, "config", "Load flag defaults from TOML `FILE`.")
+	fset.StringVar(&convergeFlag, 0, "converge", "End each direction once the throughput converged according to `SPEC` (e.g., 5%/8 for a moving average within 5% for 8 intervals of 250ms).")
+	fset.StringVar(&errorFormatFlag, 0, "error-format", "Use `FORMAT` for the final error, if any (text or json).")
+	fset.StringVar(&flightRecorderFlag, 0, "flight-recorder", "Keep the last 10ms samples in a ring buffer in `DIR`, dumping them there when the throughput collapses or the probes spike.")
+	fset.DurationVar(&flightWindowFlag, 0, "flight-recorder-window", "Keep the samples of the last `DURATION` in the flight recorder ring buffer.")
 	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
+	fset.DurationVar(&gapFlag, 0, "gap", "Wait at least `DURATION` between directions and until the queues drain (0 to disable).")
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringSliceVar(&labelFlag, 0, "label", "Tag the result document with `KEY=VALUE` (repeatable).")
+	fset.DurationVar(&lingerFlag, 0, "linger", "Wait up to `DURATION` for the server to close each test connection (0 to close right away).")
+	fset.StringVar(&netemFlag, 0, "netem", "Record the network emulation `POLICY` in effect (comma-separated KEY=VALUE pairs) in the result document.")
+	fset.BoolVar(&omitHostnamesFlag, 0, "omit-hostnames", "Omit the hostnames from the results and logs.")
+	fset.StringVar(&outputFlag, 'o', "output", "Write the result document to `FILE`.")
 	fset.StringVar(&portFlag, 'p', "port", "Use the given TCP `PORT`.")
+	fset.StringVar(&rawOutputFlag, 0, "raw-output", "Append the measurement messages received from the server to `FILE` as NDJSON.")
+	fset.StringVar(&tlsCacheFlag, 0, "tls-cache", "Resume the TLS sessions stored in `FILE`, which we update after measuring.")
+	failure.OnError(failure.Usage, config.Setup(fset, "NDT7", "measure", args))
 	runtimex.PanicOnError0(fset.Parse(args))
 
 	slogging.Setup(formatFlag)
+	failure.Setup(errorFormatFlag)
+
+	anon, err := privacy.New(anonymizeIPsFlag, omitHostnamesFlag)
+	failure.OnError(failure.Usage, err)
+	anon.Setup()
+
+	var coll *collector.Client
+	if collectorFlag != "" {
+		var err error
+		coll, err = collector.New(collectorFlag)
+		failure.OnError(failure.Usage, err)
+	}
+
+	labels, err := results.ParseLabels(labelFlag)
+	failure.OnError(failure.Usage, err)
+	var netem *results.Netem
+	if netemFlag != "" {
+		netem, err = results.ParseNetem(netemFlag)
+		failure.OnError(failure.Usage, err)
+	}
+
+	if flightWindowFlag < flightrec.MinWindow {
+		failure.Exit(failure.Usage, fmt.Errorf("--flight-recorder-window must be at least %s", flightrec.MinWindow))
+	}
+
+	var conv *convergence.Spec
+	if convergeFlag != "" {
+		conv, err = convergence.Parse(convergeFlag)
+		failure.OnError(failure.Usage, err)
+	}
+
+	var sessionCache *dialer.SessionCache
+	if tlsCacheFlag != "" {
+		sessionCache, err = dialer.LoadSessionCache(tlsCacheFlag)
+		failure.OnError(failure.Usage, err)
+	}
+
+	var raw *rawRecorder
+	if rawOutputFlag != "" {
+		var err error
+		raw, err = newRawRecorder(rawOutputFlag)
+		failure.OnError(failure.Generic, err)
+		defer raw.Close()
+	}
 
 	host := net.JoinHostPort(addressFlag, portFlag)
+	tl := &results.Timeline{}
+	dr := dialer.New("tcp")
+
+	// Keep the fine-grained samples for post-mortem, if needed.
+	var rec *flightrec.Recorder
+	if flightRecorderFlag != "" {
+		rec, err = flightrec.New(flightRecorderFlag, flightWindowFlag, dr.WireBytes, nil)
+		if err != nil {
+			slog.Warn("not running the flight recorder", slog.Any("err", err))
+		}
+	}
+
+	// Periodically submit what we collected so far, if needed.
+	var wg sync.WaitGroup
+	collectCtx, collectCancel := context.WithCancel(ctx)
+	defer collectCancel()
+	if coll != nil && collectorIntervalFlag > 0 {
+		wg.Go(func() {
+			coll.Run(collectCtx, collectorIntervalFlag, func() *results.Document {
+				doc := &results.Document{
+					SchemaVersion: results.SchemaVersion,
+					Protocol:      "ndt7",
+					Status:        results.StatusRunning,
+					Netem:         netem,
+					Labels:        labels,
+					DNSLookups:    dr.Lookups(),
+					Dials:         dr.Dials(),
+					Samples:       tl.Samples(),
+				}
+				anon.Document(doc)
+				return doc
+			})
+		})
+	}
+
+	// Measure the idle latency, which tells when the queues have drained
+	// between the directions, before the download fills them. We probe
+	// using a separate client, so the probes are not among the dials.
+	probeClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	defer probeClient.CloseIdleConnections()
+	gap, err := drain.New(ctx, gapFlag, drain.HTTPProbe(probeClient, fmt.Sprintf("https://%s/healthz", host)))
+	if err != nil {
+		slog.Warn("not waiting for the queues to drain", slog.Any("err", err))
+	}
 
-	dlURL := fmt.Sprintf("wss://%s/ndt/v7/download", host)
+	// The shared test ID allows the server to pair the two directions.
+	testID := uuid.NewString()
+	query := url.Values{testIDParam: {testID}}.Encode()
+	dlURL := fmt.Sprintf("wss://%s/ndt/v7/download?%s", host, query)
 	slog.Info("download", slog.String("url", dlURL))
-	conn, err := dial(ctx, dlURL, true)
-	runtimex.LogFatalOnError0(err)
-	receiver(ctx, conn, "download")
+	// In both directions, the server first describes the connection, and
+	// then sends measurement messages, which we save in the raw output.
+	var connections []results.ConnectionInfo
+	observeServer := func(testname string, now time.Time, data []byte, m *measurement) {
+		raw.record(testname, now, data)
+		if m == nil || m.ConnectionInfo == nil {
+			return
+		}
+		slog.Info("connection info",
+			slog.String("test", testname),
+			slog.String("client", m.ConnectionInfo.Client),
+			slog.String("server", m.ConnectionInfo.Server),
+			slog.String("uuid", m.ConnectionInfo.UUID),
+		)
+		connections = append(connections, results.ConnectionInfo{
+			Test:   testname,
+			Client: m.ConnectionInfo.Client,
+			Server: m.ConnectionInfo.Server,
+			UUID:   m.ConnectionInfo.UUID,
+		})
+	}
 
-	ulURL := fmt.Sprintf("wss://%s/ndt/v7/upload", host)
-	slog.Info("upload", slog.String("url", ulURL))
-	conn, err = dial(ctx, ulURL, true)
-	runtimex.LogFatalOnError0(err)
-	sender(ctx, conn, "upload")
+	// When we cannot connect, we skip the rest and write what we have.
+	var (
+		upgradePath string
+		checks      []results.MiddleboxCheck
+		suspected   bool
+		server      serverStats
+		downloadCS  convergenceStats
+		downloadCPU float64
+	)
+	conn, resp, dialErr := dial(ctx, dr, sessionCache, dlURL, true)
+	if dialErr != nil {
+		slog.Warn("cannot connect for the download", slog.Any("err", dialErr))
+	} else {
+		upgradePath = resp.Header.Get(upgradePathHeader)
+		slog.Info("connected", slog.String("upgradePath", upgradePath))
+		checks, suspected = checkMiddlebox(conn, resp)
+		cpu0, t0 := cputime.Now(), time.Now()
+		rec.Begin("download")
+		runUntilInterrupted(ctx, conn, lingerFlag, nil, func() {
+			downloadCS = runConverging(ctx, conv, "download", tl, func(ctx context.Context) {
+				receiver(ctx, conn, "download", tl.Emit, results.OriginClient, func(now time.Time, data []byte, m *measurement) {
+					observeServer("download", now, data, m)
+					server.observe(m)
+				})
+			})
+		})
+		rec.End()
+		downloadCPU = cputime.Usage(cpu0, t0)
+	}
+
+	var (
+		uploadCPU           float64
+		uploadMinRTT        time.Duration
+		uploadRetransmitted int64
+		uploadCS            convergenceStats
+	)
+	if ctx.Err() == nil && dialErr == nil {
+		gap.Wait(ctx)
+	}
+	if ctx.Err() == nil && dialErr == nil {
+		ulURL := fmt.Sprintf("wss://%s/ndt/v7/upload?%s", host, query)
+		slog.Info("upload", slog.String("url", ulURL))
+		conn, _, dialErr = dial(ctx, dr, sessionCache, ulURL, true)
+		if dialErr != nil {
+			slog.Warn("cannot connect for the upload", slog.Any("err", dialErr))
+		} else {
+			cpu0, t0 := cputime.Now(), time.Now()
+			rec.Begin("upload")
+			// The server samples tell the RTT and the congestion window
+			// on its side, which helps locating the bottleneck.
+			reading := readMeasurements(conn, tl.Emit, func(now time.Time, data []byte, m *measurement) {
+				observeServer("upload", now, data, m)
+			})
+			runUntilInterrupted(ctx, conn, lingerFlag, reading, func() {
+				uploadCS = runConverging(ctx, conv, "upload", tl, func(ctx context.Context) {
+					sender(ctx, conn, "upload", tl.Emit, results.OriginClient)
+				})
+				// Read before closing, since we cannot query closed conns.
+				uploadRetransmitted = dr.RetransmittedBytes()
+				if info, err := tcpinfo.Get(conn.NetConn()); err == nil {
+					uploadMinRTT = info.MinRTT
+				}
+			})
+			rec.End()
+			uploadCPU = cputime.Usage(cpu0, t0)
+		}
+	}
+
+	collectCancel()
+	wg.Wait()
+	flightRecords := rec.Close()
 
+	// When interrupted or failing to connect, this is a partial document
+	// with what we collected. Since interrupting also fails the pending
+	// dial, if any, we check for the interruption first.
+	status := results.StatusComplete
+	switch {
+	case ctx.Err() != nil:
+		slog.Warn("interrupted by the user")
+		status = results.StatusInterrupted
+	case dialErr != nil:
+		status = results.StatusFailed
+	}
+	samples := tl.Samples()
+	doc := &results.Document{
+		SchemaVersion:      results.SchemaVersion,
+		Protocol:           "ndt7",
+		Status:             status,
+		SessionID:          testID,
+		UpgradePath:        upgradePath,
+		Connections:        connections,
+		Netem:              netem,
+		Labels:             labels,
+		DNSLookups:         dr.Lookups(),
+		Dials:              dr.Dials(),
+		TLSHandshakes:      dr.TLSHandshakes(),
+		MiddleboxChecks:    checks,
+		MiddleboxSuspected: suspected,
+		FlightRecords:      flightRecords,
+		Summary: &results.Summary{
+			Download: results.Summarize(samples, results.OriginClient, "download", 0),
+			Upload:   results.Summarize(samples, results.OriginClient, "upload", 0),
+		},
+		Samples: samples,
+	}
+
+	// The server is the sender during the download, so only its samples
+	// tell how many bytes the client acknowledged.
+	if doc.Summary.Download != nil {
+		if server := results.Summarize(samples, results.OriginServer, "download", 0); server != nil {
+			doc.Summary.Download.Divergence = server.Divergence
+		}
+	}
+	downloadCS.apply(doc.Summary.Download)
+	uploadCS.apply(doc.Summary.Upload)
+	assessSummary("download", doc.Summary.Download, downloadCPU, server.bytesRetrans, server.minRTT)
+	assessSummary("upload", doc.Summary.Upload, uploadCPU, uploadRetransmitted, uploadMinRTT)
+	slog.Info("measurement complete",
+		slog.String("status", status),
+		slog.Int("samples", len(doc.Samples)),
+	)
+	if sessionCache != nil {
+		if err := sessionCache.Save(); err != nil {
+			slog.Warn("cannot save the TLS sessions", slog.Any("err", err))
+		}
+	}
+	anon.Document(doc)
+	if outputFlag != "" {
+		failure.OnError(failure.Generic, results.WriteFile(outputFlag, doc))
+		slog.Info("result written", slog.String("path", outputFlag))
+	}
+	if coll != nil {
+		coll.Add(doc)
+		failure.OnError(failure.Connectivity, coll.Flush(context.WithoutCancel(ctx)))
+	}
+
+	// Failing to connect is fatal, but only after writing the document.
+	if status == results.StatusFailed {
+		failure.Exit(dialFailureClass(dialErr), dialErr)
+	}
 	return nil
 }
+
+// checkMiddlebox compares the connection to the server and its upgrade
+// response with what we expect from the server (see [middlebox.Check]).
+func checkMiddlebox(conn *websocket.Conn, resp *http.Response) ([]results.MiddleboxCheck, bool) {
+	expected := middlebox.Expected{ALPN: "http/1.1", Server: serverName, TLSVersion: tls.VersionTLS13}
+	var state *tls.ConnectionState
+	if tlsConn, ok := conn.NetConn().(*tls.Conn); ok {
+		cs := tlsConn.ConnectionState()
+		state = &cs
+	}
+	return middlebox.Check(expected, state, resp.Header, conn.NetConn())
+}
+
+// dialFailureClass returns the [failure.Class] of a dial error, which
+// is a protocol error when the server rejected the WebSocket upgrade.
+func dialFailureClass(err error) failure.Class {
+	if errors.Is(err, websocket.ErrBadHandshake) {
+		return failure.Protocol
+	}
+	return failure.Connectivity
+}
+
+// runUntilInterrupted runs fn and then closes conn using [closeConn] with
+// the given linger and reading channel. As soon as ctx is done, we expire the deadlines of conn,
+// which unblocks fn when it is stuck in I/O, so that we close the connection
+// right after, rather than leaving it lingering into the next test.
+func runUntilInterrupted(ctx context.Context, conn *websocket.Conn, linger time.Duration, reading <-chan struct{}, fn func()) {
+	stop := context.AfterFunc(ctx, func() { conn.NetConn().SetDeadline(time.Now()) })
+	defer closeConn(conn, linger, reading)
+	defer stop()
+	fn()
+}
+
+// convergenceStats contains the outcome of [runConverging].
+//
+// The zero value is ready to use.
+type convergenceStats struct {
+	// convergence is the [results.DirectionSummary] Convergence, if any.
+	convergence string
+
+	// convergedAfter is the time it took to converge, if it converged.
+	convergedAfter time.Duration
+}
+
+// apply copies the statistics into summary, which may be nil.
+func (cs convergenceStats) apply(summary *results.DirectionSummary) {
+	if summary == nil {
+		return
+	}
+	summary.Convergence = cs.convergence
+	summary.ConvergedAfter = cs.convergedAfter
+}
+
+// runConverging runs fn with a context we cancel once the throughput of
+// direction converges according to conv, which may be nil. Since the server
+// bounds the duration of ndt7 tests, we can end them early but we cannot
+// extend them. Unlike interrupting, cancelling the context passed to fn
+// allows for closing the connection cleanly.
+func runConverging(ctx context.Context, conv *convergence.Spec, direction string, tl *results.Timeline, fn func(ctx context.Context)) convergenceStats {
+	if conv == nil {
+		fn(ctx)
+		return convergenceStats{}
+	}
+	phaseCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		converged bool
+		after     time.Duration
+		wg        sync.WaitGroup
+	)
+	wg.Go(func() {
+		after, converged = conv.Run(phaseCtx, time.Now(), 0, func() int64 {
+			if summary := results.Summarize(tl.Samples(), results.OriginClient, direction, 0); summary != nil {
+				return summary.Bytes
+			}
+			return 0
+		})
+		if converged {
+			slog.Info("throughput converged", slog.String("direction", direction), slog.Duration("after", after))
+			cancel()
+		}
+	})
+	fn(phaseCtx)
+	cancel()
+	wg.Wait()
+	switch {
+	case converged:
+		return convergenceStats{convergence: results.ConvergenceConverged, convergedAfter: after}
+	case ctx.Err() == nil:
+		return convergenceStats{convergence: results.ConvergenceUnstable}
+	default:
+		return convergenceStats{}
+	}
+}
+
+// serverStats contains the kernel statistics the server reports in the
+// measurement messages it sends during the download, when it is the sender.
+//
+// The zero value is ready to use.
+type serverStats struct {
+	// bytesRetrans is the estimate of the bytes the server retransmitted.
+	bytesRetrans int64
+
+	// minRTT is the minimum RTT the server kernel measured, if known.
+	minRTT time.Duration
+}
+
+// observe updates the statistics using m, which may be nil.
+func (ss *serverStats) observe(m *measurement) {
+	if m == nil || m.TCPInfo == nil {
+		return
+	}
+	ss.bytesRetrans = max(ss.bytesRetrans, m.TCPInfo.BytesRetrans)
+	if rtt := time.Duration(m.TCPInfo.MinRTT) * time.Microsecond; rtt > 0 {
+		if ss.minRTT <= 0 || rtt < ss.minRTT {
+			ss.minRTT = rtt
+		}
+	}
+}
+
+// assessSummary sets the quality flags of summary, logs the summary line
+// with the headline figures, and warns about the flags.
+//
+// The server bounds the duration of ndt7 tests, so we are never truncated.
+// The retransmitted bytes and the minimum RTT come from the kernel of the
+// sender, which is the server for the download.
+func assessSummary(direction string, summary *results.DirectionSummary, cpuUsage float64, retransmitted int64, minRTT time.Duration) {
+	if summary == nil {
+		return
+	}
+	summary.CPUUsage = cpuUsage
+	summary.MinRTT = minRTT
+	if summary.Bytes > 0 {
+		summary.RetransmitRate = float64(retransmitted) / float64(summary.Bytes)
+	}
+	summary.Assess()
+	slog.Info("summary",
+		slog.String("direction", direction),
+		slog.String("meanGoodput", humanize.SI(summary.Throughput, "bit/s")),
+		slog.String("maxGoodput", humanize.SI(summary.MaxThroughput, "bit/s")),
+		slog.Duration("minRTT", summary.MinRTT),
+		slog.Float64("retransmitRate", summary.RetransmitRate),
+		slog.String("convergence", summary.Convergence),
+	)
+	if len(summary.Flags) > 0 {
+		slog.Warn("low quality measurement",
+			slog.String("direction", direction),
+			slog.Any("flags", summary.Flags),
+		)
+	}
+}
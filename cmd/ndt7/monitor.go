@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"os"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/monitor"
+	"github.com/bassosimone/2026-02-provlima/internal/slogging"
+	"github.com/bassosimone/runtimex"
+	"github.com/bassosimone/vflag"
+)
+
+// monitorMain is the main of the `ndt7 monitor` command (see [monitor]).
+//
+// On metered connections, the daily and monthly quotas bound the data
+// volume, which we store in the OUTPUT.usage.json file.
+func monitorMain(ctx context.Context, args []string) error {
+	var (
+		alertFlag         = ""
+		alertCommandFlag  = ""
+		alertFailuresFlag = 0
+		alertWebhookFlag  = ""
+		configFlag        = ""
+		countFlag         = 0
+		dailyQuotaFlag    = ""
+		errorFormatFlag   = "text"
+		formatFlag        = "text"
+		intervalFlag      = 30 * time.Minute
+		jitterFlag        = 0.1
+		monthlyQuotaFlag  = ""
+		outputFlag        = "ndt7-monitor.ndjson"
+		resumeTLSFlag     = false
+		rotateSizeFlag    = int64(64 << 20)
+	)
+
+	fset := vflag.NewFlagSet("ndt7 monitor", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&alertFlag, 0, "alert", "Alert when a result violates the comma-separated `ASSERTIONS` (e.g., download>=50mbit,p95_latency<=200ms).")
+	fset.StringVar(&alertCommandFlag, 0, "alert-command", "Alert by running the shell `COMMAND` with the alert as JSON on the standard input.")
+	fset.IntVar(&alertFailuresFlag, 0, "alert-failures", "Alert when `N` measurements in a row fail (0 to disable).")
+	fset.StringVar(&alertWebhookFlag, 0, "alert-webhook", "Alert by POSTing the alert as JSON to `URL`.")
+	fset.StringVar(&configFlag, 0, "config", "Load flag defaults from TOML `FILE`.")
+	fset.IntVar(&countFlag, 'c', "count", "Stop after `N` measurements (0 to run until interrupted).")
+	fset.StringVar(&dailyQuotaFlag, 0, "daily-quota", "Skip the measurements that would use more than `SIZE` per day (e.g., 500MB).")
+	fset.StringVar(&errorFormatFlag, 0, "error-format", "Use `FORMAT` for the final error, if any (text or json).")
+	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
+	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.DurationVar(&intervalFlag, 'i', "interval", "Start a measurement every `DURATION`.")
+	fset.Float64Var(&jitterFlag, 0, "jitter", "Randomly delay each measurement by up to `FRACTION` of the interval.")
+	fset.StringVar(&monthlyQuotaFlag, 0, "monthly-quota", "Skip the measurements that would use more than `SIZE` per month (e.g., 5GiB).")
+	fset.StringVar(&outputFlag, 'o', "output", "Append the result documents to the NDJSON `FILE`.")
+	fset.BoolVar(&resumeTLSFlag, 0, "resume-tls", "Resume the TLS sessions of the previous measurements, storing them in the OUTPUT.tls.json file.")
+	fset.Int64Var(&rotateSizeFlag, 0, "rotate-size", "Rename the output to FILE.1 when it would exceed `BYTES` (0 to never rotate).")
+	fset.MaxPositionalArgs = math.MaxInt
+	failure.OnError(failure.Usage, config.Setup(fset, "NDT7", "monitor", args))
+	runtimex.PanicOnError0(fset.Parse(args))
+
+	slogging.Setup(formatFlag)
+	failure.Setup(errorFormatFlag)
+
+	if intervalFlag <= 0 {
+		failure.Exit(failure.Usage, errors.New("--interval must be positive"))
+	}
+	if jitterFlag < 0 || jitterFlag >= 1 {
+		failure.Exit(failure.Usage, errors.New("--jitter must be at least 0 and less than 1"))
+	}
+	if countFlag < 0 || rotateSizeFlag < 0 {
+		failure.Exit(failure.Usage, errors.New("--count and --rotate-size must not be negative"))
+	}
+	var dailyQuota, monthlyQuota int64
+	if dailyQuotaFlag != "" {
+		var err error
+		dailyQuota, err = humanize.ParseSize(dailyQuotaFlag)
+		failure.OnError(failure.Usage, err)
+	}
+	if monthlyQuotaFlag != "" {
+		var err error
+		monthlyQuota, err = humanize.ParseSize(monthlyQuotaFlag)
+		failure.OnError(failure.Usage, err)
+	}
+	alerter, err := monitor.NewAlerter(alertFlag, alertFailuresFlag, alertWebhookFlag, alertCommandFlag)
+	failure.OnError(failure.Usage, err)
+	exe, err := os.Executable()
+	failure.OnError(failure.Generic, err)
+	usagePath := outputFlag + ".usage.json"
+	usage, err := monitor.LoadUsage(usagePath)
+	failure.OnError(failure.Generic, err)
+
+	// The positional arguments are the `ndt7 measure` flags.
+	measureArgs := fset.Args()
+	if resumeTLSFlag {
+		measureArgs = append(measureArgs, "--tls-cache", outputFlag+".tls.json")
+	}
+	for run := 1; countFlag == 0 || run <= countFlag; run++ {
+		start := time.Now()
+		if err := usage.Check(start, dailyQuota, monthlyQuota); err != nil {
+			slog.Warn("skipping measurement", slog.Int("run", run), slog.Any("err", err))
+		} else {
+			doc, err := monitor.Measure(ctx, exe, measureArgs)
+			switch {
+			case failure.ClassOf(err) == failure.Usage:
+				// Retrying a measurement we cannot start is pointless.
+				failure.Exit(failure.Usage, err)
+			case err != nil && ctx.Err() == nil:
+				slog.Warn("measurement failed", slog.Int("run", run),
+					slog.String("class", failure.ClassOf(err).String()), slog.Any("err", err))
+			}
+			if doc != nil {
+				failure.OnError(failure.Generic, monitor.AppendNDJSON(outputFlag, rotateSizeFlag, doc))
+				usage.Add(time.Now(), monitor.DocumentBytes(doc))
+				failure.OnError(failure.Generic, usage.Save(usagePath))
+				slog.Info("measurement appended", slog.Int("run", run), slog.String("status", doc.Status),
+					slog.String("output", outputFlag), slog.Int64("bytes", usage.LastBytes),
+					slog.Int64("dayBytes", usage.DayBytes), slog.Int64("monthBytes", usage.MonthBytes),
+					slog.Int("tlsHandshakes", len(doc.TLSHandshakes)), slog.Int("tlsResumed", monitor.ResumedHandshakes(doc)))
+			}
+			alerter.Observe(ctx, run, doc, err)
+		}
+		if ctx.Err() != nil || (countFlag != 0 && run >= countFlag) {
+			break
+		}
+
+		next := monitor.Next(start, intervalFlag, jitterFlag)
+		slog.Info("next measurement", slog.Time("at", next))
+		if !monitor.SleepUntil(ctx, next) {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/privacy"
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+	"github.com/google/uuid"
+)
+
+// testIDParam is the query parameter carrying the test ID, which the client
+// shares between the download and the subsequent upload, so the server can
+// pair the two directions (see [*pairRecorder]).
+const testIDParam = "test_id"
+
+// pairTimeout is how long we wait for the other direction of a test before
+// writing a record with just the direction we saw.
+const pairTimeout = time.Minute
+
+// pairDirection summarizes one direction of a paired test.
+type pairDirection struct {
+	// Start is when the test started.
+	Start time.Time `json:"start"`
+
+	// Elapsed is how long the test lasted.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Bytes is the number of payload bytes transferred.
+	Bytes int64 `json:"bytes"`
+
+	// Throughput is the payload throughput in bit/s.
+	Throughput float64 `json:"throughput"`
+}
+
+// pairRecord is the per-client record written by [*pairRecorder], which
+// pairs a download with the subsequent upload sharing its test ID.
+type pairRecord struct {
+	// TestID is the test ID the client sent.
+	TestID string `json:"testID"`
+
+	// Client is the client address, if not omitted.
+	Client string `json:"client,omitempty"`
+
+	// Download summarizes the download, if any.
+	Download *pairDirection `json:"download,omitempty"`
+
+	// Upload summarizes the upload, if any.
+	Upload *pairDirection `json:"upload,omitempty"`
+}
+
+// pendingPair is a [pairRecord] waiting for its other direction.
+type pendingPair struct {
+	record  pairRecord
+	running map[string]bool // directions that started
+	timer   *time.Timer     // writes the record after [pairTimeout]
+}
+
+// pairRecorder appends a [pairRecord] for each test ID to a file as NDJSON
+// once both directions completed or, otherwise, after [pairTimeout].
+//
+// Construct using [newPairRecorder]. The nil recorder does not pair tests.
+type pairRecorder struct {
+	anon    *privacy.Policy
+	enc     *json.Encoder
+	file    *os.File
+	mu      sync.Mutex
+	pending map[string]*pendingPair // testID → pending record
+}
+
+// newPairRecorder opens path for appending and returns a [*pairRecorder]
+// writing to it, which anonymizes the client addresses using anon.
+func newPairRecorder(path string, anon *privacy.Policy) (*pairRecorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	pr := &pairRecorder{
+		anon:    anon,
+		enc:     json.NewEncoder(file),
+		file:    file,
+		pending: map[string]*pendingPair{},
+	}
+	return pr, nil
+}
+
+// pairTest is a test started using [*pairRecorder.begin].
+//
+// The nil test does nothing.
+type pairTest struct {
+	pr       *pairRecorder
+	start    time.Time
+	testID   string
+	testname string
+}
+
+// begin starts the given test of req, replying with problem details and
+// returning false when the test ID is invalid or the client already ran
+// this test using it. Otherwise, the caller must either call done, once
+// the test completes, or cancel, when it does not run after all.
+func (pr *pairRecorder) begin(rw http.ResponseWriter, req *http.Request, testname string) (*pairTest, bool) {
+	testID := req.URL.Query().Get(testIDParam)
+	if pr == nil || testID == "" {
+		return nil, true
+	}
+	if err := uuid.Validate(testID); err != nil {
+		err = fmt.Errorf("invalid %s: %w", testIDParam, err)
+		problem.Write(rw, problem.New(req, http.StatusBadRequest, problem.TypeInvalidRequest, err.Error()))
+		return nil, false
+	}
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pp := pr.pending[testID]
+	if pp == nil {
+		client, _, _ := net.SplitHostPort(req.RemoteAddr)
+		pp = &pendingPair{
+			record:  pairRecord{TestID: testID, Client: pr.anon.IP(client)},
+			running: map[string]bool{},
+		}
+		pr.pending[testID] = pp
+	}
+	if pp.running[testname] {
+		err := fmt.Errorf("the %s test already ran with %s %s", testname, testIDParam, testID)
+		problem.Write(rw, problem.New(req, http.StatusConflict, problem.TypeInvalidRequest, err.Error()))
+		return nil, false
+	}
+	pp.running[testname] = true
+	if pp.timer != nil {
+		pp.timer.Stop() // we are running, so we are not late
+	}
+	return &pairTest{pr: pr, start: time.Now(), testID: testID, testname: testname}, true
+}
+
+// done records that the test completed transferring count bytes and writes
+// the record when both directions did, or arms its timer otherwise.
+func (pt *pairTest) done(count int64) {
+	if pt == nil {
+		return
+	}
+	elapsed := time.Since(pt.start)
+	dir := &pairDirection{Start: pt.start, Elapsed: elapsed, Bytes: count}
+	if elapsed > 0 {
+		dir.Throughput = float64(count*8) / elapsed.Seconds()
+	}
+	pr := pt.pr
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pp := pr.pending[pt.testID]
+	if pp == nil {
+		return // already written by [*pairRecorder.Close]
+	}
+	switch pt.testname {
+	case "download":
+		pp.record.Download = dir
+	case "upload":
+		pp.record.Upload = dir
+	}
+	if pp.record.Download != nil && pp.record.Upload != nil {
+		pr.writeLocked(pt.testID)
+		return
+	}
+	pr.armLocked(pt.testID, pp)
+}
+
+// cancel forgets about the test, so the client may run it again, which
+// is what we want when, e.g., the WebSocket upgrade failed.
+func (pt *pairTest) cancel() {
+	if pt == nil {
+		return
+	}
+	pr := pt.pr
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pp := pr.pending[pt.testID]
+	if pp == nil {
+		return // already written by [*pairRecorder.Close]
+	}
+	delete(pp.running, pt.testname)
+	switch {
+	case len(pp.running) <= 0:
+		delete(pr.pending, pt.testID)
+	case pp.record.Download != nil || pp.record.Upload != nil:
+		pr.armLocked(pt.testID, pp)
+	}
+}
+
+// armLocked arranges for writing the record of testID after [pairTimeout],
+// unless the other direction starts meanwhile. The caller holds mu.
+func (pr *pairRecorder) armLocked(testID string, pp *pendingPair) {
+	pp.timer = time.AfterFunc(pairTimeout, func() {
+		pr.mu.Lock()
+		defer pr.mu.Unlock()
+		// We may have already written it, or the other direction may be running.
+		if pr.pending[testID] == pp && len(pp.running) < 2 {
+			pr.writeLocked(testID)
+		}
+	})
+}
+
+// writeLocked writes the record of testID and forgets about it, logging
+// failures rather than interrupting the server. The caller holds mu.
+func (pr *pairRecorder) writeLocked(testID string) {
+	pp := pr.pending[testID]
+	delete(pr.pending, testID)
+	if pp.timer != nil {
+		pp.timer.Stop()
+	}
+	if err := pr.enc.Encode(pp.record); err != nil {
+		slog.Warn("cannot save paired record", slog.Any("err", err))
+	}
+}
+
+// Close writes the pending records, with the directions completed so far,
+// and closes the file.
+func (pr *pairRecorder) Close() error {
+	if pr == nil {
+		return nil
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	for testID := range pr.pending {
+		pr.writeLocked(testID)
+	}
+	return pr.file.Close()
+}
+
+// connTestKey is the context key of the [*connTest] of a connection.
+type connTestKey struct{}
+
+// connTest is the test type a connection carries.
+type connTest struct {
+	mu       sync.Mutex
+	testname string
+}
+
+// withConnTest implements [http.Server.ConnContext], allowing the handlers
+// to enforce one test type per connection (see [claimConn]).
+func withConnTest(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connTestKey{}, &connTest{})
+}
+
+// claimConn binds the connection serving req to the given test, replying
+// with problem details and returning false when it already carries another
+// test type. Since the kernel statistics we sample describe the connection,
+// multiplexing a download and an upload over HTTP/2 would mix them.
+func claimConn(rw http.ResponseWriter, req *http.Request, testname string) bool {
+	ct, _ := req.Context().Value(connTestKey{}).(*connTest)
+	if ct == nil {
+		return true
+	}
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if ct.testname == "" {
+		ct.testname = testname
+	}
+	if ct.testname != testname {
+		err := fmt.Errorf("this connection already carries the %s test", ct.testname)
+		problem.Write(rw, problem.New(req, http.StatusConflict, problem.TypeInvalidRequest, err.Error()))
+		return false
+	}
+	return true
+}
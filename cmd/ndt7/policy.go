@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// upgradePolicy decides which WebSocket upgrade requests the server accepts.
+//
+// Construct using [newUpgradePolicy].
+type upgradePolicy struct {
+	// origins contains the [path.Match] patterns of the cross-origin
+	// requests we accept (e.g., "https://*.example.org").
+	origins []string
+
+	// lenient accepts requests not offering exactly [wsProto].
+	lenient bool
+}
+
+// newUpgradePolicy returns a new [*upgradePolicy] accepting the requests
+// from the comma-separated origin patterns, where "*" matches any origin,
+// with either the "strict" or the "lenient" subprotocol negotiation.
+func newUpgradePolicy(origins, subprotocol string) (*upgradePolicy, error) {
+	p := &upgradePolicy{}
+	switch subprotocol {
+	case "strict":
+	case "lenient":
+		p.lenient = true
+	default:
+		return nil, fmt.Errorf("unknown --subprotocol %q (use strict or lenient)", subprotocol)
+	}
+	for pattern := range strings.SplitSeq(origins, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid --allowed-origins pattern %q: %w", pattern, err)
+		}
+		p.origins = append(p.origins, pattern)
+	}
+	return p, nil
+}
+
+// checkOrigin returns whether we accept the Origin of req. Like the default
+// of the websocket package, we accept requests without Origin, which do not
+// come from browsers, and same-origin requests. Otherwise, the Origin must
+// match one of the allowed patterns.
+func (p *upgradePolicy) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	return slices.ContainsFunc(p.origins, func(pattern string) bool {
+		matched, _ := path.Match(pattern, origin)
+		return pattern == "*" || matched
+	})
+}
+
+// negotiate returns the subprotocol to reply with, which is empty when the
+// client did not offer [wsProto], or an error when rejecting req.
+//
+// The strict negotiation requires the client to offer exactly [wsProto], as
+// the ndt7 specification says. The lenient negotiation accepts any offer,
+// for browser code and generic tools that offer more or no subprotocols,
+// and only replies with [wsProto] when offered, since RFC 6455 does not
+// allow replying with a subprotocol the client did not offer.
+func (p *upgradePolicy) negotiate(req *http.Request) (string, error) {
+	offered := websocket.Subprotocols(req)
+	switch {
+	case slices.Equal(offered, []string{wsProto}):
+		return wsProto, nil
+	case !p.lenient:
+		return "", fmt.Errorf("the Sec-WebSocket-Protocol header must be %q", wsProto)
+	case slices.Contains(offered, wsProto):
+		return wsProto, nil
+	default:
+		return "", nil
+	}
+}
@@ -5,14 +5,20 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/bassosimone/2026-02-provlima/internal/dialer"
 	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/2026-02-provlima/internal/tcpinfo"
 	"github.com/gorilla/websocket"
 )
 
@@ -29,6 +35,9 @@ const (
 	// maxRuntime is the maximum duration for a test.
 	maxRuntime = 10 * time.Second
 
+	// defaultLinger is how long we wait for the peer's close frame by default.
+	defaultLinger = time.Second
+
 	// measureInterval is the interval between measurement reports.
 	measureInterval = 250 * time.Millisecond
 
@@ -39,19 +48,259 @@ const (
 	wsProto = "net.measurementlab.ndt.v7"
 )
 
-// emitAppInfo logs a local measurement using slog.
-func emitAppInfo(start time.Time, total int64, testname string) {
-	elapsed := time.Since(start).Seconds()
+// emitAppInfo logs a local measurement using slog and, when emit is
+// not nil, also passes it to emit as a [results.Sample]. The acked
+// argument is the number of bytes the peer acknowledged, if known.
+func emitAppInfo(start time.Time, total, acked int64, testname string, emit func(results.Sample)) {
+	now := time.Now()
+	elapsed := now.Sub(start)
 	var speed float64
 	if elapsed > 0 {
-		speed = float64(total) * 8 / elapsed
+		speed = float64(total) * 8 / elapsed.Seconds()
 	}
 	slog.Info(testname,
 		slog.String("test", testname),
 		slog.String("bytes", humanize.IEC(float64(total), "B")),
-		slog.String("elapsed", time.Since(start).Truncate(time.Millisecond).String()),
+		slog.String("elapsed", elapsed.Truncate(time.Millisecond).String()),
 		slog.String("speed", humanize.SI(speed, "bit/s")),
 	)
+	if emit != nil {
+		emit(results.Sample{
+			Origin:     results.OriginClient,
+			Direction:  testname,
+			Bytes:      total,
+			BytesAcked: acked,
+			Elapsed:    elapsed,
+			Time:       now,
+		})
+	}
+}
+
+// appInfoSampler logs and emits the bytes transferred so far every
+// [measureInterval] (see [emitAppInfo]) from its own goroutine, so the
+// samples keep their interval even when a single message takes longer to
+// transfer, as a 1 MiB message does on a slow link.
+//
+// Construct using [startAppInfoSampler].
+type appInfoSampler struct {
+	acked    func() int64
+	cancel   context.CancelFunc
+	done     chan struct{}
+	emit     func(results.Sample)
+	start    time.Time
+	testname string
+	total    atomic.Int64
+}
+
+// startAppInfoSampler starts sampling the transfer that started at start
+// until [*appInfoSampler.stop] is called. The acked argument returns the
+// bytes the peer acknowledged, if known, and emit may be nil.
+func startAppInfoSampler(start time.Time, testname string, acked func() int64, emit func(results.Sample)) *appInfoSampler {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &appInfoSampler{
+		acked:    acked,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		emit:     emit,
+		start:    start,
+		testname: testname,
+	}
+	go s.loop(ctx)
+	return s
+}
+
+// loop emits a sample every [measureInterval] until ctx is done.
+func (s *appInfoSampler) loop(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(measureInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			emitAppInfo(s.start, s.total.Load(), s.acked(), s.testname, s.emit)
+		}
+	}
+}
+
+// Write implements [io.Writer] by counting the bytes written, which
+// allows counting the bytes of a message as we read them.
+func (s *appInfoSampler) Write(data []byte) (int, error) {
+	s.total.Add(int64(len(data)))
+	return len(data), nil
+}
+
+// stop stops sampling and emits the final sample.
+func (s *appInfoSampler) stop() {
+	s.cancel()
+	<-s.done
+	emitAppInfo(s.start, s.total.Load(), s.acked(), s.testname, s.emit)
+}
+
+// measurement is an ndt7 measurement message, which the server sends
+// to the client as a text message. Field names follow the ndt7 spec.
+type measurement struct {
+	AppInfo        *appInfo        `json:",omitempty"`
+	ConnectionInfo *connectionInfo `json:",omitempty"`
+	Origin         string          `json:",omitempty"`
+	Test           string          `json:",omitempty"`
+	TCPInfo        *tcpInfo        `json:",omitempty"`
+}
+
+// connectionInfo describes the connection, which the server tells the
+// client once, at the beginning of the test.
+type connectionInfo struct {
+	// Client is the client endpoint as seen by the server.
+	Client string
+
+	// Server is the server endpoint.
+	Server string
+
+	// UUID identifies the connection in the server logs.
+	UUID string
+}
+
+// appInfo contains the application-level statistics.
+type appInfo struct {
+	// ElapsedTime is the time since the beginning of the test in µs.
+	ElapsedTime int64
+
+	// NumBytes is the number of bytes written so far.
+	NumBytes int64
+}
+
+// tcpInfo contains the kernel-level statistics.
+type tcpInfo struct {
+	// ElapsedTime is the time since the beginning of the test in µs.
+	ElapsedTime int64
+
+	// BytesAcked is the number of bytes the client acknowledged so far.
+	BytesAcked int64
+
+	// BytesReceived is the number of bytes the server received so far.
+	BytesReceived int64
+
+	// BytesRetrans is the estimate of the bytes retransmitted so far.
+	BytesRetrans int64
+
+	// MinRTT is the minimum RTT the kernel measured in µs, if known.
+	MinRTT int64
+
+	// NotsentBytes is the data written by the application that is still
+	// buffered by the kernel, hence not counted as delivered yet.
+	NotsentBytes int64
+
+	// RTT is the smoothed RTT the kernel measured in µs.
+	RTT int64
+
+	// RTTVar is the variation of the smoothed RTT in µs.
+	RTTVar int64
+
+	// SndCwnd is the congestion window in segments.
+	SndCwnd int64
+
+	// TotalRetrans is the number of segments retransmitted so far.
+	TotalRetrans int64
+}
+
+// sample converts a measurement received from the peer at the given time
+// into a [results.Sample]. It returns false when AppInfo is missing. Since
+// the server reports the raw kernel counters, BytesAcked also includes the
+// TLS and WebSocket handshakes.
+func (m *measurement) sample(now time.Time) (results.Sample, bool) {
+	if m.AppInfo == nil {
+		return results.Sample{}, false
+	}
+	sample := results.Sample{
+		Origin:    m.Origin,
+		Direction: m.Test,
+		Bytes:     m.AppInfo.NumBytes,
+		Elapsed:   time.Duration(m.AppInfo.ElapsedTime) * time.Microsecond,
+		Time:      now,
+	}
+	if m.TCPInfo != nil {
+		sample.BytesAcked = m.TCPInfo.BytesAcked
+		sample.Cwnd = m.TCPInfo.SndCwnd
+		sample.Retransmits = m.TCPInfo.TotalRetrans
+		sample.RTT = time.Duration(m.TCPInfo.RTT) * time.Microsecond
+	}
+	return sample, true
+}
+
+// sendMeasurement sends a measurement message with the given origin to the
+// peer. Since the spec reserves the kernel statistics for the server, we
+// only include them, and log them, when sending as the server and we can
+// read them.
+func sendMeasurement(conn *websocket.Conn, start time.Time, total int64, testname, origin string) error {
+	elapsed := time.Since(start)
+	m := &measurement{
+		AppInfo: &appInfo{ElapsedTime: elapsed.Microseconds(), NumBytes: total},
+		Origin:  origin,
+		Test:    testname,
+	}
+	if origin != results.OriginServer {
+		return conn.WriteJSON(m)
+	}
+	if info, err := tcpinfo.Get(conn.NetConn()); err == nil {
+		m.TCPInfo = &tcpInfo{
+			BytesAcked:    info.BytesAcked,
+			BytesReceived: info.BytesReceived,
+			BytesRetrans:  info.RetransmittedBytes(),
+			ElapsedTime:   elapsed.Microseconds(),
+			MinRTT:        info.MinRTT.Microseconds(),
+			NotsentBytes:  info.NotsentBytes,
+			RTT:           info.RTT.Microseconds(),
+			RTTVar:        info.RTTVar.Microseconds(),
+			SndCwnd:       info.SndCwnd,
+			TotalRetrans:  info.TotalRetrans,
+		}
+		slog.Info("tcpinfo",
+			slog.String("test", testname),
+			slog.String("bytes", humanize.IEC(float64(total), "B")),
+			slog.String("acked", humanize.IEC(float64(info.BytesAcked), "B")),
+			slog.String("received", humanize.IEC(float64(info.BytesReceived), "B")),
+			slog.String("notsent", humanize.IEC(float64(info.NotsentBytes), "B")),
+			slog.Duration("rtt", info.RTT),
+			slog.Int64("cwnd", info.SndCwnd),
+			slog.Int64("retrans", info.TotalRetrans),
+		)
+	}
+	return conn.WriteJSON(m)
+}
+
+// sendConnectionInfo sends the measurement message describing conn, which
+// the server sends before the test, identified by the given UUID.
+func sendConnectionInfo(conn *websocket.Conn, testname, id string) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(maxRuntime)); err != nil {
+		return err
+	}
+	return conn.WriteJSON(&measurement{
+		ConnectionInfo: &connectionInfo{
+			Client: conn.RemoteAddr().String(),
+			Server: conn.LocalAddr().String(),
+			UUID:   id,
+		},
+		Origin: results.OriginServer,
+		Test:   testname,
+	})
+}
+
+// ackedCounter returns a function returning the bytes the peer acknowledged
+// on conn since we called ackedCounter, or zero when we cannot tell.
+func ackedCounter(conn *websocket.Conn) func() int64 {
+	info, err := tcpinfo.Get(conn.NetConn())
+	if err != nil {
+		return func() int64 { return 0 }
+	}
+	acked0 := info.BytesAcked
+	return func() int64 {
+		info, err := tcpinfo.Get(conn.NetConn())
+		if err != nil {
+			return 0
+		}
+		return info.BytesAcked - acked0
+	}
 }
 
 // newMessage creates a prepared WebSocket binary message of the given size.
@@ -60,109 +309,311 @@ func newMessage(n int) (*websocket.PreparedMessage, error) {
 }
 
 // sender writes binary WebSocket messages with adaptive sizing. Used by
-// the server for download and by the client for upload.
-func sender(ctx context.Context, conn *websocket.Conn, testname string) error {
-	var total int64
+// the server for download and by the client for upload. The emit
+// argument receives the local measurements and may be nil. We also send
+// measurement messages with the given origin to the peer every
+// [measureInterval] (see [sendMeasurement]).
+// It returns the bytes written, even on error, and the error, if any.
+//
+// The message size doubles while it is below 1/[fractionForScaling] of the
+// bytes sent so far, as the ndt7 spec says. At every [measureInterval], we
+// also cap it to the same fraction of the bytes the peer acknowledged per
+// interval lately, scaling it down when the throughput drops, since writing
+// a message much larger than what the link carries per interval blocks for
+// seconds. We use the written bytes when we cannot read the acknowledged
+// ones, which overestimates the throughput while the socket buffer fills.
+func sender(ctx context.Context, conn *websocket.Conn, testname string, emit func(results.Sample), origin string) (count int64, err error) {
 	start := time.Now()
+	acked := ackedCounter(conn)
+	sampler := startAppInfoSampler(start, testname, acked, emit)
+	defer sampler.stop()
+	defer func() { count = sampler.total.Load() }()
 	if err := conn.SetWriteDeadline(start.Add(maxRuntime)); err != nil {
-		return err
+		return 0, err
 	}
 	size := minMessageSize
 	message, err := newMessage(size)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	var (
+		capSize  = int64(maxScaledMessageSize)
+		lastTime = start
+		lastSent int64
+	)
+	// Note: the measurement messages must be written by this goroutine,
+	// so they follow the granularity of the writes, unlike the samples.
 	ticker := time.NewTicker(measureInterval)
 	defer ticker.Stop()
-	for ctx.Err() == nil {
+	// We stop at maxRuntime rather than when the write deadline hits, since
+	// a write failing with a timeout would prevent the closing handshake.
+	for ctx.Err() == nil && time.Since(start) < maxRuntime {
 		if err := conn.WritePreparedMessage(message); err != nil {
-			return err
+			return 0, err
 		}
-		total += int64(size)
+		total := sampler.total.Add(int64(size))
 		select {
 		case <-ticker.C:
-			emitAppInfo(start, total, testname)
+			if err := sendMeasurement(conn, start, total, testname, origin); err != nil {
+				return 0, err
+			}
+			now, sent := time.Now(), acked()
+			if sent <= 0 {
+				sent = total
+			}
+			capSize = messageSizeCap(sent-lastSent, now.Sub(lastTime))
+			lastTime, lastSent = now, sent
+			if int64(size) > capSize {
+				for int64(size) > capSize && size > minMessageSize {
+					size >>= 1
+				}
+				if message, err = newMessage(size); err != nil {
+					return 0, err
+				}
+				continue
+			}
 		default:
 		}
-		if int64(size) >= maxScaledMessageSize || int64(size) >= (total/fractionForScaling) {
+		if int64(size) >= min(capSize, maxScaledMessageSize) || int64(size) >= (total/fractionForScaling) {
 			continue
 		}
 		size <<= 1
 		if message, err = newMessage(size); err != nil {
-			return err
+			return 0, err
 		}
 	}
-	return nil
+	return 0, nil
 }
 
-// receiver reads WebSocket messages and discards binary data.
-// Text messages (server-side measurements) are printed to stdout.
-// Used by the client for download and by the server for upload.
-func receiver(ctx context.Context, conn *websocket.Conn, testname string) error {
-	var total int64
+// messageSizeCap returns the largest message size we should write after
+// sending count bytes during elapsed, which is 1/[fractionForScaling] of
+// the bytes sent per [measureInterval] at that rate, and at least
+// [minMessageSize]. Since we measure at the granularity of the writes,
+// elapsed may span many intervals when the link is slow.
+func messageSizeCap(count int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return maxScaledMessageSize
+	}
+	perInterval := float64(count) * measureInterval.Seconds() / elapsed.Seconds()
+	return max(int64(perInterval)/fractionForScaling, minMessageSize)
+}
+
+// receiver reads WebSocket messages and discards binary data. Used by
+// the client for download and by the server for upload. The emit argument
+// receives the local measurements as well as the measurements the peer
+// sends as text messages, timestamped on arrival, and may be nil. The
+// observe argument, which may be nil, receives each text message with its
+// arrival time and, unless we cannot parse it, the parsed measurement.
+// When origin is not empty, we also send measurement messages with that
+// origin, telling the bytes read so far, to the peer every [measureInterval].
+// It returns the bytes read, even on error, and the error, if any.
+func receiver(ctx context.Context, conn *websocket.Conn, testname string, emit func(results.Sample),
+	origin string, observe func(now time.Time, data []byte, m *measurement)) (count int64, err error) {
+	if observe == nil {
+		observe = func(time.Time, []byte, *measurement) {}
+	}
 	start := time.Now()
+	sampler := startAppInfoSampler(start, testname, func() int64 { return 0 }, emit)
+	defer sampler.stop()
+	defer func() { count = sampler.total.Load() }()
 	if err := conn.SetReadDeadline(start.Add(maxRuntime)); err != nil {
-		return err
+		return 0, err
 	}
 	conn.SetReadLimit(maxMessageSize)
+	if origin != "" {
+		if err := conn.SetWriteDeadline(start.Add(maxRuntime)); err != nil {
+			return 0, err
+		}
+	}
+	// Note: we send the measurement messages between reads, since this
+	// goroutine is the only one writing to conn until closing it.
 	ticker := time.NewTicker(measureInterval)
 	defer ticker.Stop()
 	for ctx.Err() == nil {
+		select {
+		case <-ticker.C:
+			if origin != "" {
+				if err := sendMeasurement(conn, start, sampler.total.Load(), testname, origin); err != nil {
+					return 0, err
+				}
+			}
+		default:
+		}
 		kind, reader, err := conn.NextReader()
 		if err != nil {
-			return err
+			return 0, err
 		}
 		if kind == websocket.TextMessage {
-			data, err := io.ReadAll(reader)
+			data, err := readMeasurement(reader, emit, observe)
 			if err != nil {
-				return err
+				return 0, err
 			}
-			total += int64(len(data))
-			fmt.Printf("%s\n", string(data))
+			sampler.Write(data)
 			continue
 		}
-		n, err := io.Copy(io.Discard, reader)
-		if err != nil {
-			return err
+		// Counting while copying makes the samples reflect partially
+		// received messages, which matters with large messages.
+		if _, err := io.Copy(sampler, reader); err != nil {
+			return 0, err
 		}
-		total += n
-		select {
-		case <-ticker.C:
-			emitAppInfo(start, total, testname)
-		default:
+	}
+	return 0, nil
+}
+
+// readMeasurement reads the text message from reader and passes it, with
+// its arrival time, to observe, along with the parsed measurement, unless
+// we cannot parse it, and the corresponding sample, if any, to emit, which
+// may be nil. It returns the message.
+func readMeasurement(reader io.Reader, emit func(results.Sample), observe func(now time.Time, data []byte, m *measurement)) ([]byte, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var m measurement
+	if err := json.Unmarshal(data, &m); err != nil {
+		slog.Warn("cannot parse measurement", slog.Any("err", err))
+		observe(now, data, nil)
+		return data, nil
+	}
+	observe(now, data, &m)
+	if sample, ok := m.sample(now); ok && emit != nil {
+		emit(sample)
+	}
+	return data, nil
+}
+
+// readMeasurements reads conn from its own goroutine while [sender] writes
+// to it, since a WebSocket connection allows one concurrent reader and one
+// concurrent writer. It discards the binary messages and handles the text
+// messages like [receiver] does, until reading fails, which includes when
+// the peer closes the connection. The returned channel is closed when it
+// stops, which [closeConn] waits for.
+func readMeasurements(conn *websocket.Conn, emit func(results.Sample), observe func(now time.Time, data []byte, m *measurement)) <-chan struct{} {
+	if observe == nil {
+		observe = func(time.Time, []byte, *measurement) {}
+	}
+	conn.SetReadLimit(maxMessageSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			kind, reader, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+			if kind == websocket.TextMessage {
+				_, err = readMeasurement(reader, emit, observe)
+			} else {
+				_, err = io.Copy(io.Discard, reader)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// closeConn closes conn with the WebSocket closing handshake: it sends a
+// close frame, discards the frames still in flight until the peer's close
+// frame arrives or linger expires, and then closes the TCP connection. With
+// zero linger, it closes the TCP connection right away. When reading is not
+// nil, another goroutine reads conn until closing reading (see
+// [readMeasurements]), so we wait for it instead of reading.
+func closeConn(conn *websocket.Conn, linger time.Duration, reading <-chan struct{}) {
+	defer func() {
+		conn.Close()
+		if reading != nil {
+			<-reading
+		}
+	}()
+	if linger <= 0 {
+		return
+	}
+	deadline := time.Now().Add(linger)
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := conn.WriteControl(websocket.CloseMessage, message, deadline); err != nil {
+		return
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return
+	}
+	if reading != nil {
+		<-reading
+		return
+	}
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
 		}
 	}
-	return nil
 }
 
-// upgrade performs the WebSocket upgrade handshake on the server side.
-func upgrade(rw http.ResponseWriter, req *http.Request) (*websocket.Conn, error) {
-	if req.Header.Get("Sec-WebSocket-Protocol") != wsProto {
-		rw.WriteHeader(http.StatusBadRequest)
-		return nil, errors.New("missing Sec-WebSocket-Protocol header")
+// upgrade performs the WebSocket upgrade handshake on the server side,
+// replying with problem details when policy rejects the request.
+func upgrade(rw http.ResponseWriter, req *http.Request, policy *upgradePolicy) (*websocket.Conn, error) {
+	if !policy.checkOrigin(req) {
+		err := fmt.Errorf("the %q origin is not allowed", req.Header.Get("Origin"))
+		problem.Write(rw, problem.New(req, http.StatusForbidden, problem.TypeOriginNotAllowed, err.Error()))
+		return nil, err
+	}
+	subprotocol, err := policy.negotiate(req)
+	if err != nil {
+		problem.Write(rw, problem.New(req, http.StatusBadRequest, problem.TypeUnsupportedProtocol, err.Error()))
+		return nil, err
 	}
 	h := http.Header{}
-	h.Add("Sec-WebSocket-Protocol", wsProto)
-	u := websocket.Upgrader{
+	h.Set("Server", serverName)
+	if subprotocol != "" {
+		h.Add("Sec-WebSocket-Protocol", subprotocol)
+	}
+	u := &websocket.Upgrader{
+		// We already checked the origin using policy.
+		CheckOrigin: func(*http.Request) bool { return true },
+		Error: func(rw http.ResponseWriter, req *http.Request, status int, reason error) {
+			problem.Write(rw, problem.New(req, status, problem.TypeInvalidRequest, reason.Error()))
+		},
 		ReadBufferSize:  maxMessageSize,
 		WriteBufferSize: maxMessageSize,
 	}
+	if isExtendedConnect(req) {
+		h.Set(upgradePathHeader, upgradeRFC8441)
+		return upgradeExtendedConnect(u, rw, req, h)
+	}
+	h.Set(upgradePathHeader, upgradeRFC6455)
 	return u.Upgrade(rw, req, h)
 }
 
-// dial connects to a WebSocket endpoint on the client side.
-func dial(ctx context.Context, wsURL string, insecure bool) (*websocket.Conn, error) {
+// dial connects to a WebSocket endpoint on the client side using dr to
+// establish and record the underlying TCP connection and TLS handshake,
+// resuming the TLS sessions in cache, if not nil. It also returns the
+// upgrade response, which tells the upgrade path the server saw, if any.
+func dial(ctx context.Context, dr *dialer.Recorder, cache *dialer.SessionCache, wsURL string, insecure bool) (*websocket.Conn, *http.Response, error) {
 	dialer := websocket.Dialer{
+		NetDialContext:  dr.DialContext,
 		ReadBufferSize:  maxMessageSize,
 		WriteBufferSize: maxMessageSize,
+		// Like browsers, we offer http/1.1, which lets us check the ALPN.
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: insecure,
+			NextProtos:         []string{"http/1.1"},
+			VerifyConnection:   dr.VerifyConnection,
+		},
 	}
-	if insecure {
-		dialer.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+	if cache != nil {
+		dialer.TLSClientConfig.ClientSessionCache = cache
 	}
 	headers := http.Header{}
 	headers.Add("Sec-WebSocket-Protocol", wsProto)
-	conn, _, err := dialer.DialContext(ctx, wsURL, headers)
-	return conn, err
+	conn, resp, err := dialer.DialContext(ctx, wsURL, headers)
+	if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+		// Include why the server rejected us, keeping ErrBadHandshake
+		// in the chain, since it determines the failure class.
+		return nil, nil, fmt.Errorf("%w: %w", err, problem.FromResponse(resp))
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, resp, nil
 }
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// rawMessage is a text message received from the peer, as written by
+// [*rawRecorder]. Messages that are not valid JSON, which we cannot parse
+// as measurements, are saved as text rather than dropped.
+type rawMessage struct {
+	// Test is the test during which we received the message.
+	Test string `json:"test"`
+
+	// Time is when we received the message.
+	Time time.Time `json:"time"`
+
+	// Message is the message as received, if it is valid JSON.
+	Message json.RawMessage `json:"message,omitempty"`
+
+	// Text is the message as received, if it is not valid JSON.
+	Text string `json:"text,omitempty"`
+}
+
+// rawRecorder appends the text messages received from the peer to a file
+// as NDJSON, preserving the fields that the result document omits.
+//
+// Construct using [newRawRecorder]. The nil recorder discards messages.
+type rawRecorder struct {
+	enc  *json.Encoder
+	file *os.File
+	mu   sync.Mutex
+}
+
+// newRawRecorder opens path for appending and returns a [*rawRecorder]
+// writing to it.
+func newRawRecorder(path string) (*rawRecorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &rawRecorder{enc: json.NewEncoder(file), file: file}, nil
+}
+
+// record appends the message received at the given time during the given
+// test, logging failures rather than interrupting the measurement.
+func (rr *rawRecorder) record(testname string, now time.Time, data []byte) {
+	if rr == nil {
+		return
+	}
+	m := rawMessage{Test: testname, Time: now}
+	if json.Valid(data) {
+		m.Message = data
+	} else {
+		m.Text = string(data)
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if err := rr.enc.Encode(m); err != nil {
+		slog.Warn("cannot save raw message", slog.Any("err", err))
+	}
+}
+
+// Close closes the file.
+func (rr *rawRecorder) Close() error {
+	if rr == nil {
+		return nil
+	}
+	return rr.file.Close()
+}
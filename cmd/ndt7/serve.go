@@ -5,67 +5,291 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/bassosimone/2026-02-provlima/internal/acme"
+	"github.com/bassosimone/2026-02-provlima/internal/admission"
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/debug"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/health"
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/privacy"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
 	"github.com/bassosimone/2026-02-provlima/internal/slogging"
+	"github.com/bassosimone/2026-02-provlima/internal/systemd"
 	"github.com/bassosimone/runtimex"
 	"github.com/bassosimone/vflag"
+	"github.com/google/uuid"
 )
 
+// serverName is the Server header of the upgrade responses, which clients
+// check to notice middleboxes terminating the connection in our place.
+const serverName = "ndt7"
+
 func serveMain(ctx context.Context, args []string) error {
 	var (
-		addressFlag = "127.0.0.1"
-		certFlag    = "cert.pem"
-		formatFlag  = "text"
-		keyFlag     = "key.pem"
-		portFlag    = "4567"
+		acmeFlag           = false
+		acmeCacheFlag      = "acme-cache"
+		acmeEmailFlag      = ""
+		addressFlag        = "127.0.0.1"
+		allowedOriginsFlag = ""
+		anonymizeIPsFlag   = "none"
+		certFlag           = "cert.pem"
+		configFlag         = ""
+		debugAddrFlag      = ""
+		domainFlag         = ""
+		errorFormatFlag    = "text"
+		extConnectFlag     = false
+		formatFlag         = "text"
+		httpPortFlag       = "80"
+		keyFlag            = "key.pem"
+		maxTransfersFlag   = 0
+		notsentLowatFlag   = 0
+		omitHostnamesFlag  = false
+		pairsFlag          = ""
+		portFlag           = "4567"
+		printUnitFlag      = false
+		queueTimeoutFlag   = 10 * time.Second
+		subprotocolFlag    = "strict"
 	)
 
 	fset := vflag.NewFlagSet("ndt7 serve", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.BoolVar(&acmeFlag, 0, "acme", "Obtain the TLS certificate from Let's Encrypt for --domain.")
+	fset.StringVar(&acmeCacheFlag, 0, "acme-cache", "Cache ACME accounts and certificates in `DIR`.")
+	fset.StringVar(&acmeEmailFlag, 0, "acme-email", "Use `EMAIL` as the ACME account contact.")
 	fset.StringVar(&addressFlag, 'A', "address", "Use the given IP `ADDRESS`.")
+	fset.StringVar(&allowedOriginsFlag, 0, "allowed-origins", "Also accept WebSockets from the comma-separated cross-origin `PATTERNS` (e.g., https://*.example.org, or * for any).")
+	fset.StringVar(&anonymizeIPsFlag, 0, "anonymize-ips", "Store the IP addresses in the results and logs using `MODE` (none, truncate to /24 or /48, or omit).")
 	fset.StringVar(&certFlag, 0, "cert", "Use `FILE` as the TLS certificate.")
+	fset.StringVar(&configFlag, 0, "config", "Load flag defaults from TOML `FILE`.")
+	fset.StringVar(&debugAddrFlag, 0, "debug-addr", "Serve /debug/vars and /debug/pprof/ over plain HTTP at `ENDPOINT` (e.g., :6060 for localhost; empty to disable).")
+	fset.StringVar(&domainFlag, 0, "domain", "Use the comma-separated `DOMAINS` with --acme.")
+	fset.StringVar(&errorFormatFlag, 0, "error-format", "Use `FORMAT` for the final error, if any (text or json).")
+	fset.BoolVar(&extConnectFlag, 0, "extended-connect", "Also accept WebSockets over HTTP/2 using RFC 8441 extended CONNECT.")
 	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&httpPortFlag, 0, "http-port", "Answer ACME HTTP-01 challenges on TCP `PORT` (empty to disable).")
 	fset.StringVar(&keyFlag, 0, "key", "Use `FILE` as the TLS private key.")
+	fset.IntVar(&maxTransfersFlag, 0, "max-transfers", "Run at most `N` tests at once, queueing the others (0 for no limit).")
+	fset.IntVar(&notsentLowatFlag, 0, "notsent-lowat", "Limit the unsent data buffered by the kernel during downloads to `BYTES` (0 for the system default).")
+	fset.BoolVar(&omitHostnamesFlag, 0, "omit-hostnames", "Omit the hostnames from the results and logs.")
+	fset.StringVar(&pairsFlag, 0, "pairs", "Append a record pairing each download with the upload sharing its test_id to `FILE` as NDJSON.")
 	fset.StringVar(&portFlag, 'p', "port", "Use the given TCP `PORT`.")
+	fset.BoolVar(&printUnitFlag, 0, "print-systemd-unit", "Print a systemd unit running the server with the current flags and exit.")
+	fset.DurationVar(&queueTimeoutFlag, 0, "queue-timeout", "Reject queued tests with 503 after `DURATION`.")
+	fset.StringVar(&subprotocolFlag, 0, "subprotocol", "Negotiate the WebSocket subprotocol using `MODE` (strict or lenient).")
+	failure.OnError(failure.Usage, config.Setup(fset, "NDT7", "serve", args))
 	runtimex.PanicOnError0(fset.Parse(args))
+	failure.Setup(errorFormatFlag)
+
+	anon, err := privacy.New(anonymizeIPsFlag, omitHostnamesFlag)
+	failure.OnError(failure.Usage, err)
+
+	policy, err := newUpgradePolicy(allowedOriginsFlag, subprotocolFlag)
+	failure.OnError(failure.Usage, err)
+
+	var acmeConfig *acme.Config
+	if acmeFlag {
+		if domainFlag == "" {
+			failure.Exit(failure.Usage, errors.New("--acme requires --domain"))
+		}
+		acmeConfig = &acme.Config{
+			Domains:  strings.Split(domainFlag, ","),
+			CacheDir: runtimex.LogFatalOnError1(filepath.Abs(acmeCacheFlag)),
+			Email:    acmeEmailFlag,
+		}
+	}
+
+	if printUnitFlag {
+		env := config.Environ(fset, "NDT7", "config", "print-systemd-unit")
+		unit := runtimex.LogFatalOnError1(systemd.NewUnit("ndt7 server", []string{"serve"}, env))
+		if extConnectFlag {
+			unit.Environment = append(unit.Environment, "GODEBUG="+xconnectGODEBUG)
+		}
+		if acmeConfig != nil {
+			// The service cannot create the cache, so we do it here.
+			runtimex.LogFatalOnError0(os.MkdirAll(acmeConfig.CacheDir, 0700))
+			unit.ReadWritePaths = append(unit.ReadWritePaths, acmeConfig.CacheDir)
+		}
+		fmt.Print(unit.String())
+		return nil
+	}
+
+	// The HTTP/2 server reads GODEBUG when the program starts, so we cannot
+	// enable extended CONNECT here and we require the user to do that.
+	if extConnectFlag && !xconnectEnabled() {
+		failure.Exit(failure.Usage, fmt.Errorf("--extended-connect requires GODEBUG=%s", xconnectGODEBUG))
+	}
 
 	slogging.Setup(formatFlag)
+	anon.Setup()
 
+	adm := admission.New(maxTransfersFlag, queueTimeoutFlag)
+	if maxTransfersFlag > 0 {
+		slog.Info("limiting concurrent tests",
+			slog.Int("max", maxTransfersFlag),
+			slog.Duration("queueTimeout", queueTimeoutFlag),
+		)
+	}
+
+	var pairs *pairRecorder
+	if pairsFlag != "" {
+		pairs, err = newPairRecorder(pairsFlag, anon)
+		failure.OnError(failure.Generic, err)
+		defer pairs.Close()
+	}
+
+	// We admit tests before upgrading, so rejected clients get an HTTP error.
+	stats := &serveStats{}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ndt/v7/download", func(rw http.ResponseWriter, req *http.Request) {
-		conn, err := upgrade(rw, req)
+		release, ok := adm.Admit(rw, req)
+		if !ok {
+			return
+		}
+		defer release()
+		if !claimConn(rw, req, "download") {
+			return
+		}
+		pt, ok := pairs.begin(rw, req, "download")
+		if !ok {
+			return
+		}
+		conn, err := upgrade(rw, req, policy)
 		if err != nil {
+			pt.cancel()
+			return
+		}
+		defer closeConn(conn, defaultLinger, nil)
+		connID := uuid.NewString()
+		slog.Info("download",
+			slog.String("remote", req.RemoteAddr),
+			slog.String("upgradePath", rw.Header().Get(upgradePathHeader)),
+			slog.String("uuid", connID),
+		)
+		if err := sendConnectionInfo(conn, "download", connID); err != nil {
+			pt.cancel()
 			return
 		}
-		slog.Info("download", slog.String("remote", req.RemoteAddr))
-		sender(req.Context(), conn, "download")
+		if notsentLowatFlag > 0 {
+			if err := setNotsentLowat(conn.NetConn(), notsentLowatFlag); err != nil {
+				slog.Warn("cannot set TCP_NOTSENT_LOWAT", slog.Any("err", err))
+			}
+		}
+		done := stats.track("download")
+		count, _ := sender(req.Context(), conn, "download", nil, results.OriginServer)
+		done(count)
+		pt.done(count)
 	})
 	mux.HandleFunc("/ndt/v7/upload", func(rw http.ResponseWriter, req *http.Request) {
-		conn, err := upgrade(rw, req)
+		release, ok := adm.Admit(rw, req)
+		if !ok {
+			return
+		}
+		defer release()
+		if !claimConn(rw, req, "upload") {
+			return
+		}
+		pt, ok := pairs.begin(rw, req, "upload")
+		if !ok {
+			return
+		}
+		conn, err := upgrade(rw, req, policy)
 		if err != nil {
+			pt.cancel()
 			return
 		}
-		slog.Info("upload", slog.String("remote", req.RemoteAddr))
-		receiver(req.Context(), conn, "upload")
+		defer closeConn(conn, defaultLinger, nil)
+		connID := uuid.NewString()
+		slog.Info("upload",
+			slog.String("remote", req.RemoteAddr),
+			slog.String("upgradePath", rw.Header().Get(upgradePathHeader)),
+			slog.String("uuid", connID),
+		)
+		if err := sendConnectionInfo(conn, "upload", connID); err != nil {
+			pt.cancel()
+			return
+		}
+		done := stats.track("upload")
+		// The client tells us how many bytes it sent, so the difference
+		// with the bytes we read is what was still in flight.
+		var client *appInfo
+		count, _ := receiver(req.Context(), conn, "upload", nil, results.OriginServer, func(_ time.Time, _ []byte, m *measurement) {
+			if m != nil && m.AppInfo != nil && m.Origin == results.OriginClient {
+				client = m.AppInfo
+			}
+		})
+		if client != nil {
+			slog.Info("client measurement",
+				slog.String("test", "upload"),
+				slog.String("bytes", humanize.IEC(float64(client.NumBytes), "B")),
+				slog.Duration("elapsed", time.Duration(client.ElapsedTime)*time.Microsecond),
+			)
+		}
+		done(count)
+		pt.done(count)
 	})
 
 	endpoint := net.JoinHostPort(addressFlag, portFlag)
-	srv := &http.Server{Addr: endpoint, Handler: mux}
+	srv := &http.Server{Addr: endpoint, Handler: mux, ConnContext: withConnTest}
 	go func() {
 		defer srv.Close()
 		<-ctx.Done()
 	}()
 
+	certificate := health.FileCertificate(certFlag)
+	if acmeConfig != nil {
+		// The RFC 6455 WebSocket upgrade requires HTTP/1.1, so we only offer
+		// HTTP/2 with --extended-connect, besides the protocol for TLS-ALPN-01
+		// challenges, which the TLS stack answers before reaching the mux.
+		protos := []string{"http/1.1"}
+		if extConnectFlag {
+			protos = []string{"h2", "http/1.1"}
+		}
+		m := runtimex.LogFatalOnError1(acme.NewManager(acmeConfig))
+		srv.TLSConfig = acme.TLSConfig(m, protos...)
+		certificate = health.TLSCertificate(srv.TLSConfig, acmeConfig.Domains[0])
+		certFlag, keyFlag = "", "" // use srv.TLSConfig.GetCertificate
+		if httpPortFlag != "" {
+			go func() {
+				err := acme.ServeHTTP01(ctx, m, net.JoinHostPort(addressFlag, httpPortFlag))
+				if err != nil {
+					slog.Warn("cannot serve ACME HTTP-01 challenges", slog.Any("err", err))
+				}
+			}()
+		}
+		slog.Info("using ACME", slog.Any("domains", acmeConfig.Domains))
+	}
+
+	// We register the health endpoints last, once we know the certificate.
+	checker := health.New(adm, certificate)
+	checker.Register(mux)
+	ln, err := checker.Listen("tcp", endpoint)
+	failure.OnError(failure.Generic, err)
+	ln = stats.wire.Listener(ln)
+
+	if debugAddrFlag != "" {
+		stats.publish()
+		failure.OnError(failure.Generic, debug.Listen(ctx, debugAddrFlag))
+	}
+
 	slog.Info("serving at", slog.String("addr", endpoint))
-	err := srv.ListenAndServeTLS(certFlag, keyFlag)
+	err = srv.ServeTLS(ln, certFlag, keyFlag)
 	slog.Info("interrupted", slog.Any("err", err))
 
 	if errors.Is(err, http.ErrServerClosed) {
 		err = nil
 	}
-	runtimex.LogFatalOnError0(err)
+	failure.OnError(failure.Generic, err)
 	return nil
 }
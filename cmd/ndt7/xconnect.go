@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// upgradePathHeader is the response header telling the client which
+	// upgrade the server saw, which differs from the client one when an
+	// HTTP/2 reverse proxy translates the upgrade.
+	upgradePathHeader = "Ndt7-Upgrade-Path"
+
+	// upgradeRFC6455 is the HTTP/1.1 Upgrade-based upgrade path.
+	upgradeRFC6455 = "rfc6455"
+
+	// upgradeRFC8441 is the HTTP/2 extended CONNECT upgrade path.
+	upgradeRFC8441 = "rfc8441"
+
+	// xconnectGODEBUG is the GODEBUG setting enabling extended CONNECT in
+	// the net/http HTTP/2 server, which is disabled by default.
+	xconnectGODEBUG = "http2xconnect=1"
+)
+
+// xconnectEnabled returns whether the HTTP/2 server accepts extended CONNECT,
+// which net/http decides by reading GODEBUG from the environment at startup.
+func xconnectEnabled() bool {
+	return strings.Contains(os.Getenv("GODEBUG"), xconnectGODEBUG)
+}
+
+// isExtendedConnect returns whether req is an RFC 8441 WebSocket request.
+func isExtendedConnect(req *http.Request) bool {
+	return req.Method == http.MethodConnect && req.ProtoMajor == 2 &&
+		strings.EqualFold(req.Header.Get(":protocol"), "websocket")
+}
+
+// upgradeExtendedConnect upgrades an RFC 8441 request using u.
+//
+// RFC 8441 bootstraps WebSockets over an HTTP/2 stream using an extended
+// CONNECT request, which h2-only reverse proxies use to forward WebSocket
+// connections. The websocket package only supports the RFC 6455 upgrade,
+// so we give it an HTTP/1.1-looking request and a hijackable connection
+// wrapping the stream, and we reply using HTTP/2 headers instead of the
+// "101 Switching Protocols" response it writes.
+func upgradeExtendedConnect(u *websocket.Upgrader, rw http.ResponseWriter, req *http.Request, h http.Header) (*websocket.Conn, error) {
+	// RFC 8441 has no key, but the websocket package insists on one. We
+	// discard the response containing the accept value it computes.
+	var key [16]byte
+	rand.Read(key[:])
+	greq := req.Clone(req.Context())
+	greq.Method = http.MethodGet
+	greq.Header.Set("Connection", "Upgrade")
+	greq.Header.Set("Upgrade", "websocket")
+	greq.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key[:]))
+
+	for name, values := range h {
+		rw.Header()[name] = values
+	}
+	conn := &streamConn{
+		body:   req.Body,
+		rc:     http.NewResponseController(rw),
+		remote: streamAddr(req.RemoteAddr),
+		rw:     rw,
+	}
+	if local, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		conn.local = streamAddr(local.String())
+	}
+	return u.Upgrade(streamHijacker{rw, conn}, greq, nil)
+}
+
+// streamHijacker is an [http.ResponseWriter] whose Hijack method
+// returns a [*streamConn] wrapping the HTTP/2 stream.
+type streamHijacker struct {
+	http.ResponseWriter
+	conn *streamConn
+}
+
+var _ http.Hijacker = streamHijacker{}
+
+// Hijack implements [http.Hijacker].
+func (sh streamHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	brw := bufio.NewReadWriter(bufio.NewReader(sh.conn), bufio.NewWriter(sh.conn))
+	return sh.conn, brw, nil
+}
+
+// streamAddr is the [net.Addr] of a [*streamConn] endpoint.
+type streamAddr string
+
+var _ net.Addr = streamAddr("")
+
+// Network implements [net.Addr].
+func (sa streamAddr) Network() string {
+	return "tcp"
+}
+
+// String implements [net.Addr].
+func (sa streamAddr) String() string {
+	return string(sa)
+}
+
+// streamConn is a [net.Conn] reading from the request body and writing
+// to the response of an HTTP/2 extended CONNECT stream.
+type streamConn struct {
+	body      io.ReadCloser
+	handshake bool
+	local     net.Addr
+	rc        *http.ResponseController
+	remote    net.Addr
+	rw        http.ResponseWriter
+}
+
+var _ net.Conn = &streamConn{}
+
+// Read implements [net.Conn].
+func (sc *streamConn) Read(data []byte) (int, error) {
+	return sc.body.Read(data)
+}
+
+// Write implements [net.Conn].
+//
+// The first write is the HTTP/1.1 handshake response, which we replace
+// with a 200 response carrying the headers we already set.
+func (sc *streamConn) Write(data []byte) (int, error) {
+	if !sc.handshake {
+		sc.handshake = true
+		sc.rw.WriteHeader(http.StatusOK)
+		return len(data), sc.rc.Flush()
+	}
+	count, err := sc.rw.Write(data)
+	if err != nil {
+		return count, err
+	}
+	return count, sc.rc.Flush()
+}
+
+// Close implements [net.Conn]. The stream ends when the handler returns.
+func (sc *streamConn) Close() error {
+	return sc.body.Close()
+}
+
+// LocalAddr implements [net.Conn].
+func (sc *streamConn) LocalAddr() net.Addr {
+	if sc.local == nil {
+		return streamAddr("")
+	}
+	return sc.local
+}
+
+// RemoteAddr implements [net.Conn].
+func (sc *streamConn) RemoteAddr() net.Addr {
+	return sc.remote
+}
+
+// SetDeadline implements [net.Conn].
+func (sc *streamConn) SetDeadline(t time.Time) error {
+	if err := sc.rc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return sc.rc.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements [net.Conn].
+func (sc *streamConn) SetReadDeadline(t time.Time) error {
+	return sc.rc.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements [net.Conn].
+func (sc *streamConn) SetWriteDeadline(t time.Time) error {
+	return sc.rc.SetWriteDeadline(t)
+}
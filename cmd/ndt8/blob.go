@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/admission"
+	"github.com/bassosimone/2026-02-provlima/internal/infinite"
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+)
+
+// maxBlobClients is the number of clients above which the blob limiter
+// forgets about the clients that could already make a full burst.
+const maxBlobClients = 4096
+
+// blobBucket is the token bucket of a single client.
+type blobBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// blobLimiter limits the blob requests of each client using a token bucket
+// allowing perMinute requests per minute with bursts of the same size.
+//
+// Construct using [newBlobLimiter].
+type blobLimiter struct {
+	buckets   map[string]*blobBucket // client address → bucket
+	mu        sync.Mutex
+	perMinute float64
+}
+
+// newBlobLimiter constructs a new [*blobLimiter].
+func newBlobLimiter(perMinute int) *blobLimiter {
+	return &blobLimiter{
+		buckets:   make(map[string]*blobBucket),
+		perMinute: float64(perMinute),
+	}
+}
+
+// refill returns the tokens of bucket at the given time.
+func (bl *blobLimiter) refill(bucket *blobBucket, now time.Time) float64 {
+	return min(bucket.tokens+now.Sub(bucket.last).Minutes()*bl.perMinute, bl.perMinute)
+}
+
+// allow returns whether client may make a request now or, otherwise,
+// how long it should wait before trying again.
+func (bl *blobLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	if len(bl.buckets) >= maxBlobClients {
+		for key, bucket := range bl.buckets {
+			if bl.refill(bucket, now) >= bl.perMinute {
+				delete(bl.buckets, key)
+			}
+		}
+	}
+
+	bucket, found := bl.buckets[client]
+	if !found {
+		bucket = &blobBucket{tokens: bl.perMinute, last: now}
+		bl.buckets[client] = bucket
+	}
+	bucket.tokens, bucket.last = bl.refill(bucket, now), now
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / bl.perMinute * float64(time.Minute))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// blobHandler serves blobs of the requested size without a session, for
+// quick checks using generic tools (e.g., curl), limiting the requests
+// each client makes, since there is no session to account them to.
+type blobHandler struct {
+	adm     *admission.Controller
+	limiter *blobLimiter
+	pace    float64 // download rate limit in bit/s or zero
+}
+
+var _ http.Handler = &blobHandler{}
+
+// ServeHTTP implements [http.Handler].
+func (bh *blobHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	count, err := strconv.ParseInt(req.PathValue("size"), 10, 64)
+	if err != nil || count <= 0 || count > maxChunkSize {
+		problem.Write(rw, problem.New(req, http.StatusBadRequest, problem.TypeInvalidRequest,
+			fmt.Sprintf("the size must be between 1 and %d bytes", maxChunkSize)))
+		return
+	}
+
+	client, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		client = req.RemoteAddr
+	}
+	if ok, wait := bh.limiter.allow(client, time.Now()); !ok {
+		slog.Info("blob rate limited", slog.String("remote", req.RemoteAddr), slog.Duration("wait", wait))
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		problem.Write(rw, problem.New(req, http.StatusTooManyRequests, problem.TypeRateLimited,
+			fmt.Sprintf("at most %d requests per minute are allowed", int(bh.limiter.perMinute))))
+		return
+	}
+
+	release, ok := bh.adm.Admit(rw, req)
+	if !ok {
+		return
+	}
+	defer release()
+
+	slog.Info("GET blob",
+		slog.Int64("size", count),
+		slog.String("proto", req.Proto),
+		slog.String("remote", req.RemoteAddr),
+	)
+
+	t0 := time.Now()
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Content-Length", strconv.FormatInt(count, 10))
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return
+	}
+	var w io.Writer = rw
+	if bh.pace > 0 && !isKernelPaced(req.Context()) {
+		w = newPacingWriter(req.Context(), rw, bh.pace)
+	}
+	buf := make([]byte, 1<<20) // 1 MiB
+	written, err := io.CopyBuffer(w, io.LimitReader(infinite.Reader{}, count), buf)
+
+	slog.Info("GET blob done",
+		slog.Int64("bytes", written),
+		slog.Duration("elapsed", time.Since(t0)),
+		slog.Any("err", err),
+		slog.String("remote", req.RemoteAddr),
+	)
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/runtimex"
+	"github.com/google/uuid"
+)
+
+// noStore returns an [http.Handler] adding to the responses of next the
+// headers telling caches not to store them, so that transparent caches do
+// not serve measurement payloads in place of the server.
+//
+// Each response also gets a unique ETag, which lets clients notice caches
+// replaying responses anyway, unless next replaces it, as the object does,
+// and Vary: *, which tells that no stored response matches a request.
+func noStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.Header().Set("ETag", strconv.Quote(runtimex.PanicOnError1(uuid.NewV7()).String()))
+		rw.Header().Add("Vary", "*")
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// cacheDetector is an [http.RoundTripper] recording the responses showing
+// that a cache or a proxy served or forwarded them.
+//
+// Proxies add Via and caches add Age, as RFC 9110 and RFC 9111 require,
+// while caches ignoring our headers replay previously seen ETags.
+type cacheDetector struct {
+	rt    http.RoundTripper
+	mu    sync.Mutex
+	etags map[string]bool
+	found []results.Intermediary
+}
+
+// newCacheDetector returns a new [*cacheDetector] using rt.
+func newCacheDetector(rt http.RoundTripper) *cacheDetector {
+	return &cacheDetector{rt: rt, etags: make(map[string]bool)}
+}
+
+// RoundTrip implements [http.RoundTripper].
+func (cd *cacheDetector) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := cd.rt.RoundTrip(req)
+	if err == nil {
+		cd.check(req, resp)
+	}
+	return resp, err
+}
+
+// check records resp when it shows a cache or a proxy.
+func (cd *cacheDetector) check(req *http.Request, resp *http.Response) {
+	in := results.Intermediary{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Age:    resp.Header.Get("Age"),
+		Via:    resp.Header.Get("Via"),
+		Time:   time.Now(),
+	}
+
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+
+	// The object ETag never changes, so it does not tell anything.
+	if etag := resp.Header.Get("ETag"); etag != "" && etag != objectETag {
+		if cd.etags[etag] {
+			in.ETag = etag
+		}
+		cd.etags[etag] = true
+	}
+	if in.Age == "" && in.Via == "" && in.ETag == "" {
+		return
+	}
+	slog.Warn("response from a cache or proxy",
+		slog.String("method", in.Method),
+		slog.String("path", in.Path),
+		slog.String("age", in.Age),
+		slog.String("via", in.Via),
+		slog.String("etag", in.ETag),
+	)
+	cd.found = append(cd.found, in)
+}
+
+// Intermediaries returns a copy of the recorded responses.
+func (cd *cacheDetector) Intermediaries() []results.Intermediary {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	return slices.Clone(cd.found)
+}
@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/runtimex"
+)
+
+// The canary is a small body, made of zeros like the chunks, whose sender
+// writes half of it, waits [canaryGap], and writes the other half. At the
+// start of a session, the client downloads and uploads it, and the receiver
+// verifies that it arrives uncompressed, unaltered, and unbuffered, which
+// proves that no intermediary rewrites or holds the data plane.
+const (
+	// canaryHalf is the size of each half of the canary.
+	canaryHalf = 8 << 10
+
+	// canaryGap is how long the sender waits between the halves.
+	canaryGap = 200 * time.Millisecond
+)
+
+// errAlteredPayload is the error [runCanary] wraps when the canary shows
+// that an intermediary compresses, modifies, or buffers the data plane.
+var errAlteredPayload = errors.New("an intermediary altered the data plane")
+
+// writeCanary writes the canary to w, calling flush after each half.
+func writeCanary(ctx context.Context, w io.Writer, flush func() error) error {
+	half := make([]byte, canaryHalf)
+	for idx := range 2 {
+		if idx > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(canaryGap):
+			}
+		}
+		if _, err := w.Write(half); err != nil {
+			return err
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCanary reads the canary from r, whose Content-Encoding header is
+// encoding, and returns an error describing how an intermediary altered
+// it, if any. It also returns how long after the first half the second
+// half started arriving.
+func checkCanary(r io.Reader, encoding string) (time.Duration, error) {
+	if encoding != "" && encoding != "identity" {
+		return 0, fmt.Errorf("the canary arrived with Content-Encoding %q rather than uncompressed", encoding)
+	}
+	var body bytes.Buffer
+	if _, err := io.CopyN(&body, r, canaryHalf); err != nil {
+		return 0, fmt.Errorf("cannot read the first half of the canary: %w", err)
+	}
+	t0 := time.Now()
+	buf := make([]byte, canaryHalf)
+	var gap time.Duration
+	for {
+		count, err := r.Read(buf)
+		if count > 0 && gap == 0 {
+			gap = time.Since(t0)
+		}
+		body.Write(buf[:count])
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return gap, fmt.Errorf("cannot read the second half of the canary: %w", err)
+		}
+		if body.Len() > 2*canaryHalf {
+			break // no need to read more to know it is altered
+		}
+	}
+	if body.Len() != 2*canaryHalf {
+		return gap, fmt.Errorf("the canary is %d bytes long rather than %d", body.Len(), 2*canaryHalf)
+	}
+	if offset := slices.IndexFunc(body.Bytes(), func(b byte) bool { return b != 0 }); offset >= 0 {
+		return gap, fmt.Errorf("the canary contains nonzero bytes at offset %d", offset)
+	}
+	if gap < canaryGap/2 {
+		return gap, fmt.Errorf("the second half of the canary arrived %s after the first rather than %s later, so it was buffered",
+			gap.Round(time.Millisecond), canaryGap)
+	}
+	return gap, nil
+}
+
+// handleGetCanary sends the canary, which the client verifies.
+func (sm *sessionManager) handleGetCanary(rw http.ResponseWriter, req *http.Request) {
+	sid := req.PathValue("sid")
+	if _, ok := sm.getSession(sid); !ok {
+		sm.writeNotFound(rw, req, sid)
+		return
+	}
+	slog.Info("GET canary",
+		slog.String("sid", sid),
+		slog.String("remote", req.RemoteAddr),
+	)
+	rc := http.NewResponseController(rw)
+	rw.Header().Set("Content-Length", strconv.Itoa(2*canaryHalf))
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.WriteHeader(http.StatusOK)
+	if err := writeCanary(req.Context(), rw, rc.Flush); err != nil {
+		slog.Warn("GET canary failed", slog.String("sid", sid), slog.Any("err", err))
+	}
+}
+
+// handlePutCanary verifies the canary the client sends.
+func (sm *sessionManager) handlePutCanary(rw http.ResponseWriter, req *http.Request) {
+	sid := req.PathValue("sid")
+	if _, ok := sm.getSession(sid); !ok {
+		sm.writeNotFound(rw, req, sid)
+		return
+	}
+	gap, err := checkCanary(req.Body, req.Header.Get("Content-Encoding"))
+	slog.Info("PUT canary",
+		slog.String("sid", sid),
+		slog.Duration("gap", gap),
+		slog.Any("err", err),
+		slog.String("remote", req.RemoteAddr),
+	)
+	if err != nil {
+		writeProblem(rw, req, http.StatusUnprocessableEntity, problem.TypeAlteredPayload, sid, err.Error())
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// runCanary downloads and uploads the canary of the given session and
+// returns the corresponding middlebox checks, or an error, which wraps
+// [errAlteredPayload] when an intermediary altered the data plane. Servers predating the canary
+// reply with a plain 404, in which case we skip the checks.
+func runCanary(ctx context.Context, client *http.Client, baseURL *url.URL, sid string) ([]results.MiddleboxCheck, error) {
+	u := baseURL.JoinPath(fmt.Sprintf("/ndt/v8/session/%s/canary", sid)).String()
+	var checks []results.MiddleboxCheck
+	add := func(name string, err error) error {
+		check := results.MiddleboxCheck{Name: name, Expected: "unaltered", Observed: "unaltered", OK: err == nil}
+		if err != nil {
+			check.Observed = err.Error()
+		}
+		checks = append(checks, check)
+		return err
+	}
+
+	// Asking for the identity encoding also prevents the transport from
+	// transparently decompressing the body, which would hide compression.
+	req := runtimex.LogFatalOnError1(http.NewRequestWithContext(ctx, "GET", u, http.NoBody))
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var details *problem.Details
+	if resp.StatusCode != http.StatusOK {
+		err := problem.FromResponse(resp)
+		if resp.StatusCode == http.StatusNotFound && !errors.As(err, &details) {
+			slog.Warn("the server does not support the canary, skipping the conformance checks")
+			return nil, nil
+		}
+		return nil, fmt.Errorf("download canary: %w", err)
+	}
+	gap, err := checkCanary(resp.Body, resp.Header.Get("Content-Encoding"))
+	slog.Info("download canary", slog.Duration("gap", gap), slog.Any("err", err))
+	if err := add("downloadCanary", err); err != nil {
+		return checks, fmt.Errorf("download canary: %w: %w", errAlteredPayload, err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeCanary(ctx, pw, func() error { return nil }))
+	}()
+	req = runtimex.LogFatalOnError1(http.NewRequestWithContext(ctx, "PUT", u, pr))
+	req.ContentLength = 2 * canaryHalf
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = client.Do(req)
+	if err != nil {
+		return checks, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		add("uploadCanary", nil)
+		return checks, nil
+	}
+	err = problem.FromResponse(resp)
+	if errors.As(err, &details) && details.Type == problem.TypeAlteredPayload {
+		err = fmt.Errorf("%w: %s", errAlteredPayload, details.Detail)
+		add("uploadCanary", errors.New(details.Detail))
+	}
+	return checks, fmt.Errorf("upload canary: %w", err)
+}
@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import "github.com/bassosimone/2026-02-provlima/internal/debug"
+
+// recordTransfer adds a transfer in the given direction that moved count
+// bytes to the statistics of sess and to the totals of sm.
+func (sm *sessionManager) recordTransfer(sess *session, direction string, count int64) {
+	sess.recordTransfer(direction, count)
+	switch direction {
+	case "download":
+		sm.bytesSent.Add(count)
+	case "upload":
+		sm.bytesReceived.Add(count)
+	}
+}
+
+// publishDebugVars publishes the sessions, the tombstones, and the bytes
+// served by sm, both payload and wire, under "ndt8" in /debug/vars (see
+// [debug.Publish]).
+func (sm *sessionManager) publishDebugVars() {
+	debug.Publish("ndt8", func() map[string]int64 {
+		sm.mu.Lock()
+		sessions, tombstones := len(sm.sessions), len(sm.tombstones)
+		sm.mu.Unlock()
+		wireSent, wireReceived := sm.wire.Bytes()
+		return map[string]int64{
+			"bytesReceived": sm.bytesReceived.Load(),
+			"bytesSent":     sm.bytesSent.Load(),
+			"sessions":      int64(sessions),
+			"tombstones":    int64(tombstones),
+			"wireReceived":  wireReceived,
+			"wireSent":      wireSent,
+		}
+	})
+}
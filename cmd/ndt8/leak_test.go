@@ -0,0 +1,223 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/admission"
+	"github.com/bassosimone/2026-02-provlima/internal/health"
+	"github.com/bassosimone/2026-02-provlima/internal/privacy"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+// leakSettleTimeout is how long we wait for the goroutines and the file
+// descriptors to return to the baseline once the clients are done.
+const leakSettleTimeout = 5 * time.Second
+
+// countFDs returns the number of file descriptors the process has open.
+func countFDs(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("cannot count the open file descriptors: %s", err)
+	}
+	return len(entries)
+}
+
+// waitForBaseline waits for the goroutines and the file descriptors to
+// return to the given baseline, failing after [leakSettleTimeout].
+func waitForBaseline(t *testing.T, goroutines, fds int) {
+	t.Helper()
+	deadline := time.Now().Add(leakSettleTimeout)
+	for {
+		nowGoroutines, nowFDs := runtime.NumGoroutine(), countFDs(t)
+		if nowGoroutines <= goroutines && nowFDs <= fds {
+			return
+		}
+		if time.Now().After(deadline) {
+			var dump strings.Builder
+			pprof.Lookup("goroutine").WriteTo(&dump, 1)
+			t.Fatalf("goroutines: %d (baseline %d), fds: %d (baseline %d)\n%s",
+				nowGoroutines, goroutines, nowFDs, fds, dump.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newTestTLSServer is like [newTestServer] but serves the API and the
+// /healthz endpoint over TLS, like `ndt8 serve`, and also returns the flags
+// telling `ndt8 measure` how to connect to it.
+func newTestTLSServer(t *testing.T) (*sessionManager, *httptest.Server, []string) {
+	t.Helper()
+	anon, err := privacy.New("none", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := newSessionManager(0, admission.New(0, time.Second), nil, anon, nil)
+	mux := newAPIMux(sm, nil)
+	health.New(nil, nil).Register(mux)
+	srv := httptest.NewUnstartedServer(mux)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sm, srv, []string{"-A", host, "-p", port, "--cert", certFile}
+}
+
+// measureOnce runs `ndt8 measure` with the given flags, which must not
+// fail, and returns the result document.
+func measureOnce(t *testing.T, ctx context.Context, args []string) *results.Document {
+	t.Helper()
+	output := filepath.Join(t.TempDir(), "result.json")
+	if err := measureMain(ctx, append(args, "-o", output)); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := results.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// readEvents reads the events of the session with the given ID from srv in
+// the background, returning a channel closed when the stream ends.
+func readEvents(t *testing.T, srv *httptest.Server, sid string) <-chan struct{} {
+	t.Helper()
+	resp, err := srv.Client().Get(fmt.Sprintf("%s/ndt/v8/session/%s/events", srv.URL, sid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing the server waits for the handlers, so, when we fail, we
+	// must close the stream to avoid waiting for the session to end.
+	t.Cleanup(func() { resp.Body.Close() })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+	}()
+	return done
+}
+
+// waitClosed waits for done to be closed, failing after [leakSettleTimeout].
+func waitClosed(t *testing.T, done <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(leakSettleTimeout):
+		t.Fatalf("%s did not end", what)
+	}
+}
+
+// startDownload starts downloading a chunk much larger than what we read
+// for the session with the given ID, returning the response once the body
+// starts arriving.
+func startDownload(t *testing.T, ctx context.Context, srv *httptest.Server, sid string) *http.Response {
+	t.Helper()
+	url := fmt.Sprintf("%s/ndt/v8/session/%s/chunk/%d", srv.URL, sid, 1<<40)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.CopyN(io.Discard, resp.Body, testChunkSize); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestNoLeaks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the full measurement cycles in short mode")
+	}
+	sm, srv, args := newTestTLSServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sm.reapSessions(ctx, 10*time.Millisecond)
+	goroutines, fds := runtime.NumGoroutine(), countFDs(t)
+
+	// Full measurement cycles of the real client, including the canary, the
+	// idle probes, and waiting for the queues to drain between directions.
+	args = append(args, "--duration", "500ms", "--gap", "100ms", "--warm-up", "0")
+	for range 2 {
+		if doc := measureOnce(t, ctx, args); doc.Status != results.StatusComplete {
+			t.Fatalf("expected a %s measurement, got %s", results.StatusComplete, doc.Status)
+		}
+	}
+
+	// A user interrupting the client, which aborts the session.
+	measureCtx, measureCancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	doc := measureOnce(t, measureCtx, append(args, "--duration", "5s"))
+	measureCancel()
+	if doc.Status != results.StatusInterrupted {
+		t.Fatalf("expected an %s measurement, got %s", results.StatusInterrupted, doc.Status)
+	}
+
+	// A client going away in the middle of a download.
+	sid := createTestSession(t, srv)
+	dlCtx, dlCancel := context.WithCancel(ctx)
+	resp := startDownload(t, dlCtx, srv, sid)
+	dlCancel()
+	resp.Body.Close()
+	if status := do(t, srv, "DELETE", "/ndt/v8/session/"+sid, nil); status != http.StatusNoContent {
+		t.Fatalf("delete: expected %d, got %d", http.StatusNoContent, status)
+	}
+
+	// A client aborting the session in the middle of a download.
+	sid = createTestSession(t, srv)
+	resp = startDownload(t, ctx, srv, sid)
+	if status := do(t, srv, "POST", "/ndt/v8/session/"+sid+"/abort", nil); status != http.StatusNoContent {
+		t.Fatalf("abort: expected %d, got %d", http.StatusNoContent, status)
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err == nil {
+		t.Fatal("expected the aborted download to be truncated")
+	}
+	resp.Body.Close()
+	if status := do(t, srv, "DELETE", "/ndt/v8/session/"+sid, nil); status != http.StatusNoContent {
+		t.Fatalf("delete: expected %d, got %d", http.StatusNoContent, status)
+	}
+
+	// A client abandoning the session, which expires, ending the events.
+	sm.mu.Lock()
+	sm.idleLifetime = 100 * time.Millisecond
+	sm.mu.Unlock()
+	sid = createTestSession(t, srv)
+	events := readEvents(t, srv, sid)
+	waitClosed(t, events, "the events stream of the abandoned session")
+	if status := do(t, srv, "GET", fmt.Sprintf("/ndt/v8/session/%s/probe/late", sid), nil); status != http.StatusNotFound {
+		t.Fatalf("expected %d for the expired session, got %d", http.StatusNotFound, status)
+	}
+	sm.mu.Lock()
+	sessions := len(sm.sessions)
+	sm.mu.Unlock()
+	if sessions != 0 {
+		t.Fatalf("expected no sessions, got %d", sessions)
+	}
+
+	srv.Client().CloseIdleConnections()
+	waitForBaseline(t, goroutines, fds)
+}
@@ -6,14 +6,17 @@ import (
 	"context"
 	"os"
 
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
 	"github.com/bassosimone/vclip"
 	"github.com/bassosimone/vflag"
 )
 
 func main() {
 	disp := vclip.NewDispatcherCommand("ndt8", vflag.ExitOnError)
+	disp.Exit = failure.FlagExit
 
 	disp.AddCommand("measure", vclip.CommandFunc(measureMain), "Run a measurement.")
+	disp.AddCommand("monitor", vclip.CommandFunc(monitorMain), "Run measurements periodically.")
 	disp.AddCommand("serve", vclip.CommandFunc(serveMain), "Serve requests.")
 
 	vclip.Main(context.Background(), disp, os.Args[1:])
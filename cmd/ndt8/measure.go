@@ -3,25 +3,44 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
+	"runtime"
 	"sync"
 	"time"
 
+	"github.com/bassosimone/2026-02-provlima/internal/collector"
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/convergence"
+	"github.com/bassosimone/2026-02-provlima/internal/cputime"
+	"github.com/bassosimone/2026-02-provlima/internal/dialer"
+	"github.com/bassosimone/2026-02-provlima/internal/drain"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/flightrec"
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
 	"github.com/bassosimone/2026-02-provlima/internal/infinite"
+	"github.com/bassosimone/2026-02-provlima/internal/middlebox"
+	"github.com/bassosimone/2026-02-provlima/internal/otlp"
+	"github.com/bassosimone/2026-02-provlima/internal/privacy"
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
 	"github.com/bassosimone/2026-02-provlima/internal/slogging"
+	"github.com/bassosimone/2026-02-provlima/internal/threshold"
+	"github.com/bassosimone/2026-02-provlima/internal/timestamping"
 	"github.com/bassosimone/runtimex"
 	"github.com/bassosimone/vflag"
-	"github.com/google/uuid"
 )
 
 // initialChunkSize is the starting chunk size for doubling (32 bytes).
@@ -30,36 +49,200 @@ const initialChunkSize = 32
 // maxChunkSize is the maximum chunk size (256 MiB).
 const maxChunkSize = 256 << 20
 
-// timeBudget is the total time budget per direction.
+// timeBudget is the default time budget per direction.
 const timeBudget = 10 * time.Second
 
+// cleanupTimeout bounds the time spent aborting and deleting the session.
+const cleanupTimeout = 5 * time.Second
+
 func measureMain(ctx context.Context, args []string) error {
 	var (
-		addressFlag = "127.0.0.1"
-		certFlag    = "testdata/cert.pem"
-		formatFlag  = "text"
-		http2Flag   = false
-		portFlag    = "4443"
+		addressFlag           = "127.0.0.1"
+		anonymizeIPsFlag      = "none"
+		assertFlag            = ""
+		certFlag              = "testdata/cert.pem"
+		collectorFlag         = ""
+		collectorIntervalFlag = time.Duration(0)
+		configFlag            = ""
+		convergeFlag          = ""
+		durationFlag          = timeBudget
+		errorFormatFlag       = "text"
+		flightRecorderFlag    = ""
+		flightWindowFlag      = 30 * time.Second
+		formatFlag            = "text"
+		gapFlag               = time.Second
+		http2Flag             = false
+		ipv4Flag              = false
+		ipv6Flag              = false
+		kernelTimestampsFlag  = false
+		labelFlag             = []string{}
+		maxDurationFlag       = time.Duration(0)
+		netemFlag             = ""
+		omitHostnamesFlag     = false
+		otelEndpointFlag      = ""
+		outputFlag            = ""
+		patternFlag           = "saturate"
+		portFlag              = "4443"
+		probeBudgetFlag       = 0.02
+		probeBurstFlag        = 1
+		rangeFlag             = false
+		seedFlag              = int64(0)
+		stallTimeoutFlag      = time.Duration(0)
+		streamFlag            = false
+		tlsCacheFlag          = ""
+		warmUpFlag            = 2 * time.Second
 	)
 
 	fset := vflag.NewFlagSet("ndt8 measure", vflag.ExitOnError)
-	fset.StringVar(&addressFlag, 'A', "address", "Use the given IP `ADDRESS`.")
+
+	fset.Exit = failure.FlagExit
+	fset.StringVar(&addressFlag, 'A', "address", "Use the given IP `ADDRESS` or hostname.")
+	fset.StringVar(&anonymizeIPsFlag, 0, "anonymize-ips", "Store the IP addresses in the results and logs using `MODE` (none, truncate to /24 or /48, or omit).")
+	fset.StringVar(&assertFlag, 0, "assert", "Exit with an error unless the results satisfy `SPEC` (e.g., download>=80mbit,p95_latency<=120ms).")
 	fset.StringVar(&certFlag, 0, "cert", "Use `FILE` as the CA certificate.")
+	fset.StringVar(&collectorFlag, 0, "collector", "Submit the result document to the collector at HTTPS `URL`.")
+	fset.DurationVar(&collectorIntervalFlag, 0, "collector-interval", "Also submit intermediate documents every `DURATION` (0 to disable).")
+	fset.StringVar(&configFlag, 0, "config", "Load flag defaults from TOML `FILE`.")
+	fset.StringVar(&convergeFlag, 0, "converge", "End each direction once the throughput converged according to `SPEC` (e.g., 5%/8 for a moving average within 5% for 8 intervals of 250ms).")
+	fset.DurationVar(&durationFlag, 'd', "duration", "Run each direction for at most `DURATION`.")
+	fset.StringVar(&errorFormatFlag, 0, "error-format", "Use `FORMAT` for the final error, if any (text or json).")
+	fset.StringVar(&flightRecorderFlag, 0, "flight-recorder", "Keep the last 10ms samples in a ring buffer in `DIR`, dumping them there when the throughput collapses or the probes spike.")
+	fset.DurationVar(&flightWindowFlag, 0, "flight-recorder-window", "Keep the samples of the last `DURATION` in the flight recorder ring buffer.")
 	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
+	fset.DurationVar(&gapFlag, 0, "gap", "Wait at least `DURATION` between directions and until the queues drain (0 to disable).")
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
 	fset.BoolVar(&http2Flag, '2', "http2", "Force HTTP/2 (default is HTTP/1.1).")
+	fset.BoolVar(&ipv4Flag, '4', "ipv4", "Only connect using IPv4.")
+	fset.BoolVar(&ipv6Flag, '6', "ipv6", "Only connect using IPv6.")
+	fset.BoolVar(&kernelTimestampsFlag, 0, "kernel-timestamps", "Also measure the probe RTT using kernel timestamps over a dedicated connection (Linux only).")
+	fset.StringSliceVar(&labelFlag, 0, "label", "Tag the result document with `KEY=VALUE` (repeatable).")
+	fset.DurationVar(&maxDurationFlag, 0, "max-duration", "With --converge, keep measuring each direction until it converges for at most `DURATION` (default: --duration).")
+	fset.StringVar(&netemFlag, 0, "netem", "Record the network emulation `POLICY` in effect (comma-separated KEY=VALUE pairs) in the result document.")
+	fset.BoolVar(&omitHostnamesFlag, 0, "omit-hostnames", "Omit the hostnames from the results and logs.")
+	fset.StringVar(&otelEndpointFlag, 0, "otel-endpoint", "Export OpenTelemetry traces and metrics to the OTLP/HTTP collector at `URL`.")
+	fset.StringVar(&outputFlag, 'o', "output", "Write the result document to `FILE`.")
+	fset.StringVar(&patternFlag, 0, "pattern", "Request data using `PATTERN` (saturate, constant:RATE, ramp:RATE, onoff:ON/OFF, pageload, or video).")
 	fset.StringVar(&portFlag, 'p', "port", "Use the given TCP `PORT`.")
+	fset.Float64Var(&probeBudgetFlag, 0, "probe-budget", "Slow down the probes to use at most `FRACTION` of the link capacity (0 to always probe every 250ms).")
+	fset.IntVar(&probeBurstFlag, 0, "probe-burst", "Send the probes in bursts of `COUNT` back-to-back probes to measure the jitter (1 to disable).")
+	fset.BoolVar(&rangeFlag, 0, "range", "Download using Range requests for a large object rather than sized chunks.")
+	fset.Int64Var(&seedFlag, 0, "seed", "Draw the random choices (e.g., page load object sizes and probe IDs) from `SEED` (0 for a random seed).")
+	fset.DurationVar(&stallTimeoutFlag, 0, "stall-timeout", "Retry transfers making no progress for `DURATION` on a fresh connection (0 to disable).")
+	fset.BoolVar(&streamFlag, 0, "stream", "Transfer using a single request per direction streamed until the time budget expires.")
+	fset.StringVar(&tlsCacheFlag, 0, "tls-cache", "Resume the TLS sessions stored in `FILE`, which we update after measuring.")
+	fset.DurationVar(&warmUpFlag, 0, "warm-up", "Exclude the first `DURATION` of the download from the throughput.")
+	failure.OnError(failure.Usage, config.Setup(fset, "NDT8", "measure", args))
 	runtimex.PanicOnError0(fset.Parse(args))
 
 	slogging.Setup(formatFlag)
+	failure.Setup(errorFormatFlag)
+
+	anon, err := privacy.New(anonymizeIPsFlag, omitHostnamesFlag)
+	failure.OnError(failure.Usage, err)
+	anon.Setup()
+
+	assertions, err := threshold.Parse(assertFlag)
+	failure.OnError(failure.Usage, err)
+
+	var coll *collector.Client
+	if collectorFlag != "" {
+		coll, err = collector.New(collectorFlag)
+		failure.OnError(failure.Usage, err)
+	}
+
+	otel, err := otlp.New(otelEndpointFlag, "ndt8-client")
+	failure.OnError(failure.Usage, err)
+
+	var netem *results.Netem
+	if netemFlag != "" {
+		netem, err = results.ParseNetem(netemFlag)
+		failure.OnError(failure.Usage, err)
+	}
+	labels, err := results.ParseLabels(labelFlag)
+	failure.OnError(failure.Usage, err)
+
+	if rangeFlag && streamFlag {
+		failure.Exit(failure.Usage, errors.New("--range and --stream are mutually exclusive"))
+	}
+	if durationFlag <= 0 || durationFlag > maxStreamDuration {
+		failure.Exit(failure.Usage, fmt.Errorf("--duration must be positive and at most %s", maxStreamDuration))
+	}
+	var conv *convergence.Spec
+	if convergeFlag != "" {
+		conv, err = convergence.Parse(convergeFlag)
+		failure.OnError(failure.Usage, err)
+	}
+	if maxDurationFlag == 0 {
+		maxDurationFlag = durationFlag
+	}
+	if maxDurationFlag < durationFlag || maxDurationFlag > maxStreamDuration {
+		failure.Exit(failure.Usage, fmt.Errorf("--max-duration must be at least --duration and at most %s", maxStreamDuration))
+	}
+	if maxDurationFlag > durationFlag && conv == nil {
+		failure.Exit(failure.Usage, errors.New("--max-duration requires --converge"))
+	}
+	if flightWindowFlag < flightrec.MinWindow {
+		failure.Exit(failure.Usage, fmt.Errorf("--flight-recorder-window must be at least %s", flightrec.MinWindow))
+	}
+	if probeBudgetFlag < 0 || probeBudgetFlag >= 1 {
+		failure.Exit(failure.Usage, errors.New("--probe-budget must be at least 0 and less than 1"))
+	}
+	if probeBurstFlag < 1 || probeBurstFlag > maxProbeBurst {
+		failure.Exit(failure.Usage, fmt.Errorf("--probe-burst must be between 1 and %d", maxProbeBurst))
+	}
+	if kernelTimestampsFlag && runtime.GOOS != "linux" {
+		failure.Exit(failure.Usage, errors.New("--kernel-timestamps requires Linux"))
+	}
+	if stallTimeoutFlag != 0 && stallTimeoutFlag < minStallTimeout {
+		failure.Exit(failure.Usage, fmt.Errorf("--stall-timeout must be zero or at least %s", minStallTimeout))
+	}
+	pat, err := parsePattern(patternFlag)
+	failure.OnError(failure.Usage, err)
+	if _, ok := pat.(saturatePattern); !ok && streamFlag {
+		failure.Exit(failure.Usage, errors.New("--stream only works with --pattern saturate"))
+	}
+	_, pageLoad := pat.(pageLoadPattern)
+	if pageLoad && rangeFlag {
+		failure.Exit(failure.Usage, errors.New("--range does not work with --pattern pageload"))
+	}
+
+	// We log and record the seed, so that any run can be replayed.
+	if seedFlag < 0 {
+		failure.Exit(failure.Usage, errors.New("--seed must not be negative"))
+	}
+	if seedFlag == 0 {
+		seedFlag = newSeed()
+	}
+	slog.Info("random seed", slog.Int64("seed", seedFlag))
+
+	network := "tcp"
+	switch {
+	case ipv4Flag && ipv6Flag:
+		failure.Exit(failure.Usage, errors.New("--ipv4 and --ipv6 are mutually exclusive"))
+	case ipv4Flag:
+		network = "tcp4"
+	case ipv6Flag:
+		network = "tcp6"
+	}
+	dr := dialer.New(network)
 
 	// Load the CA certificate to trust the server's self-signed cert.
-	caCert := runtimex.LogFatalOnError1(os.ReadFile(certFlag))
+	caCert, err := os.ReadFile(certFlag)
+	failure.OnError(failure.Usage, err)
 	caPool := x509.NewCertPool()
-	runtimex.Assert(caPool.AppendCertsFromPEM(caCert))
+	if !caPool.AppendCertsFromPEM(caCert) {
+		failure.Exit(failure.Usage, fmt.Errorf("%s: no PEM certificates", certFlag))
+	}
 
 	tlsConfig := &tls.Config{
-		RootCAs: caPool,
+		RootCAs:          caPool,
+		VerifyConnection: dr.VerifyConnection,
+	}
+	var sessionCache *dialer.SessionCache
+	if tlsCacheFlag != "" {
+		sessionCache, err = dialer.LoadSessionCache(tlsCacheFlag)
+		failure.OnError(failure.Usage, err)
+		tlsConfig.ClientSessionCache = sessionCache
 	}
 	if !http2Flag {
 		// Disable HTTP/2 by setting NextProtos to only http/1.1.
@@ -67,47 +250,414 @@ func measureMain(ctx context.Context, args []string) error {
 	}
 
 	transport := &http.Transport{
+		DialContext:       dr.DialContext,
 		TLSClientConfig:   tlsConfig,
 		ForceAttemptHTTP2: http2Flag,
 	}
-	client := &http.Client{Transport: transport}
+	if pageLoad {
+		transport.MaxConnsPerHost = browserConnsPerHost
+	}
+	if stallTimeoutFlag > 0 {
+		// Retrying a stalled transfer on a stalled HTTP/2 connection would
+		// not help, so we close the connections not receiving any frame.
+		transport.HTTP2 = &http.HTTP2Config{
+			SendPingTimeout: stallTimeoutFlag / 2,
+			PingTimeout:     stallTimeoutFlag / 2,
+		}
+	}
+	// Close the kept-alive connections when done, which would otherwise
+	// keep their goroutines and file descriptors until the process exits.
+	defer transport.CloseIdleConnections()
+	var rt http.RoundTripper = transport
+	if kernelTimestampsFlag {
+		probes := transport.Clone()
+		probes.DialContext = dr.DialContextWrapping(timestamping.Wrap)
+		defer probes.CloseIdleConnections()
+		rt = &probeTransport{probes: probes, others: transport}
+	}
+	cd := newCacheDetector(versionTransport{rt})
+	client := &http.Client{Transport: cd}
 
 	baseURL := &url.URL{
 		Scheme: "https",
 		Host:   net.JoinHostPort(addressFlag, portFlag),
 	}
 
-	// 1. Create session.
-	sid := createSession(ctx, client, baseURL)
-	slog.Info("session created", slog.String("sid", sid))
+	// The measurement span is the root of the trace, which also contains
+	// the server spans, since we send a traceparent when creating the session.
+	ctx, span := otel.Start(ctx, "ndt8.measure", otlp.KindClient,
+		otlp.String("server.address", anon.Addr(baseURL.Host)))
+
+	// 1. Create session and start streaming the server samples.
+	expected := middlebox.Expected{ALPN: "http/1.1", Server: serverName, TLSVersion: tls.VersionTLS13}
+	if http2Flag {
+		expected.ALPN = "h2"
+	}
+	info := createSession(ctx, client, baseURL, clientMetadata(labels), expected)
+	sid, offset := info.id, info.clockOffset
+	slog.Info("session created",
+		slog.String("sid", sid),
+		slog.Duration("clockOffset", offset),
+		slog.String("clientAddr", info.clientAddr),
+	)
+
+	// Before measuring, make sure no intermediary compresses, modifies, or
+	// buffers the data plane, since the samples would not be meaningful.
+	canaryChecks, err := runCanary(ctx, client, baseURL, sid)
+	info.checks = append(info.checks, canaryChecks...)
+	if err != nil {
+		deleteCtx, deleteCancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+		deleteSession(deleteCtx, client, baseURL, sid)
+		deleteCancel()
+		class := failure.Connectivity
+		if errors.Is(err, errAlteredPayload) || problem.IsResponse(err) {
+			class = failure.Protocol
+		}
+		failure.Exit(class, fmt.Errorf("data plane conformance: %w", err))
+	}
+
+	// Cleanup must happen even when the user interrupts the measurement
+	// with Ctrl-C, which cancels ctx, so we detach it from ctx.
+	cleanupCtx := context.WithoutCancel(ctx)
+
+	var (
+		serverSamples []results.Sample
+		wg            sync.WaitGroup
+	)
+	eventsCtx, eventsCancel := context.WithCancel(cleanupCtx)
+	defer eventsCancel()
+	wg.Go(func() {
+		serverSamples = streamEvents(eventsCtx, client, baseURL, sid)
+	})
+	tl := &results.Timeline{}
+
+	// Keep the fine-grained samples for post-mortem, if needed.
+	var rec *flightrec.Recorder
+	if flightRecorderFlag != "" {
+		rec, err = flightrec.New(flightRecorderFlag, flightWindowFlag, dr.WireBytes, tl.Probes)
+		if err != nil {
+			slog.Warn("not running the flight recorder", slog.Any("err", err))
+		}
+	}
+
+	// Periodically submit what we collected so far, if needed.
+	collectCtx, collectCancel := context.WithCancel(ctx)
+	defer collectCancel()
+	if coll != nil && collectorIntervalFlag > 0 {
+		wg.Go(func() {
+			coll.Run(collectCtx, collectorIntervalFlag, func() *results.Document {
+				doc := &results.Document{
+					SchemaVersion: results.SchemaVersion,
+					Protocol:      "ndt8",
+					Status:        results.StatusRunning,
+					SessionID:     sid,
+					ClockOffset:   offset,
+					Netem:         netem,
+					Labels:        labels,
+					Probes:        tl.Probes(),
+					Samples:       tl.Samples(),
+				}
+				anon.Document(doc)
+				return doc
+			})
+		})
+	}
+
+	// Measure the idle latency, which tells when the queues have drained
+	// between the directions, before the download fills them.
+	gap, err := drain.New(ctx, gapFlag, drain.HTTPProbe(client, baseURL.JoinPath("/healthz").String()))
+	if err != nil {
+		slog.Warn("not waiting for the queues to drain", slog.Any("err", err))
+	}
+
+	// Measure the idle latency, against which we compare the latency
+	// of the probes sent during the transfers.
+	idle := runIdleProbes(ctx, client, baseURL, sid, newProbeIDs(seedFlag, "idle"))
 
 	// 2. Run download with concurrent probes.
-	slog.Info("starting download")
-	runWithProbes(ctx, client, baseURL, sid, "download")
+	pc := &phaseConfig{
+		budget:       maxDurationFlag,
+		conv:         conv,
+		downloadMode: "chunk",
+		pattern:      pat,
+		probeBudget:  probeBudgetFlag,
+		probeBurst:   probeBurstFlag,
+		seed:         seedFlag,
+		stall:        stallTimeoutFlag,
+		uploadMode:   "chunk",
+		warmUp:       warmUpFlag,
+	}
+	switch {
+	case rangeFlag:
+		pc.downloadMode = "range"
+	case streamFlag:
+		pc.downloadMode = "stream"
+	}
+	if streamFlag {
+		pc.uploadMode = "stream"
+	}
+	slog.Info("starting download", slog.String("mode", pc.downloadMode), slog.String("pattern", pat.String()))
+	rec.Begin("download")
+	download := runWithProbes(ctx, dr, client, baseURL, sid, "download", pc, tl)
+	rec.End()
 
 	// 3. Run upload with concurrent probes.
-	slog.Info("starting upload")
-	runWithProbes(ctx, client, baseURL, sid, "upload")
+	var upload phaseStats
+	if ctx.Err() == nil {
+		gap.Wait(ctx)
+	}
+	if ctx.Err() == nil {
+		slog.Info("starting upload", slog.String("mode", pc.uploadMode), slog.String("pattern", pat.String()))
+		rec.Begin("upload")
+		upload = runWithProbes(ctx, dr, client, baseURL, sid, "upload", pc, tl)
+		rec.End()
+	}
+
+	// Tell the server to stop in-flight transfers when interrupted.
+	status := results.StatusComplete
+	if ctx.Err() != nil {
+		status = results.StatusInterrupted
+		slog.Warn("interrupted by the user")
+		abortCtx, abortCancel := context.WithTimeout(cleanupCtx, cleanupTimeout)
+		abortSession(abortCtx, client, baseURL, sid)
+		abortCancel()
+	}
+
+	// 4. Delete session, which causes the server to end the events stream.
+	deleteCtx, deleteCancel := context.WithTimeout(cleanupCtx, cleanupTimeout)
+	deleteSession(deleteCtx, client, baseURL, sid)
+	deleteCancel()
+	time.AfterFunc(cleanupTimeout, eventsCancel)
+	collectCancel()
+	wg.Wait()
+	flightRecords := rec.Close()
 
-	// 4. Delete session.
-	deleteSession(ctx, client, baseURL, sid)
+	// 5. Merge client and server samples into a single timeline. When
+	// interrupted, this is a partial document with what we collected.
+	clientSamples := tl.Samples()
+	intermediaries := cd.Intermediaries()
+	if len(intermediaries) > 0 {
+		status = results.StatusInvalid
+	}
+	doc := &results.Document{
+		SchemaVersion:      results.SchemaVersion,
+		Protocol:           "ndt8",
+		Status:             status,
+		SessionID:          sid,
+		ClockOffset:        offset,
+		ClientAddr:         info.clientAddr,
+		DownloadMode:       pc.downloadMode,
+		UploadMode:         pc.uploadMode,
+		Pattern:            pat.String(),
+		Seed:               seedFlag,
+		Netem:              netem,
+		Labels:             labels,
+		DNSLookups:         dr.Lookups(),
+		Dials:              dr.Dials(),
+		TLSHandshakes:      dr.TLSHandshakes(),
+		Probes:             tl.Probes(),
+		IdleProbes:         idle,
+		PageLoads:          tl.PageLoads(),
+		Videos:             tl.Videos(),
+		Interruptions:      tl.Interruptions(),
+		Intermediaries:     intermediaries,
+		MiddleboxChecks:    info.checks,
+		MiddleboxSuspected: info.suspected,
+		FlightRecords:      flightRecords,
+		Summary: &results.Summary{
+			Download: results.Summarize(clientSamples, results.OriginClient, "download", warmUpFlag),
+			Upload:   results.Summarize(clientSamples, results.OriginClient, "upload", 0),
+		},
+		Samples: results.Merge(clientSamples, serverSamples, offset),
+	}
+	// The server is the sender during the download, so only its samples
+	// tell how many bytes the client acknowledged and how many segments
+	// the server retransmitted.
+	if doc.Summary.Download != nil {
+		if server := results.Summarize(doc.Samples, results.OriginServer, "download", warmUpFlag); server != nil {
+			doc.Summary.Download.Divergence = server.Divergence
+			doc.Summary.Download.ServerRetransmits = server.ServerRetransmits
+		}
+	}
+	results.SummarizeLatency(doc.Summary, doc.IdleProbes, doc.Probes)
+	download.apply(doc.Summary.Download)
+	upload.apply(doc.Summary.Upload)
+	logSummary("download", doc.Summary.Download)
+	logSummary("upload", doc.Summary.Upload)
+	slog.Info("measurement complete",
+		slog.String("sid", sid),
+		slog.String("status", status),
+		slog.Int("clientSamples", len(clientSamples)),
+		slog.Int("serverSamples", len(serverSamples)),
+		slog.Duration("idleLatency", doc.Summary.IdleLatency),
+		slog.Duration("kernelIdleLatency", doc.Summary.KernelIdleLatency),
+	)
+	if sessionCache != nil {
+		if err := sessionCache.Save(); err != nil {
+			slog.Warn("cannot save the TLS sessions", slog.Any("err", err))
+		}
+	}
+	span.SetAttrs(otlp.String("ndt8.session.id", sid), otlp.String("ndt8.status", status))
+	span.End(nil)
+	flushTelemetry(otel)
+	anon.Document(doc)
+	if outputFlag != "" {
+		failure.OnError(failure.Generic, results.WriteFile(outputFlag, doc))
+		slog.Info("result written", slog.String("path", outputFlag))
+	}
+	if coll != nil {
+		coll.Add(doc)
+		failure.OnError(failure.Connectivity, coll.Flush(cleanupCtx))
+	}
 
-	slog.Info("measurement complete", slog.String("sid", sid))
+	// Like a threshold violation, an invalid measurement still writes the document.
+	if status == results.StatusInvalid {
+		failure.Exit(failure.Protocol, fmt.Errorf("%d responses came from a cache or proxy", len(intermediaries)))
+	}
+
+	// Check the thresholds last, so a violation still writes the document.
+	if len(assertions) > 0 {
+		failure.OnError(failure.Threshold, threshold.CheckAll(assertions, doc))
+		slog.Info("all assertions satisfied", slog.String("spec", assertFlag))
+	}
 	return nil
 }
 
-func createSession(ctx context.Context, client *http.Client, baseURL *url.URL) string {
+// logSummary logs the headline figures for the given direction.
+func logSummary(direction string, summary *results.DirectionSummary) {
+	if summary == nil {
+		return
+	}
+	slog.Info("summary",
+		slog.String("direction", direction),
+		slog.String("throughput", humanize.SI(summary.Throughput, "bit/s")),
+		slog.Int64("bytes", summary.Bytes),
+		slog.Duration("elapsed", summary.Elapsed),
+		slog.Duration("warmUp", summary.WarmUp),
+		slog.Float64("variation", summary.Variation),
+		slog.String("convergence", summary.Convergence),
+		slog.Duration("convergedAfter", summary.ConvergedAfter),
+		slog.Float64("divergence", summary.Divergence),
+		slog.Float64("cpuUsage", summary.CPUUsage),
+		slog.Float64("retransmitRate", summary.RetransmitRate),
+		slog.Int64("clientRetransmits", summary.ClientRetransmits),
+		slog.Int64("serverRetransmits", summary.ServerRetransmits),
+		slog.Duration("loadedLatency", summary.LoadedLatency),
+		slog.Duration("latencyIncrease", summary.LatencyIncrease),
+		slog.Duration("kernelLoadedLatency", summary.KernelLoadedLatency),
+		slog.Float64("rpm", summary.RPM),
+		slog.Float64("probeLoad", summary.ProbeLoad),
+		slog.Int("bursts", summary.Bursts),
+		slog.Duration("jitter", summary.Jitter),
+		slog.Duration("maxIPDV", summary.MaxIPDV),
+		slog.Float64("overhead", summary.Overhead),
+		slog.String("wireThroughput", humanize.SI(summary.WireThroughput, "bit/s")),
+		slog.Any("flags", summary.Flags),
+	)
+	if len(summary.Flags) > 0 {
+		slog.Warn("low quality measurement",
+			slog.String("direction", direction),
+			slog.Any("flags", summary.Flags),
+		)
+	}
+}
+
+// sessionInfo contains information about a newly created session.
+type sessionInfo struct {
+	// id is the session ID.
+	id string
+
+	// clockOffset is the estimated server clock minus client clock.
+	clockOffset time.Duration
+
+	// clientAddr is the client address as seen by the server.
+	clientAddr string
+
+	// checks contains the middlebox checks of the session creation.
+	checks []results.MiddleboxCheck
+
+	// suspected indicates that some middlebox checks failed.
+	suspected bool
+}
+
+// createSession creates a session declaring md and returns information about
+// it, including how the connection we used compares with exp.
+func createSession(ctx context.Context, client *http.Client, baseURL *url.URL,
+	md *results.Metadata, exp middlebox.Expected) sessionInfo {
 	u := baseURL.JoinPath("/ndt/v8/session")
-	req := runtimex.LogFatalOnError1(http.NewRequestWithContext(ctx, "POST", u.String(), http.NoBody))
-	resp := runtimex.LogFatalOnError1(client.Do(req))
+	var conn net.Conn
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { conn = info.Conn },
+	})
+	body := runtimex.PanicOnError1(json.Marshal(map[string]any{"metadata": md}))
+	req := runtimex.LogFatalOnError1(http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(body)))
+	req.Header.Set("Content-Type", "application/json")
+	otlp.Inject(ctx, req.Header)
+	t0 := time.Now()
+	resp, err := client.Do(req)
+	failure.OnError(failure.Connectivity, err)
 	defer resp.Body.Close()
 
-	runtimex.Assert(resp.StatusCode == http.StatusCreated)
+	if resp.StatusCode != http.StatusCreated {
+		failure.Exit(failure.Protocol, fmt.Errorf("create session: %w", problem.FromResponse(resp)))
+	}
 	var result struct {
-		SessionID string `json:"sessionID"`
+		ClientAddr string    `json:"clientAddr"`
+		SessionID  string    `json:"sessionID"`
+		ServerTime time.Time `json:"serverTime"`
+		Version    int       `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		failure.Exit(failure.Protocol, fmt.Errorf("create session: %w", err))
+	}
+	// Note: servers predating the negotiation do not tell the version
+	if result.Version != 0 && result.Version != protocolVersion {
+		failure.Exit(failure.Protocol, fmt.Errorf("create session: the server speaks version %d, not %d",
+			result.Version, protocolVersion))
+	}
+	rtt := time.Since(t0)
+
+	// Assume the server took its timestamp halfway through the exchange.
+	offset := result.ServerTime.Sub(t0.Add(rtt / 2))
+	checks, suspected := middlebox.Check(exp, resp.TLS, resp.Header, conn)
+	return sessionInfo{
+		id:          result.SessionID,
+		clockOffset: offset,
+		clientAddr:  result.ClientAddr,
+		checks:      checks,
+		suspected:   suspected,
+	}
+}
+
+// streamEvents reads the server samples from the events endpoint until
+// the server ends the stream or ctx is done.
+func streamEvents(ctx context.Context, client *http.Client, baseURL *url.URL, sid string) []results.Sample {
+	u := baseURL.JoinPath(fmt.Sprintf("/ndt/v8/session/%s/events", sid))
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), http.NoBody)
+	if err != nil {
+		slog.Warn("events request failed", slog.Any("err", err))
+		return nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Warn("events failed", slog.Any("err", err))
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.Warn("events failed", slog.Any("err", problem.FromResponse(resp)))
+		return nil
+	}
+
+	var samples []results.Sample
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var sample results.Sample
+		if err := dec.Decode(&sample); err != nil {
+			return samples
+		}
+		samples = append(samples, sample)
 	}
-	runtimex.LogFatalOnError0(json.NewDecoder(resp.Body).Decode(&result))
-	return result.SessionID
 }
 
 func deleteSession(ctx context.Context, client *http.Client, baseURL *url.URL, sid string) {
@@ -122,50 +672,279 @@ func deleteSession(ctx context.Context, client *http.Client, baseURL *url.URL, s
 		slog.Warn("delete session failed", slog.Any("err", err))
 		return
 	}
-	resp.Body.Close()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		slog.Warn("delete session failed", slog.Any("err", problem.FromResponse(resp)))
+		return
+	}
 	slog.Info("session deleted", slog.String("sid", sid), slog.Int("status", resp.StatusCode))
 }
 
-// runWithProbes runs chunk-doubling transfers with concurrent probes.
-func runWithProbes(ctx context.Context, client *http.Client, baseURL *url.URL, sid, direction string) {
-	ctx, cancel := context.WithTimeout(ctx, timeBudget)
+// abortSession tells the server to stop in-flight transfers immediately.
+func abortSession(ctx context.Context, client *http.Client, baseURL *url.URL, sid string) {
+	u := baseURL.JoinPath(fmt.Sprintf("/ndt/v8/session/%s/abort", sid))
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), http.NoBody)
+	if err != nil {
+		slog.Warn("abort session request failed", slog.Any("err", err))
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Warn("abort session failed", slog.Any("err", err))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		slog.Warn("abort session failed", slog.Any("err", problem.FromResponse(resp)))
+		return
+	}
+	slog.Info("session aborted", slog.String("sid", sid), slog.Int("status", resp.StatusCode))
+}
+
+// phaseStats contains the quality indicators of a direction.
+type phaseStats struct {
+	// truncated indicates that the time budget expired.
+	truncated bool
+
+	// convergence is the [results.DirectionSummary] Convergence, if any.
+	convergence string
+
+	// convergedAfter is the time it took to converge, if it converged.
+	convergedAfter time.Duration
+
+	// cpuUsage is the client CPU usage (see [cputime.Usage]).
+	cpuUsage float64
+
+	// retransmitted is the estimate of the bytes the client retransmitted,
+	// which we only know when the client is the sender.
+	retransmitted int64
+
+	// retransmits is the number of segments the client retransmitted,
+	// which we know in both directions, since ACKs may also be lost.
+	retransmits int64
+
+	// probeLoad is the estimated fraction of the throughput the probes used.
+	probeLoad float64
+
+	// payloadBytes is the number of payload bytes of the whole direction.
+	payloadBytes int64
+
+	// wireBytes is the number of bytes the client read (download) or wrote
+	// (upload) at the connection level during the whole direction.
+	wireBytes int64
+}
+
+// apply copies the indicators into summary and assesses its quality.
+func (ps phaseStats) apply(summary *results.DirectionSummary) {
+	if summary == nil {
+		return
+	}
+	summary.Truncated = ps.truncated
+	summary.Convergence = ps.convergence
+	summary.ConvergedAfter = ps.convergedAfter
+	summary.CPUUsage = ps.cpuUsage
+	summary.ClientRetransmits = ps.retransmits
+	summary.ProbeLoad = ps.probeLoad
+	if summary.Bytes > 0 {
+		summary.RetransmitRate = float64(ps.retransmitted) / float64(summary.Bytes)
+	}
+	if ps.payloadBytes > 0 && ps.wireBytes > 0 {
+		summary.WireBytes = ps.wireBytes
+		summary.Overhead = float64(ps.wireBytes-ps.payloadBytes) / float64(ps.payloadBytes)
+		summary.WireThroughput = summary.Throughput * (1 + summary.Overhead)
+	}
+	summary.Assess()
+}
+
+// phaseConfig configures the directions we run using [runWithProbes].
+type phaseConfig struct {
+	// budget is the maximum duration of each direction.
+	budget time.Duration
+
+	// conv, if not nil, ends each direction once the throughput converges.
+	conv *convergence.Spec
+
+	// downloadMode is the download mode (see [phaseConfig.mode]).
+	downloadMode string
+
+	// pattern decides the size and timing of the transfers.
+	pattern pattern
+
+	// probeBudget is the fraction of the link capacity the probes may use.
+	probeBudget float64
+
+	// probeBurst is the number of probes we send in each burst.
+	probeBurst int
+
+	// seed determines the random choices of pattern and the probe IDs.
+	seed int64
+
+	// stall is how long a transfer may make no progress before we retry it.
+	stall time.Duration
+
+	// uploadMode is the upload mode (see [phaseConfig.mode]).
+	uploadMode string
+
+	// warmUp is the minimum duration of the download when converging.
+	warmUp time.Duration
+}
+
+// mode returns the mode of the given direction, which is "chunk" for sized
+// chunk transfers, "range" for downloads fetching consecutive ranges of the
+// server object, or "stream" for a single transfer lasting for the whole
+// time budget.
+func (pc *phaseConfig) mode(direction string) string {
+	if direction == "download" {
+		return pc.downloadMode
+	}
+	return pc.uploadMode
+}
+
+// minElapsed returns the minimum duration of the given direction when
+// converging, since only the download has a warm-up.
+func (pc *phaseConfig) minElapsed(direction string) time.Duration {
+	if direction == "download" {
+		return pc.warmUp
+	}
+	return 0
+}
+
+// runWithProbes runs transfers in the given direction with concurrent probes
+// for at most the budget of pc, using the mode pc assigns to the direction.
+// Unless streaming, the pattern decides the size and timing of the transfers,
+// which we retry when they fail mid-chunk or make no progress for the stall
+// timeout (see [retrier]). The probes use at most the probe budget of the
+// link capacity and we send them in bursts (see [probeScheduler]). With
+// convergence, we end as soon as the throughput converges, but not before
+// the minimum elapsed time of the direction.
+func runWithProbes(parent context.Context, dr *dialer.Recorder, client *http.Client, baseURL *url.URL, sid, direction string, pc *phaseConfig, tl *results.Timeline) phaseStats {
+	mode, pat, seed, conv := pc.mode(direction), pc.pattern, pc.seed, pc.conv
+	parent, span := otlp.Start(parent, "ndt8.phase", otlp.KindInternal, otlp.String("ndt8.direction", direction))
+	cpu0, t0, rb0, rs0 := cputime.Now(), time.Now(), dr.RetransmittedBytes(), dr.Retransmits()
+	sent0, received0 := dr.WireBytes()
+	ctx, cancel := context.WithTimeout(parent, pc.budget)
 	defer cancel()
 
 	// Start probes in background.
-	var wg sync.WaitGroup
+	var (
+		probes int
+		wg     sync.WaitGroup
+	)
+	sched := &probeScheduler{budget: pc.probeBudget, burst: pc.probeBurst, direction: direction, tl: tl}
 	wg.Go(func() {
-		runProbes(ctx, client, baseURL, sid)
+		probes = runProbes(ctx, client, baseURL, sid, direction, newProbeIDs(seed, direction), sched, tl)
 	})
 
-	// Run chunk-doubling transfers.
-	for size := int64(initialChunkSize); size <= maxChunkSize; size *= 2 {
-		if ctx.Err() != nil {
-			break
-		}
-		switch direction {
-		case "download":
-			doDownload(ctx, client, baseURL, sid, size)
-		case "upload":
-			doUpload(ctx, client, baseURL, sid, size)
-		}
+	// Stop transferring once the throughput converges, if needed.
+	var (
+		converged      bool
+		convergedAfter time.Duration
+	)
+	if conv != nil {
+		wg.Go(func() {
+			convergedAfter, converged = conv.Run(ctx, t0, pc.minElapsed(direction), func() int64 {
+				if summary := results.Summarize(tl.Samples(), results.OriginClient, direction, 0); summary != nil {
+					return summary.Bytes
+				}
+				return 0
+			})
+			if converged {
+				slog.Info("throughput converged", slog.String("direction", direction), slog.Duration("after", convergedAfter))
+				cancel()
+			}
+		})
+	}
+
+	// Stream a single transfer or follow the pattern.
+	var (
+		completed bool
+		offset    int64
+	)
+	switch {
+	case mode == "stream" && direction == "download":
+		doStreamDownload(ctx, client, baseURL, sid, tl)
+		completed = ctx.Err() == nil
+	case mode == "stream" && direction == "upload":
+		doStreamUpload(ctx, client, baseURL, sid, tl)
+		completed = ctx.Err() == nil
+	default:
+		retry := &retrier{client: client, direction: direction, stall: pc.stall, tl: tl}
+		completed = pat.run(ctx, &phase{
+			direction: direction,
+			rng:       newRand(seed, "pattern/"+direction),
+			tl:        tl,
+			transfer: func(ctx context.Context, size int64) error {
+				// Retries fetch the same range again.
+				defer func() { offset += size }()
+				return retry.do(ctx, size, func(ctx context.Context, emit emitFunc) error {
+					switch {
+					case direction == "download" && mode == "range":
+						return doRangeDownload(ctx, client, baseURL, sid, offset, size, emit)
+					case direction == "download":
+						return doDownload(ctx, client, baseURL, sid, size, emit)
+					default:
+						return doUpload(ctx, client, baseURL, sid, size, emit)
+					}
+				})
+			},
+		})
+	}
+
+	stats := phaseStats{
+		cpuUsage:    cputime.Usage(cpu0, t0),
+		retransmits: dr.Retransmits() - rs0,
+	}
+	if direction == "upload" {
+		stats.retransmitted = dr.RetransmittedBytes() - rb0
 	}
 
 	cancel()
 	wg.Wait()
+
+	// We are truncated when the time budget, rather than the user
+	// or the convergence, stopped us before completing the last chunk.
+	stats.truncated = !completed && !converged && parent.Err() == nil
+	switch {
+	case converged:
+		stats.convergence = results.ConvergenceConverged
+		stats.convergedAfter = convergedAfter
+	case conv != nil && parent.Err() == nil:
+		stats.convergence = results.ConvergenceUnstable
+	}
+	sent, received := dr.WireBytes()
+	stats.wireBytes = received - received0
+	if direction == "upload" {
+		stats.wireBytes = sent - sent0
+	}
+	if summary := results.Summarize(tl.Samples(), results.OriginClient, direction, 0); summary != nil {
+		stats.probeLoad = probeLoad(probes, time.Since(t0), summary.Throughput)
+		stats.payloadBytes = summary.Bytes
+	}
+	span.SetAttrs(otlp.Bool("ndt8.truncated", stats.truncated), otlp.Float64("ndt8.cpu_usage", stats.cpuUsage))
+	span.End(nil)
+	return stats
 }
 
-func doDownload(ctx context.Context, client *http.Client, baseURL *url.URL, sid string, size int64) {
+func doDownload(ctx context.Context, client *http.Client, baseURL *url.URL, sid string, size int64, emit emitFunc) error {
+	var (
+		count int64
+		err   error
+	)
+	ctx, span := startClientTransfer(ctx, "download", size)
+	t0 := time.Now()
+	defer func() { endTransfer(ctx, span, "download", count, time.Since(t0), err) }()
+
 	u := baseURL.JoinPath(fmt.Sprintf("/ndt/v8/session/%s/chunk/%d", sid, size))
 	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), http.NoBody)
 	if err != nil {
 		slog.Warn("download request failed", slog.Any("err", err))
-		return
+		return err
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
 		slog.Warn("download failed", slog.Any("err", err))
-		return
+		return err
 	}
 	bodyWrapper := slogging.NewReadCloser(resp.Body)
 	defer bodyWrapper.Close()
@@ -175,20 +954,146 @@ func doDownload(ctx context.Context, client *http.Client, baseURL *url.URL, sid
 		slog.Int("status", resp.StatusCode),
 		slog.String("proto", resp.Proto),
 	)
+	if resp.StatusCode != http.StatusOK {
+		err = problem.FromResponse(resp)
+		slog.Warn("download failed", slog.Any("err", err))
+		return err
+	}
 
+	smp := newSampler(results.OriginClient, "download", size, emit)
 	buf := make([]byte, 1<<20) // 1 MiB
-	io.CopyBuffer(io.Discard, bodyWrapper, buf)
+	count, err = io.CopyBuffer(io.Discard, samplingReader{bodyWrapper, smp}, buf)
+	smp.done()
+	return err
 }
 
-func doUpload(ctx context.Context, client *http.Client, baseURL *url.URL, sid string, size int64) {
-	u := baseURL.JoinPath(fmt.Sprintf("/ndt/v8/session/%s/chunk/%d", sid, size))
-	body := io.LimitReader(infinite.Reader{}, size)
+// doRangeDownload downloads size bytes of the server object starting at
+// offset, like a CDN client fetching the next segment of a large file.
+func doRangeDownload(ctx context.Context, client *http.Client, baseURL *url.URL, sid string, offset, size int64, emit emitFunc) error {
+	var (
+		count int64
+		err   error
+	)
+	ctx, span := startClientTransfer(ctx, "download", size)
+	span.SetAttrs(otlp.Int64("ndt8.range.start", offset))
+	t0 := time.Now()
+	defer func() { endTransfer(ctx, span, "download", count, time.Since(t0), err) }()
+
+	u := baseURL.JoinPath(fmt.Sprintf("/ndt/v8/session/%s/object", sid))
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), http.NoBody)
+	if err != nil {
+		slog.Warn("download request failed", slog.Any("err", err))
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+size-1))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Warn("download failed", slog.Any("err", err))
+		return err
+	}
+	bodyWrapper := slogging.NewReadCloser(resp.Body)
+	defer bodyWrapper.Close()
+
+	slog.Info("download range",
+		slog.Int64("offset", offset),
+		slog.Int64("size", size),
+		slog.Int("status", resp.StatusCode),
+		slog.String("contentRange", resp.Header.Get("Content-Range")),
+		slog.String("proto", resp.Proto),
+	)
+
+	// Reading a whole object we did not ask for would exceed the budget.
+	if resp.StatusCode != http.StatusPartialContent {
+		err = problem.FromResponse(resp)
+		slog.Warn("download failed", slog.Any("err", err))
+		return err
+	}
+
+	smp := newSampler(results.OriginClient, "download", size, emit)
+	buf := make([]byte, 1<<20) // 1 MiB
+	count, err = io.CopyBuffer(io.Discard, samplingReader{bodyWrapper, smp}, buf)
+	smp.done()
+	return err
+}
+
+// doStreamDownload downloads a single response the server streams until
+// shortly before our time budget expires, as set by the ctx deadline.
+func doStreamDownload(ctx context.Context, client *http.Client, baseURL *url.URL, sid string, tl *results.Timeline) {
+	var (
+		count int64
+		err   error
+	)
+	ctx, span := startClientTransfer(ctx, "download", 0)
+	t0 := time.Now()
+	defer func() { endTransfer(ctx, span, "download", count, time.Since(t0), err) }()
+
+	deadline, _ := ctx.Deadline()
+	duration := time.Until(deadline) - streamMargin
+	if duration <= 0 {
+		return
+	}
+	u := baseURL.JoinPath(fmt.Sprintf("/ndt/v8/session/%s/stream", sid))
+	u.RawQuery = url.Values{"duration": {duration.String()}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), http.NoBody)
+	if err != nil {
+		slog.Warn("download request failed", slog.Any("err", err))
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Warn("download failed", slog.Any("err", err))
+		return
+	}
+	bodyWrapper := slogging.NewReadCloser(resp.Body)
+	defer bodyWrapper.Close()
+
+	slog.Info("download stream",
+		slog.Duration("duration", duration),
+		slog.Int("status", resp.StatusCode),
+		slog.Any("transferEncoding", resp.TransferEncoding),
+		slog.String("proto", resp.Proto),
+	)
+	if resp.StatusCode != http.StatusOK {
+		err = problem.FromResponse(resp)
+		slog.Warn("download failed", slog.Any("err", err))
+		return
+	}
+
+	smp := newSampler(results.OriginClient, "download", 0, tl.Emit)
+	buf := make([]byte, 1<<20) // 1 MiB
+	count, err = io.CopyBuffer(io.Discard, samplingReader{bodyWrapper, smp}, buf)
+	smp.done()
+}
+
+// doStreamUpload uploads a single request body, without declaring its
+// size, until shortly before our time budget expires, as set by the ctx
+// deadline. The server also stops reading at the deadline we tell it.
+func doStreamUpload(ctx context.Context, client *http.Client, baseURL *url.URL, sid string, tl *results.Timeline) {
+	var err error
+	ctx, span := startClientTransfer(ctx, "upload", 0)
+	deadline, _ := ctx.Deadline()
+	duration := time.Until(deadline) - streamMargin
+	if duration <= 0 {
+		span.End(nil)
+		return
+	}
+	u := baseURL.JoinPath(fmt.Sprintf("/ndt/v8/session/%s/stream", sid))
+	u.RawQuery = url.Values{"duration": {duration.String()}}.Encode()
+	smp := newSampler(results.OriginClient, "upload", 0, tl.Emit)
+	defer func() { endTransfer(ctx, span, "upload", smp.tot, time.Since(smp.t0), err) }()
+	body := samplingReader{untilReader{infinite.Reader{}, time.Now().Add(duration)}, smp}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { smp.track(info.Conn) },
+	}
+	ctx = httptrace.WithClientTrace(ctx, trace)
 	req, err := http.NewRequestWithContext(ctx, "PUT", u.String(), body)
 	if err != nil {
 		slog.Warn("upload request failed", slog.Any("err", err))
 		return
 	}
-	req.ContentLength = size
+	req.ContentLength = -1 // unknown, hence chunked with HTTP/1.1
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -196,51 +1101,157 @@ func doUpload(ctx context.Context, client *http.Client, baseURL *url.URL, sid st
 		return
 	}
 	defer resp.Body.Close()
+	smp.done()
+
+	slog.Info("upload stream",
+		slog.Duration("duration", duration),
+		slog.Int("status", resp.StatusCode),
+		slog.String("proto", resp.Proto),
+	)
+	if resp.StatusCode != http.StatusNoContent {
+		err = problem.FromResponse(resp)
+		slog.Warn("upload failed", slog.Any("err", err))
+	}
+}
+
+func doUpload(ctx context.Context, client *http.Client, baseURL *url.URL, sid string, size int64, emit emitFunc) error {
+	var err error
+	ctx, span := startClientTransfer(ctx, "upload", size)
+	u := baseURL.JoinPath(fmt.Sprintf("/ndt/v8/session/%s/chunk/%d", sid, size))
+	smp := newSampler(results.OriginClient, "upload", size, emit)
+	defer func() { endTransfer(ctx, span, "upload", smp.tot, time.Since(smp.t0), err) }()
+	body := samplingReader{io.LimitReader(infinite.Reader{}, size), smp}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { smp.track(info.Conn) },
+	}
+	ctx = httptrace.WithClientTrace(ctx, trace)
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.String(), body)
+	if err != nil {
+		slog.Warn("upload request failed", slog.Any("err", err))
+		return err
+	}
+	req.ContentLength = size
+
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.Warn("upload failed", slog.Any("err", err))
+		return err
+	}
+	defer resp.Body.Close()
+	smp.done()
 
 	slog.Info("upload chunk",
 		slog.Int64("size", size),
 		slog.Int("status", resp.StatusCode),
 		slog.String("proto", resp.Proto),
 	)
+	if resp.StatusCode != http.StatusNoContent {
+		err = problem.FromResponse(resp)
+		slog.Warn("upload failed", slog.Any("err", err))
+	}
+	return err
 }
 
-// runProbes sends small probe requests at regular intervals until ctx is done.
-func runProbes(ctx context.Context, client *http.Client, baseURL *url.URL, sid string) {
-	ticker := time.NewTicker(250 * time.Millisecond)
-	defer ticker.Stop()
-
+// runProbes sends small probe requests, or bursts of them, at the intervals
+// decided by sched until ctx is done and returns the number of probes sent.
+// Like a ticker, we send the next probe right away when a probe outlasts
+// the interval.
+func runProbes(ctx context.Context, client *http.Client, baseURL *url.URL, sid, direction string, ids *probeIDs, sched *probeScheduler, tl *results.Timeline) int {
+	var count, burst int
+	next := time.Now()
 	for {
+		next = next.Add(sched.interval())
+		if now := time.Now(); next.Before(now) {
+			next = now
+		}
+		timer := time.NewTimer(time.Until(next))
 		select {
 		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			pid, err := uuid.NewV7()
-			if err != nil {
-				pid = uuid.New()
+			timer.Stop()
+			return count
+		case <-timer.C:
+			// A burst of a single probe is not a burst.
+			if sched.burst > 1 {
+				burst++
+			}
+			for range max(sched.burst, 1) {
+				if ctx.Err() != nil {
+					break
+				}
+				probeOnce(ctx, client, baseURL, sid, ids.next(), direction, burst, tl)
+				count++
 			}
-			probeOnce(ctx, client, baseURL, sid, pid.String())
 		}
 	}
 }
 
-func probeOnce(ctx context.Context, client *http.Client, baseURL *url.URL, sid, pid string) {
+// idleProbes is the number of probes measuring the idle latency.
+const idleProbes = 5
+
+// runIdleProbes sends [idleProbes] probes before the transfers, while the
+// queues are empty, and returns them. Since the idle latency is their
+// median, the first probe, which may wait for a new connection, does
+// not skew it.
+func runIdleProbes(ctx context.Context, client *http.Client, baseURL *url.URL, sid string, ids *probeIDs) []results.Probe {
+	tl := &results.Timeline{}
+	for range idleProbes {
+		if ctx.Err() != nil {
+			break
+		}
+		probeOnce(ctx, client, baseURL, sid, ids.next(), "idle", 0, tl)
+	}
+	return tl.Probes()
+}
+
+// probeOnce sends a probe belonging to the given burst, if positive.
+func probeOnce(ctx context.Context, client *http.Client, baseURL *url.URL, sid, pid, direction string, burst int, tl *results.Timeline) {
 	u := baseURL.JoinPath(fmt.Sprintf("/ndt/v8/session/%s/probe/%s", sid, pid))
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), http.NoBody)
+	var conn net.Conn
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { conn = info.Conn },
+	})
+	spanCtx, span := otlp.Start(ctx, "ndt8.probe", otlp.KindClient,
+		otlp.String("ndt8.probe.id", pid),
+		otlp.String("ndt8.direction", direction),
+	)
+	req, err := http.NewRequestWithContext(spanCtx, "GET", u.String(), http.NoBody)
 	if err != nil {
+		span.End(err)
 		return
 	}
-
+	otlp.Inject(spanCtx, req.Header)
 	t0 := time.Now()
 	resp, err := client.Do(req)
 	rtt := time.Since(t0)
 	if err != nil {
+		// Probes failing because the transfer ended are not interesting,
+		// but we still end their spans, so the trace shows them.
+		if ctx.Err() != nil {
+			span.SetAttrs(otlp.Bool("ndt8.probe.cancelled", true))
+			span.End(err)
+			return
+		}
+		endProbe(spanCtx, span, rtt, err)
+		tl.EmitProbe(results.Probe{Direction: direction, RTT: rtt, Failure: err.Error(), Time: t0, Burst: burst})
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		perr := problem.FromResponse(resp)
+		slog.Warn("probe failed", slog.String("pid", pid), slog.Any("err", perr))
+		endProbe(spanCtx, span, rtt, perr)
+		tl.EmitProbe(results.Probe{Direction: direction, RTT: rtt, Failure: perr.Error(), Time: t0, Burst: burst})
 		return
 	}
-	resp.Body.Close()
+	endProbe(spanCtx, span, rtt, nil)
+	probe := results.Probe{Direction: direction, RTT: rtt, Time: t0, Burst: burst}
+	addKernelStamps(&probe, conn)
+	tl.EmitProbe(probe)
 
 	slog.Info("probe",
 		slog.String("pid", pid),
 		slog.Duration("rtt", rtt),
+		slog.Duration("kernelRTT", probe.KernelRTT),
 		slog.Int("status", resp.StatusCode),
 	)
 }
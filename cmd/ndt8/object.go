@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/infinite"
+	"github.com/bassosimone/2026-02-provlima/internal/otlp"
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+)
+
+const (
+	// objectSize is the size of the virtual object served to Range requests,
+	// which is larger than what we can transfer within the time budget at
+	// 10 Gbit/s, so clients never need to wrap around.
+	objectSize = 16 << 30
+
+	// objectETag is the strong validator of the virtual object, which
+	// never changes, so clients can use If-Range like with CDNs.
+	objectETag = `"ndt8-object-v1"`
+)
+
+// errUnsatisfiableRange indicates that a range starts past the object end.
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// parseRange parses the value of a Range header for an object of the given
+// size, returning the first byte and the length of the requested range.
+//
+// We only support a single byte range, which is what CDN clients use
+// for segmented downloads. The boolean is false when we should ignore the
+// header and serve the whole object, as RFC 9110 allows for unsupported
+// or malformed ranges. The error is [errUnsatisfiableRange] when the
+// range does not overlap with the object.
+func parseRange(value string, size int64) (int64, int64, bool, error) {
+	spec, found := strings.CutPrefix(value, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	// A suffix range (e.g., "-500") selects the last bytes.
+	if first == "" {
+		count, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || count < 0 {
+			return 0, 0, false, nil
+		}
+		if count == 0 {
+			return 0, 0, false, errUnsatisfiableRange
+		}
+		count = min(count, size)
+		return size - count, count, true, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, nil
+		}
+	}
+	if start >= size {
+		return 0, 0, false, errUnsatisfiableRange
+	}
+	end = min(end, size-1)
+	return start, end - start + 1, true, nil
+}
+
+// handleGetObject serves byte ranges of a large virtual object, which makes
+// the download look like a CDN-style segmented fetch and allows comparing
+// with generic HTTP tools (e.g., curl --range) using the same session.
+func (sm *sessionManager) handleGetObject(rw http.ResponseWriter, req *http.Request) {
+	sid := req.PathValue("sid")
+	sess, ok := sm.getSession(sid)
+	if !ok {
+		sm.writeNotFound(rw, req, sid)
+		return
+	}
+	if sess.isAborted() {
+		writeProblem(rw, req, http.StatusConflict, problem.TypeSessionAborted, sid, "the client aborted the session")
+		return
+	}
+
+	rw.Header().Set("Accept-Ranges", "bytes")
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("ETag", objectETag)
+
+	start, count, partial, err := parseRange(req.Header.Get("Range"), objectSize)
+	if ifRange := req.Header.Get("If-Range"); ifRange != "" && ifRange != objectETag {
+		start, count, partial, err = 0, 0, false, nil
+	}
+	if errors.Is(err, errUnsatisfiableRange) {
+		rw.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", objectSize))
+		writeProblem(rw, req, http.StatusRequestedRangeNotSatisfiable, problem.TypeRangeNotSatisfiable, sid,
+			fmt.Sprintf("the range must overlap with the %d bytes of the object", objectSize))
+		return
+	}
+	status := http.StatusOK
+	if partial {
+		status = http.StatusPartialContent
+		rw.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+count-1, objectSize))
+	} else {
+		count = objectSize
+	}
+
+	slog.Info("GET object",
+		slog.String("sid", sid),
+		slog.Int64("start", start),
+		slog.Int64("size", count),
+		slog.String("proto", req.Proto),
+		slog.String("remote", req.RemoteAddr),
+	)
+
+	release, ok := sm.adm.Admit(rw, req)
+	if !ok {
+		return
+	}
+	defer release()
+
+	t0 := time.Now()
+	rw.Header().Set("Content-Length", strconv.FormatInt(count, 10))
+	rw.WriteHeader(status)
+	if req.Method == http.MethodHead {
+		return
+	}
+	ctx, span := sess.startTransfer(req, "download", count)
+	span.SetAttrs(otlp.Int64("ndt8.range.start", start))
+	written, err := sm.writeBody(rw, req, sess, io.LimitReader(infinite.Reader{}, count), count)
+	elapsed := time.Since(t0)
+	endTransfer(ctx, span, "download", written, elapsed, err)
+	sm.recordTransfer(sess, "download", written)
+
+	slog.Info("GET object done",
+		slog.String("sid", sid),
+		slog.Int64("bytes", written),
+		slog.Duration("elapsed", elapsed),
+		slog.Any("err", err),
+		slog.String("remote", req.RemoteAddr),
+	)
+	if errors.Is(err, errSessionAborted) {
+		// Abort the response so the client sees a truncated body.
+		panic(http.ErrAbortHandler)
+	}
+}
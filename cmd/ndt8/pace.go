@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/conncount"
+)
+
+// kernelPacedKey is the context key telling whether the kernel paces
+// the connection serving a request (see [withKernelPacing]).
+type kernelPacedKey struct{}
+
+// withKernelPacing asks the kernel to pace conn at rate bit/s and returns
+// a context recording whether it succeeded (see [isKernelPaced]).
+func withKernelPacing(ctx context.Context, conn net.Conn, rate float64) context.Context {
+	err := setMaxPacingRate(conncount.Unwrap(conn), rate)
+	if err != nil {
+		slog.Info("using userspace pacing", slog.String("remote", conn.RemoteAddr().String()), slog.Any("err", err))
+	}
+	return context.WithValue(ctx, kernelPacedKey{}, err == nil)
+}
+
+// isKernelPaced returns whether the kernel paces the connection
+// associated with the given request context.
+func isKernelPaced(ctx context.Context) bool {
+	paced, _ := ctx.Value(kernelPacedKey{}).(bool)
+	return paced
+}
+
+// pacingWriter is an [io.Writer] writing at most rate bit/s.
+//
+// Construct using [newPacingWriter].
+type pacingWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	rate    float64
+	maxSize int
+	t0      time.Time
+	tot     int64
+}
+
+var _ io.Writer = &pacingWriter{}
+
+// newPacingWriter constructs a new [*pacingWriter] writing to w at the given rate
+// in bit/s until ctx is done, which interrupts waiting for the next write.
+func newPacingWriter(ctx context.Context, w io.Writer, rate float64) *pacingWriter {
+	return &pacingWriter{
+		ctx:     ctx,
+		w:       w,
+		rate:    rate,
+		maxSize: max(int(rate/8/100), 1500), // 10ms worth of data, like the tbf burst
+		t0:      time.Now(),
+	}
+}
+
+// Write implements [io.Writer].
+//
+// We split data into small writes and wait after each of them until the
+// time by which we should have written the bytes so far at the given rate.
+func (pw *pacingWriter) Write(data []byte) (int, error) {
+	var total int
+	for len(data) > 0 {
+		count := min(len(data), pw.maxSize)
+		written, err := pw.w.Write(data[:count])
+		total += written
+		pw.tot += int64(written)
+		if err != nil {
+			return total, err
+		}
+		data = data[count:]
+
+		deadline := pw.t0.Add(time.Duration(float64(pw.tot*8) / pw.rate * float64(time.Second)))
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-pw.ctx.Done():
+			timer.Stop()
+			return total, pw.ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"syscall"
+)
+
+// soMaxPacingRate is SO_MAX_PACING_RATE, which the syscall package lacks.
+const soMaxPacingRate = 47
+
+// setMaxPacingRate asks the kernel to pace conn at rate bit/s. Since Linux 4.13,
+// TCP paces by itself, so this works without the fq qdisc as well.
+func setMaxPacingRate(conn net.Conn, rate float64) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	bytesPerSecond := int(min(rate/8, math.MaxInt32))
+	var serr error
+	err = rawConn.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soMaxPacingRate, bytesPerSecond)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
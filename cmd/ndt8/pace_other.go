@@ -0,0 +1,15 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// setMaxPacingRate fails, since we only know how to pace on Linux.
+func setMaxPacingRate(conn net.Conn, rate float64) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,315 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+const (
+	// patternInterval is the interval between transfers of the rate-based patterns.
+	patternInterval = 250 * time.Millisecond
+
+	// pageThinkTime is the time between page loads, during which a user
+	// would be reading the page.
+	pageThinkTime = time.Second
+
+	// browserConnsPerHost is the number of connections browsers open to
+	// the same host when using HTTP/1.1.
+	browserConnsPerHost = 6
+)
+
+// transferFunc transfers size bytes in the direction being measured and
+// returns the error that occurred, if any.
+type transferFunc func(ctx context.Context, size int64) error
+
+// phase is what a [pattern] knows about the direction it runs.
+type phase struct {
+	// direction is either "download" or "upload".
+	direction string
+
+	// rng draws the random choices of the pattern.
+	rng *rand.Rand
+
+	// tl collects the results.
+	tl *results.Timeline
+
+	// transfer transfers data in direction.
+	transfer transferFunc
+}
+
+// pattern is how the client requests bulk data during a direction, which
+// allows studying how the burstiness of the traffic, rather than just its
+// volume, interacts with the buffers and the AQM of the emulated link.
+type pattern interface {
+	// String returns the pattern specification.
+	String() string
+
+	// run invokes ph.transfer until ctx is done or the pattern completes
+	// and returns whether it completed.
+	run(ctx context.Context, ph *phase) bool
+}
+
+// parsePattern parses a --pattern specification, which is "saturate",
+// "constant:RATE", "ramp:RATE", "onoff:ON/OFF" (e.g., "onoff:1s/2s"),
+// "pageload[:OBJECTS/MEDIAN[/SIGMA]]" (e.g., "pageload:70/12000/1.5"), or
+// "video[:SEGMENT[/RATE,RATE,...]]" (e.g., "video:2s/1mbit,3mbit").
+func parsePattern(spec string) (pattern, error) {
+	name, param, _ := strings.Cut(spec, ":")
+	switch name {
+	case "saturate":
+		if param != "" {
+			break
+		}
+		return saturatePattern{}, nil
+
+	case "constant", "ramp":
+		rate, err := humanize.ParseRate(param)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("--pattern %s needs a positive rate (e.g., %s:20mbit)", name, name)
+		}
+		if name == "ramp" {
+			return rampPattern{rate}, nil
+		}
+		return constantPattern{rate}, nil
+
+	case "onoff":
+		onStr, offStr, _ := strings.Cut(param, "/")
+		on, err1 := time.ParseDuration(onStr)
+		off, err2 := time.ParseDuration(offStr)
+		if err1 != nil || err2 != nil || on <= 0 || off <= 0 {
+			return nil, fmt.Errorf("--pattern onoff needs positive on and off durations (e.g., onoff:1s/2s)")
+		}
+		return onOffPattern{on, off}, nil
+
+	case "pageload":
+		return parsePageLoad(param)
+
+	case "video":
+		return parseVideo(param)
+	}
+	return nil, fmt.Errorf("unknown --pattern %q (use saturate, constant:RATE, ramp:RATE, onoff:ON/OFF, pageload, or video)", spec)
+}
+
+// saturatePattern is the default [pattern], doubling the chunk size until
+// the largest chunk completes or ctx is done, as described in the README.
+type saturatePattern struct{}
+
+var _ pattern = saturatePattern{}
+
+// String implements [pattern].
+func (saturatePattern) String() string {
+	return "saturate"
+}
+
+// run implements [pattern].
+func (saturatePattern) run(ctx context.Context, ph *phase) bool {
+	for size := int64(initialChunkSize); size <= maxChunkSize && ctx.Err() == nil; size *= 2 {
+		ph.transfer(ctx, size)
+	}
+	return ctx.Err() == nil
+}
+
+// constantPattern transfers at a constant rate, in chunks of the size we
+// should transfer in each [patternInterval], idling in between.
+type constantPattern struct {
+	rate float64
+}
+
+var _ pattern = constantPattern{}
+
+// String implements [pattern].
+func (p constantPattern) String() string {
+	return fmt.Sprintf("constant:%.0fbit", p.rate)
+}
+
+// run implements [pattern]. Since the pattern lasts until ctx is done,
+// it always completes, unlike [saturatePattern].
+func (p constantPattern) run(ctx context.Context, ph *phase) bool {
+	return paced(ctx, ph, func(time.Duration) float64 { return p.rate })
+}
+
+// rampPattern is like [constantPattern] but the rate grows linearly from
+// zero to the given rate at the end of the time budget.
+type rampPattern struct {
+	rate float64
+}
+
+var _ pattern = rampPattern{}
+
+// String implements [pattern].
+func (p rampPattern) String() string {
+	return fmt.Sprintf("ramp:%.0fbit", p.rate)
+}
+
+// run implements [pattern].
+func (p rampPattern) run(ctx context.Context, ph *phase) bool {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return constantPattern(p).run(ctx, ph)
+	}
+	budget := time.Until(deadline)
+	return paced(ctx, ph, func(elapsed time.Duration) float64 {
+		return p.rate * min(float64(elapsed+patternInterval)/float64(budget), 1)
+	})
+}
+
+// paced invokes ph.transfer every [patternInterval] until ctx is done, with
+// the size the rate returned for the elapsed time implies. When a transfer
+// takes longer than the interval, the next one starts right away.
+func paced(ctx context.Context, ph *phase, rate func(elapsed time.Duration) float64) bool {
+	t0 := time.Now()
+	ticker := time.NewTicker(patternInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		size := int64(rate(time.Since(t0)) * patternInterval.Seconds() / 8)
+		ph.transfer(ctx, min(max(size, 1), maxChunkSize))
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// onOffPattern alternates saturating bursts lasting on, during which the
+// chunk size doubles as in [saturatePattern], and idle periods lasting off.
+type onOffPattern struct {
+	on, off time.Duration
+}
+
+var _ pattern = onOffPattern{}
+
+// String implements [pattern].
+func (p onOffPattern) String() string {
+	return fmt.Sprintf("onoff:%s/%s", p.on, p.off)
+}
+
+// run implements [pattern]. We abort the transfer in flight when a burst
+// ends, so the bursts do not overshoot into the idle periods.
+func (p onOffPattern) run(ctx context.Context, ph *phase) bool {
+	for ctx.Err() == nil {
+		burstCtx, cancel := context.WithTimeout(ctx, p.on)
+		saturatePattern{}.run(burstCtx, ph)
+		<-burstCtx.Done()
+		cancel()
+		timer := time.NewTimer(p.off)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	return true
+}
+
+// pageLoadPattern repeatedly loads simulated pages made of objects whose
+// sizes follow a log-normal distribution, which approximates the heavy
+// tail of the object sizes of real web pages, and records how long each
+// page took to load, separated by [pageThinkTime].
+type pageLoadPattern struct {
+	objects int
+	median  float64
+	sigma   float64
+}
+
+var _ pattern = pageLoadPattern{}
+
+// defaultPageLoad is the default [pageLoadPattern], which roughly matches
+// the median page of the HTTP Archive.
+var defaultPageLoad = pageLoadPattern{objects: 70, median: 12000, sigma: 1.5}
+
+// parsePageLoad parses the OBJECTS/MEDIAN[/SIGMA] parameter of the
+// pageload pattern, where MEDIAN is the median object size in bytes.
+func parsePageLoad(param string) (pattern, error) {
+	if param == "" {
+		return defaultPageLoad, nil
+	}
+	fields := strings.Split(param, "/")
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("--pattern pageload needs OBJECTS/MEDIAN[/SIGMA] (e.g., pageload:70/12000/1.5)")
+	}
+	p := defaultPageLoad
+	var err error
+	if p.objects, err = strconv.Atoi(fields[0]); err != nil || p.objects <= 0 {
+		return nil, fmt.Errorf("--pattern pageload needs a positive number of objects")
+	}
+	if p.median, err = strconv.ParseFloat(fields[1], 64); err != nil || p.median < 1 {
+		return nil, fmt.Errorf("--pattern pageload needs a median object size of at least one byte")
+	}
+	if len(fields) == 3 {
+		if p.sigma, err = strconv.ParseFloat(fields[2], 64); err != nil || p.sigma < 0 {
+			return nil, fmt.Errorf("--pattern pageload needs a non-negative sigma")
+		}
+	}
+	return p, nil
+}
+
+// String implements [pattern].
+func (p pageLoadPattern) String() string {
+	return fmt.Sprintf("pageload:%d/%.0f/%g", p.objects, p.median, p.sigma)
+}
+
+// run implements [pattern]. We only record the pages that completed
+// before ctx was done, since the others are not telling.
+func (p pageLoadPattern) run(ctx context.Context, ph *phase) bool {
+	for ctx.Err() == nil {
+		pl := p.load(ctx, ph)
+		if ctx.Err() != nil {
+			break
+		}
+		slog.Info("page load",
+			slog.String("direction", pl.Direction),
+			slog.Int("objects", pl.Objects),
+			slog.Int64("bytes", pl.Bytes),
+			slog.Duration("elapsed", pl.Elapsed),
+			slog.String("failure", pl.Failure),
+		)
+		ph.tl.EmitPageLoad(pl)
+		timer := time.NewTimer(pageThinkTime)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	return true
+}
+
+// load starts transferring all the objects of a page and waits for them.
+// The transport limits the connections like a browser would.
+func (p pageLoadPattern) load(ctx context.Context, ph *phase) results.PageLoad {
+	pl := results.PageLoad{Direction: ph.direction, Objects: p.objects, Time: time.Now()}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for range p.objects {
+		size := int64(p.median * math.Exp(p.sigma*ph.rng.NormFloat64()))
+		size = min(max(size, 1), maxChunkSize)
+		pl.Bytes += size
+		wg.Go(func() {
+			if err := ph.transfer(ctx, size); err != nil {
+				mu.Lock()
+				if pl.Failure == "" {
+					pl.Failure = err.Error()
+				}
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	pl.Elapsed = time.Since(pl.Time)
+	return pl
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/2026-02-provlima/internal/timestamping"
+)
+
+const (
+	// minProbeInterval is the interval between probes on fast links.
+	minProbeInterval = 250 * time.Millisecond
+
+	// maxProbeInterval bounds the interval between probes on slow links,
+	// so that we still sample the latency under load.
+	maxProbeInterval = 2 * time.Second
+
+	// probeWireBytes estimates the bytes a probe exchange costs on the
+	// wire, counting both directions and the HTTP, TLS, and TCP/IP headers.
+	probeWireBytes = 600
+
+	// maxProbeBurst is the largest number of probes in a burst.
+	maxProbeBurst = 20
+)
+
+// probeScheduler decides the interval between the probes sent during a
+// transfer, such that they use at most the budget fraction of the link
+// capacity, which we estimate from the throughput of the transfer so far.
+//
+// On slow links (e.g., 2g), probing every [minProbeInterval] would use a
+// meaningful fraction of the capacity, interfering with the transfer.
+// A zero budget always probes every [minProbeInterval].
+//
+// When we send the probes in bursts, the interval between the bursts is
+// the interval between the probes times the burst size, such that the
+// probes use the same budget (e.g., bursts of 5 probes every 1.25s on
+// fast links). The back-to-back probes of a burst measure the jitter.
+type probeScheduler struct {
+	// budget is the fraction of the capacity the probes may use.
+	budget float64
+
+	// burst is the number of probes in a burst, where 1 means no bursts.
+	burst int
+
+	// direction is the direction of the transfer.
+	direction string
+
+	// tl is the timeline containing the transfer samples.
+	tl *results.Timeline
+}
+
+// interval returns the interval before the next probe or burst.
+func (ps *probeScheduler) interval() time.Duration {
+	return ps.probeInterval() * time.Duration(max(ps.burst, 1))
+}
+
+// probeInterval returns the interval between the probes.
+func (ps *probeScheduler) probeInterval() time.Duration {
+	if ps.budget <= 0 {
+		return minProbeInterval
+	}
+	// Until we have samples, we do not know better than probing often.
+	summary := results.Summarize(ps.tl.Samples(), results.OriginClient, ps.direction, 0)
+	if summary == nil || summary.Throughput <= 0 {
+		return minProbeInterval
+	}
+	seconds := probeWireBytes * 8 / (ps.budget * summary.Throughput)
+	interval := time.Duration(seconds * float64(time.Second))
+	return min(max(interval, minProbeInterval), maxProbeInterval)
+}
+
+// probeLoad returns the fraction of the throughput used by count probes
+// sent during elapsed, estimated using [probeWireBytes].
+func probeLoad(count int, elapsed time.Duration, throughput float64) float64 {
+	if elapsed <= 0 || throughput <= 0 {
+		return 0
+	}
+	return float64(count*probeWireBytes*8) / elapsed.Seconds() / throughput
+}
+
+// probeTransport is an [http.RoundTripper] sending the probes using the
+// probes transport, whose connections record kernel timestamps (see
+// [timestamping.Wrap]), and the other requests using the others transport.
+//
+// Since the transfers do not share the connections of the probes, even
+// using HTTP/2, the first data we read after sending a probe is always
+// the response, so the kernel timestamps measure the probe RTT.
+type probeTransport struct {
+	probes http.RoundTripper
+	others http.RoundTripper
+}
+
+// RoundTrip implements [http.RoundTripper].
+func (pt *probeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.Contains(req.URL.Path, "/probe/") {
+		return pt.probes.RoundTrip(req)
+	}
+	return pt.others.RoundTrip(req)
+}
+
+// addKernelStamps sets the kernel timestamps of probe using those of the
+// last exchange on conn, if any (see [timestamping.Last]).
+func addKernelStamps(probe *results.Probe, conn net.Conn) {
+	if conn == nil {
+		return
+	}
+	sent, received, err := timestamping.Last(conn)
+	if err != nil || sent.IsZero() || !received.After(sent) {
+		return
+	}
+	probe.KernelSent = sent
+	probe.KernelReceived = received
+	probe.KernelRTT = received.Sub(sent)
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/privacy"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+// Reasons for ending a session, which [sessionRecord] tells.
+const (
+	// sessionDeleted means the client deleted the session.
+	sessionDeleted = "deleted"
+
+	// sessionExpired means the client abandoned the session, which
+	// expired (see [sessionIdleLifetime]).
+	sessionExpired = "expired"
+
+	// sessionShutdown means the server stopped with the session active.
+	sessionShutdown = "shutdown"
+)
+
+// sessionRecord is the server-side record of a session, which
+// [*sessionRecorder] writes when the session ends.
+type sessionRecord struct {
+	// SessionID is the session ID.
+	SessionID string `json:"sessionID"`
+
+	// Client is the client address, if not omitted.
+	Client string `json:"client,omitempty"`
+
+	// Metadata is the metadata the client declared, if any.
+	Metadata *results.Metadata `json:"metadata,omitempty"`
+
+	// Created is when the client created the session.
+	Created time.Time `json:"created"`
+
+	// Ended is when the session ended.
+	Ended time.Time `json:"ended"`
+
+	// Reason is why the session ended: [sessionDeleted], [sessionExpired],
+	// or [sessionShutdown].
+	Reason string `json:"reason"`
+
+	// Aborted indicates that the client aborted the session.
+	Aborted bool `json:"aborted,omitempty"`
+
+	// Downloads is the number of download transfers.
+	Downloads int64 `json:"downloads"`
+
+	// Uploads is the number of upload transfers.
+	Uploads int64 `json:"uploads"`
+
+	// Probes is the number of probes served.
+	Probes int64 `json:"probes"`
+
+	// BytesSent is the number of bytes sent in the downloads.
+	BytesSent int64 `json:"bytesSent"`
+
+	// BytesReceived is the number of bytes received in the uploads.
+	BytesReceived int64 `json:"bytesReceived"`
+}
+
+// sessionRecorder appends a [sessionRecord] for each session to a file as
+// NDJSON once the session ends.
+//
+// Construct using [newSessionRecorder]. The nil recorder does nothing.
+type sessionRecorder struct {
+	anon *privacy.Policy
+	enc  *json.Encoder
+	file *os.File
+	mu   sync.Mutex
+}
+
+// newSessionRecorder opens path for appending and returns a
+// [*sessionRecorder] writing to it, which anonymizes the client
+// addresses using anon.
+func newSessionRecorder(path string, anon *privacy.Policy) (*sessionRecorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionRecorder{anon: anon, enc: json.NewEncoder(file), file: file}, nil
+}
+
+// record writes the record of the session with the given ID, which ended
+// for the given reason, logging failures rather than interrupting the
+// server. Since transfers in flight may complete after the session ends,
+// the record contains the statistics as of when we call record.
+func (sr *sessionRecorder) record(sid string, sess *session, reason string) {
+	if sr == nil {
+		return
+	}
+	stats := sess.snapshot()
+	client, _, _ := net.SplitHostPort(sess.clientAddr)
+	rec := sessionRecord{
+		SessionID:     sid,
+		Client:        sr.anon.IP(client),
+		Metadata:      sess.metadata,
+		Created:       sess.created,
+		Ended:         time.Now(),
+		Reason:        reason,
+		Aborted:       sess.isAborted(),
+		Downloads:     stats.downloads,
+		Uploads:       stats.uploads,
+		Probes:        stats.probes,
+		BytesSent:     stats.bytesSent,
+		BytesReceived: stats.bytesReceived,
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if err := sr.enc.Encode(rec); err != nil {
+		slog.Warn("cannot save session record", slog.Any("err", err))
+	}
+}
+
+// Close closes the file.
+func (sr *sessionRecorder) Close() error {
+	if sr == nil {
+		return nil
+	}
+	return sr.file.Close()
+}
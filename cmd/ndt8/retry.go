@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+const (
+	// retryBackoff is the time we wait before retrying an interrupted
+	// transfer, which avoids spinning while the network is down.
+	retryBackoff = 250 * time.Millisecond
+
+	// minStallTimeout is the minimum stall timeout, which must be well
+	// above [sampleInterval], since the samples tell the progress.
+	minStallTimeout = 4 * sampleInterval
+)
+
+// errStalled indicates that a transfer made no progress for too long.
+var errStalled = errors.New("transfer stalled")
+
+// emitFunc records a client sample.
+type emitFunc func(results.Sample)
+
+// retrier retries the transfers that fail mid-chunk on a fresh connection
+// and records the interruptions, so that the phase continues when the
+// network goes down for a while, rather than only measuring the outage.
+type retrier struct {
+	// client is the client performing the transfers.
+	client *http.Client
+
+	// direction is either "download" or "upload".
+	direction string
+
+	// stall is the time without progress after which we abort a transfer,
+	// which we need because transfers over a blackholed path do not fail
+	// until the TCP retransmissions give up, or zero to wait.
+	stall time.Duration
+
+	// tl collects the interruptions.
+	tl *results.Timeline
+}
+
+// do invokes fn to transfer size bytes until it succeeds, fails in a way
+// that retrying would not fix, or ctx is done, and returns the last error.
+func (r *retrier) do(ctx context.Context, size int64, fn func(ctx context.Context, emit emitFunc) error) error {
+	var in *results.Interruption
+	for {
+		first, last, err := r.attempt(ctx, fn)
+
+		// The interruption ends when a retry makes progress.
+		if in != nil && !first.IsZero() {
+			in.Recovered, in.Elapsed = true, first.Sub(in.Time)
+			r.emit(in)
+			in = nil
+		}
+
+		// We do not retry after the server responded, which tells that the
+		// network works and that retrying would get the same response.
+		if err == nil || ctx.Err() != nil || problem.IsResponse(err) {
+			if in != nil {
+				in.Elapsed = time.Since(in.Time)
+				r.emit(in)
+			}
+			return err
+		}
+
+		if in == nil {
+			in = &results.Interruption{Direction: r.direction, ChunkSize: size, Failure: err.Error(), Time: last}
+		}
+		in.Retries++
+		slog.Warn("retrying on a fresh connection",
+			slog.String("direction", r.direction),
+			slog.Int64("size", size),
+			slog.Int("retries", in.Retries),
+			slog.Any("err", err),
+		)
+
+		// The idle connections are likely as broken as the one that failed.
+		r.client.CloseIdleConnections()
+		timer := time.NewTimer(retryBackoff)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// attempt invokes fn once and returns the time of the first and of the last
+// progress, where the start of the attempt counts as the last progress when
+// there is none, along with the error. When r.stall is positive, we abort
+// the attempt after r.stall without progress.
+func (r *retrier) attempt(ctx context.Context, fn func(ctx context.Context, emit emitFunc) error) (time.Time, time.Time, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	pr := &progress{last: time.Now()}
+	emit := func(sample results.Sample) {
+		pr.add(sample)
+		r.tl.Emit(sample)
+	}
+
+	if r.stall > 0 {
+		go func() {
+			ticker := time.NewTicker(r.stall / 4)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if _, last := pr.times(); time.Since(last) >= r.stall {
+						cancel(errStalled)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	err := fn(ctx, emit)
+	if err != nil {
+		pr.failed()
+	}
+	if err != nil && errors.Is(context.Cause(ctx), errStalled) {
+		err = errStalled
+	}
+	first, last := pr.times()
+	return first, last, err
+}
+
+// progress tracks the progress of a transfer attempt, within [sampleInterval],
+// using the samples reporting more bytes than the previous ones.
+type progress struct {
+	mu          sync.Mutex
+	bytes       int64
+	first, last time.Time
+	prev        time.Time
+	final       bool
+}
+
+// add accounts for sample.
+func (pr *progress) add(sample results.Sample) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	pr.final = sample.Bytes > pr.bytes
+	if pr.final {
+		if pr.first.IsZero() {
+			pr.first = sample.Time
+		}
+		pr.bytes, pr.prev, pr.last = sample.Bytes, pr.last, sample.Time
+	}
+}
+
+// failed discounts the final sample, which the sampler emits when the
+// transfer fails, since it accounts for bytes we read before the failure
+// but bears the time of the failure.
+func (pr *progress) failed() {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.final {
+		if pr.first.Equal(pr.last) {
+			pr.first = time.Time{}
+		}
+		pr.last, pr.final = pr.prev, false
+	}
+}
+
+// times returns the time of the first progress, if any, and of the last one.
+func (pr *progress) times() (time.Time, time.Time) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return pr.first, pr.last
+}
+
+// emit logs and records the given interruption.
+func (r *retrier) emit(in *results.Interruption) {
+	slog.Info("interruption",
+		slog.String("direction", in.Direction),
+		slog.Int64("size", in.ChunkSize),
+		slog.Int("retries", in.Retries),
+		slog.Bool("recovered", in.Recovered),
+		slog.Duration("elapsed", in.Elapsed),
+		slog.String("failure", in.Failure),
+	)
+	r.tl.EmitInterruption(*in)
+}
@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/2026-02-provlima/internal/tcpinfo"
+)
+
+// sampleInterval is the interval between throughput samples.
+const sampleInterval = 250 * time.Millisecond
+
+// sampler periodically records the bytes transferred by a chunk.
+//
+// Construct using [newSampler].
+type sampler struct {
+	acked0   int64
+	conn     net.Conn
+	emit     func(results.Sample)
+	proto    results.Sample
+	retrans0 int64
+	t0       time.Time
+	tot      int64
+	tprev    time.Time
+}
+
+// newSampler constructs a new [*sampler] invoking emit for each sample.
+func newSampler(origin, direction string, size int64, emit func(results.Sample)) *sampler {
+	now := time.Now()
+	return &sampler{
+		emit: emit,
+		proto: results.Sample{
+			Origin:    origin,
+			Direction: direction,
+			ChunkSize: size,
+		},
+		t0:    now,
+		tot:   0,
+		tprev: now,
+	}
+}
+
+// track makes the sampler also record the bytes the peer acknowledged
+// and the segments we retransmitted on conn from now on, which is only
+// meaningful for the sender.
+func (s *sampler) track(conn net.Conn) {
+	if info, err := tcpinfo.Get(conn); err == nil {
+		s.conn, s.acked0, s.retrans0 = conn, info.BytesAcked, info.TotalRetrans
+	}
+}
+
+// add accounts for count bytes and emits a sample if the interval elapsed.
+//
+// We ignore the reads and writes transferring no bytes, which happen when
+// the transfer fails, so the time of each sample is the time when the
+// transfer last made progress.
+func (s *sampler) add(count int) {
+	if count <= 0 {
+		return
+	}
+	s.tot += int64(count)
+	now := time.Now()
+	if now.Sub(s.tprev) >= sampleInterval {
+		s.sample(now)
+		s.tprev = now
+	}
+}
+
+// done emits the final sample for the chunk.
+func (s *sampler) done() {
+	s.sample(time.Now())
+}
+
+func (s *sampler) sample(now time.Time) {
+	sample := s.proto
+	sample.Bytes = s.tot
+	sample.Elapsed = now.Sub(s.t0)
+	sample.Time = now
+	if s.conn != nil {
+		if info, err := tcpinfo.Get(s.conn); err == nil {
+			sample.BytesAcked = info.BytesAcked - s.acked0
+			sample.Retransmits = info.TotalRetrans - s.retrans0
+		}
+	}
+	s.emit(sample)
+}
+
+// samplingReader is an [io.Reader] feeding a [*sampler].
+type samplingReader struct {
+	r io.Reader
+	s *sampler
+}
+
+var _ io.Reader = samplingReader{}
+
+// Read implements [io.Reader].
+func (r samplingReader) Read(data []byte) (int, error) {
+	count, err := r.r.Read(data)
+	r.s.add(count)
+	return count, err
+}
+
+// samplingWriter is an [io.Writer] feeding a [*sampler].
+type samplingWriter struct {
+	w io.Writer
+	s *sampler
+}
+
+var _ io.Writer = samplingWriter{}
+
+// Write implements [io.Writer].
+func (w samplingWriter) Write(data []byte) (int, error) {
+	count, err := w.w.Write(data)
+	w.s.add(count)
+	return count, err
+}
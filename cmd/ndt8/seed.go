@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// maxSeed is the largest seed we draw, which JSON consumers parsing
+// numbers as doubles (e.g., JavaScript) represent exactly.
+const maxSeed = 1<<53 - 1
+
+// newSeed returns a random seed for runs without --seed, which we record
+// in the result document, so that every run can be replayed.
+func newSeed() int64 {
+	return rand.Int64N(maxSeed) + 1
+}
+
+// newRand returns a [*rand.Rand] drawing from the stream of seed used for
+// the given purpose. Each purpose has an independent stream, so that the
+// choices made for one purpose do not depend on how many random numbers
+// the others consumed.
+func newRand(seed int64, purpose string) *rand.Rand {
+	return rand.New(newChaCha8(seed, purpose))
+}
+
+// newChaCha8 returns the [*rand.ChaCha8] behind [newRand].
+func newChaCha8(seed int64, purpose string) *rand.ChaCha8 {
+	return rand.NewChaCha8(sha256.Sum256(fmt.Appendf(nil, "%d/%s", seed, purpose)))
+}
+
+// probeIDs generates the probe IDs from a seed.
+//
+// Construct using [newProbeIDs].
+type probeIDs struct {
+	mu  sync.Mutex
+	src *rand.ChaCha8
+}
+
+// newProbeIDs returns a new [*probeIDs] for the probes of the given
+// direction using the given seed.
+func newProbeIDs(seed int64, direction string) *probeIDs {
+	return &probeIDs{src: newChaCha8(seed, "probe/"+direction)}
+}
+
+// next returns the next probe ID, which is a random UUID, since a UUIDv7
+// would depend on the time.
+func (ids *probeIDs) next() string {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	return uuid.Must(uuid.NewRandomFromReader(ids.src)).String()
+}
@@ -7,65 +7,230 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/bassosimone/2026-02-provlima/internal/acme"
+	"github.com/bassosimone/2026-02-provlima/internal/admission"
+	"github.com/bassosimone/2026-02-provlima/internal/config"
+	"github.com/bassosimone/2026-02-provlima/internal/conncount"
+	"github.com/bassosimone/2026-02-provlima/internal/cors"
+	"github.com/bassosimone/2026-02-provlima/internal/debug"
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/health"
 	"github.com/bassosimone/2026-02-provlima/internal/humanize"
 	"github.com/bassosimone/2026-02-provlima/internal/infinite"
+	"github.com/bassosimone/2026-02-provlima/internal/otlp"
+	"github.com/bassosimone/2026-02-provlima/internal/privacy"
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+	"github.com/bassosimone/2026-02-provlima/internal/realip"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
 	"github.com/bassosimone/2026-02-provlima/internal/slogging"
+	"github.com/bassosimone/2026-02-provlima/internal/systemd"
 	"github.com/bassosimone/runtimex"
 	"github.com/bassosimone/vflag"
 	"github.com/google/uuid"
 )
 
+// serverName is the Server header of our responses, which clients check
+// to notice middleboxes terminating the connection in our place.
+const serverName = "ndt8"
+
 func serveMain(ctx context.Context, args []string) error {
 	var (
-		addressFlag = "127.0.0.1"
-		certFlag    = "testdata/cert.pem"
-		formatFlag  = "text"
-		keyFlag     = "testdata/key.pem"
-		portFlag    = "4443"
-		staticFlag  = "static"
+		acmeFlag          = false
+		acmeCacheFlag     = "acme-cache"
+		acmeEmailFlag     = ""
+		addressFlag       = "127.0.0.1"
+		anonymizeIPsFlag  = "none"
+		blobLimitFlag     = 10
+		certFlag          = "testdata/cert.pem"
+		configFlag        = ""
+		corsHeadersFlag   = "Content-Type,Traceparent,X-NDT8-Version"
+		corsMaxAgeFlag    = 10 * time.Minute
+		corsMethodsFlag   = "GET,POST,PUT,DELETE"
+		corsOriginsFlag   = ""
+		debugAddrFlag     = ""
+		domainFlag        = ""
+		errorFormatFlag   = "text"
+		formatFlag        = "text"
+		httpPortFlag      = "80"
+		keyFlag           = "testdata/key.pem"
+		maxTransfersFlag  = 0
+		omitHostnamesFlag = false
+		otelEndpointFlag  = ""
+		paceFlag          = ""
+		portFlag          = "4443"
+		printUnitFlag     = false
+		queueTimeoutFlag  = 10 * time.Second
+		sessionsFlag      = ""
+		staticFlag        = "static"
+		trustedProxyFlag  = ""
+		uiCertFlag        = ""
+		uiKeyFlag         = ""
+		uiPortFlag        = ""
 	)
 
 	fset := vflag.NewFlagSet("ndt8 serve", vflag.ExitOnError)
+
+	fset.Exit = failure.FlagExit
+	fset.BoolVar(&acmeFlag, 0, "acme", "Obtain the TLS certificate from Let's Encrypt for --domain.")
+	fset.StringVar(&acmeCacheFlag, 0, "acme-cache", "Cache ACME accounts and certificates in `DIR`.")
+	fset.StringVar(&acmeEmailFlag, 0, "acme-email", "Use `EMAIL` as the ACME account contact.")
 	fset.StringVar(&addressFlag, 'A', "address", "Use the given IP `ADDRESS`.")
+	fset.StringVar(&anonymizeIPsFlag, 0, "anonymize-ips", "Store the IP addresses in the results and logs using `MODE` (none, truncate to /24 or /48, or omit).")
+	fset.IntVar(&blobLimitFlag, 0, "blob-limit", "Allow each client `N` requests per minute to /static/blob/SIZE (0 to disable it).")
 	fset.StringVar(&certFlag, 0, "cert", "Use `FILE` as the TLS certificate.")
+	fset.StringVar(&configFlag, 0, "config", "Load flag defaults from TOML `FILE`.")
+	fset.StringVar(&corsHeadersFlag, 0, "cors-headers", "Allow cross-origin requests to send the comma-separated `HEADERS`.")
+	fset.DurationVar(&corsMaxAgeFlag, 0, "cors-max-age", "Let browsers cache the preflight responses for `DURATION`.")
+	fset.StringVar(&corsMethodsFlag, 0, "cors-methods", "Allow cross-origin requests using the comma-separated `METHODS`.")
+	fset.StringVar(&corsOriginsFlag, 0, "cors-origins", "Allow cross-origin API requests from the comma-separated `PATTERNS` (e.g., https://*.example.org, or * for any).")
+	fset.StringVar(&debugAddrFlag, 0, "debug-addr", "Serve /debug/vars and /debug/pprof/ over plain HTTP at `ENDPOINT` (e.g., :6060 for localhost; empty to disable).")
+	fset.StringVar(&domainFlag, 0, "domain", "Use the comma-separated `DOMAINS` with --acme.")
+	fset.StringVar(&errorFormatFlag, 0, "error-format", "Use `FORMAT` for the final error, if any (text or json).")
 	fset.StringVar(&formatFlag, 0, "format", "Use `FORMAT` for log output (text or json).")
 	fset.AutoHelp('h', "help", "Print this help text and exit.")
+	fset.StringVar(&httpPortFlag, 0, "http-port", "Answer ACME HTTP-01 challenges on TCP `PORT` (empty to disable).")
 	fset.StringVar(&keyFlag, 0, "key", "Use `FILE` as the TLS private key.")
+	fset.IntVar(&maxTransfersFlag, 0, "max-transfers", "Run at most `N` chunk transfers at once, queueing the others (0 for no limit).")
+	fset.BoolVar(&omitHostnamesFlag, 0, "omit-hostnames", "Omit the hostnames from the results and logs.")
+	fset.StringVar(&otelEndpointFlag, 0, "otel-endpoint", "Export OpenTelemetry traces and metrics to the OTLP/HTTP collector at `URL`.")
+	fset.StringVar(&paceFlag, 0, "pace", "Pace downloads to at most `RATE` per connection (e.g., 50mbit).")
 	fset.StringVar(&portFlag, 'p', "port", "Use the given TCP `PORT`.")
+	fset.BoolVar(&printUnitFlag, 0, "print-systemd-unit", "Print a systemd unit running the server with the current flags and exit.")
+	fset.DurationVar(&queueTimeoutFlag, 0, "queue-timeout", "Reject queued transfers with 503 after `DURATION`.")
+	fset.StringVar(&sessionsFlag, 0, "sessions", "Append a record of each session, including the client metadata, to `FILE` as NDJSON when it ends.")
 	fset.StringVar(&staticFlag, 's', "static", "Serve static files from `DIR`.")
+	fset.StringVar(&trustedProxyFlag, 0, "trusted-proxy", "Trust the forwarding headers set by the proxies in the comma-separated `CIDRS`.")
+	fset.StringVar(&uiCertFlag, 0, "ui-cert", "Use `FILE` as the TLS certificate of the --ui-port listener (empty for plain HTTP).")
+	fset.StringVar(&uiKeyFlag, 0, "ui-key", "Use `FILE` as the TLS private key of the --ui-port listener.")
+	fset.StringVar(&uiPortFlag, 0, "ui-port", "Serve the static files on a separate listener on TCP `PORT` (empty to serve them with the API).")
+	failure.OnError(failure.Usage, config.Setup(fset, "NDT8", "serve", args))
 	runtimex.PanicOnError0(fset.Parse(args))
+	failure.Setup(errorFormatFlag)
+
+	anon, err := privacy.New(anonymizeIPsFlag, omitHostnamesFlag)
+	failure.OnError(failure.Usage, err)
+
+	var acmeConfig *acme.Config
+	if acmeFlag {
+		if domainFlag == "" {
+			failure.Exit(failure.Usage, errors.New("--acme requires --domain"))
+		}
+		acmeConfig = &acme.Config{
+			Domains:  strings.Split(domainFlag, ","),
+			CacheDir: runtimex.LogFatalOnError1(filepath.Abs(acmeCacheFlag)),
+			Email:    acmeEmailFlag,
+		}
+	}
+
+	if printUnitFlag {
+		env := config.Environ(fset, "NDT8", "config", "print-systemd-unit")
+		unit := runtimex.LogFatalOnError1(systemd.NewUnit("ndt8 server", []string{"serve"}, env))
+		if acmeConfig != nil {
+			// The service cannot create the cache, so we do it here.
+			runtimex.LogFatalOnError0(os.MkdirAll(acmeConfig.CacheDir, 0700))
+			unit.ReadWritePaths = append(unit.ReadWritePaths, acmeConfig.CacheDir)
+		}
+		fmt.Print(unit.String())
+		return nil
+	}
 
 	slogging.Setup(formatFlag)
+	anon.Setup()
 
-	sm := newSessionManager()
+	var pace float64
+	if paceFlag != "" {
+		var err error
+		pace, err = humanize.ParseRate(paceFlag)
+		failure.OnError(failure.Usage, err)
+	}
+	adm := admission.New(maxTransfersFlag, queueTimeoutFlag)
+	otel, err := otlp.New(otelEndpointFlag, "ndt8-server")
+	failure.OnError(failure.Usage, err)
+	var records *sessionRecorder
+	if sessionsFlag != "" {
+		records, err = newSessionRecorder(sessionsFlag, anon)
+		failure.OnError(failure.Generic, err)
+		defer records.Close()
+	}
+	sm := newSessionManager(pace, adm, otel, anon, records)
+	go sm.reapSessions(ctx, sessionReapInterval)
 
-	mux := http.NewServeMux()
-	mux.Handle("POST /ndt/v8/session", http.HandlerFunc(sm.handleCreateSession))
-	mux.Handle("GET /ndt/v8/session/{sid}/chunk/{size}", http.HandlerFunc(sm.handleGetChunk))
-	mux.Handle("PUT /ndt/v8/session/{sid}/chunk/{size}", http.HandlerFunc(sm.handlePutChunk))
-	mux.Handle("GET /ndt/v8/session/{sid}/probe/{pid}", http.HandlerFunc(sm.handleProbe))
-	mux.Handle("DELETE /ndt/v8/session/{sid}", http.HandlerFunc(sm.handleDeleteSession))
+	// With split listeners, the browser client calls the API cross-origin,
+	// so we allow its origin unless told otherwise.
+	if uiPortFlag != "" {
+		if staticFlag == "" {
+			failure.Exit(failure.Usage, errors.New("--ui-port requires --static"))
+		}
+		if (uiCertFlag == "") != (uiKeyFlag == "") {
+			failure.Exit(failure.Usage, errors.New("--ui-cert and --ui-key go together"))
+		}
+		if corsOriginsFlag == "" {
+			scheme := "http"
+			if uiCertFlag != "" {
+				scheme = "https"
+			}
+			corsOriginsFlag = scheme + "://*:" + uiPortFlag
+		}
+	}
 
+	var corsPolicy *cors.Policy
+	if corsOriginsFlag != "" {
+		corsPolicy, err = cors.New(strings.Split(corsOriginsFlag, ","), strings.Split(corsMethodsFlag, ","),
+			strings.Split(corsHeadersFlag, ","), corsMaxAgeFlag)
+		failure.OnError(failure.Usage, err)
+		slog.Info("allowing cross-origin requests", slog.String("origins", corsOriginsFlag))
+	}
+
+	mux := newAPIMux(sm, corsPolicy)
+
+	uiMux := mux
+	if uiPortFlag != "" {
+		uiMux = http.NewServeMux()
+	}
 	if staticFlag != "" {
 		slog.Info("serving static files", slog.String("dir", staticFlag))
-		mux.Handle("GET /", http.FileServer(http.Dir(staticFlag)))
+		uiMux.Handle("GET /", http.FileServer(http.Dir(staticFlag)))
+	}
+	if blobLimitFlag > 0 {
+		slog.Info("serving blobs", slog.Int("perMinute", blobLimitFlag))
+		mux.Handle("GET /static/blob/{size}", &blobHandler{adm: adm, limiter: newBlobLimiter(blobLimitFlag), pace: pace})
+	}
+
+	var handler http.Handler = mux
+	if trustedProxyFlag != "" {
+		resolver, err := realip.New(strings.Split(trustedProxyFlag, ","))
+		failure.OnError(failure.Usage, err)
+		slog.Info("trusting proxies", slog.String("cidrs", trustedProxyFlag))
+		handler = realip.Handler(resolver, mux)
 	}
+	next := handler
+	handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Server", serverName)
+		next.ServeHTTP(rw, req)
+	})
 
 	endpoint := net.JoinHostPort(addressFlag, portFlag)
 	srv := &http.Server{
 		Addr:    endpoint,
-		Handler: mux,
+		Handler: handler,
 		TLSConfig: &tls.Config{
 			NextProtos: []string{"h2", "http/1.1"},
 		},
+		ConnContext: sm.connContext,
 		ConnState: func(conn net.Conn, state http.ConnState) {
 			switch state {
 			case http.StateNew:
@@ -81,88 +246,571 @@ func serveMain(ctx context.Context, args []string) error {
 		<-ctx.Done()
 	}()
 
+	if pace > 0 {
+		slog.Info("pacing downloads", slog.String("rate", humanize.SI(pace, "bit/s")))
+	}
+	if maxTransfersFlag > 0 {
+		slog.Info("limiting concurrent transfers",
+			slog.Int("max", maxTransfersFlag),
+			slog.Duration("queueTimeout", queueTimeoutFlag),
+		)
+	}
+
+	if otel != nil {
+		slog.Info("exporting telemetry", slog.String("endpoint", otelEndpointFlag))
+		go otel.Run(ctx, telemetryInterval)
+	}
+
+	certificate := health.FileCertificate(certFlag)
+	if acmeConfig != nil {
+		m := runtimex.LogFatalOnError1(acme.NewManager(acmeConfig))
+		srv.TLSConfig = acme.TLSConfig(m, "h2", "http/1.1")
+		certificate = health.TLSCertificate(srv.TLSConfig, acmeConfig.Domains[0])
+		certFlag, keyFlag = "", "" // use srv.TLSConfig.GetCertificate
+		if httpPortFlag != "" {
+			go func() {
+				err := acme.ServeHTTP01(ctx, m, net.JoinHostPort(addressFlag, httpPortFlag))
+				if err != nil {
+					slog.Warn("cannot serve ACME HTTP-01 challenges", slog.Any("err", err))
+				}
+			}()
+		}
+		slog.Info("using ACME", slog.Any("domains", acmeConfig.Domains))
+	}
+
+	// We register the health endpoints last, once we know the certificate.
+	checker := health.New(adm, certificate)
+	checker.Register(mux)
+	ln, err := checker.Listen("tcp", endpoint)
+	failure.OnError(failure.Generic, err)
+	ln = sm.wire.Listener(ln)
+
+	if uiPortFlag != "" {
+		uiEndpoint := net.JoinHostPort(addressFlag, uiPortFlag)
+		err := listenUI(ctx, uiEndpoint, uiCertFlag, uiKeyFlag, apiRedirect(portFlag, uiMux))
+		failure.OnError(failure.Generic, err)
+	}
+	if debugAddrFlag != "" {
+		sm.publishDebugVars()
+		failure.OnError(failure.Generic, debug.Listen(ctx, debugAddrFlag))
+	}
+
 	slog.Info("serving at", slog.String("addr", endpoint))
-	err := srv.ListenAndServeTLS(certFlag, keyFlag)
+	err = srv.ServeTLS(ln, certFlag, keyFlag)
 	slog.Info("interrupted", slog.Any("err", err))
+	sm.endSessions()
+	flushTelemetry(otel)
 
 	if errors.Is(err, http.ErrServerClosed) {
 		err = nil
 	}
-	runtimex.LogFatalOnError0(err)
+	failure.OnError(failure.Generic, err)
 	return nil
 }
 
-// sessionManager tracks active measurement sessions.
+// newAPIMux returns the [*http.ServeMux] serving the API endpoints of sm.
+//
+// The endpoints honor corsPolicy, if not nil, which also answers the
+// preflight requests, so the unknown OPTIONS requests get a 404, and
+// reject the protocol versions we do not speak.
+func newAPIMux(sm *sessionManager, corsPolicy *cors.Policy) *http.ServeMux {
+	mux := http.NewServeMux()
+	api := func(pattern string, handler http.HandlerFunc) {
+		mux.Handle(pattern, cors.Handler(corsPolicy, noStore(checkVersion(handler))))
+	}
+	api("POST /ndt/v8/session", sm.handleCreateSession)
+	api("GET /ndt/v8/session/{sid}/chunk/{size}", sm.handleGetChunk)
+	api("PUT /ndt/v8/session/{sid}/chunk/{size}", sm.handlePutChunk)
+	api("GET /ndt/v8/session/{sid}/object", sm.handleGetObject)
+	api("GET /ndt/v8/session/{sid}/stream", sm.handleGetStream)
+	api("PUT /ndt/v8/session/{sid}/stream", sm.handlePutStream)
+	api("GET /ndt/v8/session/{sid}/canary", sm.handleGetCanary)
+	api("PUT /ndt/v8/session/{sid}/canary", sm.handlePutCanary)
+	api("GET /ndt/v8/session/{sid}/probe/{pid}", sm.handleProbe)
+	api("GET /ndt/v8/session/{sid}/events", sm.handleEvents)
+	api("GET /ndt/v8/session/{sid}/summary", sm.handleSummary)
+	api("DELETE /ndt/v8/session/{sid}", sm.handleDeleteSession)
+	api("POST /ndt/v8/session/{sid}/abort", sm.handleAbortSession)
+	if corsPolicy != nil {
+		api("OPTIONS /ndt/v8/", http.NotFound)
+	}
+	return mux
+}
+
+// maxPendingEvents is the maximum number of server samples buffered
+// for a session while nobody is reading the events endpoint.
+const maxPendingEvents = 4096
+
+// session is an active measurement session.
 //
-// TODO(bassosimone): sessions should expire.
+// Many handlers may use a session concurrently, since the client may run
+// transfers and probes in parallel and delete the session while they are
+// in flight. The channels and the timeline synchronize themselves, while mu
+// protects stats. When both are needed, lock [*sessionManager] first.
+type session struct {
+	// created is the session creation time.
+	created time.Time
+
+	// lastUsed is when the client last used the session in Unix
+	// nanoseconds, which tells whether the session expired.
+	lastUsed atomic.Int64
+
+	// aborted is closed when the client aborts the session.
+	aborted chan struct{}
+
+	// abortOnce ensures we close aborted at most once.
+	abortOnce sync.Once
+
+	// done is closed when the session is deleted.
+	done chan struct{}
+
+	// events contains server samples for the events endpoint.
+	events chan results.Sample
+
+	// timeline contains all the server samples for the summary endpoint.
+	timeline *results.Timeline
+
+	// span traces the session lifecycle, if we export telemetry.
+	span *otlp.Span
+
+	// metadata is the metadata the client declared, if any.
+	metadata *results.Metadata
+
+	// clientAddr is the address of the client that created the session.
+	clientAddr string
+
+	// mu protects stats.
+	mu sync.Mutex
+
+	// stats accumulates the transfers and probes of the session.
+	stats sessionStats
+}
+
+// sessionStats contains the statistics of a session.
+type sessionStats struct {
+	downloads     int64 // completed or failed download transfers
+	uploads       int64 // completed or failed upload transfers
+	probes        int64 // probes served
+	bytesSent     int64 // bytes we sent in the downloads
+	bytesReceived int64 // bytes we received in the uploads
+}
+
+// touch records that the client used the session now.
+func (s *session) touch() {
+	s.lastUsed.Store(time.Now().UnixNano())
+}
+
+// idleSince returns how long the client did not use the session as of now.
+func (s *session) idleSince(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, s.lastUsed.Load()))
+}
+
+// recordTransfer adds to the statistics a transfer in the given direction
+// that moved count bytes.
+func (s *session) recordTransfer(direction string, count int64) {
+	s.touch()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch direction {
+	case "download":
+		s.stats.downloads++
+		s.stats.bytesSent += count
+	case "upload":
+		s.stats.uploads++
+		s.stats.bytesReceived += count
+	}
+}
+
+// recordProbe adds a probe to the statistics.
+func (s *session) recordProbe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.probes++
+}
+
+// snapshot returns a copy of the statistics.
+func (s *session) snapshot() sessionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// emit records a server sample and publishes it without blocking, dropping
+// it from the events when nobody is draining the events endpoint.
+func (s *session) emit(sample results.Sample) {
+	s.timeline.Emit(sample)
+	select {
+	case s.events <- sample:
+	default:
+	}
+}
+
+// abort marks the session as aborted, which stops in-flight transfers.
+func (s *session) abort() {
+	s.abortOnce.Do(func() { close(s.aborted) })
+}
+
+// isAborted returns whether the client aborted the session.
+func (s *session) isAborted() bool {
+	select {
+	case <-s.aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// errSessionAborted indicates that the client aborted the session.
+var errSessionAborted = errors.New("session aborted")
+
+// abortableReader is an [io.Reader] failing once the session is aborted.
+type abortableReader struct {
+	r    io.Reader
+	sess *session
+}
+
+var _ io.Reader = abortableReader{}
+
+// Read implements [io.Reader].
+func (r abortableReader) Read(data []byte) (int, error) {
+	if r.sess.isAborted() {
+		return 0, errSessionAborted
+	}
+	return r.r.Read(data)
+}
+
+// tombstoneLifetime is how long we remember deleted sessions, which is
+// enough for clients to retry the DELETE and for in-flight requests to
+// complete, and short enough to keep the tombstones few.
+const tombstoneLifetime = 5 * time.Minute
+
+// sessionIdleLifetime is how long a session may go unused before we expire
+// it, since the client abandoned it without deleting it. It is well beyond
+// the longest transfer (see [maxStreamDuration]), since the transfers only
+// use the session when they start and when they end.
+const sessionIdleLifetime = 10 * time.Minute
+
+// sessionReapInterval is the interval between checks for idle sessions.
+const sessionReapInterval = time.Minute
+
+// sessionManager tracks active measurement sessions.
 type sessionManager struct {
-	mu       sync.Mutex
-	sessions map[string]time.Time // sessionID → creation time
+	adm           *admission.Controller // bounds the concurrent transfers
+	anon          *privacy.Policy       // anonymizes the client addresses
+	bytesReceived atomic.Int64          // bytes we received in the uploads
+	bytesSent     atomic.Int64          // bytes we sent in the downloads
+	idleLifetime  time.Duration         // see sessionIdleLifetime
+	mu            sync.Mutex
+	otel          *otlp.Exporter       // exports telemetry or nil
+	pace          float64              // download rate limit in bit/s or zero
+	records       *sessionRecorder     // records the ended sessions or nil
+	sessions      map[string]*session  // sessionID → session
+	tombstones    map[string]time.Time // sessionID → deletion time
+	wire          conncount.Counter    // bytes on the wire, including framing
+}
+
+func newSessionManager(pace float64, adm *admission.Controller, otel *otlp.Exporter,
+	anon *privacy.Policy, records *sessionRecorder) *sessionManager {
+	return &sessionManager{
+		adm:          adm,
+		anon:         anon,
+		idleLifetime: sessionIdleLifetime,
+		otel:         otel,
+		pace:         pace,
+		records:      records,
+		sessions:     make(map[string]*session),
+		tombstones:   make(map[string]time.Time),
+	}
 }
 
-func newSessionManager() *sessionManager {
-	return &sessionManager{sessions: make(map[string]time.Time)}
+// connKey is the context key for the connection serving a request.
+type connKey struct{}
+
+// connContext implements [http.Server.ConnContext], saving conn in the
+// context and, when pacing, asking the kernel to pace it.
+func (sm *sessionManager) connContext(ctx context.Context, conn net.Conn) context.Context {
+	ctx = context.WithValue(ctx, connKey{}, conn)
+	if sm.pace > 0 {
+		ctx = withKernelPacing(ctx, conn, sm.pace)
+	}
+	return ctx
 }
 
-func (sm *sessionManager) createSession() string {
+func (sm *sessionManager) createSession(span *otlp.Span, clientAddr string, metadata *results.Metadata) (string, *session) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 	sid := runtimex.PanicOnError1(uuid.NewV7())
 	id := sid.String()
-	sm.sessions[id] = time.Now()
-	return id
+	sess := &session{
+		created:    time.Now(),
+		aborted:    make(chan struct{}),
+		done:       make(chan struct{}),
+		events:     make(chan results.Sample, maxPendingEvents),
+		timeline:   &results.Timeline{},
+		span:       span,
+		metadata:   metadata,
+		clientAddr: clientAddr,
+	}
+	sess.touch()
+	span.SetAttrs(otlp.String("ndt8.session.id", id))
+	sm.sessions[id] = sess
+	return id, sess
 }
 
-func (sm *sessionManager) sessionExists(sid string) bool {
+func (sm *sessionManager) getSession(sid string) (*session, bool) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
-	_, ok := sm.sessions[sid]
-	return ok
+	sess, ok := sm.sessions[sid]
+	if ok {
+		sess.touch()
+	}
+	return sess, ok
 }
 
-func (sm *sessionManager) deleteSession(sid string) bool {
+// deleteSession removes the session with the given ID, returning it and
+// whether it existed. Transfers in flight keep the session alive until they
+// complete, so the statistics may still grow after we return.
+func (sm *sessionManager) deleteSession(sid string) (*session, bool) {
 	sm.mu.Lock()
-	defer sm.mu.Unlock()
-	_, ok := sm.sessions[sid]
+	sess, ok := sm.sessions[sid]
+	if ok {
+		sm.endLocked(sid, sess)
+		sm.buryLocked(sid)
+	}
+	sm.mu.Unlock()
+	// Recording outside the lock keeps the file writes off the requests.
 	if ok {
-		delete(sm.sessions, sid)
+		sm.records.record(sid, sess, sessionDeleted)
 	}
-	return ok
+	return sess, ok
+}
+
+// endLocked removes the given session, which wakes up the readers of its
+// events, and ends its span. The caller must hold sm.mu.
+func (sm *sessionManager) endLocked(sid string, sess *session) {
+	close(sess.done)
+	delete(sm.sessions, sid)
+	stats := sess.snapshot()
+	sess.span.SetAttrs(
+		otlp.Int64("ndt8.session.downloads", stats.downloads),
+		otlp.Int64("ndt8.session.uploads", stats.uploads),
+		otlp.Int64("ndt8.session.probes", stats.probes),
+	)
+	sess.span.End(nil)
 }
 
+// expireIdleSessions removes the sessions the clients did not use for
+// longer than sm.idleLifetime as of now, without leaving tombstones, since
+// the clients did not delete them, along with the expired tombstones.
+func (sm *sessionManager) expireIdleSessions(now time.Time) {
+	sm.mu.Lock()
+	expired := map[string]*session{}
+	for sid, sess := range sm.sessions {
+		idle := sess.idleSince(now)
+		if idle <= sm.idleLifetime {
+			continue
+		}
+		sess.span.SetAttrs(otlp.Bool("ndt8.session.expired", true))
+		sm.endLocked(sid, sess)
+		expired[sid] = sess
+		slog.Info("session expired",
+			slog.String("sid", sid),
+			slog.Duration("idle", idle),
+		)
+	}
+	sm.sweepLocked(now)
+	sm.mu.Unlock()
+	for sid, sess := range expired {
+		sm.records.record(sid, sess, sessionExpired)
+	}
+}
+
+// endSessions ends the sessions still active when the server stops, so
+// that we record them and export their spans.
+func (sm *sessionManager) endSessions() {
+	sm.mu.Lock()
+	active := maps.Clone(sm.sessions)
+	for sid, sess := range active {
+		sm.endLocked(sid, sess)
+	}
+	sm.mu.Unlock()
+	for sid, sess := range active {
+		sm.records.record(sid, sess, sessionShutdown)
+	}
+}
+
+// reapSessions calls [*sessionManager.expireIdleSessions] every interval
+// until ctx is done.
+func (sm *sessionManager) reapSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sm.expireIdleSessions(now)
+		}
+	}
+}
+
+// buryLocked records the tombstone of the session with the given ID and
+// removes the expired tombstones. The caller must hold sm.mu.
+func (sm *sessionManager) buryLocked(sid string) {
+	now := time.Now()
+	sm.sweepLocked(now)
+	sm.tombstones[sid] = now
+}
+
+// sweepLocked removes the tombstones expired as of now. The caller must
+// hold sm.mu.
+func (sm *sessionManager) sweepLocked(now time.Time) {
+	for sid, deleted := range sm.tombstones {
+		if now.Sub(deleted) > tombstoneLifetime {
+			delete(sm.tombstones, sid)
+		}
+	}
+}
+
+// deletedAt returns when the session with the given ID was deleted, if
+// we still remember it.
+func (sm *sessionManager) deletedAt(sid string) (time.Time, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	deleted, ok := sm.tombstones[sid]
+	if ok && time.Since(deleted) > tombstoneLifetime {
+		return time.Time{}, false
+	}
+	return deleted, ok
+}
+
+// writeNotFound replies to a request concerning a session that does not
+// exist, with 410 when the client recently deleted it and 404 otherwise.
+func (sm *sessionManager) writeNotFound(rw http.ResponseWriter, req *http.Request, sid string) {
+	if deleted, ok := sm.deletedAt(sid); ok {
+		problem.Write(rw, problem.New(req, http.StatusGone, problem.TypeSessionDeleted,
+			"the client deleted the session at "+deleted.UTC().Format(time.RFC3339Nano)).
+			WithSession(sid).WithDeleted(deleted.UTC()))
+		return
+	}
+	writeProblem(rw, req, http.StatusNotFound, problem.TypeSessionNotFound, sid, "no such session")
+}
+
+// handleDeleteSession deletes a session. Since a retried DELETE must not
+// fail when the first one succeeded, deleting a session we deleted
+// recently succeeds again.
 func (sm *sessionManager) handleDeleteSession(rw http.ResponseWriter, req *http.Request) {
 	sid := req.PathValue("sid")
-	if !sm.deleteSession(sid) {
-		rw.WriteHeader(http.StatusNotFound)
+	sess, ok := sm.deleteSession(sid)
+	if !ok {
+		if _, gone := sm.deletedAt(sid); gone {
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeProblem(rw, req, http.StatusNotFound, problem.TypeSessionNotFound, sid, "no such session")
 		return
 	}
+	stats := sess.snapshot()
 	slog.Info("session deleted",
+		slog.String("sid", sid),
+		slog.Int64("downloads", stats.downloads),
+		slog.Int64("uploads", stats.uploads),
+		slog.Int64("probes", stats.probes),
+		slog.Int64("bytesSent", stats.bytesSent),
+		slog.Int64("bytesReceived", stats.bytesReceived),
+		slog.Any("metadata", sess.metadata),
+		slog.String("remote", req.RemoteAddr),
+	)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (sm *sessionManager) handleAbortSession(rw http.ResponseWriter, req *http.Request) {
+	sid := req.PathValue("sid")
+	sess, ok := sm.getSession(sid)
+	if !ok {
+		sm.writeNotFound(rw, req, sid)
+		return
+	}
+	sess.abort()
+	sess.span.SetAttrs(otlp.Bool("ndt8.session.aborted", true))
+	slog.Info("session aborted",
 		slog.String("sid", sid),
 		slog.String("remote", req.RemoteAddr),
 	)
 	rw.WriteHeader(http.StatusNoContent)
 }
 
+// readMetadata reads the optional JSON request body of the session creation,
+// which may declare the client metadata using the metadata field.
+func readMetadata(rw http.ResponseWriter, req *http.Request) (*results.Metadata, error) {
+	var body struct {
+		Metadata *results.Metadata `json:"metadata"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(rw, req.Body, results.MaxMetadataSize))
+	if err := dec.Decode(&body); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil // no body, like the clients predating the metadata
+		}
+		return nil, err
+	}
+	if body.Metadata == nil {
+		return nil, nil
+	}
+	if err := body.Metadata.Validate(); err != nil {
+		return nil, err
+	}
+	return body.Metadata, nil
+}
+
+// handleCreateSession creates a session, storing the client metadata, if any.
 func (sm *sessionManager) handleCreateSession(rw http.ResponseWriter, req *http.Request) {
-	sid := sm.createSession()
+	metadata, err := readMetadata(rw, req)
+	if err != nil {
+		problem.Write(rw, problem.New(req, http.StatusBadRequest, problem.TypeInvalidRequest,
+			fmt.Sprintf("invalid session request: %s", err)))
+		return
+	}
+	// The client may send a traceparent, making the session its child.
+	_, span := sm.otel.Start(otlp.Extract(req.Context(), req.Header), "ndt8.session", otlp.KindServer,
+		otlp.String("client.address", sm.anon.Addr(req.RemoteAddr)))
+	sm.otel.Add("ndt8.sessions", "{session}", 1)
+	sid, sess := sm.createSession(span, req.RemoteAddr, metadata)
+	if metadata != nil {
+		span.SetAttrs(
+			otlp.String("ndt8.client.name", metadata.ClientName),
+			otlp.String("ndt8.client.version", metadata.ClientVersion),
+			otlp.String("ndt8.client.platform", metadata.Platform),
+		)
+	}
 	slog.Info("session created",
 		slog.String("sid", sid),
+		slog.Any("metadata", metadata),
 		slog.String("remote", req.RemoteAddr),
 	)
 	rw.Header().Set("Content-Type", "application/json")
 	rw.WriteHeader(http.StatusCreated)
-	json.NewEncoder(rw).Encode(map[string]string{"sessionID": sid})
+	json.NewEncoder(rw).Encode(map[string]any{
+		"clientAddr": req.RemoteAddr,
+		"sessionID":  sid,
+		"serverTime": sess.created.Format(time.RFC3339Nano),
+		"version":    protocolVersion,
+	})
 }
 
 func (sm *sessionManager) handleGetChunk(rw http.ResponseWriter, req *http.Request) {
 	sid := req.PathValue("sid")
-	if !sm.sessionExists(sid) {
-		rw.WriteHeader(http.StatusNotFound)
+	sess, ok := sm.getSession(sid)
+	if !ok {
+		sm.writeNotFound(rw, req, sid)
+		return
+	}
+	if sess.isAborted() {
+		writeProblem(rw, req, http.StatusConflict, problem.TypeSessionAborted, sid, "the client aborted the session")
 		return
 	}
 	count, err := strconv.ParseInt(req.PathValue("size"), 10, 64)
 	if err != nil || count <= 0 {
-		rw.WriteHeader(http.StatusBadRequest)
+		writeProblem(rw, req, http.StatusBadRequest, problem.TypeInvalidRequest, sid, "the chunk size must be a positive integer")
 		return
 	}
 
@@ -178,31 +826,73 @@ func (sm *sessionManager) handleGetChunk(rw http.ResponseWriter, req *http.Reque
 		slog.String("remote", req.RemoteAddr),
 	)
 
+	release, ok := sm.adm.Admit(rw, req)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx, span := sess.startTransfer(req, "download", count)
 	t0 := time.Now()
-	bodyReader := io.LimitReader(infinite.Reader{}, count)
 	rw.Header().Set("Content-Length", strconv.FormatInt(count, 10))
 	rw.WriteHeader(http.StatusOK)
-	buf := make([]byte, 1<<20) // 1 MiB
-	written, _ := io.CopyBuffer(rw, bodyReader, buf)
+	written, err := sm.writeBody(rw, req, sess, io.LimitReader(infinite.Reader{}, count), count)
 	elapsed := time.Since(t0)
+	endTransfer(ctx, span, "download", written, elapsed, err)
+	sm.recordTransfer(sess, "download", written)
 
 	slog.Info("GET chunk done",
 		slog.String("sid", sid),
 		slog.Int64("bytes", written),
 		slog.Duration("elapsed", elapsed),
+		slog.Any("err", err),
 		slog.String("remote", req.RemoteAddr),
 	)
+	if errors.Is(err, errSessionAborted) {
+		// Abort the response so the client sees a truncated body.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeProblem replies to req with the problem details of a request
+// concerning the session with the given ID.
+func writeProblem(rw http.ResponseWriter, req *http.Request, status int, ptype, sid, detail string) {
+	problem.Write(rw, problem.New(req, status, ptype, detail).WithSession(sid))
+}
+
+// writeBody writes the download body read from r to rw, sampling and pacing
+// the transfer, and stopping early when the client aborts the session. The
+// size is the chunk size we record in the samples, if any.
+func (sm *sessionManager) writeBody(rw http.ResponseWriter, req *http.Request, sess *session, r io.Reader, size int64) (int64, error) {
+	bodyReader := abortableReader{r, sess}
+	smp := newSampler(results.OriginServer, "download", size, sess.emit)
+	if conn, ok := req.Context().Value(connKey{}).(net.Conn); ok {
+		smp.track(conn)
+	}
+	var w io.Writer = rw
+	if sm.pace > 0 && !isKernelPaced(req.Context()) {
+		w = newPacingWriter(req.Context(), rw, sm.pace)
+	}
+	buf := make([]byte, 1<<20) // 1 MiB
+	written, err := io.CopyBuffer(samplingWriter{w, smp}, bodyReader, buf)
+	smp.done()
+	return written, err
 }
 
 func (sm *sessionManager) handlePutChunk(rw http.ResponseWriter, req *http.Request) {
 	sid := req.PathValue("sid")
-	if !sm.sessionExists(sid) {
-		rw.WriteHeader(http.StatusNotFound)
+	sess, ok := sm.getSession(sid)
+	if !ok {
+		sm.writeNotFound(rw, req, sid)
+		return
+	}
+	if sess.isAborted() {
+		writeProblem(rw, req, http.StatusConflict, problem.TypeSessionAborted, sid, "the client aborted the session")
 		return
 	}
 	expectCount, err := strconv.ParseInt(req.PathValue("size"), 10, 64)
 	if err != nil || expectCount <= 0 {
-		rw.WriteHeader(http.StatusBadRequest)
+		writeProblem(rw, req, http.StatusBadRequest, problem.TypeInvalidRequest, sid, "the chunk size must be a positive integer")
 		return
 	}
 
@@ -218,11 +908,22 @@ func (sm *sessionManager) handlePutChunk(rw http.ResponseWriter, req *http.Reque
 		slog.String("remote", req.RemoteAddr),
 	)
 
+	release, ok := sm.adm.Admit(rw, req)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx, span := sess.startTransfer(req, "upload", expectCount)
 	t0 := time.Now()
-	bodyReader := io.LimitReader(req.Body, expectCount)
+	smp := newSampler(results.OriginServer, "upload", expectCount, sess.emit)
+	bodyReader := samplingReader{abortableReader{io.LimitReader(req.Body, expectCount), sess}, smp}
 	buf := make([]byte, 1<<20) // 1 MiB
-	read, _ := io.CopyBuffer(io.Discard, bodyReader, buf)
+	read, err := io.CopyBuffer(io.Discard, bodyReader, buf)
+	smp.done()
 	elapsed := time.Since(t0)
+	endTransfer(ctx, span, "upload", read, elapsed, err)
+	sm.recordTransfer(sess, "upload", read)
 
 	speed := float64(read*8) / elapsed.Seconds()
 	slog.Info("PUT chunk done",
@@ -230,18 +931,34 @@ func (sm *sessionManager) handlePutChunk(rw http.ResponseWriter, req *http.Reque
 		slog.Int64("bytes", read),
 		slog.Duration("elapsed", elapsed),
 		slog.String("speed", humanize.SI(speed, "bit/s")),
+		slog.Any("err", err),
 		slog.String("remote", req.RemoteAddr),
 	)
+	if errors.Is(err, errSessionAborted) {
+		writeProblem(rw, req, http.StatusConflict, problem.TypeSessionAborted, sid, "the client aborted the session during the upload")
+		return
+	}
 	rw.WriteHeader(http.StatusNoContent)
 }
 
 func (sm *sessionManager) handleProbe(rw http.ResponseWriter, req *http.Request) {
 	sid := req.PathValue("sid")
-	if !sm.sessionExists(sid) {
-		rw.WriteHeader(http.StatusNotFound)
+	sess, ok := sm.getSession(sid)
+	if !ok {
+		sm.writeNotFound(rw, req, sid)
 		return
 	}
 	pid := req.PathValue("pid")
+	// The client probe span is the parent, when the client sends its
+	// traceparent, and the session span otherwise.
+	parent := otlp.Extract(req.Context(), req.Header)
+	if !otlp.Extracted(parent) {
+		parent = otlp.ContextWithSpan(parent, sess.span)
+	}
+	ctx, span := sm.otel.Start(parent, "ndt8.probe", otlp.KindServer,
+		otlp.String("ndt8.probe.id", pid))
+	defer endProbe(ctx, span, 0, nil)
+	sess.recordProbe()
 	slog.Info("probe",
 		slog.String("sid", sid),
 		slog.String("pid", pid),
@@ -249,3 +966,95 @@ func (sm *sessionManager) handleProbe(rw http.ResponseWriter, req *http.Request)
 	)
 	rw.WriteHeader(http.StatusNoContent)
 }
+
+// handleEvents streams the server samples of a session as NDJSON
+// until the session is deleted or the client goes away.
+func (sm *sessionManager) handleEvents(rw http.ResponseWriter, req *http.Request) {
+	sid := req.PathValue("sid")
+	sess, ok := sm.getSession(sid)
+	if !ok {
+		sm.writeNotFound(rw, req, sid)
+		return
+	}
+	slog.Info("events",
+		slog.String("sid", sid),
+		slog.String("remote", req.RemoteAddr),
+	)
+
+	rc := http.NewResponseController(rw)
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	enc := json.NewEncoder(rw)
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-sess.done:
+			// Flush what is still pending so the client sees all the samples.
+			for {
+				select {
+				case sample := <-sess.events:
+					if err := enc.Encode(sample); err != nil {
+						return
+					}
+				default:
+					rc.Flush()
+					return
+				}
+			}
+		case sample := <-sess.events:
+			if err := enc.Encode(sample); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+	}
+}
+
+// defaultSummaryWarmUp is the default download warm-up of the summary
+// endpoint, which matches the default of ndt8 measure.
+const defaultSummaryWarmUp = 2 * time.Second
+
+// handleSummary summarizes the server samples of a session in the format
+// negotiated using the Accept header (see [results.NegotiateFormat]). The
+// warmUp query parameter overrides the download warm-up.
+func (sm *sessionManager) handleSummary(rw http.ResponseWriter, req *http.Request) {
+	sid := req.PathValue("sid")
+	sess, ok := sm.getSession(sid)
+	if !ok {
+		sm.writeNotFound(rw, req, sid)
+		return
+	}
+	format, ok := results.NegotiateFormat(req.Header.Get("Accept"))
+	if !ok {
+		writeProblem(rw, req, http.StatusNotAcceptable, problem.TypeNotAcceptable, sid,
+			fmt.Sprintf("the summary is available as %s, %s, or %s", results.FormatJSON, results.FormatCSV, results.FormatText))
+		return
+	}
+	warmUp := defaultSummaryWarmUp
+	if value := req.URL.Query().Get("warmUp"); value != "" {
+		var err error
+		if warmUp, err = time.ParseDuration(value); err != nil || warmUp < 0 {
+			writeProblem(rw, req, http.StatusBadRequest, problem.TypeInvalidRequest, sid, "warmUp must be a non-negative duration (e.g., 2s)")
+			return
+		}
+	}
+
+	samples := sess.timeline.Samples()
+	summary := &results.Summary{
+		Download: results.Summarize(samples, results.OriginServer, "download", warmUp),
+		Upload:   results.Summarize(samples, results.OriginServer, "upload", 0),
+		Metadata: sess.metadata,
+	}
+	slog.Info("summary",
+		slog.String("sid", sid),
+		slog.String("format", format),
+		slog.String("remote", req.RemoteAddr),
+	)
+	rw.Header().Set("Content-Type", format+"; charset=utf-8")
+	rw.Header().Add("Vary", "Accept")
+	rw.WriteHeader(http.StatusOK)
+	results.WriteSummary(rw, format, summary)
+}
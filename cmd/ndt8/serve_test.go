@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/admission"
+	"github.com/bassosimone/2026-02-provlima/internal/privacy"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+// testChunkSize is the size of the chunks the tests transfer.
+const testChunkSize = 64 << 10
+
+// newTestServer returns a [*sessionManager] without transfer limits along
+// with an [*httptest.Server] serving its API endpoints.
+func newTestServer(t *testing.T) (*sessionManager, *httptest.Server) {
+	t.Helper()
+	anon, err := privacy.New("none", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := newSessionManager(0, admission.New(0, time.Second), nil, anon, nil)
+	srv := httptest.NewServer(newAPIMux(sm, nil))
+	t.Cleanup(srv.Close)
+	return sm, srv
+}
+
+// do sends a request with the given method to the given path of srv,
+// discards the response body, and returns the status code.
+func do(t *testing.T, srv *httptest.Server, method, path string, body []byte) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Error(err)
+		return 0
+	}
+	return resp.StatusCode
+}
+
+// createTestSession creates a session on srv and returns its ID.
+func createTestSession(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	resp, err := srv.Client().Post(srv.URL+"/ndt/v8/session", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	var created struct {
+		SessionID string `json:"sessionID"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	return created.SessionID
+}
+
+// testCounts counts the requests the server completed.
+type testCounts struct {
+	downloads atomic.Int64
+	uploads   atomic.Int64
+	probes    atomic.Int64
+}
+
+// hammer sends concurrent chunk and probe requests for the session with
+// the given ID, counting the successful ones, and expecting the others, if
+// any, to fail with 410 because the session was deleted meanwhile.
+func hammer(t *testing.T, srv *httptest.Server, sid string, workers int, counts *testCounts) {
+	t.Helper()
+	check := func(status, success int, counter *atomic.Int64) {
+		switch status {
+		case success:
+			counter.Add(1)
+		case http.StatusGone:
+		default:
+			t.Errorf("expected %d or %d, got %d", success, http.StatusGone, status)
+		}
+	}
+	chunkPath := fmt.Sprintf("/ndt/v8/session/%s/chunk/%d", sid, testChunkSize)
+	var wg sync.WaitGroup
+	for idx := range workers {
+		wg.Go(func() {
+			check(do(t, srv, "GET", chunkPath, nil), http.StatusOK, &counts.downloads)
+		})
+		wg.Go(func() {
+			check(do(t, srv, "PUT", chunkPath, make([]byte, testChunkSize)), http.StatusNoContent, &counts.uploads)
+		})
+		wg.Go(func() {
+			probePath := fmt.Sprintf("/ndt/v8/session/%s/probe/%d", sid, idx)
+			check(do(t, srv, "GET", probePath, nil), http.StatusNoContent, &counts.probes)
+		})
+	}
+	wg.Wait()
+}
+
+func TestSessionConcurrentRequests(t *testing.T) {
+	sm, srv := newTestServer(t)
+	sid := createTestSession(t, srv)
+	sess, ok := sm.getSession(sid)
+	if !ok {
+		t.Fatal("session not found")
+	}
+
+	// All the requests succeed while the session exists.
+	const workers = 16
+	var counts testCounts
+	hammer(t, srv, sid, workers, &counts)
+	if counts.downloads.Load() != workers || counts.uploads.Load() != workers || counts.probes.Load() != workers {
+		t.Fatalf("expected %d successful requests of each kind, got %d downloads, %d uploads, and %d probes",
+			workers, counts.downloads.Load(), counts.uploads.Load(), counts.probes.Load())
+	}
+
+	// Retried DELETEs racing with transfers and probes all succeed, while
+	// the requests arriving after the first DELETE fail with 410.
+	var wg sync.WaitGroup
+	wg.Go(func() { hammer(t, srv, sid, workers, &counts) })
+	for range workers {
+		wg.Go(func() {
+			if status := do(t, srv, "DELETE", "/ndt/v8/session/"+sid, nil); status != http.StatusNoContent {
+				t.Errorf("expected %d, got %d", http.StatusNoContent, status)
+			}
+		})
+	}
+	wg.Wait()
+	if status := do(t, srv, "GET", fmt.Sprintf("/ndt/v8/session/%s/probe/late", sid), nil); status != http.StatusGone {
+		t.Fatalf("expected %d, got %d", http.StatusGone, status)
+	}
+
+	// Closing waits for the handlers, which may record a download after
+	// the client read the whole body.
+	srv.Close()
+	stats := sess.snapshot()
+	if stats.downloads != counts.downloads.Load() || stats.uploads != counts.uploads.Load() || stats.probes != counts.probes.Load() {
+		t.Fatalf("expected %d downloads, %d uploads, and %d probes, got %+v",
+			counts.downloads.Load(), counts.uploads.Load(), counts.probes.Load(), stats)
+	}
+	if want := stats.downloads * testChunkSize; stats.bytesSent != want || sm.bytesSent.Load() != want {
+		t.Fatalf("expected %d bytes sent, got %d (session) and %d (server)", want, stats.bytesSent, sm.bytesSent.Load())
+	}
+	if want := stats.uploads * testChunkSize; stats.bytesReceived != want || sm.bytesReceived.Load() != want {
+		t.Fatalf("expected %d bytes received, got %d (session) and %d (server)", want, stats.bytesReceived, sm.bytesReceived.Load())
+	}
+}
+
+func TestSessionRecords(t *testing.T) {
+	sm, srv := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "sessions.ndjson")
+	records, err := newSessionRecorder(path, sm.anon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.records = records
+
+	// A session the client deletes after a download, declaring metadata.
+	body, _ := json.Marshal(map[string]any{"metadata": &results.Metadata{ClientName: "test", Labels: map[string]string{"hw": "rev2"}}})
+	resp, err := srv.Client().Post(srv.URL+"/ndt/v8/session", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created struct {
+		SessionID string `json:"sessionID"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := created.SessionID
+	if status := do(t, srv, "GET", fmt.Sprintf("/ndt/v8/session/%s/chunk/%d", deleted, testChunkSize), nil); status != http.StatusOK {
+		t.Fatalf("download: expected %d, got %d", http.StatusOK, status)
+	}
+	if status := do(t, srv, "DELETE", "/ndt/v8/session/"+deleted, nil); status != http.StatusNoContent {
+		t.Fatalf("delete: expected %d, got %d", http.StatusNoContent, status)
+	}
+
+	// A session the client abandons, which expires.
+	expired := createTestSession(t, srv)
+	sm.idleLifetime = 0
+	sm.expireIdleSessions(time.Now().Add(time.Second))
+	if err := records.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []sessionRecord
+	for line := range bytes.Lines(data) {
+		var rec sessionRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rec)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(got))
+	}
+	if rec := got[0]; rec.SessionID != deleted || rec.Reason != sessionDeleted || rec.Metadata == nil ||
+		rec.Metadata.Labels["hw"] != "rev2" || rec.Downloads != 1 || rec.BytesSent != testChunkSize || rec.Client == "" {
+		t.Fatalf("unexpected record of the deleted session: %+v", rec)
+	}
+	if rec := got[1]; rec.SessionID != expired || rec.Reason != sessionExpired || rec.Metadata != nil || rec.Downloads != 0 {
+		t.Fatalf("unexpected record of the expired session: %+v", rec)
+	}
+}
@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/infinite"
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+const (
+	// maxStreamDuration is the maximum duration of a streamed transfer,
+	// which is also the maximum time budget of a direction.
+	maxStreamDuration = time.Minute
+
+	// streamMargin is how much earlier than its time budget the client asks
+	// the server to stop streaming, so it sees the end of the body in time.
+	streamMargin = 250 * time.Millisecond
+)
+
+// untilReader is an [io.Reader] returning [io.EOF] after the deadline.
+type untilReader struct {
+	r        io.Reader
+	deadline time.Time
+}
+
+var _ io.Reader = untilReader{}
+
+// Read implements [io.Reader].
+func (r untilReader) Read(data []byte) (int, error) {
+	if !time.Now().Before(r.deadline) {
+		return 0, io.EOF
+	}
+	return r.r.Read(data)
+}
+
+// parseStreamDuration returns the duration query parameter of req, replying
+// with 400 Bad Request and returning false when it is invalid.
+func parseStreamDuration(rw http.ResponseWriter, req *http.Request, sid string) (time.Duration, bool) {
+	duration, err := time.ParseDuration(req.URL.Query().Get("duration"))
+	if err != nil || duration <= 0 || duration > maxStreamDuration {
+		writeProblem(rw, req, http.StatusBadRequest, problem.TypeInvalidRequest, sid,
+			fmt.Sprintf("duration must be a positive duration up to %s (e.g., 10s)", maxStreamDuration))
+		return 0, false
+	}
+	return duration, true
+}
+
+// handleGetStream streams the download for the duration the client asks
+// for without declaring its size, so the response uses chunked encoding with
+// HTTP/1.1. Since the server stops exactly when the time is up, the client
+// does not need to guess the size of the chunks near the end of the test.
+func (sm *sessionManager) handleGetStream(rw http.ResponseWriter, req *http.Request) {
+	sid := req.PathValue("sid")
+	sess, ok := sm.getSession(sid)
+	if !ok {
+		sm.writeNotFound(rw, req, sid)
+		return
+	}
+	if sess.isAborted() {
+		writeProblem(rw, req, http.StatusConflict, problem.TypeSessionAborted, sid, "the client aborted the session")
+		return
+	}
+	duration, ok := parseStreamDuration(rw, req, sid)
+	if !ok {
+		return
+	}
+
+	slog.Info("GET stream",
+		slog.String("sid", sid),
+		slog.Duration("duration", duration),
+		slog.String("proto", req.Proto),
+		slog.String("remote", req.RemoteAddr),
+	)
+
+	release, ok := sm.adm.Admit(rw, req)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// The deadline starts after admission, since queueing is not streaming.
+	ctx, span := sess.startTransfer(req, "download", 0)
+	t0 := time.Now()
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.WriteHeader(http.StatusOK)
+	written, err := sm.writeBody(rw, req, sess, untilReader{infinite.Reader{}, t0.Add(duration)}, 0)
+	elapsed := time.Since(t0)
+	endTransfer(ctx, span, "download", written, elapsed, err)
+	sm.recordTransfer(sess, "download", written)
+
+	slog.Info("GET stream done",
+		slog.String("sid", sid),
+		slog.Int64("bytes", written),
+		slog.Duration("elapsed", elapsed),
+		slog.Any("err", err),
+		slog.String("remote", req.RemoteAddr),
+	)
+	if errors.Is(err, errSessionAborted) {
+		// Abort the response so the client sees a truncated body.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// handlePutStream reads the upload for the duration the client asks for,
+// with the client not declaring its size, and cuts it off at the deadline,
+// so a client sending for longer cannot make the test overshoot.
+func (sm *sessionManager) handlePutStream(rw http.ResponseWriter, req *http.Request) {
+	sid := req.PathValue("sid")
+	sess, ok := sm.getSession(sid)
+	if !ok {
+		sm.writeNotFound(rw, req, sid)
+		return
+	}
+	if sess.isAborted() {
+		writeProblem(rw, req, http.StatusConflict, problem.TypeSessionAborted, sid, "the client aborted the session")
+		return
+	}
+	duration, ok := parseStreamDuration(rw, req, sid)
+	if !ok {
+		return
+	}
+
+	slog.Info("PUT stream",
+		slog.String("sid", sid),
+		slog.Duration("duration", duration),
+		slog.String("proto", req.Proto),
+		slog.String("remote", req.RemoteAddr),
+	)
+
+	release, ok := sm.adm.Admit(rw, req)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx, span := sess.startTransfer(req, "upload", 0)
+	t0 := time.Now()
+	smp := newSampler(results.OriginServer, "upload", 0, sess.emit)
+	bodyReader := samplingReader{abortableReader{untilReader{req.Body, t0.Add(duration)}, sess}, smp}
+	buf := make([]byte, 1<<20) // 1 MiB
+	read, err := io.CopyBuffer(io.Discard, bodyReader, buf)
+	smp.done()
+	elapsed := time.Since(t0)
+	endTransfer(ctx, span, "upload", read, elapsed, err)
+	sm.recordTransfer(sess, "upload", read)
+
+	slog.Info("PUT stream done",
+		slog.String("sid", sid),
+		slog.Int64("bytes", read),
+		slog.Duration("elapsed", elapsed),
+		slog.Any("err", err),
+		slog.String("remote", req.RemoteAddr),
+	)
+	if errors.Is(err, errSessionAborted) {
+		writeProblem(rw, req, http.StatusConflict, problem.TypeSessionAborted, sid, "the client aborted the session during the upload")
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
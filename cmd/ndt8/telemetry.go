@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/otlp"
+)
+
+// telemetryInterval is the interval between telemetry exports.
+const telemetryInterval = 10 * time.Second
+
+// flushTelemetry exports the telemetry we have not exported yet, if any.
+func flushTelemetry(otel *otlp.Exporter) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := otel.Flush(ctx); err != nil {
+		slog.Warn("cannot export telemetry", slog.Any("err", err))
+	}
+}
+
+// startTransfer starts the span of a transfer, which is a child of the
+// session span, so traces group the transfers by session.
+func (s *session) startTransfer(req *http.Request, direction string, size int64) (context.Context, *otlp.Span) {
+	ctx := otlp.ContextWithSpan(req.Context(), s.span)
+	return otlp.Start(ctx, "ndt8.transfer", otlp.KindServer,
+		otlp.String("ndt8.direction", direction),
+		otlp.Int64("ndt8.transfer.size", size),
+	)
+}
+
+// startClientTransfer is like [*session.startTransfer] but for the client,
+// where the span is a child of the span in ctx.
+func startClientTransfer(ctx context.Context, direction string, size int64) (context.Context, *otlp.Span) {
+	return otlp.Start(ctx, "ndt8.transfer", otlp.KindClient,
+		otlp.String("ndt8.direction", direction),
+		otlp.Int64("ndt8.transfer.size", size),
+	)
+}
+
+// endTransfer ends the span of a transfer, recording the bytes we actually
+// transferred and the time it took, and counts the bytes.
+func endTransfer(ctx context.Context, span *otlp.Span, direction string, count int64, elapsed time.Duration, err error) {
+	span.SetAttrs(
+		otlp.Int64("ndt8.transfer.bytes", count),
+		otlp.Float64("ndt8.transfer.elapsed", elapsed.Seconds()),
+	)
+	span.End(err)
+	otlp.Add(ctx, "ndt8.bytes", "By", count, otlp.String("ndt8.direction", direction))
+}
+
+// endProbe ends the span of a probe, recording the RTT when the client
+// measured it, and counts the probes.
+func endProbe(ctx context.Context, span *otlp.Span, rtt time.Duration, err error) {
+	if rtt > 0 {
+		span.SetAttrs(otlp.Float64("ndt8.probe.rtt", rtt.Seconds()))
+	}
+	span.End(err)
+	otlp.Add(ctx, "ndt8.probes", "{probe}", 1)
+}
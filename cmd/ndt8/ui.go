@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiRedirect returns an [http.Handler] redirecting the requests for the
+// browser client page lacking the server query parameter to the same page
+// with the parameter pointing to the API at apiPort on the host the browser
+// used, and passing the other requests to next.
+//
+// With split listeners, the page and the API have different origins, so
+// the page cannot default to its own origin, as it does otherwise.
+func apiRedirect(apiPort string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" || req.URL.Query().Has("server") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = strings.TrimSuffix(strings.TrimPrefix(req.Host, "["), "]")
+		}
+		server := (&url.URL{Scheme: "https", Host: net.JoinHostPort(host, apiPort)}).String()
+		query := url.Values{"server": {server}}.Encode()
+		http.Redirect(rw, req, "/?"+query, http.StatusFound)
+	})
+}
+
+// listenUI listens for the browser client at endpoint and serves handler
+// in the background until ctx is done, using TLS when certFile is not
+// empty, or plain HTTP otherwise.
+func listenUI(ctx context.Context, endpoint, certFile, keyFile string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", endpoint)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: endpoint, Handler: handler}
+	go func() {
+		defer srv.Close()
+		<-ctx.Done()
+	}()
+	go func() {
+		scheme := "http"
+		if certFile != "" {
+			scheme = "https"
+		}
+		slog.Info("serving the browser client at", slog.String("addr", endpoint), slog.String("scheme", scheme))
+		if certFile != "" {
+			err = srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("cannot serve the browser client", slog.Any("err", err))
+		}
+	}()
+	return nil
+}
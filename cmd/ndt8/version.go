@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+// versionHeader is the request header containing the version of the wire
+// protocol the client speaks, which lets the protocol evolve within the
+// /ndt/v8 prefix without breaking the deployed clients.
+const versionHeader = "X-NDT8-Version"
+
+// protocolVersion is the version of the wire protocol we speak.
+const protocolVersion = 1
+
+// supportedVersions contains the versions of the wire protocol the server
+// speaks, which must include [protocolVersion].
+var supportedVersions = []int{protocolVersion}
+
+// checkVersion returns an [http.Handler] replying with 426 to the requests
+// using a version the server does not speak, and passing the others to next.
+// Requests without [versionHeader], including the ones of the clients that
+// predate it, use [protocolVersion].
+func checkVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		value := req.Header.Get(versionHeader)
+		if value == "" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		version, err := strconv.Atoi(value)
+		if err != nil || version <= 0 {
+			problem.Write(rw, problem.New(req, http.StatusBadRequest, problem.TypeInvalidRequest,
+				fmt.Sprintf("the %s header must be a positive integer", versionHeader)))
+			return
+		}
+		if !slices.Contains(supportedVersions, version) {
+			problem.Write(rw, problem.New(req, http.StatusUpgradeRequired, problem.TypeUnsupportedVersion,
+				fmt.Sprintf("the server does not speak version %d of the protocol (supported: %v)", version, supportedVersions)).
+				WithSupportedVersions(supportedVersions))
+			return
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// versionTransport is an [http.RoundTripper] adding [versionHeader] to the
+// requests, so the server rejects them when it does not speak our version.
+type versionTransport struct {
+	rt http.RoundTripper
+}
+
+var _ http.RoundTripper = versionTransport{}
+
+// RoundTrip implements [http.RoundTripper].
+func (vt versionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(versionHeader, strconv.Itoa(protocolVersion))
+	return vt.rt.RoundTrip(req)
+}
+
+// clientMetadata returns the [results.Metadata] we declare when creating a
+// session, including the given labels, if any.
+func clientMetadata(labels map[string]string) *results.Metadata {
+	md := &results.Metadata{
+		ClientName: "ndt8",
+		Platform:   runtime.GOOS + "/" + runtime.GOARCH,
+		Labels:     labels,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		md.ClientVersion = info.Main.Version
+	}
+	return md
+}
@@ -0,0 +1,181 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+const (
+	// videoStartupSegments is the number of segments the player buffers
+	// before starting or resuming playback.
+	videoStartupSegments = 1
+
+	// videoMaxBufferSegments is the number of buffered segments above
+	// which the player stops fetching until playback drains the buffer.
+	videoMaxBufferSegments = 4
+
+	// videoSafetyFactor is the fraction of the measured throughput that
+	// the player deems available for the next segment.
+	videoSafetyFactor = 0.8
+)
+
+// videoPattern emulates an adaptive-bitrate video player fetching segments
+// lasting segment, choosing their bitrate from ladder, and tracking how
+// much video it buffered, to tell when playback would stall.
+type videoPattern struct {
+	segment time.Duration
+	ladder  []float64
+}
+
+var _ pattern = videoPattern{}
+
+// defaultVideo is the default [videoPattern], whose ladder resembles the
+// ones streaming services use from mobile to 1080p video.
+var defaultVideo = videoPattern{
+	segment: 2 * time.Second,
+	ladder:  []float64{400e3, 1e6, 2.5e6, 5e6, 8e6, 16e6},
+}
+
+// parseVideo parses the SEGMENT[/RATE,RATE,...] parameter of the video
+// pattern (e.g., "4s/1mbit,3mbit,6mbit").
+func parseVideo(param string) (pattern, error) {
+	if param == "" {
+		return defaultVideo, nil
+	}
+	p := defaultVideo
+	segmentStr, ladderStr, hasLadder := strings.Cut(param, "/")
+	segment, err := time.ParseDuration(segmentStr)
+	if err != nil || segment <= 0 {
+		return nil, fmt.Errorf("--pattern video needs a positive segment duration (e.g., video:2s)")
+	}
+	p.segment = segment
+	if hasLadder {
+		p.ladder = nil
+		for entry := range strings.SplitSeq(ladderStr, ",") {
+			rate, err := humanize.ParseRate(entry)
+			if err != nil || rate <= 0 {
+				return nil, fmt.Errorf("--pattern video needs positive ladder rates (e.g., video:2s/1mbit,3mbit)")
+			}
+			p.ladder = append(p.ladder, rate)
+		}
+		slices.Sort(p.ladder)
+	}
+	return p, nil
+}
+
+// String implements [pattern].
+func (p videoPattern) String() string {
+	rates := make([]string, 0, len(p.ladder))
+	for _, rate := range p.ladder {
+		rates = append(rates, fmt.Sprintf("%.0fbit", rate))
+	}
+	return fmt.Sprintf("video:%s/%s", p.segment, strings.Join(rates, ","))
+}
+
+// run implements [pattern]. The player starts from the lowest bitrate and
+// climbs the ladder one step at a time while the throughput of the last
+// segment allows, but falls straight to the bitrate it allows otherwise.
+// Playback drains the buffer in real time while we fetch segments.
+func (p videoPattern) run(ctx context.Context, ph *phase) bool {
+	video := results.Video{Direction: ph.direction, Time: time.Now()}
+	var (
+		buffered  time.Duration
+		playing   bool
+		started   bool
+		stalledAt time.Time
+		rung      int
+		bitrates  float64
+	)
+	for ctx.Err() == nil {
+		// Wait for playback to drain the buffer when it is full.
+		if excess := buffered - videoMaxBufferSegments*p.segment; excess > 0 {
+			timer := time.NewTimer(excess)
+			select {
+			case <-ctx.Done():
+			case <-timer.C:
+			}
+			timer.Stop()
+			buffered -= excess
+			continue
+		}
+
+		bitrate := p.ladder[rung]
+		segment := results.VideoSegment{
+			Bitrate: bitrate,
+			Bytes:   int64(bitrate * p.segment.Seconds() / 8),
+			Time:    time.Now(),
+		}
+		err := ph.transfer(ctx, min(max(segment.Bytes, 1), maxChunkSize))
+		segment.Elapsed = time.Since(segment.Time)
+
+		// Account for the playback while we were fetching, even when
+		// we could not complete the segment, to catch the last stall.
+		switch {
+		case playing && segment.Elapsed > buffered:
+			stalledAt = segment.Time.Add(buffered)
+			playing, buffered = false, 0
+		case playing:
+			buffered -= segment.Elapsed
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			video.Failure = err.Error()
+			break
+		}
+		video.Segments = append(video.Segments, segment)
+		bitrates += bitrate
+		buffered += p.segment
+		if !playing && buffered >= videoStartupSegments*p.segment {
+			playing = true
+			now := time.Now()
+			if !started {
+				started, video.StartupDelay = true, now.Sub(video.Time)
+			} else {
+				video.Rebuffers = append(video.Rebuffers, results.Rebuffer{Elapsed: now.Sub(stalledAt), Time: stalledAt})
+			}
+		}
+
+		// Choose the bitrate of the next segment.
+		throughput := float64(segment.Bytes*8) / segment.Elapsed.Seconds()
+		next := 0
+		for idx, rate := range p.ladder {
+			if rate <= videoSafetyFactor*throughput {
+				next = idx
+			}
+		}
+		rung = min(next, rung+1)
+	}
+
+	// A stall lasting until the end is the worst kind of stall.
+	now := time.Now()
+	switch {
+	case !started:
+		video.StartupDelay = now.Sub(video.Time)
+	case !playing:
+		video.Rebuffers = append(video.Rebuffers, results.Rebuffer{Elapsed: now.Sub(stalledAt), Time: stalledAt})
+	}
+	if len(video.Segments) > 0 {
+		video.Bitrate = bitrates / float64(len(video.Segments))
+	}
+	slog.Info("video",
+		slog.String("direction", video.Direction),
+		slog.String("bitrate", humanize.SI(video.Bitrate, "bit/s")),
+		slog.Duration("startupDelay", video.StartupDelay),
+		slog.Int("segments", len(video.Segments)),
+		slog.Int("rebuffers", len(video.Rebuffers)),
+		slog.String("failure", video.Failure),
+	)
+	ph.tl.EmitVideo(video)
+	return true
+}
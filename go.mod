@@ -10,6 +10,8 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.3
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51
+	golang.org/x/crypto v0.48.0
+	software.sslmate.com/src/go-pkcs12 v0.7.3
 )
 
 require (
@@ -18,4 +20,6 @@ require (
 	github.com/bassosimone/must v0.0.0-20260118074942-4ad662f6c302 // indirect
 	github.com/bassosimone/textwrap v0.0.0-20260116080944-4f25bc1114c3 // indirect
 	github.com/rogpeppe/go-internal v1.14.1 // indirect
+	golang.org/x/net v0.49.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
 )
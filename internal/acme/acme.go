@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package acme obtains TLS certificates from Let's Encrypt using ACME.
+//
+// We answer TLS-ALPN-01 challenges on the TLS listener, which only works
+// when it is reachable on port 443, and HTTP-01 challenges on a separate
+// cleartext listener, which only works when it is reachable on port 80.
+// Having both means that either port suffices to obtain certificates.
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	xacme "golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Config contains the ACME configuration.
+type Config struct {
+	// Domains contains the domains for which we obtain certificates.
+	Domains []string
+
+	// CacheDir is the directory where we cache account keys and
+	// certificates, which avoids hitting the CA rate limits.
+	CacheDir string
+
+	// Email is the optional contact email for the ACME account.
+	Email string
+}
+
+// NewManager returns a [*autocert.Manager] accepting the Let's Encrypt
+// terms of service and only issuing certificates for config.Domains.
+func NewManager(config *Config) (*autocert.Manager, error) {
+	if len(config.Domains) <= 0 {
+		return nil, errors.New("acme: no domains configured")
+	}
+	if config.CacheDir == "" {
+		return nil, errors.New("acme: no cache directory configured")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(config.CacheDir),
+		HostPolicy: autocert.HostWhitelist(config.Domains...),
+		Email:      config.Email,
+	}
+	return m, nil
+}
+
+// TLSConfig returns a [*tls.Config] obtaining certificates using m and
+// negotiating the given ALPN protocols as well as the TLS-ALPN-01 one.
+func TLSConfig(m *autocert.Manager, nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     append(slices.Clone(nextProtos), xacme.ALPNProto),
+	}
+}
+
+// ServeHTTP01 answers HTTP-01 challenges on the given cleartext address,
+// redirecting any other request to HTTPS, until ctx is done.
+func ServeHTTP01(ctx context.Context, m *autocert.Manager, address string) error {
+	srv := &http.Server{
+		Addr:              address,
+		Handler:           m.HTTPHandler(nil),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		defer srv.Close()
+		<-ctx.Done()
+	}()
+
+	slog.Info("serving ACME HTTP-01 challenges at", slog.String("addr", address))
+	err := srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
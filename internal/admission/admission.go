@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package admission bounds the number of concurrent transfers a server
+// runs, queueing the excess requests for a bounded time.
+//
+// Saturating transfers compete for the same CPU and link, so running too
+// many of them at once makes all of them slow and the results meaningless.
+// Making the excess clients wait, and then telling them to retry, keeps
+// the admitted measurements accurate as the load grows.
+package admission
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+)
+
+// queuedThreshold is the wait above which [*Controller.Admit] logs that
+// a transfer has been queued.
+const queuedThreshold = time.Millisecond
+
+// ErrBusy indicates that no slot became free within the queue timeout.
+var ErrBusy = errors.New("admission: too many concurrent transfers")
+
+// Controller admits a bounded number of concurrent transfers.
+//
+// Construct using [New]. The nil controller admits everything.
+type Controller struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// New constructs a new [*Controller] admitting at most limit concurrent
+// transfers and queueing the others for at most timeout. It returns nil,
+// which admits everything, when limit is not positive.
+func New(limit int, timeout time.Duration) *Controller {
+	if limit <= 0 {
+		return nil
+	}
+	return &Controller{slots: make(chan struct{}, limit), timeout: timeout}
+}
+
+// Acquire waits for a free slot, for at most the queue timeout, and returns
+// the function to release it. We return [ErrBusy] when the timeout expires
+// and the context error when ctx is done first.
+func (c *Controller) Acquire(ctx context.Context) (func(), error) {
+	if c == nil {
+		return func() {}, nil
+	}
+	release := func() { <-c.slots }
+	select {
+	case c.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case c.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, ErrBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Admit is like [*Controller.Acquire] but, when it fails, replies to req
+// with 503 Service Unavailable problem details and a Retry-After header,
+// so the handler should just return when the boolean is false.
+func (c *Controller) Admit(rw http.ResponseWriter, req *http.Request) (func(), bool) {
+	t0 := time.Now()
+	release, err := c.Acquire(req.Context())
+	if err != nil {
+		slog.Info("transfer not admitted",
+			slog.Duration("waited", time.Since(t0)),
+			slog.Any("err", err),
+			slog.String("remote", req.RemoteAddr),
+		)
+		rw.Header().Set("Retry-After", strconv.Itoa(c.RetryAfter()))
+		problem.Write(rw, problem.New(req, http.StatusServiceUnavailable, problem.TypeBusy, err.Error()))
+		return nil, false
+	}
+	if waited := time.Since(t0); waited >= queuedThreshold {
+		slog.Info("transfer admitted after queueing",
+			slog.Duration("waited", waited),
+			slog.Int("inUse", c.InUse()),
+			slog.String("remote", req.RemoteAddr),
+		)
+	}
+	return release, true
+}
+
+// RetryAfter returns the number of seconds a rejected client should wait
+// before retrying, suitable for the Retry-After header.
+func (c *Controller) RetryAfter() int {
+	if c == nil {
+		return 0
+	}
+	return max(int(c.timeout.Seconds()), 1)
+}
+
+// InUse returns the number of transfers currently admitted.
+func (c *Controller) InUse() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.slots)
+}
+
+// Capacity returns the maximum number of concurrent transfers or zero
+// when there is no limit.
+func (c *Controller) Capacity() int {
+	if c == nil {
+		return 0
+	}
+	return cap(c.slots)
+}
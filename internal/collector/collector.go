@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package collector submits result documents to a remote collector.
+//
+// Each submission is an HTTPS POST whose body is a JSON array containing
+// one or more documents, so we can batch the periodic documents queued
+// while the collector was unreachable. We retry failed submissions with
+// exponential backoff and keep the documents queued until one succeeds.
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+const (
+	// maxAttempts is the maximum number of attempts per submission.
+	maxAttempts = 4
+
+	// initialBackoff is the wait before the first retry, which we
+	// double after each subsequent failure.
+	initialBackoff = time.Second
+
+	// requestTimeout bounds the duration of each attempt.
+	requestTimeout = 30 * time.Second
+)
+
+// Client submits documents to a collector.
+//
+// Construct using [New].
+type Client struct {
+	client  *http.Client
+	mu      sync.Mutex
+	pending []*results.Document
+	url     string
+}
+
+// New constructs a new [*Client] submitting to the given HTTPS URL.
+func New(collectorURL string) (*Client, error) {
+	u, err := url.Parse(collectorURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("collector: %s: not an HTTPS URL", collectorURL)
+	}
+	return &Client{client: &http.Client{Timeout: requestTimeout}, url: u.String()}, nil
+}
+
+// Add queues doc for the next [*Client.Flush].
+func (c *Client) Add(doc *results.Document) {
+	c.mu.Lock()
+	c.pending = append(c.pending, doc)
+	c.mu.Unlock()
+}
+
+// Flush submits all the queued documents as a single batch, retrying on
+// failure until we run out of attempts or ctx is done. On failure, the
+// documents remain queued, so the next flush submits them again.
+func (c *Client) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	batch := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	if len(batch) <= 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err = c.post(ctx, body)
+		slog.Info("collector submit",
+			slog.String("url", c.url),
+			slog.Int("documents", len(batch)),
+			slog.Int("attempt", attempt),
+			slog.Any("err", err),
+		)
+		if err == nil {
+			return nil
+		}
+		if attempt >= maxAttempts || !sleep(ctx, backoff) {
+			break
+		}
+		backoff *= 2
+	}
+
+	// Requeue in front of the documents added meanwhile.
+	c.mu.Lock()
+	c.pending = append(batch, c.pending...)
+	c.mu.Unlock()
+	return err
+}
+
+// sleep waits for d and returns true, unless ctx is done before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// post performs a single submission attempt.
+func (c *Client) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector: unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// Run calls snapshot every interval, queues the returned document, and
+// flushes the queue, until ctx is done. Failures are only logged, since
+// the documents stay queued for the next flush.
+func (c *Client) Run(ctx context.Context, interval time.Duration, snapshot func() *results.Document) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ctx.Err() != nil {
+				return // both channels were ready
+			}
+			c.Add(snapshot())
+			if err := c.Flush(ctx); err != nil {
+				slog.Warn("cannot submit to the collector", slog.Any("err", err))
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package config loads command line flag defaults from a TOML file.
+//
+// The file contains top-level keys, which apply to every command, and
+// sections named after commands (e.g., [serve] and [measure]), whose keys
+// apply only to the corresponding command. Keys are long flag names:
+//
+//	address = "192.168.1.2"
+//	format = "json"
+//
+//	[serve]
+//	cert = "/etc/ndt8/cert.pem"
+//	key = "/etc/ndt8/key.pem"
+//
+// Use [Setup] before parsing the command line, such that flags provided
+// on the command line override the values read from the file.
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bassosimone/vflag"
+)
+
+// Load reads and parses the given TOML configuration file.
+func Load(path string) (File, error) {
+	filep, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer filep.Close()
+	file, err := Parse(filep)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Apply sets the long flags of fset using the top-level keys and the keys
+// inside the given section, with the latter taking precedence. Unknown keys
+// inside the section are an error, while we ignore unknown top-level keys
+// because they may be meant for another command.
+func (f File) Apply(fset *vflag.FlagSet, section string) error {
+	flags := make(map[string]vflag.Value)
+	for _, fx := range fset.LongFlags {
+		flags[fx.Name] = fx.Value
+	}
+	for _, sect := range []string{"", section} {
+		for key, values := range f[sect] {
+			value, found := flags[key]
+			if !found && sect == "" {
+				continue
+			}
+			if !found {
+				return fmt.Errorf("[%s]: unknown key: %s", sect, key)
+			}
+			for _, entry := range values {
+				if err := value.Set(entry); err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// FindFlag returns the value of the --name long flag inside args, if
+// present, without otherwise parsing args. We stop at "--".
+func FindFlag(args []string, name string) (string, bool) {
+	prefix := "--" + name
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if arg == "--" {
+			break
+		}
+		if arg == prefix && idx+1 < len(args) {
+			return args[idx+1], true
+		}
+		if value, ok := strings.CutPrefix(arg, prefix+"="); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Setup configures fset from the configuration file and the environment
+// before parsing the command line. The file is named by the --config flag
+// inside args or, when missing, by the corresponding environment variable
+// (see [EnvName]). Then, [ApplyEnv] lets the environment override the file.
+//
+// Call this function before parsing args with fset, such that the
+// command line flags override both the file and the environment.
+func Setup(fset *vflag.FlagSet, envPrefix, section string, args []string) error {
+	path, found := FindFlag(args, "config")
+	if !found {
+		path = os.Getenv(EnvName(envPrefix, "config"))
+	}
+	if path != "" {
+		file, err := Load(path)
+		if err != nil {
+			return err
+		}
+		if err := file.Apply(fset, section); err != nil {
+			return err
+		}
+	}
+	return ApplyEnv(fset, envPrefix)
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/bassosimone/vflag"
+)
+
+// EnvName returns the name of the environment variable for the given long
+// flag name and prefix. For example, "NDT8" and "tbf-latency" map to the
+// NDT8_TBF_LATENCY environment variable.
+func EnvName(prefix, name string) string {
+	return prefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// ApplyEnv sets the long flags of fset using the environment variables
+// named according to [EnvName]. Call this function before parsing the
+// command line, such that flags override the environment.
+func ApplyEnv(fset *vflag.FlagSet, prefix string) error {
+	for _, fx := range fset.LongFlags {
+		if _, ok := fx.Value.(vflag.ValueAutoHelp); ok {
+			continue
+		}
+		name := EnvName(prefix, fx.Name)
+		value, found := os.LookupEnv(name)
+		if !found {
+			continue
+		}
+		if err := fx.Value.Set(value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Environ returns the KEY=VALUE environment entries reproducing the current
+// values of the long flags of fset, except for the flags named in skip.
+func Environ(fset *vflag.FlagSet, prefix string, skip ...string) []string {
+	var env []string
+	for _, fx := range fset.LongFlags {
+		if _, ok := fx.Value.(vflag.ValueAutoHelp); ok || slices.Contains(skip, fx.Name) {
+			continue
+		}
+		env = append(env, EnvName(prefix, fx.Name)+"="+fx.Value.String())
+	}
+	return env
+}
@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// File maps section names to keys to values. Top-level keys belong
+// to the "" section. Each key maps to one or more values, where more
+// than one value means that the file contained an array.
+type File map[string]map[string][]string
+
+// bareKey matches TOML bare keys and section names.
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Parse parses the subset of TOML we need for configuration files: comments,
+// sections, and key/value pairs whose values are strings, booleans, numbers,
+// or single-line arrays of these. We convert all the values to strings,
+// since we are going to use them to set command line flags.
+func Parse(r io.Reader) (File, error) {
+	out := File{"": {}}
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if name, ok := strings.CutPrefix(line, "["); ok {
+			name, ok = strings.CutSuffix(name, "]")
+			name = strings.TrimSpace(name)
+			if !ok || !bareKey.MatchString(name) {
+				return nil, fmt.Errorf("line %d: invalid section: %s", lineno, line)
+			}
+			section = name
+			if _, found := out[section]; !found {
+				out[section] = map[string][]string{}
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || !bareKey.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected key = value: %s", lineno, line)
+		}
+		if _, found := out[section][key]; found {
+			return nil, fmt.Errorf("line %d: duplicate key: %s", lineno, key)
+		}
+		values, err := parseValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+		out[section][key] = values
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// stripComment removes a trailing comment, ignoring # inside strings.
+func stripComment(line string) string {
+	var quote byte
+	for idx := 0; idx < len(line); idx++ {
+		switch ch := line[idx]; {
+		case quote != 0 && ch == '\\' && quote == '"':
+			idx++ // skip the escaped character
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+		case quote == 0 && ch == '#':
+			return line[:idx]
+		}
+	}
+	return line
+}
+
+// parseValue parses a scalar or a single-line array of scalars.
+func parseValue(value string) ([]string, error) {
+	inner, ok := strings.CutPrefix(value, "[")
+	if !ok {
+		scalar, err := parseScalar(value)
+		if err != nil {
+			return nil, err
+		}
+		return []string{scalar}, nil
+	}
+	inner, ok = strings.CutSuffix(inner, "]")
+	if !ok {
+		return nil, fmt.Errorf("unterminated array: %s", value)
+	}
+	var values []string
+	for _, entry := range splitArray(inner) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue // allow trailing commas
+		}
+		scalar, err := parseScalar(entry)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, scalar)
+	}
+	return values, nil
+}
+
+// splitArray splits the array body at commas outside of strings.
+func splitArray(inner string) []string {
+	var (
+		entries []string
+		quote   byte
+		start   int
+	)
+	for idx := 0; idx < len(inner); idx++ {
+		switch ch := inner[idx]; {
+		case quote != 0 && ch == '\\' && quote == '"':
+			idx++
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+		case quote == 0 && ch == ',':
+			entries = append(entries, inner[start:idx])
+			start = idx + 1
+		}
+	}
+	return append(entries, inner[start:])
+}
+
+// parseScalar parses a string, boolean, or number.
+func parseScalar(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		return strconv.Unquote(value)
+
+	case strings.HasPrefix(value, `'`):
+		inner, ok := strings.CutSuffix(value[1:], `'`)
+		if !ok || strings.Contains(inner, `'`) {
+			return "", fmt.Errorf("invalid literal string: %s", value)
+		}
+		return inner, nil
+
+	case value == "true" || value == "false":
+		return value, nil
+
+	default:
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64); err != nil {
+			return "", fmt.Errorf("invalid value: %s", value)
+		}
+		return strings.ReplaceAll(value, "_", ""), nil
+	}
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package conncount counts the bytes read from and written to connections
+// along with the time of the first and last byte, which allows both the
+// clients and the servers to account for the bytes on the wire, including
+// the TLS and HTTP framing, unlike the payload bytes.
+//
+// Clients inject a [*Counter] using [*Counter.DialContext] (which suits both
+// [http.Transport.DialContext] and [http.Transport.DialTLSContext]), while
+// servers wrap their listener using [*Counter.Listener].
+package conncount
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Counter accumulates the bytes transferred by the connections it wraps.
+//
+// The zero value is ready to use.
+type Counter struct {
+	received atomic.Int64
+	sent     atomic.Int64
+	first    atomic.Int64 // unix nanoseconds, zero until the first byte
+	last     atomic.Int64 // unix nanoseconds, zero until the first byte
+}
+
+// Bytes returns the bytes written and read so far.
+func (c *Counter) Bytes() (sent, received int64) {
+	return c.sent.Load(), c.received.Load()
+}
+
+// FirstByte returns when we first read or wrote a byte, or the zero
+// time if no byte has been transferred yet.
+func (c *Counter) FirstByte() time.Time {
+	return unixNano(c.first.Load())
+}
+
+// LastByte returns when we last read or wrote a byte, or the zero
+// time if no byte has been transferred yet.
+func (c *Counter) LastByte() time.Time {
+	return unixNano(c.last.Load())
+}
+
+// unixNano converts the unix nanoseconds we store into a [time.Time].
+func unixNano(value int64) time.Time {
+	if value == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, value)
+}
+
+// add accounts for count bytes transferred through the counter.
+func (c *Counter) add(total *atomic.Int64, count int) {
+	if count <= 0 {
+		return
+	}
+	total.Add(int64(count))
+	now := time.Now().UnixNano()
+	c.first.CompareAndSwap(0, now)
+	c.last.Store(now)
+}
+
+// Wrap returns a [net.Conn] wrapping conn that accounts its bytes to c.
+//
+// The returned connection implements NetConn, which returns conn, so we
+// can still read its kernel statistics (see [Unwrap]).
+func (c *Counter) Wrap(conn net.Conn) net.Conn {
+	return &countingConn{Conn: conn, c: c}
+}
+
+// DialFunc is the signature of [http.Transport.DialContext] and
+// [http.Transport.DialTLSContext].
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// DialContext returns a [DialFunc] that uses dial and wraps the resulting
+// connections using [*Counter.Wrap].
+func (c *Counter) DialContext(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return c.Wrap(conn), nil
+	}
+}
+
+// Listener returns a [net.Listener] wrapping ln whose accepted connections
+// account their bytes to c.
+func (c *Counter) Listener(ln net.Listener) net.Listener {
+	return &listener{Listener: ln, c: c}
+}
+
+// listener is the [net.Listener] returned by [*Counter.Listener].
+type listener struct {
+	net.Listener
+	c *Counter
+}
+
+// Accept implements [net.Listener].
+func (ln *listener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return ln.c.Wrap(conn), nil
+}
+
+// countingConn is the [net.Conn] returned by [*Counter.Wrap].
+type countingConn struct {
+	net.Conn
+	c *Counter
+}
+
+// Read implements [net.Conn].
+func (cc *countingConn) Read(data []byte) (int, error) {
+	count, err := cc.Conn.Read(data)
+	cc.c.add(&cc.c.received, count)
+	return count, err
+}
+
+// Write implements [net.Conn].
+func (cc *countingConn) Write(data []byte) (int, error) {
+	count, err := cc.Conn.Write(data)
+	cc.c.add(&cc.c.sent, count)
+	return count, err
+}
+
+// NetConn returns the underlying connection.
+func (cc *countingConn) NetConn() net.Conn {
+	return cc.Conn
+}
+
+// netConner is implemented by connections wrapping a [net.Conn], such
+// as [*tls.Conn] and the connections returned by [*Counter.Wrap].
+type netConner interface {
+	NetConn() net.Conn
+}
+
+// Unwrap returns the innermost connection wrapped by conn (e.g., the
+// [*net.TCPConn] beneath a counted [*tls.Conn]), or conn itself.
+func Unwrap(conn net.Conn) net.Conn {
+	for {
+		c, ok := conn.(netConner)
+		if !ok {
+			return conn
+		}
+		conn = c.NetConn()
+	}
+}
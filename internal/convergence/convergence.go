@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package convergence detects when the throughput of a transfer converged,
+// which allows the clients to end the tests early on stable links, saving
+// time and data, and to extend them on volatile links, improving accuracy.
+package convergence
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Interval is the interval between the throughput samples.
+	Interval = 250 * time.Millisecond
+
+	// window is the number of throughput samples in the moving average.
+	window = 4
+)
+
+// Spec configures the detection: the throughput converged when its moving
+// average over the last second changed by at most Tolerance, relative to
+// the previous interval, for Intervals consecutive intervals.
+//
+// Construct using [Parse].
+type Spec struct {
+	// Tolerance is the maximum relative change (e.g., 0.05 for 5%).
+	Tolerance float64
+
+	// Intervals is the number of consecutive stable intervals.
+	Intervals int
+}
+
+// Parse parses a specification like "5%/8", i.e., the tolerance followed
+// by the number of intervals of [Interval] during which the moving average
+// must not change by more than the tolerance.
+func Parse(spec string) (*Spec, error) {
+	tolStr, countStr, found := strings.Cut(spec, "/")
+	percent, ok := strings.CutSuffix(tolStr, "%")
+	if !found || !ok {
+		return nil, fmt.Errorf("convergence: invalid spec %q (e.g., 5%%/8)", spec)
+	}
+	tolerance, err := strconv.ParseFloat(percent, 64)
+	if err != nil || tolerance <= 0 || tolerance >= 100 {
+		return nil, fmt.Errorf("convergence: tolerance must be between 0%% and 100%%: %q", tolStr)
+	}
+	intervals, err := strconv.Atoi(countStr)
+	if err != nil || intervals < 1 {
+		return nil, fmt.Errorf("convergence: intervals must be a positive integer: %q", countStr)
+	}
+	return &Spec{Tolerance: tolerance / 100, Intervals: intervals}, nil
+}
+
+// String returns the specification in the format [Parse] accepts.
+func (s *Spec) String() string {
+	return fmt.Sprintf("%s%%/%d", strconv.FormatFloat(s.Tolerance*100, 'f', -1, 64), s.Intervals)
+}
+
+// Run reads the bytes transferred so far every [Interval] until the
+// throughput converges or ctx is done. When the throughput converges,
+// it returns true along with the time elapsed since start. We do not
+// declare convergence before minElapsed (e.g., during the warm-up).
+func (s *Spec) Run(ctx context.Context, start time.Time, minElapsed time.Duration, bytes func() int64) (time.Duration, bool) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+	var (
+		d     = &detector{spec: s}
+		prevT = start
+		prevB = bytes()
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, false
+		case now := <-ticker.C:
+			current := bytes()
+			if elapsed := now.Sub(prevT); elapsed > 0 {
+				d.observe(float64((current-prevB)*8) / elapsed.Seconds())
+			}
+			prevT, prevB = now, current
+			if d.converged() && now.Sub(start) >= minElapsed {
+				return now.Sub(start), true
+			}
+		}
+	}
+}
+
+// detector implements the detection for a [*Spec].
+type detector struct {
+	spec    *Spec
+	rates   []float64
+	prevAvg float64
+	stable  int
+}
+
+// observe adds the throughput of the last interval in bit/s.
+func (d *detector) observe(rate float64) {
+	d.rates = append(d.rates, rate)
+	if len(d.rates) > window {
+		d.rates = d.rates[1:]
+	}
+	if len(d.rates) < window {
+		return
+	}
+	var sum float64
+	for _, r := range d.rates {
+		sum += r
+	}
+	avg := sum / float64(len(d.rates))
+	switch {
+	case avg <= 0 || d.prevAvg <= 0:
+		d.stable = 0
+	case math.Abs(avg-d.prevAvg)/d.prevAvg <= d.spec.Tolerance:
+		d.stable++
+	default:
+		d.stable = 0
+	}
+	d.prevAvg = avg
+}
+
+// converged returns whether the moving average has been stable for long enough.
+func (d *detector) converged() bool {
+	return d.stable >= d.spec.Intervals
+}
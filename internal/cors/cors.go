@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package cors implements Cross-Origin Resource Sharing (CORS), which lets
+// browser pages served by other origins call an API.
+//
+// Browsers send the Origin header with cross-origin requests and only let
+// the page read the response when the server echoes the origin in the
+// Access-Control-Allow-Origin header. Before requests that are not simple
+// (e.g., PUT or DELETE), they send an OPTIONS preflight request asking the
+// server whether to proceed, whose response they may cache.
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/problem"
+)
+
+// Policy decides which cross-origin requests we allow.
+//
+// Construct using [New]. The nil policy does not handle CORS.
+type Policy struct {
+	headers string
+	maxAge  time.Duration
+	methods string
+	origins []string
+}
+
+// New constructs a new [*Policy] allowing requests from the origins matching
+// the given [path.Match] patterns (e.g., "https://*.example.org"), where "*"
+// matches any origin, using the given methods and request headers, and
+// allowing browsers to cache preflight responses for maxAge.
+func New(origins, methods, headers []string, maxAge time.Duration) (*Policy, error) {
+	p := &Policy{maxAge: maxAge}
+	for _, pattern := range origins {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		p.origins = append(p.origins, pattern)
+	}
+	p.methods = strings.ToUpper(joinTrimmed(methods))
+	p.headers = joinTrimmed(headers)
+	return p, nil
+}
+
+// joinTrimmed joins values using commas after trimming the spaces.
+func joinTrimmed(values []string) string {
+	out := make([]string, 0, len(values))
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			out = append(out, value)
+		}
+	}
+	return strings.Join(out, ", ")
+}
+
+// Allows returns whether p allows requests from origin.
+func (p *Policy) Allows(origin string) bool {
+	origin = strings.ToLower(origin)
+	return slices.ContainsFunc(p.origins, func(pattern string) bool {
+		matched, _ := path.Match(pattern, origin)
+		return pattern == "*" || matched
+	})
+}
+
+// Handler returns an [http.Handler] answering the preflight requests and
+// adding the CORS headers to the responses of next. We echo the origin
+// rather than replying with "*", so the responses vary by origin. Requests
+// without Origin, including same-origin GET requests, go straight to next.
+// When p is nil, it returns next.
+func Handler(p *Policy, next http.Handler) http.Handler {
+	if p == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		rw.Header().Add("Vary", "Origin")
+		if origin == "" {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		preflight := req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+		if !p.Allows(origin) {
+			if preflight {
+				problem.Write(rw, problem.New(req, http.StatusForbidden, problem.TypeOriginNotAllowed,
+					fmt.Sprintf("the %q origin is not allowed", origin)))
+				return
+			}
+			// Without the CORS headers, the browser hides the response.
+			next.ServeHTTP(rw, req)
+			return
+		}
+		rw.Header().Set("Access-Control-Allow-Origin", origin)
+		if !preflight {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		rw.Header().Add("Vary", "Access-Control-Request-Method")
+		rw.Header().Add("Vary", "Access-Control-Request-Headers")
+		rw.Header().Set("Access-Control-Allow-Methods", p.methods)
+		if p.headers != "" {
+			rw.Header().Set("Access-Control-Allow-Headers", p.headers)
+		}
+		rw.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.maxAge.Seconds())))
+		rw.WriteHeader(http.StatusNoContent)
+	})
+}
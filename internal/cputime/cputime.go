@@ -0,0 +1,17 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package cputime measures the CPU time used by the current process.
+package cputime
+
+import "time"
+
+// Usage returns the fraction of a CPU the process used since the given
+// CPU time and wall-clock time, as previously returned by [Now] and
+// [time.Now]. A value of 1 means that we saturated a CPU.
+func Usage(cpu0 time.Duration, t0 time.Time) float64 {
+	elapsed := time.Since(t0)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(Now()-cpu0) / float64(elapsed)
+}
@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build !unix
+
+package cputime
+
+import "time"
+
+// Now returns zero, since we do not know how to measure CPU time.
+func Now() time.Duration {
+	return 0
+}
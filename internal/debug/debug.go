@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package debug implements the optional debug listener of the servers,
+// which serves the expvar variables at /debug/vars and the pprof profiles
+// at /debug/pprof/, to investigate leaks under sustained load.
+//
+// Since these endpoints expose the server internals, we serve them on a
+// separate plain HTTP listener, which binds to localhost unless the
+// operator explicitly asks otherwise.
+package debug
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+)
+
+// defaultHost is the host we listen on when the endpoint omits it.
+const defaultHost = "127.0.0.1"
+
+// Endpoint returns endpoint, which is either HOST:PORT, :PORT, or PORT,
+// using [defaultHost] when the host is missing.
+func Endpoint(endpoint string) (string, error) {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host, port = "", endpoint
+	}
+	if port == "" {
+		return "", errors.New("debug: missing port")
+	}
+	if host == "" {
+		host = defaultHost
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Publish publishes the variables returned by vars, along with the number
+// of goroutines and, where we can count them, of open file descriptors,
+// under name in /debug/vars.
+func Publish(name string, vars func() map[string]int64) {
+	expvar.Publish(name, expvar.Func(func() any {
+		values := vars()
+		values["goroutines"] = int64(runtime.NumGoroutine())
+		if count, ok := openFiles(); ok {
+			values["fds"] = count
+		}
+		return values
+	}))
+}
+
+// openFiles returns the number of open file descriptors, which we can only
+// count on systems with a /proc filesystem.
+func openFiles() (int64, bool) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, false
+	}
+	// Note: reading the directory opens a descriptor we should not count
+	return int64(len(entries)) - 1, true
+}
+
+// Listen listens at endpoint (see [Endpoint]) and serves the debug endpoints
+// in the background until ctx is done.
+func Listen(ctx context.Context, endpoint string) error {
+	endpoint, err := Endpoint(endpoint)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", endpoint)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+	srv := &http.Server{Addr: endpoint, Handler: mux}
+	go func() {
+		defer srv.Close()
+		<-ctx.Done()
+	}()
+	go func() {
+		slog.Info("serving debug endpoints at", slog.String("addr", endpoint))
+		err := srv.Serve(ln)
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("cannot serve debug endpoints", slog.Any("err", err))
+		}
+	}()
+	return nil
+}
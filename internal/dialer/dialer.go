@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package dialer dials connections recording DNS lookups and connect attempts.
+package dialer
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http/httptrace"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/conncount"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/2026-02-provlima/internal/tcpinfo"
+)
+
+// Recorder dials connections on behalf of an [*http.Transport] or of a
+// websocket dialer, possibly forcing an address family, and records each
+// DNS lookup and connection attempt, as well as the TLS handshakes, when
+// used as the [tls.Config.VerifyConnection] callback.
+//
+// Construct using [New].
+type Recorder struct {
+	dialer     *net.Dialer
+	network    string
+	mu         sync.Mutex
+	dials      []results.Dial
+	lookups    []results.DNSLookup
+	handshakes []results.TLSHandshake
+	conns      []net.Conn
+	wire       conncount.Counter
+}
+
+// New constructs a new [*Recorder] using the given network, which
+// is "tcp4" or "tcp6" to force a family, or "tcp" otherwise.
+func New(network string) *Recorder {
+	return &Recorder{dialer: &net.Dialer{}, network: network}
+}
+
+// DialContext is compatible with [http.Transport.DialContext].
+func (dr *Recorder) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return dr.dial(ctx, network, address, nil)
+}
+
+// DialContextWrapping returns a [conncount.DialFunc] like [*Recorder.DialContext]
+// that also wraps the connections using wrap (e.g., to read kernel timestamps) before
+// we account their bytes, so wrap sees the underlying [*net.TCPConn].
+func (dr *Recorder) DialContextWrapping(wrap func(net.Conn) net.Conn) conncount.DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dr.dial(ctx, network, address, wrap)
+	}
+}
+
+// dial implements [*Recorder.DialContext] and [*Recorder.DialContextWrapping].
+func (dr *Recorder) dial(ctx context.Context, network, address string, wrap func(net.Conn) net.Conn) (net.Conn, error) {
+	if network == "tcp" {
+		network = dr.network
+	}
+	dial := results.Dial{
+		Network: network,
+		Address: address,
+		Time:    time.Now(),
+	}
+
+	// The net package invokes these hooks only when address contains a
+	// hostname, so we only record lookups that actually happened.
+	var lookup *results.DNSLookup
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			lookup = &results.DNSLookup{Hostname: info.Host, Time: time.Now()}
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if lookup == nil {
+				return
+			}
+			lookup.Elapsed = time.Since(lookup.Time)
+			for _, addr := range info.Addrs {
+				lookup.Addresses = append(lookup.Addresses, addr.String())
+			}
+			if info.Err != nil {
+				lookup.Failure = info.Err.Error()
+			}
+			dr.recordLookup(*lookup)
+		},
+	}
+	ctx = httptrace.WithClientTrace(ctx, trace)
+
+	conn, err := dr.dialer.DialContext(ctx, network, address)
+	dial.Elapsed = time.Since(dial.Time)
+	if err != nil {
+		dial.Failure = err.Error()
+	}
+	if conn != nil {
+		dial.RemoteAddr = conn.RemoteAddr().String()
+		dial.Family = addressFamily(conn.RemoteAddr())
+		// Best effort, since only some systems and families support it.
+		tcpinfo.EnableTTL(conn)
+		if wrap != nil {
+			conn = wrap(conn)
+		}
+		conn = dr.wire.Wrap(conn)
+	}
+	slog.Info("dial",
+		slog.String("network", dial.Network),
+		slog.String("address", dial.Address),
+		slog.String("remoteAddr", dial.RemoteAddr),
+		slog.String("family", dial.Family),
+		slog.Duration("elapsed", dial.Elapsed),
+		slog.Any("err", err),
+	)
+
+	dr.mu.Lock()
+	dr.dials = append(dr.dials, dial)
+	if conn != nil {
+		dr.conns = append(dr.conns, conn)
+	}
+	dr.mu.Unlock()
+	return conn, err
+}
+
+func (dr *Recorder) recordLookup(lookup results.DNSLookup) {
+	slog.Info("dns lookup",
+		slog.String("hostname", lookup.Hostname),
+		slog.Any("addresses", lookup.Addresses),
+		slog.Duration("elapsed", lookup.Elapsed),
+		slog.String("failure", lookup.Failure),
+	)
+	dr.mu.Lock()
+	dr.lookups = append(dr.lookups, lookup)
+	dr.mu.Unlock()
+}
+
+// VerifyConnection is compatible with [tls.Config.VerifyConnection], which
+// the TLS stack invokes for every handshake, including the resumed ones,
+// and records the handshake without further verifying it.
+func (dr *Recorder) VerifyConnection(state tls.ConnectionState) error {
+	handshake := results.TLSHandshake{
+		Resumed: state.DidResume,
+		Version: tls.VersionName(state.Version),
+		ALPN:    state.NegotiatedProtocol,
+		Time:    time.Now(),
+	}
+	slog.Info("tls handshake",
+		slog.Bool("resumed", handshake.Resumed),
+		slog.String("version", handshake.Version),
+		slog.String("alpn", handshake.ALPN),
+	)
+	dr.mu.Lock()
+	dr.handshakes = append(dr.handshakes, handshake)
+	dr.mu.Unlock()
+	return nil
+}
+
+// Dials returns a copy of the recorded connection attempts.
+func (dr *Recorder) Dials() []results.Dial {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return slices.Clone(dr.dials)
+}
+
+// Lookups returns a copy of the recorded DNS lookups.
+func (dr *Recorder) Lookups() []results.DNSLookup {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return slices.Clone(dr.lookups)
+}
+
+// TLSHandshakes returns a copy of the recorded TLS handshakes.
+func (dr *Recorder) TLSHandshakes() []results.TLSHandshake {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	return slices.Clone(dr.handshakes)
+}
+
+// RetransmittedBytes estimates the bytes retransmitted so far by the
+// connections we dialed that are still open. The estimate is zero on
+// systems where we cannot read the kernel TCP statistics.
+func (dr *Recorder) RetransmittedBytes() int64 {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	var total int64
+	for _, conn := range dr.conns {
+		if info, err := tcpinfo.Get(conn); err == nil {
+			total += info.RetransmittedBytes()
+		}
+	}
+	return total
+}
+
+// Retransmits returns the number of segments retransmitted so far by the
+// connections we dialed that are still open, which, like the estimate of
+// [*Recorder.RetransmittedBytes], is zero when we cannot tell.
+func (dr *Recorder) Retransmits() int64 {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	var total int64
+	for _, conn := range dr.conns {
+		if info, err := tcpinfo.Get(conn); err == nil {
+			total += info.TotalRetrans
+		}
+	}
+	return total
+}
+
+// WireBytes returns the bytes written to and read from the connections we
+// dialed so far, including the TLS and HTTP framing.
+func (dr *Recorder) WireBytes() (sent, received int64) {
+	return dr.wire.Bytes()
+}
+
+// addressFamily returns "inet" or "inet6" depending on the address.
+func addressFamily(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	if tcpAddr.IP.To4() != nil {
+		return "inet"
+	}
+	return "inet6"
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package dialer
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// SessionCache is a [tls.ClientSessionCache] we can store into a file, so
+// that consecutive measurements, which run in distinct processes (e.g.,
+// when monitoring), resume the TLS sessions of the previous ones, skipping
+// the certificate exchange, which matters with short tests on high-RTT
+// paths. Since the file contains the session secrets, only the owner can
+// read it.
+//
+// Construct using [LoadSessionCache].
+type SessionCache struct {
+	mu       sync.Mutex
+	path     string
+	sessions map[string]*tls.ClientSessionState
+}
+
+// storedSession is how we store a session (see [tls.ClientSessionState.ResumptionState]).
+type storedSession struct {
+	Ticket []byte `json:"ticket"`
+	State  []byte `json:"state"`
+}
+
+// LoadSessionCache loads the [*SessionCache] stored at path, returning an
+// empty one when the file does not exist.
+func LoadSessionCache(path string) (*SessionCache, error) {
+	sc := &SessionCache{path: path, sessions: map[string]*tls.ClientSessionState{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return sc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored map[string]storedSession
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for key, entry := range stored {
+		state, err := tls.ParseSessionState(entry.State)
+		if err != nil {
+			continue // e.g., stored by an incompatible Go version
+		}
+		session, err := tls.NewResumptionState(entry.Ticket, state)
+		if err != nil {
+			continue
+		}
+		sc.sessions[key] = session
+	}
+	return sc, nil
+}
+
+// Get implements [tls.ClientSessionCache].
+func (sc *SessionCache) Get(key string) (*tls.ClientSessionState, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	session, ok := sc.sessions[key]
+	return session, ok
+}
+
+// Put implements [tls.ClientSessionCache].
+func (sc *SessionCache) Put(key string, session *tls.ClientSessionState) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if session == nil {
+		delete(sc.sessions, key)
+		return
+	}
+	sc.sessions[key] = session
+}
+
+// Save atomically stores the sessions into the file we loaded them from.
+func (sc *SessionCache) Save() error {
+	sc.mu.Lock()
+	stored := make(map[string]storedSession, len(sc.sessions))
+	for key, session := range sc.sessions {
+		ticket, state, err := session.ResumptionState()
+		if err != nil || state == nil {
+			continue
+		}
+		data, err := state.Bytes()
+		if err != nil {
+			continue
+		}
+		stored[key] = storedSession{Ticket: ticket, State: data}
+	}
+	sc.mu.Unlock()
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	tmp := sc.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sc.path)
+}
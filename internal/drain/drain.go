@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package drain keeps back-to-back test phases apart, waiting for the queues
+// a phase filled to drain before the next phase begins.
+//
+// A download leaves the bottleneck queue full when it ends, and the packets
+// still queued delay the upload ACKs, so an upload starting right away
+// measures the leftovers of the download rather than the uplink. We wait
+// for a quiet period and then probe until the latency is back to what we
+// measured while idle, which tells that the queues are empty again.
+package drain
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// baselineProbes is the number of probes measuring the idle latency.
+	baselineProbes = 5
+
+	// probeInterval is the interval between probes.
+	probeInterval = 100 * time.Millisecond
+
+	// maxWait bounds how long [*Gap.Wait] probes after the quiet period.
+	maxWait = 10 * time.Second
+
+	// slack is the latency above the baseline we still consider idle, which
+	// avoids waiting forever for sub-millisecond baselines to repeat.
+	slack = time.Millisecond
+)
+
+// ProbeFunc measures the current round-trip time.
+type ProbeFunc func(ctx context.Context) (time.Duration, error)
+
+// HTTPProbe returns a [ProbeFunc] measuring the time to fetch URL using
+// client. Since we only care about the time it takes, any response will
+// do, so servers not implementing URL are fine.
+func HTTPProbe(client *http.Client, URL string) ProbeFunc {
+	return func(ctx context.Context) (time.Duration, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", URL, http.NoBody)
+		if err != nil {
+			return 0, err
+		}
+		t0 := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(io.Discard, resp.Body)
+		return time.Since(t0), err
+	}
+}
+
+// Gap is the quiet period between back-to-back test phases.
+//
+// Construct using [New]. The nil gap does not wait.
+type Gap struct {
+	baseline time.Duration
+	period   time.Duration
+	probe    ProbeFunc
+}
+
+// New measures the idle latency using probe, which must happen before the
+// first phase, and returns a [*Gap] waiting for at least period between
+// phases. It returns nil, which does not wait, when period is not positive.
+func New(ctx context.Context, period time.Duration, probe ProbeFunc) (*Gap, error) {
+	if period <= 0 {
+		return nil, nil
+	}
+	baseline, err := minRTT(ctx, probe, baselineProbes)
+	if err != nil {
+		return nil, fmt.Errorf("drain: cannot measure the idle latency: %w", err)
+	}
+	slog.Info("idle latency", slog.Duration("rtt", baseline))
+	return &Gap{baseline: baseline, period: period, probe: probe}, nil
+}
+
+// minRTT returns the minimum RTT of count probes, the first of which may
+// include the connection setup, or the first error.
+func minRTT(ctx context.Context, probe ProbeFunc, count int) (time.Duration, error) {
+	var out time.Duration
+	for idx := range count {
+		if idx > 0 {
+			if err := sleep(ctx, probeInterval); err != nil {
+				return 0, err
+			}
+		}
+		rtt, err := probe(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if idx == 0 || rtt < out {
+			out = rtt
+		}
+	}
+	return out, nil
+}
+
+// Wait waits for the quiet period and then probes until the latency is
+// back within twice the idle latency. When the queues do not drain within
+// a bounded time, we warn and return, since the next phase may be polluted,
+// but it is not our call to skip it.
+func (g *Gap) Wait(ctx context.Context) {
+	if g == nil {
+		return
+	}
+	slog.Info("quiet period", slog.Duration("period", g.period))
+	if sleep(ctx, g.period) != nil {
+		return
+	}
+	deadline := time.Now().Add(maxWait)
+	for time.Now().Before(deadline) {
+		rtt, err := g.probe(ctx)
+		if err == nil && rtt <= 2*g.baseline+slack {
+			slog.Info("queues drained", slog.Duration("rtt", rtt), slog.Duration("baseline", g.baseline))
+			return
+		}
+		if sleep(ctx, probeInterval) != nil {
+			return
+		}
+	}
+	slog.Warn("queues did not drain", slog.Duration("baseline", g.baseline), slog.Duration("maxWait", maxWait))
+}
+
+// sleep sleeps for d or until ctx is done, returning the ctx error.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package failure maps fatal errors to exit codes shared by all the tools.
+//
+// Scripts driving the tools can branch on the exit code, or, when using
+// `--error-format json`, parse the final error object written to stderr.
+package failure
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Class is a failure class. Its value is the process exit code.
+type Class int
+
+const (
+	// Generic is a failure not belonging to any other class.
+	Generic = Class(1)
+
+	// Usage is an invalid command line or configuration. Its value is
+	// EX_USAGE from sysexits.h, since the flag parser and the Go runtime,
+	// when a goroutine panics, both exit with 2 (see [FlagExit]).
+	Usage = Class(64)
+
+	// Connectivity is a failure to reach or to talk to the server.
+	Connectivity = Class(3)
+
+	// Protocol is an unexpected response or message from the peer.
+	Protocol = Class(4)
+
+	// Threshold is a measurement violating a user-provided threshold.
+	Threshold = Class(5)
+)
+
+// String returns the class name used in the JSON error object.
+func (c Class) String() string {
+	switch c {
+	case Usage:
+		return "usage"
+	case Connectivity:
+		return "connectivity"
+	case Protocol:
+		return "protocol"
+	case Threshold:
+		return "threshold"
+	default:
+		return "generic"
+	}
+}
+
+// Error is an error with an associated [Class].
+type Error struct {
+	// Class is the failure class.
+	Class Class
+
+	// Err is the underlying error.
+	Err error
+}
+
+var _ error = &Error{}
+
+// New returns a new [*Error] wrapping err with the given class.
+func New(class Class, err error) *Error {
+	return &Error{Class: class, Err: err}
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap allows using [errors.Is] and [errors.As].
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ClassOf returns the [Class] of err, which is [Generic] unless
+// err wraps an [*Error].
+func ClassOf(err error) Class {
+	var ferr *Error
+	if errors.As(err, &ferr) {
+		return ferr.Class
+	}
+	return Generic
+}
+
+// errorFormat is the format configured using [Setup].
+var errorFormat = "text"
+
+// Setup configures the format used by [Exit]. When format is "json", we
+// write a JSON object to stderr; otherwise, we write a line of text.
+func Setup(format string) {
+	errorFormat = format
+}
+
+// redact is the function configured using [Redact].
+var redact = func(message string) string { return message }
+
+// Redact makes [Exit] pass the error message through fn, which, e.g.,
+// removes the addresses we must not log.
+func Redact(fn func(message string) string) {
+	redact = fn
+}
+
+// jsonError is the JSON error object written by [Exit].
+type jsonError struct {
+	// Program is the name of the program that failed.
+	Program string `json:"program"`
+
+	// Class is the failure class name (e.g., "connectivity").
+	Class string `json:"class"`
+
+	// Code is the exit code.
+	Code int `json:"code"`
+
+	// Error is the error message.
+	Error string `json:"error"`
+}
+
+// Exit writes err to stderr using the configured format and exits using
+// the exit code of class, or the class of err when class is zero.
+func Exit(class Class, err error) {
+	if class == 0 {
+		class = ClassOf(err)
+	}
+	program := filepath.Base(os.Args[0])
+	message := redact(err.Error())
+	if errorFormat == "json" {
+		enc := json.NewEncoder(os.Stderr)
+		enc.SetEscapeHTML(false) // keep ">=" readable in threshold errors
+		enc.Encode(&jsonError{
+			Program: program,
+			Class:   class.String(),
+			Code:    int(class),
+			Error:   message,
+		})
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %s error: %s\n", program, class, message)
+	}
+	os.Exit(int(class))
+}
+
+// OnError calls [Exit] with the given class when err is not nil.
+func OnError(class Class, err error) {
+	if err != nil {
+		Exit(class, err)
+	}
+}
+
+// FlagExit is the Exit function of the flag sets and the dispatchers, which
+// exit with 2 on usage errors. It exits with [Usage] instead, so that, e.g.,
+// the monitors do not mistake a crashing measurement for a usage error.
+func FlagExit(code int) {
+	if code == 2 {
+		code = int(Usage)
+	}
+	os.Exit(code)
+}
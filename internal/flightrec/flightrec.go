@@ -0,0 +1,392 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package flightrec implements a flight recorder for the measure commands,
+// which keeps the last seconds of fine-grained samples, taken every [Tick],
+// in a ring buffer and dumps them to disk when it detects an anomaly (see
+// [ReasonThroughputCollapse] and [ReasonProbeSpike]), so that the rare
+// events captured by long monitor runs have high-resolution context.
+//
+// On Linux, the ring buffer is a memory-mapped file in the dump directory,
+// named flightrec.ring, which we remove when closing the [*Recorder]. Thus,
+// when the monitor kills a stuck measurement, the file still contains the
+// last samples. The file is an array of little-endian records, each made
+// of the unix time in nanoseconds, the bytes sent, and the bytes received,
+// as int64, where a zero time marks an unused record.
+package flightrec
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+const (
+	// Tick is the interval between the records.
+	Tick = 10 * time.Millisecond
+
+	// MinWindow is the minimum window the ring buffer can hold.
+	MinWindow = 2 * rateWindow
+
+	// recordSize is the size of a record in the ring buffer.
+	recordSize = 24
+
+	// rateWindow is the interval over which we compute the throughput.
+	rateWindow = time.Second
+
+	// minActive is how long a direction must run before we detect collapses,
+	// so that the peak throughput is meaningful.
+	minActive = 2 * time.Second
+
+	// collapseFraction is the fraction of the peak throughput below which
+	// the throughput collapsed.
+	collapseFraction = 0.1
+
+	// spikeFactor is how many times the RTT of a probe must exceed the median
+	// RTT of the previous [spikeHistory] probes to be a spike.
+	spikeFactor = 10
+
+	// minSpike is the minimum RTT of a spike, which prevents the queues
+	// filling up at the start of a direction from looking like spikes.
+	minSpike = time.Second
+
+	// spikeHistory is the number of previous probes we compare with.
+	spikeHistory = 8
+
+	// probeTicks is the number of ticks between checking the probes.
+	probeTicks = 25
+)
+
+// These are the values of [Dump] Reason.
+const (
+	// ReasonThroughputCollapse indicates that the throughput over the last
+	// second fell below a tenth of the peak throughput of the direction.
+	ReasonThroughputCollapse = "throughput-collapse"
+
+	// ReasonProbeSpike indicates that a probe failed or that its RTT was
+	// above one second and ten times the median of the previous probes.
+	ReasonProbeSpike = "probe-spike"
+)
+
+// Record is a sample of the bytes transferred on the wire so far.
+type Record struct {
+	// Time is the time when we took the sample.
+	Time time.Time `json:"time"`
+
+	// Sent is the number of bytes sent so far.
+	Sent int64 `json:"sent"`
+
+	// Received is the number of bytes received so far.
+	Received int64 `json:"received"`
+}
+
+// Dump is the content of the files the [*Recorder] writes.
+type Dump struct {
+	// Reason is [ReasonThroughputCollapse] or [ReasonProbeSpike].
+	Reason string `json:"reason"`
+
+	// Direction is the direction running when we detected the anomaly.
+	Direction string `json:"direction"`
+
+	// Time is the time when we detected the anomaly.
+	Time time.Time `json:"time"`
+
+	// Records contains the records of the ring buffer, oldest first.
+	Records []Record `json:"records"`
+
+	// Probes contains the probes sent during the records, if any.
+	Probes []results.Probe `json:"probes,omitempty"`
+}
+
+// Recorder is the flight recorder.
+//
+// Construct using [New]. The methods of a nil [*Recorder] do nothing,
+// which is convenient when the flight recorder is disabled.
+type Recorder struct {
+	cancel    context.CancelFunc
+	dir       string
+	done      chan struct{}
+	probes    func() []results.Probe
+	ring      *ring
+	wireBytes func() (sent, received int64)
+
+	mu         sync.Mutex
+	direction  string          // direction running, if any
+	phaseStart time.Time       // when the direction started
+	peak       float64         // peak throughput of the direction in bit/s
+	tripped    bool            // whether we detected an anomaly during the direction
+	seenProbes int             // number of probes we already checked
+	rtts       []time.Duration // RTTs of the previous probes of the direction
+	pending    *Dump           // dump waiting for the records following the anomaly
+	due        time.Time       // when to write the pending dump
+	dumps      []string        // paths of the dumps written so far
+}
+
+// New constructs a [*Recorder] keeping the records of the last window in
+// a ring buffer and writing the dumps into dir, which must exist. The
+// wireBytes function returns the bytes sent and received so far and the
+// probes function, which may be nil, returns the probes sent so far.
+// The window must be at least [MinWindow].
+//
+// The recorder runs in the background until [*Recorder.Close].
+func New(dir string, window time.Duration, wireBytes func() (sent, received int64), probes func() []results.Probe) (*Recorder, error) {
+	if window < MinWindow {
+		return nil, fmt.Errorf("flightrec: the window must be at least %s", MinWindow)
+	}
+	rg, err := newRing(filepath.Join(dir, "flightrec.ring"), int(window/Tick))
+	if err != nil {
+		return nil, err
+	}
+	if probes == nil {
+		probes = func() []results.Probe { return nil }
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Recorder{
+		cancel:    cancel,
+		dir:       dir,
+		done:      make(chan struct{}),
+		probes:    probes,
+		ring:      rg,
+		wireBytes: wireBytes,
+	}
+	go r.loop(ctx)
+	return r, nil
+}
+
+// Begin tells the recorder that the given direction started, which
+// enables detecting anomalies until [*Recorder.End].
+func (r *Recorder) Begin(direction string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.direction, r.phaseStart, r.peak, r.tripped, r.rtts = direction, time.Now(), 0, false, nil
+}
+
+// End tells the recorder that the direction ended.
+func (r *Recorder) End() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.direction = ""
+}
+
+// Close stops the recorder, writes the pending dump, if any, removes the
+// ring buffer, and returns the paths of the dumps written.
+func (r *Recorder) Close() []string {
+	if r == nil {
+		return nil
+	}
+	r.cancel()
+	<-r.done
+	r.flush(time.Time{})
+	if err := r.ring.close(); err != nil {
+		slog.Warn("cannot remove the flight recorder ring buffer", slog.Any("err", err))
+	}
+	return r.dumps
+}
+
+// loop records a sample every [Tick] until ctx is done.
+func (r *Recorder) loop(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(Tick)
+	defer ticker.Stop()
+	for tick := 1; ; tick++ {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sent, received := r.wireBytes()
+			r.ring.put(Record{Time: now, Sent: sent, Received: received})
+			r.checkThroughput(now)
+			if tick%probeTicks == 0 {
+				r.checkProbes(now)
+			}
+			r.flush(now)
+		}
+	}
+}
+
+// checkThroughput detects whether the throughput collapsed at now.
+func (r *Recorder) checkThroughput(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.direction == "" || r.tripped {
+		return
+	}
+	last, _ := r.ring.ago(0)
+	prev, ok := r.ring.ago(int(rateWindow / Tick))
+	if !ok || prev.Time.Before(r.phaseStart) {
+		return
+	}
+	count := (last.Sent - prev.Sent) + (last.Received - prev.Received)
+	rate := float64(count*8) / last.Time.Sub(prev.Time).Seconds()
+	r.peak = max(r.peak, rate)
+	if now.Sub(r.phaseStart) >= minActive && rate < collapseFraction*r.peak {
+		r.trip(now, ReasonThroughputCollapse)
+	}
+}
+
+// checkProbes detects whether the probes sent since the last check spiked.
+func (r *Recorder) checkProbes(now time.Time) {
+	probes := r.probes()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fresh := probes[min(r.seenProbes, len(probes)):]
+	r.seenProbes = len(probes)
+	for _, probe := range fresh {
+		if r.direction == "" || probe.Direction != r.direction || probe.Time.Before(r.phaseStart) {
+			continue
+		}
+		if r.isSpike(probe) && !r.tripped {
+			r.trip(now, ReasonProbeSpike)
+		}
+		if probe.Failure == "" {
+			r.rtts = append(r.rtts, probe.RTT)
+			if len(r.rtts) > spikeHistory {
+				r.rtts = r.rtts[1:]
+			}
+		}
+	}
+}
+
+// isSpike returns whether probe is a spike compared to the previous probes.
+func (r *Recorder) isSpike(probe results.Probe) bool {
+	if probe.Failure != "" {
+		return true
+	}
+	if len(r.rtts) < spikeHistory || probe.RTT < minSpike {
+		return false
+	}
+	sorted := slices.Clone(r.rtts)
+	slices.Sort(sorted)
+	return probe.RTT > spikeFactor*sorted[len(sorted)/2]
+}
+
+// trip records that we detected an anomaly for the given reason at now,
+// which we dump after a quarter of the window, so the dump also contains
+// what happened afterwards. The caller must hold the mutex.
+func (r *Recorder) trip(now time.Time, reason string) {
+	slog.Warn("flight recorder detected an anomaly", slog.String("reason", reason),
+		slog.String("direction", r.direction))
+	r.tripped = true
+	if r.pending != nil {
+		return
+	}
+	r.pending = &Dump{Reason: reason, Direction: r.direction, Time: now}
+	r.due = now.Add(time.Duration(r.ring.capacity()) * Tick / 4)
+}
+
+// flush writes the pending dump, if any, when it is due at now or when
+// now is the zero time.
+func (r *Recorder) flush(now time.Time) {
+	r.mu.Lock()
+	dump := r.pending
+	if dump == nil || (!now.IsZero() && now.Before(r.due)) {
+		r.mu.Unlock()
+		return
+	}
+	r.pending = nil
+	r.mu.Unlock()
+
+	dump.Records = r.ring.snapshot()
+	if len(dump.Records) > 0 {
+		first, last := dump.Records[0].Time, dump.Records[len(dump.Records)-1].Time
+		for _, probe := range r.probes() {
+			if !probe.Time.Before(first) && !probe.Time.After(last) {
+				dump.Probes = append(dump.Probes, probe)
+			}
+		}
+	}
+	path := filepath.Join(r.dir, fmt.Sprintf("flightrec-%s-%s.json",
+		dump.Time.UTC().Format("20060102T150405.000Z"), dump.Reason))
+	if err := writeDump(path, dump); err != nil {
+		slog.Warn("cannot write the flight recorder dump", slog.Any("err", err))
+		return
+	}
+	slog.Info("flight recorder dump written", slog.String("path", path))
+	r.mu.Lock()
+	r.dumps = append(r.dumps, path)
+	r.mu.Unlock()
+}
+
+// writeDump writes dump to path.
+func writeDump(path string, dump *Dump) error {
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// ring is the ring buffer of [Record].
+//
+// Construct using [newRing].
+type ring struct {
+	data  []byte
+	next  int // total number of records written
+	unmap func() error
+}
+
+// newRing constructs a [*ring] holding count records, backed by the file at
+// path, if we know how to map it (see mapFile).
+func newRing(path string, count int) (*ring, error) {
+	data, unmap, err := mapFile(path, max(count, 1)*recordSize)
+	if err != nil {
+		return nil, err
+	}
+	return &ring{data: data, unmap: unmap}, nil
+}
+
+// capacity returns the number of records the ring holds.
+func (rg *ring) capacity() int {
+	return len(rg.data) / recordSize
+}
+
+// put appends rec, overwriting the oldest record when the ring is full.
+func (rg *ring) put(rec Record) {
+	buf := rg.data[(rg.next%rg.capacity())*recordSize:]
+	binary.LittleEndian.PutUint64(buf[0:], uint64(rec.Time.UnixNano()))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(rec.Sent))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(rec.Received))
+	rg.next++
+}
+
+// ago returns the record written count records before the last one.
+func (rg *ring) ago(count int) (Record, bool) {
+	if count < 0 || count >= min(rg.next, rg.capacity()) {
+		return Record{}, false
+	}
+	buf := rg.data[((rg.next-1-count)%rg.capacity())*recordSize:]
+	return Record{
+		Time:     time.Unix(0, int64(binary.LittleEndian.Uint64(buf[0:]))),
+		Sent:     int64(binary.LittleEndian.Uint64(buf[8:])),
+		Received: int64(binary.LittleEndian.Uint64(buf[16:])),
+	}, true
+}
+
+// snapshot returns the records in the ring, oldest first.
+func (rg *ring) snapshot() []Record {
+	var records []Record
+	for count := min(rg.next, rg.capacity()) - 1; count >= 0; count-- {
+		rec, _ := rg.ago(count)
+		records = append(records, rec)
+	}
+	return records
+}
+
+// close releases the ring.
+func (rg *ring) close() error {
+	return rg.unmap()
+}
@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build linux
+
+package flightrec
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// mapFile maps size bytes of the file at path, which we create or truncate,
+// into memory and returns them along with the function unmapping them and
+// removing the file.
+func mapFile(path string, size int) ([]byte, func() error, error) {
+	fp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fp.Close()
+	if err := fp.Truncate(int64(size)); err != nil {
+		return nil, nil, err
+	}
+	data, err := syscall.Mmap(int(fp.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("mmap", err)
+	}
+	unmap := func() error {
+		return errors.Join(syscall.Munmap(data), os.Remove(path))
+	}
+	return data, unmap, nil
+}
@@ -0,0 +1,11 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build !linux
+
+package flightrec
+
+// mapFile allocates size bytes in memory, since we only map the ring
+// buffer into a file on Linux, and ignores path.
+func mapFile(path string, size int) ([]byte, func() error, error) {
+	return make([]byte, size), func() error { return nil }, nil
+}
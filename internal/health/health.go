@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package health implements the liveness and readiness endpoints of the
+// servers, which orchestrators and load balancers poll to decide whether
+// to restart a server and whether to route new clients to it.
+package health
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/admission"
+)
+
+// CertificateFunc returns the certificate the server presents.
+type CertificateFunc func() (*x509.Certificate, error)
+
+// FileCertificate returns a [CertificateFunc] returning the first certificate
+// in the given PEM file, which we parse once, since the server also loads
+// its certificate once at startup.
+func FileCertificate(path string) CertificateFunc {
+	return sync.OnceValues(func() (*x509.Certificate, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				return nil, fmt.Errorf("%s: no PEM certificates", path)
+			}
+			if block.Type == "CERTIFICATE" {
+				return x509.ParseCertificate(block.Bytes)
+			}
+		}
+	})
+}
+
+// TLSCertificate returns a [CertificateFunc] obtaining the certificate for
+// serverName from config.GetCertificate, which is how we check certificates
+// obtained using ACME, which may change over time.
+func TLSCertificate(config *tls.Config, serverName string) CertificateFunc {
+	return func() (*x509.Certificate, error) {
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+		if err != nil {
+			return nil, err
+		}
+		if cert.Leaf != nil {
+			return cert.Leaf, nil
+		}
+		if len(cert.Certificate) <= 0 {
+			return nil, errors.New("empty certificate chain")
+		}
+		return x509.ParseCertificate(cert.Certificate[0])
+	}
+}
+
+// Checker implements the health and readiness endpoints.
+//
+// Construct using [New].
+type Checker struct {
+	adm         *admission.Controller
+	certificate CertificateFunc
+	listening   atomic.Bool
+}
+
+// New constructs a new [*Checker] checking the given certificate and the
+// capacity headroom of the given admission controller, which may be nil.
+func New(adm *admission.Controller, certificate CertificateFunc) *Checker {
+	return &Checker{adm: adm, certificate: certificate}
+}
+
+// Register registers the /healthz and /readyz endpoints with mux.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", c.handleHealth)
+	mux.HandleFunc("GET /readyz", c.handleReady)
+}
+
+// Listen listens on the given TCP address and returns a listener that makes
+// the server unready once closed (e.g., when shutting down).
+func (c *Checker) Listen(network, address string) (net.Listener, error) {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	c.listening.Store(true)
+	return &listener{Listener: ln, c: c}, nil
+}
+
+// listener is the [net.Listener] returned by [*Checker.Listen].
+type listener struct {
+	net.Listener
+	c *Checker
+}
+
+// Close implements [net.Listener].
+func (ln *listener) Close() error {
+	ln.c.listening.Store(false)
+	return ln.Listener.Close()
+}
+
+// handleHealth tells that the process is alive, which is true as long as
+// it is able to answer, so orchestrators only restart a wedged server.
+func (c *Checker) handleHealth(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.WriteHeader(http.StatusOK)
+	fmt.Fprintln(rw, "ok")
+}
+
+// check is the result of a readiness check.
+type check struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// handleReady tells whether the server should receive new clients, which
+// is the case when it is listening, its certificate is valid, and it has
+// capacity for another transfer. We reply with 503 otherwise.
+func (c *Checker) handleReady(rw http.ResponseWriter, req *http.Request) {
+	checks := map[string]check{
+		"capacity":    c.checkCapacity(),
+		"certificate": c.checkCertificate(time.Now()),
+		"listener":    c.checkListener(),
+	}
+	status, ready := http.StatusOK, "ready"
+	for _, entry := range checks {
+		if !entry.OK {
+			status, ready = http.StatusServiceUnavailable, "unready"
+		}
+	}
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(map[string]any{"status": ready, "checks": checks})
+}
+
+// checkCapacity checks that we can admit another transfer right away.
+func (c *Checker) checkCapacity() check {
+	capacity, inUse := c.adm.Capacity(), c.adm.InUse()
+	if capacity <= 0 {
+		return check{OK: true, Detail: fmt.Sprintf("%d transfers, no limit", inUse)}
+	}
+	detail := fmt.Sprintf("%d/%d transfers", inUse, capacity)
+	return check{OK: inUse < capacity, Detail: detail}
+}
+
+// checkCertificate checks that the certificate is valid at the given time.
+func (c *Checker) checkCertificate(now time.Time) check {
+	cert, err := c.certificate()
+	switch {
+	case err != nil:
+		return check{Detail: err.Error()}
+	case now.Before(cert.NotBefore):
+		return check{Detail: fmt.Sprintf("not valid before %s", cert.NotBefore.Format(time.RFC3339))}
+	case now.After(cert.NotAfter):
+		return check{Detail: fmt.Sprintf("expired at %s", cert.NotAfter.Format(time.RFC3339))}
+	default:
+		return check{OK: true, Detail: fmt.Sprintf("valid until %s", cert.NotAfter.Format(time.RFC3339))}
+	}
+}
+
+// checkListener checks that we are accepting connections.
+func (c *Checker) checkListener() check {
+	if !c.listening.Load() {
+		return check{Detail: "not listening"}
+	}
+	return check{OK: true, Detail: "listening"}
+}
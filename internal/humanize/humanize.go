@@ -6,7 +6,11 @@
 
 package humanize
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 // IEC formats a value using IEC (base-1024) prefixes.
 func IEC(value float64, unit string) string {
@@ -35,3 +39,54 @@ func SI(value float64, unit string) string {
 		return fmt.Sprintf("%.0f %s", value, unit)
 	}
 }
+
+// ParseRate parses a rate using the tc units (e.g., "80mbit") and
+// returns the corresponding value in bit/s.
+func ParseRate(value string) (float64, error) {
+	lower := strings.ToLower(strings.TrimSpace(value))
+	for _, suffix := range []struct {
+		s string
+		m float64
+	}{
+		{"gbit", 1e9},
+		{"mbit", 1e6},
+		{"kbit", 1e3},
+		{"bit", 1},
+	} {
+		if numStr, ok := strings.CutSuffix(lower, suffix.s); ok {
+			num, err := strconv.ParseFloat(numStr, 64)
+			if err != nil || num < 0 {
+				return 0, fmt.Errorf("invalid rate %q", value)
+			}
+			return num * suffix.m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid rate %q: missing unit", value)
+}
+
+// ParseSize parses a size using SI or IEC prefixes (e.g., "500MB" or
+// "5GiB") and returns the corresponding number of bytes.
+func ParseSize(value string) (int64, error) {
+	trimmed := strings.TrimSpace(value)
+	for _, suffix := range []struct {
+		s string
+		m float64
+	}{
+		{"GiB", 1 << 30},
+		{"MiB", 1 << 20},
+		{"KiB", 1 << 10},
+		{"GB", 1e9},
+		{"MB", 1e6},
+		{"kB", 1e3},
+		{"B", 1},
+	} {
+		if numStr, ok := strings.CutSuffix(trimmed, suffix.s); ok {
+			num, err := strconv.ParseFloat(numStr, 64)
+			if err != nil || num < 0 {
+				return 0, fmt.Errorf("invalid size %q", value)
+			}
+			return int64(num * suffix.m), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid size %q: missing unit", value)
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package middlebox checks for middleboxes interfering with the connection
+// to the measurement server, in the spirit of the OONI checks looking for
+// proxies that manipulate the HTTP headers.
+//
+// A middlebox terminating the connection in place of the server, such as
+// a TLS-intercepting proxy whose root certificate the client trusts, tends
+// to negotiate a different ALPN or TLS version, to replace the Server header,
+// and to send packets with another initial TTL than the server.
+package middlebox
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/2026-02-provlima/internal/tcpinfo"
+)
+
+// maxTTL is the initial TTL of Linux, where the servers run, so packets
+// arriving with a larger TTL come from a device using another initial TTL
+// (e.g., 128 or 255) rather than from the server.
+const maxTTL = 64
+
+// Expected contains the values we expect without middleboxes.
+type Expected struct {
+	// ALPN is the protocol the server negotiates (e.g., "h2").
+	ALPN string
+
+	// Server is the Server header the server sends (e.g., "ndt8").
+	Server string
+
+	// TLSVersion is the TLS version the server negotiates.
+	TLSVersion uint16
+}
+
+// Check compares with exp the TLS state of the connection to the server,
+// the header of a response received over it, and the TTL of the packets
+// conn received, when the kernel tells it (see [tcpinfo.TTL]). It returns
+// the checks and whether any of them failed, which it also logs.
+func Check(exp Expected, state *tls.ConnectionState, header http.Header, conn net.Conn) ([]results.MiddleboxCheck, bool) {
+	var checks []results.MiddleboxCheck
+	add := func(name, expected, observed string, ok bool) {
+		checks = append(checks, results.MiddleboxCheck{Name: name, Expected: expected, Observed: observed, OK: ok})
+	}
+	if state != nil {
+		add("alpn", exp.ALPN, state.NegotiatedProtocol, state.NegotiatedProtocol == exp.ALPN)
+		add("tlsVersion", tls.VersionName(exp.TLSVersion), tls.VersionName(state.Version), state.Version == exp.TLSVersion)
+	}
+	server := header.Get("Server")
+	add("server", exp.Server, server, server == exp.Server)
+	if conn != nil {
+		if ttl, err := tcpinfo.TTL(conn); err == nil {
+			add("ttl", "<="+strconv.Itoa(maxTTL), strconv.Itoa(ttl), ttl <= maxTTL)
+		}
+	}
+
+	suspected := false
+	for _, check := range checks {
+		if !check.OK {
+			suspected = true
+			slog.Warn("possible middlebox",
+				slog.String("check", check.Name),
+				slog.String("expected", check.Expected),
+				slog.String("observed", check.Observed),
+			)
+		}
+	}
+	return checks, suspected
+}
@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+	"github.com/bassosimone/2026-02-provlima/internal/threshold"
+)
+
+// alertTimeout bounds the time spent delivering each alert.
+const alertTimeout = 30 * time.Second
+
+// These are the values of [Alert] Reason.
+const (
+	// AlertThreshold indicates that a result violated the assertions.
+	AlertThreshold = "threshold"
+
+	// AlertFailures indicates too many consecutive failed measurements.
+	AlertFailures = "failures"
+)
+
+// Alert is what we POST to the webhook and write to the standard input
+// of the command, as JSON, when a detector fires.
+type Alert struct {
+	// Reason is [AlertThreshold] or [AlertFailures].
+	Reason string `json:"reason"`
+
+	// Message describes the violated assertions or the last failure.
+	Message string `json:"message"`
+
+	// Run is the number of the measurement that fired the alert.
+	Run int `json:"run"`
+
+	// Failures is the number of consecutive failed measurements.
+	Failures int `json:"failures,omitempty"`
+
+	// Time is the time when the alert fired.
+	Time time.Time `json:"time"`
+
+	// Document is the offending result document, if any.
+	Document *results.Document `json:"document,omitempty"`
+}
+
+// Alerter fires an [Alert] when a result violates the assertions or when
+// the measurements fail too many times in a row, delivering it to the
+// webhook, the command, or both.
+//
+// Construct using [NewAlerter].
+type Alerter struct {
+	assertions  []threshold.Assertion
+	client      *http.Client
+	command     string
+	failures    int
+	maxFailures int
+	webhook     string
+}
+
+// NewAlerter constructs a new [*Alerter] checking the results against the
+// assertions in spec (see [threshold.Parse]), if not empty, and firing
+// once maxFailures measurements in a row failed, if positive. It POSTs
+// the alerts to the HTTP or HTTPS webhook URL, if not empty, and runs
+// command using the shell, if not empty. It returns nil, which is a valid
+// [*Alerter] that never fires, when there is nothing to check.
+func NewAlerter(spec string, maxFailures int, webhook, command string) (*Alerter, error) {
+	assertions, err := threshold.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if maxFailures < 0 {
+		return nil, errors.New("monitor: the maximum failures must not be negative")
+	}
+	if webhook != "" {
+		u, err := url.Parse(webhook)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("monitor: %s: not an HTTP or HTTPS URL", webhook)
+		}
+	}
+	hasDetectors := len(assertions) > 0 || maxFailures > 0
+	hasActions := webhook != "" || command != ""
+	switch {
+	case hasDetectors && !hasActions:
+		return nil, errors.New("monitor: alerts need a webhook or a command")
+	case !hasDetectors && hasActions:
+		return nil, errors.New("monitor: alerts need assertions or a maximum number of failures")
+	case !hasDetectors:
+		return nil, nil
+	}
+	return &Alerter{
+		assertions:  assertions,
+		client:      &http.Client{Timeout: alertTimeout},
+		command:     command,
+		maxFailures: maxFailures,
+		webhook:     webhook,
+	}, nil
+}
+
+// Observe checks the outcome of the given run, i.e., the result document,
+// if any, and the error, if the measurement failed, and fires the alerts.
+// Since delivering is best effort, we only log the delivery failures.
+func (a *Alerter) Observe(ctx context.Context, run int, doc *results.Document, err error) {
+	if a == nil || ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		a.failures++
+		// Fire once per streak, when reaching the maximum.
+		if a.maxFailures > 0 && a.failures == a.maxFailures {
+			a.fire(ctx, &Alert{Reason: AlertFailures, Message: err.Error(), Run: run,
+				Failures: a.failures, Time: time.Now(), Document: doc})
+		}
+		return
+	}
+	a.failures = 0
+	if doc == nil || doc.Status != results.StatusComplete || len(a.assertions) <= 0 {
+		return
+	}
+	if err := threshold.CheckAll(a.assertions, doc); err != nil {
+		a.fire(ctx, &Alert{Reason: AlertThreshold, Message: err.Error(), Run: run,
+			Time: time.Now(), Document: doc})
+	}
+}
+
+// fire delivers alert.
+func (a *Alerter) fire(ctx context.Context, alert *Alert) {
+	slog.Warn("alert", slog.String("reason", alert.Reason), slog.Int("run", alert.Run),
+		slog.String("message", alert.Message))
+	body, err := json.Marshal(alert)
+	if err != nil {
+		slog.Warn("cannot serialize the alert", slog.Any("err", err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, alertTimeout)
+	defer cancel()
+	if a.webhook != "" {
+		if err := a.post(ctx, body); err != nil {
+			slog.Warn("cannot deliver the alert to the webhook", slog.Any("err", err))
+		}
+	}
+	if a.command != "" {
+		if err := a.run(ctx, body); err != nil {
+			slog.Warn("cannot deliver the alert to the command", slog.Any("err", err))
+		}
+	}
+}
+
+// post POSTs body to the webhook.
+func (a *Alerter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", a.webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("monitor: unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// run runs the command, writing body to its standard input.
+func (a *Alerter) run(ctx context.Context, body []byte) error {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", a.command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package monitor contains the building blocks of the monitor commands, which
+// turn the clients into continuous connection monitors by measuring on a
+// schedule and appending the result documents to a rolling NDJSON file.
+//
+// Each measurement runs as a `measure` child process, so that a failed
+// measurement, which exits the process, does not stop the monitor.
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+// waitDelay is how long we wait for an interrupted measurement to write
+// its partial document before killing it.
+const waitDelay = 30 * time.Second
+
+// Measure runs the `measure` command of exe with the given args and returns
+// the result document, if any, and the error, if the measurement failed,
+// whose [failure.Class] is the one of the exit code.
+func Measure(ctx context.Context, exe string, args []string) (*results.Document, error) {
+	tmp, err := os.CreateTemp("", "monitor-*.json")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	argv := append([]string{"measure"}, args...)
+	argv = append(argv, "-o", tmp.Name())
+	cmd := exec.CommandContext(ctx, exe, argv...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Let the measurement write what it collected when interrupted.
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = waitDelay
+	runErr := cmd.Run()
+	if exitErr := (*exec.ExitError)(nil); errors.As(runErr, &exitErr) && exitErr.ExitCode() > 0 {
+		runErr = failure.New(failure.Class(exitErr.ExitCode()), fmt.Errorf("measure: %w", runErr))
+	}
+
+	// Note: measurements failing before the end write no document
+	doc, err := results.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, runErr
+	}
+	return doc, runErr
+}
+
+// AppendNDJSON appends doc as a line to the file at path, which we first
+// rename to path.1 when the line would make it larger than rotateSize.
+func AppendNDJSON(path string, rotateSize int64, doc *results.Document) error {
+	line, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if info, err := os.Stat(path); err == nil && rotateSize > 0 && info.Size()+int64(len(line)) > rotateSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return fmt.Errorf("cannot rotate %s: %w", path, err)
+		}
+		slog.Info("output rotated", slog.String("output", path))
+	}
+	fp, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(line); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// ResumedHandshakes returns how many of the TLS handshakes in doc resumed
+// a previous session, which tells whether resuming (see the --tls-cache
+// flag of the measure commands) works.
+func ResumedHandshakes(doc *results.Document) int {
+	var count int
+	for _, handshake := range doc.TLSHandshakes {
+		if handshake.Resumed {
+			count++
+		}
+	}
+	return count
+}
+
+// Next returns when to start the measurement following the one started at
+// start, which is after interval plus a random delay up to the jitter
+// fraction of the interval, so that many monitors started together do not
+// load the server at the same time.
+func Next(start time.Time, interval time.Duration, jitter float64) time.Time {
+	return start.Add(interval + time.Duration(rand.Float64()*jitter*float64(interval)))
+}
+
+// SleepUntil sleeps until deadline and returns true, or returns false as
+// soon as ctx is done.
+func SleepUntil(ctx context.Context, deadline time.Time) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+// Usage is the data volume the measurements used during the current day
+// and month, in local time, which we persist to enforce data volume quotas
+// on metered connections across restarts of the monitor.
+type Usage struct {
+	// Day is the current day (e.g., "2026-10-16").
+	Day string `json:"day"`
+
+	// DayBytes is the volume used during Day.
+	DayBytes int64 `json:"dayBytes"`
+
+	// Month is the current month (e.g., "2026-10").
+	Month string `json:"month"`
+
+	// MonthBytes is the volume used during Month.
+	MonthBytes int64 `json:"monthBytes"`
+
+	// LastBytes is the volume of the last measurement, which estimates the
+	// volume of the next one.
+	LastBytes int64 `json:"lastBytes"`
+}
+
+// LoadUsage loads the [Usage] stored at path, returning an empty [Usage]
+// when the file does not exist.
+func LoadUsage(path string) (*Usage, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Usage{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var u Usage
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &u, nil
+}
+
+// Save atomically stores u at path.
+func (u *Usage) Save(path string) error {
+	data, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// roll resets the counters when the day or the month of now differ from
+// the ones of u.
+func (u *Usage) roll(now time.Time) {
+	if day := now.Format(time.DateOnly); day != u.Day {
+		u.Day, u.DayBytes = day, 0
+	}
+	if month := now.Format("2006-01"); month != u.Month {
+		u.Month, u.MonthBytes = month, 0
+	}
+}
+
+// Add accounts for a measurement that used count bytes at now.
+func (u *Usage) Add(now time.Time, count int64) {
+	u.roll(now)
+	u.DayBytes += count
+	u.MonthBytes += count
+	u.LastBytes = count
+}
+
+// Check returns an error when a measurement as large as the last one would
+// exceed the daily or monthly quota at now, where zero means no quota.
+func (u *Usage) Check(now time.Time, daily, monthly int64) error {
+	u.roll(now)
+	if daily > 0 && u.DayBytes+u.LastBytes > daily {
+		return fmt.Errorf("daily quota exhausted: used %s of %s",
+			humanize.IEC(float64(u.DayBytes), "B"), humanize.IEC(float64(daily), "B"))
+	}
+	if monthly > 0 && u.MonthBytes+u.LastBytes > monthly {
+		return fmt.Errorf("monthly quota exhausted: used %s of %s",
+			humanize.IEC(float64(u.MonthBytes), "B"), humanize.IEC(float64(monthly), "B"))
+	}
+	return nil
+}
+
+// DocumentBytes returns the bytes the client transferred according to the
+// samples it collected in doc, ignoring the protocol overhead. Since the
+// bytes restart from zero with each chunk, we sum the last bytes of each
+// chunk (or test) of each direction.
+func DocumentBytes(doc *results.Document) int64 {
+	var total int64
+	last := map[string]int64{}
+	for _, s := range doc.Samples {
+		if s.Origin != results.OriginClient {
+			continue
+		}
+		if s.Bytes < last[s.Direction] {
+			total += last[s.Direction]
+		}
+		last[s.Direction] = s.Bytes
+	}
+	for _, count := range last {
+		total += count
+	}
+	return total
+}
@@ -0,0 +1,440 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package otlp exports traces and metrics to an OpenTelemetry collector
+// using OTLP over HTTP with the JSON encoding, which is what we need when
+// embedding the clients and servers into larger observability setups.
+//
+// We implement the small subset of OTLP we use with the standard library
+// rather than depending on the OpenTelemetry SDK: spans with attributes,
+// monotonic counters, and W3C Trace Context propagation.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPendingSpans is the number of ended spans above which we drop the
+// new ones until the next flush, so a missing collector costs bounded memory.
+const maxPendingSpans = 1 << 16
+
+// scopeName is the instrumentation scope of the spans and metrics.
+const scopeName = "github.com/bassosimone/2026-02-provlima"
+
+// These are the span kinds defined by OTLP.
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// Attr is a span or metric attribute.
+type Attr struct {
+	Key   string
+	Value any // string, int64, float64, or bool
+}
+
+// String returns a string [Attr].
+func String(key, value string) Attr {
+	return Attr{key, value}
+}
+
+// Int64 returns an integer [Attr].
+func Int64(key string, value int64) Attr {
+	return Attr{key, value}
+}
+
+// Bool returns a boolean [Attr].
+func Bool(key string, value bool) Attr {
+	return Attr{key, value}
+}
+
+// Float64 returns a floating point [Attr].
+func Float64(key string, value float64) Attr {
+	return Attr{key, value}
+}
+
+// MarshalJSON implements [json.Marshaler] using the OTLP KeyValue encoding.
+func (a Attr) MarshalJSON() ([]byte, error) {
+	var value map[string]any
+	switch v := a.Value.(type) {
+	case string:
+		value = map[string]any{"stringValue": v}
+	case int64:
+		// OTLP JSON encodes 64-bit integers as strings.
+		value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		value = map[string]any{"doubleValue": v}
+	case bool:
+		value = map[string]any{"boolValue": v}
+	default:
+		value = map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+	return json.Marshal(map[string]any{"key": a.Key, "value": value})
+}
+
+// Exporter buffers spans and counters and periodically exports them.
+//
+// Construct using [New]. The nil exporter discards everything, so callers
+// do not need to check whether telemetry is enabled.
+type Exporter struct {
+	client   *http.Client
+	counters map[string]*counter // name and attributes → counter
+	endpoint *url.URL
+	mu       sync.Mutex
+	resource []Attr
+	spans    []*Span
+	start    time.Time
+}
+
+// counter is a monotonic cumulative counter.
+type counter struct {
+	name  string
+	unit  string
+	attrs []Attr
+	value int64
+}
+
+// New constructs a new [*Exporter] exporting to the OTLP/HTTP collector at
+// the given base URL (e.g., http://localhost:4318) on behalf of the given
+// service. It returns nil, which discards everything, when endpoint is empty.
+func New(endpoint, service string) (*Exporter, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("otlp: the endpoint must be an HTTP(S) URL: %q", endpoint)
+	}
+	exp := &Exporter{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		counters: make(map[string]*counter),
+		endpoint: u,
+		resource: []Attr{String("service.name", service)},
+		start:    time.Now(),
+	}
+	return exp, nil
+}
+
+// Add adds value to the counter with the given name, unit (e.g., "By"),
+// and attributes, creating it if needed.
+func (e *Exporter) Add(name, unit string, value int64, attrs ...Attr) {
+	if e == nil {
+		return
+	}
+	key := name
+	for _, attr := range attrs {
+		key += fmt.Sprintf("\x00%s=%v", attr.Key, attr.Value)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c, found := e.counters[key]
+	if !found {
+		c = &counter{name: name, unit: unit, attrs: attrs}
+		e.counters[key] = c
+	}
+	c.value += value
+}
+
+// Run flushes every interval until ctx is done, logging failures.
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	if e == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Flush(ctx); err != nil {
+				slog.Warn("cannot export telemetry", slog.Any("err", err))
+			}
+		}
+	}
+}
+
+// Flush exports the ended spans and the current value of the counters.
+// The spans are dropped even when the export fails, while the counters
+// are cumulative, so the next successful export catches up.
+func (e *Exporter) Flush(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	var metrics []map[string]any
+	now := time.Now()
+	for _, c := range e.counters {
+		metrics = append(metrics, map[string]any{
+			"name": c.name,
+			"unit": c.unit,
+			"sum": map[string]any{
+				"aggregationTemporality": 2, // cumulative
+				"isMonotonic":            true,
+				"dataPoints": []map[string]any{{
+					"attributes":        attrsOrEmpty(c.attrs),
+					"startTimeUnixNano": unixNano(e.start),
+					"timeUnixNano":      unixNano(now),
+					"asInt":             strconv.FormatInt(c.value, 10),
+				}},
+			},
+		})
+	}
+	e.mu.Unlock()
+
+	scope := map[string]string{"name": scopeName}
+	resource := map[string]any{"attributes": e.resource}
+	var errs []error
+	if len(spans) > 0 {
+		errs = append(errs, e.post(ctx, "/v1/traces", map[string]any{
+			"resourceSpans": []map[string]any{{
+				"resource":   resource,
+				"scopeSpans": []map[string]any{{"scope": scope, "spans": spans}},
+			}},
+		}))
+	}
+	if len(metrics) > 0 {
+		errs = append(errs, e.post(ctx, "/v1/metrics", map[string]any{
+			"resourceMetrics": []map[string]any{{
+				"resource":     resource,
+				"scopeMetrics": []map[string]any{{"scope": scope, "metrics": metrics}},
+			}},
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// post sends the given OTLP request to the given path of the endpoint.
+func (e *Exporter) post(ctx context.Context, path string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	u := e.endpoint.JoinPath(path)
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("otlp: %s: unexpected status: %s", u, resp.Status)
+	}
+	return nil
+}
+
+// attrsOrEmpty returns attrs or, when nil, an empty slice, since OTLP
+// collectors may reject null attributes.
+func attrsOrEmpty(attrs []Attr) []Attr {
+	if attrs == nil {
+		return []Attr{}
+	}
+	return attrs
+}
+
+// unixNano formats t as OTLP JSON expects, i.e., nanoseconds as a string.
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// Span is an operation we trace.
+//
+// Construct using [*Exporter.Start]. The nil span ignores everything.
+type Span struct {
+	exp      *Exporter
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []Attr
+	failure  string
+	mu       sync.Mutex
+}
+
+// spanKey is the context key for the current [*Span].
+type spanKey struct{}
+
+// remoteKey is the context key for the span context received from a peer.
+type remoteKey struct{}
+
+// spanContext identifies a span across processes.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// Start starts a span with the given name and kind that is the child of
+// the span in ctx, if any, or of the remote span extracted using [Extract],
+// and returns a context containing the new span.
+func (e *Exporter) Start(ctx context.Context, name string, kind int, attrs ...Attr) (context.Context, *Span) {
+	if e == nil {
+		return ctx, nil
+	}
+	span := &Span{exp: e, name: name, kind: kind, start: time.Now(), attrs: attrs}
+	switch {
+	case SpanFromContext(ctx) != nil:
+		parent := SpanFromContext(ctx)
+		span.traceID, span.parentID = parent.traceID, parent.spanID
+	case ctx.Value(remoteKey{}) != nil:
+		remote := ctx.Value(remoteKey{}).(spanContext)
+		span.traceID, span.parentID = remote.traceID, remote.spanID
+	default:
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Start starts a span like [*Exporter.Start] using the exporter of the
+// span in ctx, so nested operations need not know about the exporter. It
+// returns a nil span, which ignores everything, when ctx has no span.
+func Start(ctx context.Context, name string, kind int, attrs ...Attr) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.exp.Start(ctx, name, kind, attrs...)
+}
+
+// Add adds value to a counter like [*Exporter.Add] using the exporter of
+// the span in ctx, if any.
+func Add(ctx context.Context, name, unit string, value int64, attrs ...Attr) {
+	if parent := SpanFromContext(ctx); parent != nil {
+		parent.exp.Add(name, unit, value, attrs...)
+	}
+}
+
+// ContextWithSpan returns a context containing span, which allows starting
+// children of a span that outlives the request (e.g., a session).
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span in ctx or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SetAttrs adds attributes to the span.
+func (s *Span) SetAttrs(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// End ends the span, marking it as failed when err is not nil, and queues
+// it for export. Ending a span more than once has no effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	if err != nil {
+		s.failure = err.Error()
+	}
+	s.mu.Unlock()
+
+	s.exp.mu.Lock()
+	defer s.exp.mu.Unlock()
+	if len(s.exp.spans) < maxPendingSpans {
+		s.exp.spans = append(s.exp.spans, s)
+	}
+}
+
+// MarshalJSON implements [json.Marshaler] using the OTLP Span encoding.
+func (s *Span) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]any{
+		"traceId":           hex.EncodeToString(s.traceID[:]),
+		"spanId":            hex.EncodeToString(s.spanID[:]),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": unixNano(s.start),
+		"endTimeUnixNano":   unixNano(s.end),
+		"attributes":        attrsOrEmpty(s.attrs),
+		"status":            map[string]any{"code": 1}, // ok
+	}
+	if s.parentID != [8]byte{} {
+		out["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	if s.failure != "" {
+		out["status"] = map[string]any{"code": 2, "message": s.failure} // error
+	}
+	return json.Marshal(out)
+}
+
+// Inject sets the W3C traceparent header of the span in ctx, if any, so the
+// peer can make its spans children of ours.
+func Inject(ctx context.Context, header http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		header.Set("traceparent", fmt.Sprintf("00-%x-%x-01", span.traceID, span.spanID))
+	}
+}
+
+// Extract returns a context containing the span context in the W3C
+// traceparent header, if valid, which [*Exporter.Start] uses as the parent.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	fields := strings.Split(header.Get("traceparent"), "-")
+	if len(fields) != 4 || fields[0] != "00" {
+		return ctx
+	}
+	var sc spanContext
+	traceID, err1 := hex.DecodeString(fields[1])
+	spanID, err2 := hex.DecodeString(fields[2])
+	if err1 != nil || err2 != nil || len(traceID) != len(sc.traceID) || len(spanID) != len(sc.spanID) {
+		return ctx
+	}
+	copy(sc.traceID[:], traceID)
+	copy(sc.spanID[:], spanID)
+	if sc.traceID == [16]byte{} || sc.spanID == [8]byte{} {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Extracted returns whether ctx contains the span context of a peer,
+// which [Extract] adds when the traceparent header is valid.
+func Extracted(ctx context.Context) bool {
+	_, found := ctx.Value(remoteKey{}).(spanContext)
+	return found
+}
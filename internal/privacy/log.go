@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package privacy
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/bassosimone/2026-02-provlima/internal/failure"
+)
+
+// addrKeys are the log attribute keys whose values are addresses.
+var addrKeys = map[string]bool{
+	"address":    true,
+	"client":     true,
+	"clientAddr": true,
+	"remote":     true,
+	"remoteAddr": true,
+}
+
+// Setup makes the default logger, and the final error written by the
+// failure package, anonymize the addresses and hostnames according to p,
+// so call it after configuring the logger.
+func (p *Policy) Setup() {
+	if p.keepsAll() {
+		return
+	}
+	slog.SetDefault(slog.New(&handler{next: slog.Default().Handler(), p: p}))
+	failure.Redact(p.Text)
+}
+
+// handler is the [slog.Handler] installed by [*Policy.Setup].
+type handler struct {
+	next slog.Handler
+	p    *Policy
+}
+
+// Enabled implements [slog.Handler].
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle implements [slog.Handler].
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	out := slog.NewRecord(record.Time, record.Level, h.p.Text(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		out.AddAttrs(h.p.attr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+// WithAttrs implements [slog.Handler].
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		out = append(out, h.p.attr(attr))
+	}
+	return &handler{next: h.next.WithAttrs(out), p: h.p}
+}
+
+// WithGroup implements [slog.Handler].
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{next: h.next.WithGroup(name), p: h.p}
+}
+
+// attr anonymizes attr according to p, treating the values of [addrKeys],
+// "hostname", and "url" as such, and the other strings, string slices, and
+// errors as free text.
+func (p *Policy) attr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindGroup:
+		var attrs []slog.Attr
+		for _, child := range value.Group() {
+			attrs = append(attrs, p.attr(child))
+		}
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(attrs...)}
+	case slog.KindString:
+		switch {
+		case addrKeys[attr.Key]:
+			return slog.String(attr.Key, p.Addr(value.String()))
+		case attr.Key == "hostname":
+			return slog.String(attr.Key, p.Hostname(value.String()))
+		case attr.Key == "url":
+			return slog.String(attr.Key, p.URL(value.String()))
+		default:
+			return slog.String(attr.Key, p.Text(value.String()))
+		}
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, p.Text(v.Error()))
+		case []string:
+			var values []string
+			for _, entry := range v {
+				if entry = p.Text(entry); entry != "" {
+					values = append(values, entry)
+				}
+			}
+			return slog.Any(attr.Key, values)
+		}
+	}
+	return attr
+}
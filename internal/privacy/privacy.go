@@ -0,0 +1,247 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package privacy anonymizes the IP addresses and the hostnames that we
+// would otherwise persist in the result documents, the server records,
+// and the logs, which is needed before sharing experiment datasets.
+//
+// The [*Policy] either keeps the IP addresses, truncates them to their /24
+// (IPv4) or /48 (IPv6) network, like M-Lab and RIPE Atlas do, or omits
+// them. Independently, it may omit the hostnames.
+package privacy
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+// These are the modes for the IP addresses.
+const (
+	// IPsNone keeps the IP addresses.
+	IPsNone = "none"
+
+	// IPsTruncate truncates the IP addresses to /24 or /48.
+	IPsTruncate = "truncate"
+
+	// IPsOmit omits the IP addresses.
+	IPsOmit = "omit"
+)
+
+// The prefix lengths used by [IPsTruncate].
+const (
+	truncateBits4 = 24
+	truncateBits6 = 48
+)
+
+// omitted replaces addresses and hostnames omitted from free text.
+const omitted = "[omitted]"
+
+// Policy controls how we persist IP addresses and hostnames.
+//
+// Construct using [New]. The nil policy keeps everything.
+type Policy struct {
+	ips           string
+	omitHostnames bool
+
+	// mu protects hostnames.
+	mu sync.Mutex
+
+	// hostnames contains the hostnames we omitted so far, which we also
+	// omit from free text (see [*Policy.Text]).
+	hostnames map[string]struct{}
+}
+
+// New constructs a new [*Policy] using the given mode for the IP addresses
+// ([IPsNone], [IPsTruncate], or [IPsOmit]) and omitting the hostnames when
+// omitHostnames is true.
+func New(ips string, omitHostnames bool) (*Policy, error) {
+	switch ips {
+	case IPsNone, IPsTruncate, IPsOmit:
+	default:
+		return nil, fmt.Errorf("privacy: unknown IP anonymization mode: %q", ips)
+	}
+	p := &Policy{
+		ips:           ips,
+		omitHostnames: omitHostnames,
+		hostnames:     map[string]struct{}{},
+	}
+	return p, nil
+}
+
+// keepsAll returns whether p does not change anything.
+func (p *Policy) keepsAll() bool {
+	return p == nil || (p.ips == IPsNone && !p.omitHostnames)
+}
+
+// IP returns the IP address ip anonymized according to p, or the empty
+// string when p omits it. Strings that are not IP addresses are treated
+// as hostnames (see [*Policy.Hostname]).
+func (p *Policy) IP(ip string) string {
+	if p.keepsAll() {
+		return ip
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return p.Hostname(ip)
+	}
+	switch p.ips {
+	case IPsTruncate:
+		addr = addr.Unmap()
+		bits := truncateBits6
+		if addr.Is4() {
+			bits = truncateBits4
+		}
+		prefix, _ := addr.WithZone("").Prefix(bits)
+		return prefix.Addr().String()
+	case IPsOmit:
+		return ""
+	default:
+		return ip
+	}
+}
+
+// Hostname returns hostname, or the empty string when p omits it.
+func (p *Policy) Hostname(hostname string) string {
+	if p == nil || !p.omitHostnames || hostname == "" {
+		return hostname
+	}
+	p.mu.Lock()
+	p.hostnames[hostname] = struct{}{}
+	p.mu.Unlock()
+	return ""
+}
+
+// Addr returns addr, which is either HOST:PORT or HOST, where HOST is an
+// IP address or a hostname, with HOST anonymized according to p, or the
+// empty string when p omits HOST.
+func (p *Policy) Addr(addr string) string {
+	if p.keepsAll() || addr == "" {
+		return addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return p.IP(addr)
+	}
+	host = p.IP(host)
+	if host == "" {
+		return ""
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// URL returns rawURL with its host anonymized according to p, omitting
+// the host when p omits it, or rawURL itself when we cannot parse it.
+func (p *Policy) URL(rawURL string) string {
+	if p.keepsAll() {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return p.Text(rawURL)
+	}
+	u.Host = p.Addr(u.Host)
+	return u.String()
+}
+
+// ipv4Candidate and ipv6Candidate match what may be an IP address in free
+// text, which we parse to confirm, since they also match, e.g., times.
+var (
+	ipv4Candidate = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}\b`)
+	ipv6Candidate = regexp.MustCompile(`(?i)[0-9a-f]{0,4}(?::[0-9a-f]{0,4}){2,7}`)
+)
+
+// Text anonymizes according to p the IP addresses within text (e.g., an
+// error message), replacing the omitted ones with a placeholder. Since we
+// cannot tell hostnames apart from other words, we only omit those that p
+// already omitted elsewhere (e.g., using [*Policy.Addr]).
+func (p *Policy) Text(text string) string {
+	if p.keepsAll() || text == "" {
+		return text
+	}
+	replace := func(ip string) string {
+		if _, err := netip.ParseAddr(ip); err != nil {
+			return ip
+		}
+		if ip = p.IP(ip); ip == "" {
+			ip = omitted
+		}
+		return ip
+	}
+	text = ipv4Candidate.ReplaceAllStringFunc(text, replace)
+	text = ipv6Candidate.ReplaceAllStringFunc(text, replace)
+	p.mu.Lock()
+	hostnames := slices.Collect(maps.Keys(p.hostnames))
+	p.mu.Unlock()
+	// Replace the longest first, so that, e.g., example.org does not
+	// leave the www of www.example.org behind.
+	slices.SortFunc(hostnames, func(a, b string) int { return len(b) - len(a) })
+	for _, hostname := range hostnames {
+		text = strings.ReplaceAll(text, hostname, omitted)
+	}
+	return text
+}
+
+// Document anonymizes in place the addresses and the hostnames that doc
+// contains according to p, including those within the failures.
+func (p *Policy) Document(doc *results.Document) {
+	if p.keepsAll() || doc == nil {
+		return
+	}
+	// Anonymize the addresses first, so we know the hostnames to omit
+	// from the failures.
+	doc.ClientAddr = p.Addr(doc.ClientAddr)
+	for idx := range doc.DNSLookups {
+		lookup := &doc.DNSLookups[idx]
+		lookup.Hostname = p.Hostname(lookup.Hostname)
+		var addresses []string
+		for _, address := range lookup.Addresses {
+			if address = p.IP(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+		lookup.Addresses = addresses
+	}
+	for idx := range doc.Connections {
+		info := &doc.Connections[idx]
+		info.Client = p.Addr(info.Client)
+		info.Server = p.Addr(info.Server)
+	}
+	for idx := range doc.Dials {
+		dial := &doc.Dials[idx]
+		dial.Address = p.Addr(dial.Address)
+		dial.RemoteAddr = p.Addr(dial.RemoteAddr)
+	}
+	for idx := range doc.DNSLookups {
+		doc.DNSLookups[idx].Failure = p.Text(doc.DNSLookups[idx].Failure)
+	}
+	for idx := range doc.Dials {
+		doc.Dials[idx].Failure = p.Text(doc.Dials[idx].Failure)
+	}
+	for idx := range doc.Probes {
+		doc.Probes[idx].Failure = p.Text(doc.Probes[idx].Failure)
+	}
+	for idx := range doc.IdleProbes {
+		doc.IdleProbes[idx].Failure = p.Text(doc.IdleProbes[idx].Failure)
+	}
+	for idx := range doc.PageLoads {
+		doc.PageLoads[idx].Failure = p.Text(doc.PageLoads[idx].Failure)
+	}
+	for idx := range doc.Videos {
+		doc.Videos[idx].Failure = p.Text(doc.Videos[idx].Failure)
+	}
+	for idx := range doc.Interruptions {
+		doc.Interruptions[idx].Failure = p.Text(doc.Interruptions[idx].Failure)
+	}
+	// Proxies may name themselves, or the next hop, in the Via header.
+	for idx := range doc.Intermediaries {
+		doc.Intermediaries[idx].Via = p.Text(doc.Intermediaries[idx].Via)
+	}
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package problem implements RFC 9457 problem details, which the servers
+// use as the body of their error responses, so that clients can report
+// why a request failed rather than just its status code.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// ContentType is the media type of problem details.
+const ContentType = "application/problem+json"
+
+// maxBodySize is the maximum size of a problem details body we parse.
+const maxBodySize = 1 << 16
+
+// ErrUnexpectedStatus is the error [FromResponse] wraps when the body does
+// not contain problem details.
+var ErrUnexpectedStatus = errors.New("unexpected status")
+
+// These are the problem types, which are relative URI references resolved
+// against the request URL, as RFC 9457 allows, and are stable identifiers
+// that clients can match rather than being meant for dereferencing.
+const (
+	TypeAlteredPayload      = "/problems/altered-payload"
+	TypeBusy                = "/problems/busy"
+	TypeInvalidRequest      = "/problems/invalid-request"
+	TypeNotAcceptable       = "/problems/not-acceptable"
+	TypeOriginNotAllowed    = "/problems/origin-not-allowed"
+	TypeRangeNotSatisfiable = "/problems/range-not-satisfiable"
+	TypeRateLimited         = "/problems/rate-limited"
+	TypeSessionAborted      = "/problems/session-aborted"
+	TypeSessionDeleted      = "/problems/session-deleted"
+	TypeSessionNotFound     = "/problems/session-not-found"
+	TypeUnsupportedProtocol = "/problems/unsupported-protocol"
+	TypeUnsupportedVersion  = "/problems/unsupported-version"
+)
+
+// Details contains the details of a problem and implements error.
+type Details struct {
+	// Type identifies the problem type (e.g., [TypeSessionNotFound]).
+	Type string `json:"type"`
+
+	// Title is the short human-readable summary of the problem type.
+	Title string `json:"title"`
+
+	// Status is the HTTP status code.
+	Status int `json:"status"`
+
+	// Detail is the human-readable explanation of this occurrence.
+	Detail string `json:"detail,omitempty"`
+
+	// Instance identifies this occurrence, which is the request path.
+	Instance string `json:"instance,omitempty"`
+
+	// SessionID is the ndt8 session ID, if any.
+	SessionID string `json:"sessionID,omitempty"`
+
+	// Deleted is when the client deleted the session, if it did.
+	Deleted time.Time `json:"deleted,omitzero"`
+
+	// SupportedVersions contains the protocol versions the server speaks,
+	// when the client used another one.
+	SupportedVersions []int `json:"supportedVersions,omitempty"`
+}
+
+var _ error = &Details{}
+
+// Error implements error.
+func (d *Details) Error() string {
+	if d.Detail == "" {
+		return fmt.Sprintf("%d %s (%s)", d.Status, d.Title, d.Type)
+	}
+	return fmt.Sprintf("%d %s: %s (%s)", d.Status, d.Title, d.Detail, d.Type)
+}
+
+// New constructs problem [*Details] for req using the given status and
+// type, with the title being the status text.
+func New(req *http.Request, status int, ptype, detail string) *Details {
+	return &Details{
+		Type:     ptype,
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: req.URL.Path,
+	}
+}
+
+// WithSession sets the session ID and returns d.
+func (d *Details) WithSession(sid string) *Details {
+	d.SessionID = sid
+	return d
+}
+
+// WithDeleted sets the session deletion time and returns d.
+func (d *Details) WithDeleted(t time.Time) *Details {
+	d.Deleted = t
+	return d
+}
+
+// WithSupportedVersions sets the supported protocol versions and returns d.
+func (d *Details) WithSupportedVersions(versions []int) *Details {
+	d.SupportedVersions = versions
+	return d
+}
+
+// Write writes d as the response using d.Status as the status code.
+func Write(rw http.ResponseWriter, d *Details) {
+	rw.Header().Set("Content-Type", ContentType)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(d.Status)
+	json.NewEncoder(rw).Encode(d)
+}
+
+// FromResponse returns the problem [*Details] in the body of resp or,
+// when the body does not contain them, an error with just the status,
+// so the caller always gets an error describing the failed response. Use
+// [IsResponse] to tell these errors from the ones preventing a response.
+func FromResponse(resp *http.Response) error {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == ContentType {
+		var d Details
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&d); err == nil {
+			if d.Status == 0 {
+				d.Status = resp.StatusCode
+			}
+			return &d
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnexpectedStatus, resp.Status)
+}
+
+// IsResponse returns whether err is an error [FromResponse] returned.
+func IsResponse(err error) bool {
+	var d *Details
+	return errors.As(err, &d) || errors.Is(err, ErrUnexpectedStatus)
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package realip determines the client address of requests forwarded by
+// trusted reverse proxies using the Forwarded (RFC 7239) header or, when
+// it is missing, the X-Forwarded-For header.
+//
+// We walk the chain of forwarding addresses from right to left, skipping
+// trusted proxies, and take the first untrusted address as the client,
+// since clients can forge any entry to the left of it.
+package realip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// Resolver determines the client address of requests.
+//
+// Construct using [New].
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// New constructs a new [*Resolver] trusting the proxies within the
+// given CIDRs (e.g., "10.0.0.0/8"), or single addresses.
+func New(cidrs []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(cidr)
+			if aerr != nil {
+				return nil, err
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	return r, nil
+}
+
+// isTrusted returns whether addr belongs to a trusted proxy.
+func (r *Resolver) isTrusted(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	return slices.ContainsFunc(r.trusted, func(prefix netip.Prefix) bool {
+		return prefix.Contains(ip)
+	})
+}
+
+// ClientAddr returns the client address of req, which is req.RemoteAddr
+// unless the peer is a trusted proxy, in which case it is the address,
+// without port, that the proxies recorded in the forwarding headers.
+func (r *Resolver) ClientAddr(req *http.Request) string {
+	peer, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil || !r.isTrusted(peer) {
+		return req.RemoteAddr
+	}
+	chain := forwardedFor(req.Header)
+	if len(chain) <= 0 {
+		return req.RemoteAddr
+	}
+	for _, addr := range slices.Backward(chain) {
+		if !r.isTrusted(addr) {
+			return addr
+		}
+	}
+	return chain[0] // all trusted, so the leftmost is the client
+}
+
+// forwardedFor returns the forwarding addresses, leftmost first.
+func forwardedFor(header http.Header) (out []string) {
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for element := range strings.SplitSeq(value, ",") {
+				for pair := range strings.SplitSeq(element, ";") {
+					key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+					if strings.EqualFold(key, "for") {
+						out = append(out, stripPort(strings.Trim(value, `"`)))
+					}
+				}
+			}
+		}
+		return
+	}
+	for _, value := range header.Values("X-Forwarded-For") {
+		for addr := range strings.SplitSeq(value, ",") {
+			out = append(out, stripPort(strings.TrimSpace(addr)))
+		}
+	}
+	return
+}
+
+// stripPort removes the port, if any, and the brackets around IPv6
+// addresses, leaving other values (e.g., "unknown") unchanged.
+func stripPort(value string) string {
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+}
+
+// Handler returns an [http.Handler] replacing req.RemoteAddr with the
+// client address determined by r before invoking next.
+func Handler(r *Resolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if addr := r.ClientAddr(req); addr != req.RemoteAddr {
+			req = req.Clone(req.Context())
+			req.RemoteAddr = addr
+		}
+		next.ServeHTTP(rw, req)
+	})
+}
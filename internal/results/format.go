@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package results
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These are the media types in which we can serialize a [*Summary].
+const (
+	FormatCSV  = "text/csv"
+	FormatJSON = "application/json"
+	FormatText = "text/plain"
+)
+
+// formats contains the supported formats in order of preference.
+var formats = []string{FormatJSON, FormatCSV, FormatText}
+
+// NegotiateFormat returns the supported format best matching the value of
+// an Accept header, preferring JSON on ties and when the header is empty.
+// The boolean is false when the client accepts none of them.
+func NegotiateFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return FormatJSON, true
+	}
+	best, bestQ := "", 0.0
+	for _, format := range formats {
+		if q := acceptQuality(accept, format); q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best, best != ""
+}
+
+// acceptQuality returns the quality the Accept header value assigns to
+// format, using the most specific matching media range.
+func acceptQuality(accept, format string) float64 {
+	mainType, _, _ := strings.Cut(format, "/")
+	quality, specificity := 0.0, -1
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		var s int
+		switch mediaRange {
+		case format:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		q := 1.0
+		if value, found := params["q"]; found {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		quality, specificity = q, s
+	}
+	return quality
+}
+
+// summaryColumns contains the CSV columns of [WriteSummary]. Durations are
+// in seconds and throughputs in bit/s, so spreadsheets need no conversion.
+var summaryColumns = []string{
+	"direction",
+	"bytes",
+	"elapsed",
+	"throughput",
+	"warmUp",
+	"variation",
+	"divergence",
+	"truncated",
+	"cpuUsage",
+	"retransmitRate",
+	"clientRetransmits",
+	"serverRetransmits",
+	"loadedLatency",
+	"latencyIncrease",
+	"rpm",
+	"overhead",
+	"wireThroughput",
+	"flags",
+}
+
+// summaryRows returns the rows of summary, one per direction, formatted
+// according to [summaryColumns].
+func summaryRows(summary *Summary) [][]string {
+	var rows [][]string
+	for _, entry := range []struct {
+		direction string
+		ds        *DirectionSummary
+	}{
+		{"download", summary.Download},
+		{"upload", summary.Upload},
+	} {
+		if entry.ds == nil {
+			continue
+		}
+		ds := entry.ds
+		rows = append(rows, []string{
+			entry.direction,
+			strconv.FormatInt(ds.Bytes, 10),
+			strconv.FormatFloat(ds.Elapsed.Seconds(), 'f', -1, 64),
+			strconv.FormatFloat(ds.Throughput, 'f', 0, 64),
+			strconv.FormatFloat(ds.WarmUp.Seconds(), 'f', -1, 64),
+			strconv.FormatFloat(ds.Variation, 'f', -1, 64),
+			strconv.FormatFloat(ds.Divergence, 'f', -1, 64),
+			strconv.FormatBool(ds.Truncated),
+			strconv.FormatFloat(ds.CPUUsage, 'f', -1, 64),
+			strconv.FormatFloat(ds.RetransmitRate, 'f', -1, 64),
+			strconv.FormatInt(ds.ClientRetransmits, 10),
+			strconv.FormatInt(ds.ServerRetransmits, 10),
+			formatLatency(ds.LoadedLatency),
+			formatLatency(ds.LatencyIncrease),
+			formatRPM(ds.RPM),
+			formatKnown(ds.Overhead, -1),
+			formatKnown(ds.WireThroughput, 0),
+			strings.Join(ds.Flags, ";"),
+		})
+	}
+	return rows
+}
+
+// formatLatency formats a latency figure in seconds, or as an empty string
+// when it is unknown, which is zero, since zero would be misleading.
+func formatLatency(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// formatRPM is like [formatLatency] but for the responsiveness.
+func formatRPM(rpm float64) string {
+	if rpm == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(rpm, 'f', 0, 64)
+}
+
+// formatKnown formats value with the given precision, or as an empty string
+// when it is unknown, which is zero, like [formatLatency].
+func formatKnown(value float64, prec int) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', prec, 64)
+}
+
+// WriteSummary writes summary to w using the given format, which must be
+// one of those returned by [NegotiateFormat].
+//
+// The CSV has a header row and one row per direction. The text format has
+// one line per direction containing key=value pairs using the same names
+// and units as the CSV columns, which suits grep and awk.
+func WriteSummary(w io.Writer, format string, summary *Summary) error {
+	switch format {
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(summary)
+
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(summaryColumns)
+		cw.WriteAll(summaryRows(summary))
+		return cw.Error()
+
+	case FormatText:
+		for _, row := range summaryRows(summary) {
+			var pairs []string
+			for idx, value := range row {
+				if value == "" {
+					continue
+				}
+				pairs = append(pairs, summaryColumns[idx]+"="+value)
+			}
+			if _, err := fmt.Fprintln(w, strings.Join(pairs, " ")); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported summary format: %s", format)
+	}
+}
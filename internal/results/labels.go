@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package results
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ParseLabels parses the KEY=VALUE labels tagging a run (e.g., with the
+// hypothesis under test, the hardware revision, or a ticket number), which
+// is nil when there are no labels. We reject commas, which separate the
+// labels in the CSV export (see [FormatLabels]).
+func ParseLabels(labels []string) (map[string]string, error) {
+	var m map[string]string
+	for _, label := range labels {
+		key, value, found := strings.Cut(label, "=")
+		if !found {
+			return nil, fmt.Errorf("label: expected KEY=VALUE, got %q", label)
+		}
+		if err := validateLabel(key, value); err != nil {
+			return nil, err
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("label: duplicate key: %q", key)
+		}
+		if m == nil {
+			m = make(map[string]string)
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// validateLabel checks the key and the value of a label.
+func validateLabel(key, value string) error {
+	if key == "" {
+		return errors.New("label: empty key")
+	}
+	if strings.Contains(key, ",") || strings.Contains(value, ",") {
+		return fmt.Errorf("label: %s: commas are not allowed", key)
+	}
+	return nil
+}
+
+// FormatLabels returns the comma-separated KEY=VALUE labels sorted by key.
+func FormatLabels(labels map[string]string) string {
+	var pairs []string
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+	return strings.Join(pairs, ",")
+}
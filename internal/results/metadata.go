@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package results
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+const (
+	// MaxMetadataSize is the maximum size of the JSON request body in which
+	// the client declares its [Metadata].
+	MaxMetadataSize = 8192
+
+	// maxMetadataField is the maximum length of each [Metadata] string.
+	maxMetadataField = 256
+
+	// maxMetadataLabels is the maximum number of [Metadata] labels.
+	maxMetadataLabels = 32
+)
+
+// Metadata is the metadata the ndt8 client declares when creating a
+// session, which the server trusts no more than the rest of the request.
+type Metadata struct {
+	// ClientName is the name of the client software (e.g., "ndt8").
+	ClientName string `json:"clientName,omitempty"`
+
+	// ClientVersion is the version of the client software.
+	ClientVersion string `json:"clientVersion,omitempty"`
+
+	// Platform is the platform running the client (e.g., "linux/amd64").
+	Platform string `json:"platform,omitempty"`
+
+	// Labels contains the labels the operator tagged the run with (see
+	// [ParseLabels]), if any.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Validate checks that m is well-formed and within the size limits, which
+// matters because the metadata comes from untrusted clients.
+func (m *Metadata) Validate() error {
+	for name, value := range map[string]string{
+		"clientName":    m.ClientName,
+		"clientVersion": m.ClientVersion,
+		"platform":      m.Platform,
+	} {
+		if len(value) > maxMetadataField {
+			return fmt.Errorf("metadata: %s longer than %d bytes", name, maxMetadataField)
+		}
+	}
+	if len(m.Labels) > maxMetadataLabels {
+		return fmt.Errorf("metadata: more than %d labels", maxMetadataLabels)
+	}
+	for key, value := range m.Labels {
+		if len(key) > maxMetadataField || len(value) > maxMetadataField {
+			return fmt.Errorf("metadata: label longer than %d bytes", maxMetadataField)
+		}
+		if err := validateLabel(key, value); err != nil {
+			return fmt.Errorf("metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// LogValue implements [slog.LogValuer], logging nothing when m is nil.
+func (m *Metadata) LogValue() slog.Value {
+	if m == nil {
+		return slog.GroupValue()
+	}
+	return slog.GroupValue(
+		slog.String("clientName", m.ClientName),
+		slog.String("clientVersion", m.ClientVersion),
+		slog.String("platform", m.Platform),
+		slog.String("labels", FormatLabels(m.Labels)),
+	)
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package results
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Netem is the network emulation policy in effect during the measurement,
+// which makes documents measured in the lab self-describing.
+//
+// The values use the tc syntax (e.g., "25ms" or "100mbit").
+type Netem struct {
+	// Template is the named profile the policy started from, if any.
+	Template string `json:"template,omitempty"`
+
+	// Delay is the one-way delay.
+	Delay string `json:"delay"`
+
+	// Download is the download rate, if shaped.
+	Download string `json:"download,omitempty"`
+
+	// Upload is the upload rate, if shaped.
+	Upload string `json:"upload,omitempty"`
+
+	// TBFLatency is the maximum time a packet waits in the rate limiter.
+	TBFLatency string `json:"tbfLatency,omitempty"`
+
+	// Loss is the netem loss model, if any.
+	Loss string `json:"loss,omitempty"`
+
+	// Slot is the netem slot model, if any.
+	Slot string `json:"slot,omitempty"`
+
+	// Seed is the seed of the netem random choices, if any.
+	Seed uint64 `json:"seed,omitempty"`
+
+	// Queue is how the rate limiter queues packets (e.g., "fifo").
+	Queue string `json:"queue,omitempty"`
+
+	// Family is the address family (inet or inet6) whose packets the policy
+	// impairs, if the router treats the families differently.
+	Family string `json:"family,omitempty"`
+}
+
+// ParseNetem parses the comma-separated KEY=VALUE pairs that [Netem.String]
+// returns, where KEY is the JSON name of a field.
+func ParseNetem(spec string) (*Netem, error) {
+	n := &Netem{}
+	for pair := range strings.SplitSeq(spec, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("netem: expected KEY=VALUE, got %q", pair)
+		}
+		switch key {
+		case "template":
+			n.Template = value
+		case "delay":
+			n.Delay = value
+		case "download":
+			n.Download = value
+		case "upload":
+			n.Upload = value
+		case "tbfLatency":
+			n.TBFLatency = value
+		case "loss":
+			n.Loss = value
+		case "slot":
+			n.Slot = value
+		case "seed":
+			seed, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("netem: invalid seed: %w", err)
+			}
+			n.Seed = seed
+		case "queue":
+			n.Queue = value
+		case "family":
+			n.Family = value
+		default:
+			return nil, fmt.Errorf("netem: unknown key: %q", key)
+		}
+	}
+	if n.Delay == "" {
+		return nil, errors.New("netem: missing delay")
+	}
+	return n, nil
+}
+
+// String returns the comma-separated KEY=VALUE pairs of the fields that are
+// set, which [ParseNetem] parses. None of the values contains commas.
+func (n *Netem) String() string {
+	var pairs []string
+	add := func(key, value string) {
+		if value != "" {
+			pairs = append(pairs, key+"="+value)
+		}
+	}
+	add("template", n.Template)
+	add("delay", n.Delay)
+	add("download", n.Download)
+	add("upload", n.Upload)
+	add("tbfLatency", n.TBFLatency)
+	add("loss", n.Loss)
+	add("slot", n.Slot)
+	if n.Seed != 0 {
+		add("seed", strconv.FormatUint(n.Seed, 10))
+	}
+	add("queue", n.Queue)
+	add("family", n.Family)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package results
+
+const (
+	// FlagTruncated indicates that the time budget expired before the
+	// planned transfers completed, so the measurement did not converge.
+	FlagTruncated = "truncated"
+
+	// FlagUnstable indicates that the throughput did not converge
+	// before the time budget expired (see [ConvergenceUnstable]).
+	FlagUnstable = "unstable"
+
+	// FlagHighVariance indicates that the per-interval throughput varied
+	// more than [MaxVariation], so the mean is not representative.
+	FlagHighVariance = "high-variance"
+
+	// FlagHighRetransmissions indicates a retransmission rate above
+	// [MaxRetransmitRate], so the result is likely loss-limited.
+	FlagHighRetransmissions = "high-retransmissions"
+
+	// FlagCPUBound indicates that the client used more than [MaxCPUUsage]
+	// of a CPU, so the CPU rather than the network may be the bottleneck.
+	FlagCPUBound = "cpu-bound"
+)
+
+const (
+	// MaxVariation is the threshold for [FlagHighVariance].
+	MaxVariation = 0.5
+
+	// MaxRetransmitRate is the threshold for [FlagHighRetransmissions].
+	MaxRetransmitRate = 0.05
+
+	// MaxCPUUsage is the threshold for [FlagCPUBound].
+	MaxCPUUsage = 0.9
+
+	// minVariationSamples is the minimum number of intervals required
+	// to compute the coefficient of variation.
+	minVariationSamples = 4
+)
+
+// Assess sets the Flags field according to the other fields. Call this
+// method after filling all the fields. It is safe to call this method
+// on a nil summary, in which case it does nothing.
+func (s *DirectionSummary) Assess() {
+	if s == nil {
+		return
+	}
+	s.Flags = nil
+	if s.Truncated {
+		s.Flags = append(s.Flags, FlagTruncated)
+	}
+	if s.Convergence == ConvergenceUnstable {
+		s.Flags = append(s.Flags, FlagUnstable)
+	}
+	if s.Variation > MaxVariation {
+		s.Flags = append(s.Flags, FlagHighVariance)
+	}
+	if s.RetransmitRate > MaxRetransmitRate {
+		s.Flags = append(s.Flags, FlagHighRetransmissions)
+	}
+	if s.CPUUsage > MaxCPUUsage {
+		s.Flags = append(s.Flags, FlagCPUBound)
+	}
+}
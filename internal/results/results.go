@@ -0,0 +1,559 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package results contains the measurement result documents.
+package results
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// OriginClient marks samples collected by the client.
+	OriginClient = "client"
+
+	// OriginServer marks samples collected by the server.
+	OriginServer = "server"
+)
+
+// Sample is a throughput sample collected by either endpoint.
+type Sample struct {
+	// Origin is either [OriginClient] or [OriginServer].
+	Origin string `json:"origin"`
+
+	// Direction is either "download" or "upload".
+	Direction string `json:"direction"`
+
+	// ChunkSize is the size of the chunk being transferred, if any.
+	ChunkSize int64 `json:"chunkSize,omitempty"`
+
+	// Bytes is the number of bytes transferred so far in the chunk (or
+	// in the whole test for protocols not using chunks).
+	Bytes int64 `json:"bytes"`
+
+	// BytesAcked is the number of bytes the peer acknowledged so far in
+	// the chunk (or test) according to the kernel, which only the sender
+	// knows and only on Linux. Unlike Bytes, it includes the TLS and HTTP
+	// framing overhead and excludes the data still sitting in buffers.
+	BytesAcked int64 `json:"bytesAcked,omitempty"`
+
+	// Retransmits is the number of segments retransmitted so far in the
+	// chunk (or test) according to the kernel, which, like BytesAcked,
+	// only the sender knows and only on Linux.
+	Retransmits int64 `json:"retransmits,omitempty"`
+
+	// RTT is the smoothed RTT according to the kernel of the endpoint
+	// collecting the sample, only on Linux, if it reports it.
+	RTT time.Duration `json:"rtt,omitempty"`
+
+	// Cwnd is the congestion window in segments according to the kernel
+	// of the endpoint collecting the sample, only on Linux, if it reports it.
+	Cwnd int64 `json:"cwnd,omitempty"`
+
+	// Elapsed is the time elapsed since the chunk (or test) started.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Time is the time when the sample was collected.
+	Time time.Time `json:"time"`
+}
+
+const (
+	// StatusComplete indicates that the measurement ran to completion.
+	StatusComplete = "complete"
+
+	// StatusRunning indicates an intermediate document that we submit
+	// to a collector while the measurement is still running.
+	StatusRunning = "running"
+
+	// StatusInterrupted indicates that the user interrupted the measurement
+	// and the document only contains the samples collected until then.
+	StatusInterrupted = "interrupted"
+
+	// StatusFailed indicates that an error stopped the measurement and
+	// the document only contains the samples collected until then.
+	StatusFailed = "failed"
+
+	// StatusInvalid indicates that caches or proxies on the path served or
+	// forwarded the measurement responses, so the samples may not measure
+	// the path to the server.
+	StatusInvalid = "invalid"
+)
+
+// Document is the result of a measurement.
+type Document struct {
+	// SchemaVersion is the version of the document schema, which is
+	// [SchemaVersion] for the documents we write (see [Document.Migrate]).
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+
+	// Protocol is the measurement protocol (e.g., "ndt8").
+	Protocol string `json:"protocol"`
+
+	// Status is [StatusComplete], [StatusInterrupted], [StatusFailed],
+	// [StatusInvalid], or [StatusRunning].
+	Status string `json:"status"`
+
+	// SessionID is the server-assigned session ID or, with ndt7, the test
+	// ID shared by the download and the upload, if any.
+	SessionID string `json:"sessionID,omitempty"`
+
+	// ClockOffset is the estimated server clock minus the client clock.
+	ClockOffset time.Duration `json:"clockOffset,omitempty"`
+
+	// ClientAddr is the client address as seen by the server, which
+	// accounts for the trusted reverse proxies, if known.
+	ClientAddr string `json:"clientAddr,omitempty"`
+
+	// UpgradePath is the WebSocket upgrade the server saw, either "rfc6455"
+	// (HTTP/1.1 Upgrade) or "rfc8441" (HTTP/2 extended CONNECT), which is
+	// not the one the client used when a reverse proxy translated it.
+	UpgradePath string `json:"upgradePath,omitempty"`
+
+	// Connections contains the connections as the ndt7 server described
+	// them at the beginning of each test, if any.
+	Connections []ConnectionInfo `json:"connections,omitempty"`
+
+	// DownloadMode is how the ndt8 client downloaded, either "chunk" (sized
+	// chunk paths), "range" (Range requests for a large object), or "stream"
+	// (a single response streamed for the whole time budget).
+	DownloadMode string `json:"downloadMode,omitempty"`
+
+	// UploadMode is how the ndt8 client uploaded, either "chunk" (sized
+	// chunk paths) or "stream" (a single request streamed for the whole
+	// time budget).
+	UploadMode string `json:"uploadMode,omitempty"`
+
+	// Pattern is how the ndt8 client requested bulk data, either "saturate"
+	// (chunk doubling) or one of the paced patterns described in the README
+	// (e.g., "constant:20000000bit").
+	Pattern string `json:"pattern,omitempty"`
+
+	// Seed is the seed of the random choices of the ndt8 client, which
+	// makes the same choices when rerun with the same --seed.
+	Seed int64 `json:"seed,omitempty"`
+
+	// Netem is the network emulation policy in effect during the measurement,
+	// which `lxs measure` passes to the client, if any.
+	Netem *Netem `json:"netem,omitempty"`
+
+	// Labels contains the KEY=VALUE labels the user tagged the run with
+	// (see [ParseLabels]), if any.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// DNSLookups contains the DNS lookups made by the client, if any.
+	DNSLookups []DNSLookup `json:"dnsLookups,omitempty"`
+
+	// Dials contains the connection attempts made by the client.
+	Dials []Dial `json:"dials,omitempty"`
+
+	// TLSHandshakes contains the TLS handshakes the client completed.
+	TLSHandshakes []TLSHandshake `json:"tlsHandshakes,omitempty"`
+
+	// Probes contains the responsiveness probes sent by the client, if any.
+	Probes []Probe `json:"probes,omitempty"`
+
+	// IdleProbes contains the probes the client sent before the transfers,
+	// which measure the idle latency and whose direction is "idle", if any.
+	IdleProbes []Probe `json:"idleProbes,omitempty"`
+
+	// PageLoads contains the simulated page loads, if any.
+	PageLoads []PageLoad `json:"pageLoads,omitempty"`
+
+	// Videos contains the simulated video sessions, if any.
+	Videos []Video `json:"videos,omitempty"`
+
+	// Interruptions contains the transfers that failed mid-chunk and that
+	// the client retried on a fresh connection, if any.
+	Interruptions []Interruption `json:"interruptions,omitempty"`
+
+	// Intermediaries contains the responses showing that a cache or a proxy
+	// served or forwarded them, if any, which invalidate the measurement.
+	Intermediaries []Intermediary `json:"intermediaries,omitempty"`
+
+	// MiddleboxChecks compares properties of the connection to the server
+	// with the values we expect, if the client checked them.
+	MiddleboxChecks []MiddleboxCheck `json:"middleboxChecks,omitempty"`
+
+	// MiddleboxSuspected indicates that some middlebox checks failed, which
+	// suggests that a middlebox interfered with the measurement.
+	MiddleboxSuspected bool `json:"middleboxSuspected,omitempty"`
+
+	// FlightRecords contains the paths of the dumps the flight recorder
+	// wrote when detecting anomalies (see the --flight-recorder flag of
+	// the measure commands), if any.
+	FlightRecords []string `json:"flightRecords,omitempty"`
+
+	// Summary contains the headline figures.
+	Summary *Summary `json:"summary,omitempty"`
+
+	// Samples is the merged client and server timeline.
+	Samples []Sample `json:"samples"`
+}
+
+// DNSLookup is a DNS lookup made by the client.
+type DNSLookup struct {
+	// Hostname is the hostname we resolved.
+	Hostname string `json:"hostname"`
+
+	// Addresses contains the resolved addresses.
+	Addresses []string `json:"addresses,omitempty"`
+
+	// Failure is the error that occurred, if any.
+	Failure string `json:"failure,omitempty"`
+
+	// Elapsed is the time it took to resolve the hostname.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Time is the time when we started resolving.
+	Time time.Time `json:"time"`
+}
+
+// ConnectionInfo describes a connection as the server saw it.
+type ConnectionInfo struct {
+	// Test is the test using the connection (e.g., "download").
+	Test string `json:"test"`
+
+	// Client is the client endpoint as seen by the server.
+	Client string `json:"client"`
+
+	// Server is the server endpoint.
+	Server string `json:"server"`
+
+	// UUID identifies the connection in the server logs.
+	UUID string `json:"uuid"`
+}
+
+// Dial is a connection attempt made by the client.
+type Dial struct {
+	// Network is the network used for dialing (e.g., "tcp4").
+	Network string `json:"network"`
+
+	// Address is the endpoint we were asked to dial.
+	Address string `json:"address"`
+
+	// RemoteAddr is the literal address we connected to, if any.
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+
+	// Family is the address family used, either "inet" or "inet6".
+	Family string `json:"family,omitempty"`
+
+	// Failure is the error that occurred, if any.
+	Failure string `json:"failure,omitempty"`
+
+	// Elapsed is the time it took to connect or fail.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Time is the time when we started dialing.
+	Time time.Time `json:"time"`
+}
+
+// TLSHandshake is a TLS handshake completed by the client.
+type TLSHandshake struct {
+	// Resumed is true when the handshake resumed a session of a previous
+	// connection, which skips sending and verifying the certificates.
+	Resumed bool `json:"resumed"`
+
+	// Version is the negotiated TLS version (e.g., "TLS 1.3").
+	Version string `json:"version"`
+
+	// ALPN is the negotiated application protocol, if any.
+	ALPN string `json:"alpn,omitempty"`
+
+	// Time is the time when the handshake completed.
+	Time time.Time `json:"time"`
+}
+
+// Probe is a responsiveness probe sent by the client during a transfer.
+type Probe struct {
+	// Direction is the direction of the concurrent transfer.
+	Direction string `json:"direction"`
+
+	// RTT is the time it took to receive the response.
+	RTT time.Duration `json:"rtt"`
+
+	// Failure is the error that occurred, if any.
+	Failure string `json:"failure,omitempty"`
+
+	// Time is the time when we sent the probe.
+	Time time.Time `json:"time"`
+
+	// Burst is the 1-based number of the burst containing the probe, if
+	// the client sent the probes in bursts (see [DirectionSummary.Jitter]).
+	Burst int `json:"burst,omitempty"`
+
+	// KernelSent is when the probe left the host according to the kernel,
+	// if the client used kernel timestamps (see [Probe.KernelRTT]).
+	KernelSent time.Time `json:"kernelSent,omitzero"`
+
+	// KernelReceived is like KernelSent but for when the response reached
+	// the host.
+	KernelReceived time.Time `json:"kernelReceived,omitzero"`
+
+	// KernelRTT is KernelReceived minus KernelSent, which, unlike RTT,
+	// excludes the time the client waited to be scheduled, if known.
+	KernelRTT time.Duration `json:"kernelRTT,omitempty"`
+}
+
+// PageLoad is a simulated page load, i.e., many small transfers that the
+// client starts together, like a browser fetching the objects of a page.
+type PageLoad struct {
+	// Direction is the direction of the transfers.
+	Direction string `json:"direction"`
+
+	// Objects is the number of transfers.
+	Objects int `json:"objects"`
+
+	// Bytes is the total size of the transfers.
+	Bytes int64 `json:"bytes"`
+
+	// Elapsed is the time it took for all the transfers to complete.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Failure is the first error that occurred, if any.
+	Failure string `json:"failure,omitempty"`
+
+	// Time is the time when we started the transfers.
+	Time time.Time `json:"time"`
+}
+
+// Video is a simulated adaptive-bitrate video session, in which the client
+// fetches fixed-duration segments choosing their bitrate from a ladder.
+type Video struct {
+	// Direction is the direction of the segment transfers.
+	Direction string `json:"direction"`
+
+	// Bitrate is the mean bitrate of the segments in bit/s, i.e., the
+	// bitrate that the link sustained.
+	Bitrate float64 `json:"bitrate"`
+
+	// StartupDelay is the time before playback started or, when playback
+	// never started, the duration of the whole session.
+	StartupDelay time.Duration `json:"startupDelay"`
+
+	// Segments contains the segments we fetched.
+	Segments []VideoSegment `json:"segments,omitempty"`
+
+	// Rebuffers contains the playback stalls after startup, if any.
+	Rebuffers []Rebuffer `json:"rebuffers,omitempty"`
+
+	// Failure is the error that ended the session, if any.
+	Failure string `json:"failure,omitempty"`
+
+	// Time is the time when the session started.
+	Time time.Time `json:"time"`
+}
+
+// VideoSegment is a segment of a [Video].
+type VideoSegment struct {
+	// Bitrate is the bitrate of the segment in bit/s.
+	Bitrate float64 `json:"bitrate"`
+
+	// Bytes is the size of the segment.
+	Bytes int64 `json:"bytes"`
+
+	// Elapsed is the time it took to transfer the segment.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Time is the time when we started transferring the segment.
+	Time time.Time `json:"time"`
+}
+
+// Rebuffer is a playback stall of a [Video].
+type Rebuffer struct {
+	// Elapsed is how long playback stalled.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Time is the time when playback stalled.
+	Time time.Time `json:"time"`
+}
+
+// Interruption is a period during which a transfer could not make progress
+// because it failed mid-chunk (e.g., the connection was reset or stalled),
+// which ends when a retry on a fresh connection makes progress again.
+type Interruption struct {
+	// Direction is the direction of the transfer.
+	Direction string `json:"direction"`
+
+	// ChunkSize is the size of the chunk being transferred.
+	ChunkSize int64 `json:"chunkSize"`
+
+	// Failure is the error that interrupted the transfer.
+	Failure string `json:"failure"`
+
+	// Retries is the number of times we retried the transfer.
+	Retries int `json:"retries"`
+
+	// Recovered indicates that a retry made progress, as opposed to the
+	// phase ending, or the server refusing the retry, while interrupted.
+	Recovered bool `json:"recovered"`
+
+	// Elapsed is the time from the interruption until a retry made
+	// progress or we stopped retrying.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Time is the time when the transfer last made progress before the
+	// failure or, without progress, when the transfer started.
+	Time time.Time `json:"time"`
+}
+
+// Intermediary is a response showing that a cache or a proxy on the path
+// served or forwarded it, despite the server forbidding caching.
+type Intermediary struct {
+	// Method is the request method.
+	Method string `json:"method"`
+
+	// Path is the request path.
+	Path string `json:"path"`
+
+	// Age is the Age header, which caches add when serving stored responses.
+	Age string `json:"age,omitempty"`
+
+	// Via is the Via header, which proxies add when forwarding responses.
+	Via string `json:"via,omitempty"`
+
+	// ETag is the ETag header, when a previous response had the same, which
+	// means that a cache replayed it, since the server makes them unique.
+	ETag string `json:"etag,omitempty"`
+
+	// Time is the time when we received the response.
+	Time time.Time `json:"time"`
+}
+
+// MiddleboxCheck compares a property of the connection to the server with
+// the value we expect, where a mismatch suggests that a middlebox (e.g., a
+// TLS-intercepting proxy) terminated the connection instead of the server.
+type MiddleboxCheck struct {
+	// Name is the checked property: "alpn", "tlsVersion", "server",
+	// or "ttl".
+	Name string `json:"name"`
+
+	// Expected is the value we expect.
+	Expected string `json:"expected"`
+
+	// Observed is the value we observed.
+	Observed string `json:"observed"`
+
+	// OK indicates that the observed value is the expected one.
+	OK bool `json:"ok"`
+}
+
+// Merge returns a timeline interleaving client and server samples.
+//
+// The server sample times are shifted into the client clock by
+// subtracting offset, i.e., the server clock minus the client clock.
+func Merge(client, server []Sample, offset time.Duration) []Sample {
+	out := make([]Sample, 0, len(client)+len(server))
+	out = append(out, client...)
+	for _, s := range server {
+		s.Time = s.Time.Add(-offset)
+		out = append(out, s)
+	}
+	slices.SortStableFunc(out, func(a, b Sample) int {
+		return a.Time.Compare(b.Time)
+	})
+	return out
+}
+
+// WriteFile writes the document as indented JSON to the given file.
+func WriteFile(path string, doc *Document) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// ReadFile reads, migrates, and validates the document in the given file
+// (see [Decode]).
+func ReadFile(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// Timeline collects samples, probes, page loads, videos, and interruptions
+// from concurrent goroutines.
+//
+// The zero value is ready to use.
+type Timeline struct {
+	mu            sync.Mutex
+	interruptions []Interruption
+	pageLoads     []PageLoad
+	probes        []Probe
+	samples       []Sample
+	videos        []Video
+}
+
+// Emit appends a sample to the timeline.
+func (tl *Timeline) Emit(sample Sample) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.samples = append(tl.samples, sample)
+}
+
+// Samples returns a copy of the samples collected so far.
+func (tl *Timeline) Samples() []Sample {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return slices.Clone(tl.samples)
+}
+
+// EmitProbe appends a probe to the timeline.
+func (tl *Timeline) EmitProbe(probe Probe) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.probes = append(tl.probes, probe)
+}
+
+// Probes returns a copy of the probes collected so far.
+func (tl *Timeline) Probes() []Probe {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return slices.Clone(tl.probes)
+}
+
+// EmitPageLoad appends a page load to the timeline.
+func (tl *Timeline) EmitPageLoad(pageLoad PageLoad) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.pageLoads = append(tl.pageLoads, pageLoad)
+}
+
+// PageLoads returns a copy of the page loads collected so far.
+func (tl *Timeline) PageLoads() []PageLoad {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return slices.Clone(tl.pageLoads)
+}
+
+// EmitVideo appends a video session to the timeline.
+func (tl *Timeline) EmitVideo(video Video) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.videos = append(tl.videos, video)
+}
+
+// Videos returns a copy of the video sessions collected so far.
+func (tl *Timeline) Videos() []Video {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return slices.Clone(tl.videos)
+}
+
+// EmitInterruption appends an interruption to the timeline.
+func (tl *Timeline) EmitInterruption(interruption Interruption) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	tl.interruptions = append(tl.interruptions, interruption)
+}
+
+// Interruptions returns a copy of the interruptions collected so far.
+func (tl *Timeline) Interruptions() []Interruption {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return slices.Clone(tl.interruptions)
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package results
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SchemaVersion is the version of the result document schema we write.
+//
+// Bump it when a change to [Document] would otherwise break reading the
+// archived documents, and add a migration from the previous version.
+// Version 1 documents predate the schemaVersion field.
+const SchemaVersion = 2
+
+// migrations maps each schema version to the function upgrading documents
+// using that version to the next one.
+var migrations = map[int]func(doc *Document){
+	1: migrateV1,
+}
+
+// migrateV1 upgrades version 1 documents, which lack the status when they
+// predate writing partial documents, since they always ran to completion.
+func migrateV1(doc *Document) {
+	if doc.Status == "" {
+		doc.Status = StatusComplete
+	}
+}
+
+// Migrate upgrades doc to [SchemaVersion], so that code reading archived
+// documents only deals with the current schema. It fails when doc uses a
+// schema newer than ours.
+func (doc *Document) Migrate() error {
+	if doc.SchemaVersion == 0 {
+		doc.SchemaVersion = 1
+	}
+	if doc.SchemaVersion < 0 || doc.SchemaVersion > SchemaVersion {
+		return fmt.Errorf("unsupported schema version: %d", doc.SchemaVersion)
+	}
+	for doc.SchemaVersion < SchemaVersion {
+		migrations[doc.SchemaVersion](doc)
+		doc.SchemaVersion++
+	}
+	return nil
+}
+
+// Decode decodes, migrates, and validates the document in data.
+func Decode(data []byte) (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := doc.Migrate(); err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
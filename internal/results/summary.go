@@ -0,0 +1,390 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package results
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// Summary contains the headline figures of a measurement.
+type Summary struct {
+	// Download summarizes the download, if any.
+	Download *DirectionSummary `json:"download,omitempty"`
+
+	// Upload summarizes the upload, if any.
+	Upload *DirectionSummary `json:"upload,omitempty"`
+
+	// IdleLatency is the median RTT of the probes the client sent before
+	// the transfers, i.e., while the queues were empty, if any.
+	IdleLatency time.Duration `json:"idleLatency,omitempty"`
+
+	// KernelIdleLatency is like IdleLatency but for the RTT measured using
+	// kernel timestamps (see [Probe.KernelRTT]), if known.
+	KernelIdleLatency time.Duration `json:"kernelIdleLatency,omitempty"`
+
+	// Metadata is the metadata the client declared when creating the
+	// session, which the summary endpoint of the ndt8 server echoes.
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// DirectionSummary summarizes a single direction.
+type DirectionSummary struct {
+	// Bytes is the number of bytes transferred after the warm-up.
+	Bytes int64 `json:"bytes"`
+
+	// Elapsed is the time spent transferring after the warm-up.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Throughput is the throughput after the warm-up in bit/s.
+	Throughput float64 `json:"throughput"`
+
+	// MaxThroughput is the largest per-interval throughput after the
+	// warm-up in bit/s.
+	MaxThroughput float64 `json:"maxThroughput,omitempty"`
+
+	// MinRTT is the minimum RTT the kernel of the sender measured, if known.
+	MinRTT time.Duration `json:"minRTT,omitempty"`
+
+	// WarmUp is the initial time excluded from the throughput, which is
+	// zero when the direction was too short to exclude anything.
+	WarmUp time.Duration `json:"warmUp"`
+
+	// Variation is the coefficient of variation (i.e., the standard deviation
+	// divided by the mean) of the per-interval throughput after the warm-up.
+	Variation float64 `json:"variation"`
+
+	// Divergence is the relative difference between the bytes the sender
+	// wrote and the bytes the peer acknowledged after the warm-up, i.e.,
+	// (written - acked) / acked. A positive value means that the written
+	// bytes include data sitting in socket buffers, which inflates the
+	// throughput. It is zero when the samples lack the acknowledged bytes.
+	Divergence float64 `json:"divergence,omitempty"`
+
+	// Truncated indicates that the time budget expired before all the
+	// planned transfers completed, as opposed to converging.
+	Truncated bool `json:"truncated"`
+
+	// Convergence tells whether the throughput converged before the time
+	// budget expired (see [ConvergenceConverged]), if the client tried
+	// to detect it, in which case the client ended the direction early.
+	Convergence string `json:"convergence,omitempty"`
+
+	// ConvergedAfter is the time it took for the throughput to converge,
+	// if it converged.
+	ConvergedAfter time.Duration `json:"convergedAfter,omitempty"`
+
+	// CPUUsage is the fraction of a CPU the client used (see [cputime.Usage]).
+	CPUUsage float64 `json:"cpuUsage"`
+
+	// RetransmitRate is the fraction of retransmitted segments, if known.
+	RetransmitRate float64 `json:"retransmitRate,omitempty"`
+
+	// ClientRetransmits is the number of segments the client retransmitted
+	// during the whole direction, including the warm-up, if known.
+	ClientRetransmits int64 `json:"clientRetransmits,omitempty"`
+
+	// ServerRetransmits is like ClientRetransmits but for the server.
+	ServerRetransmits int64 `json:"serverRetransmits,omitempty"`
+
+	// LoadedLatency is the 95th percentile of the RTT of the probes sent
+	// during this direction, if any.
+	LoadedLatency time.Duration `json:"loadedLatency,omitempty"`
+
+	// LatencyIncrease is LoadedLatency minus the idle latency (see
+	// [Summary]), i.e., the queueing delay the transfer added, which
+	// is how bufferbloat shows. It is zero when either is unknown.
+	LatencyIncrease time.Duration `json:"latencyIncrease,omitempty"`
+
+	// KernelLoadedLatency is like LoadedLatency but for the RTT measured
+	// using kernel timestamps (see [Probe.KernelRTT]), if known.
+	KernelLoadedLatency time.Duration `json:"kernelLoadedLatency,omitempty"`
+
+	// RPM is the responsiveness in round trips per minute, i.e., one
+	// minute divided by the median RTT of the probes sent during this
+	// direction, if any.
+	RPM float64 `json:"rpm,omitempty"`
+
+	// Bursts is the number of bursts of probes sent back-to-back during
+	// this direction, if the client sent the probes in bursts.
+	Bursts int `json:"bursts,omitempty"`
+
+	// Jitter is the mean absolute difference between the RTTs of the
+	// consecutive successful probes of the same burst, i.e., the mean
+	// inter-packet delay variation (IPDV, see RFC 5481), if known.
+	Jitter time.Duration `json:"jitter,omitempty"`
+
+	// MaxIPDV is the 95th percentile of the absolute differences from
+	// which we compute Jitter, if known.
+	MaxIPDV time.Duration `json:"maxIPDV,omitempty"`
+
+	// ProbeLoad is the estimated fraction of the throughput the probes
+	// sent during this direction used, if any.
+	ProbeLoad float64 `json:"probeLoad,omitempty"`
+
+	// WireBytes is the number of bytes the client read from (download) or
+	// wrote to (upload) its connections during the whole direction, which,
+	// unlike Bytes, includes the warm-up, the TLS and HTTP framing, and the
+	// concurrent probes and events, if known.
+	WireBytes int64 `json:"wireBytes,omitempty"`
+
+	// Overhead is the relative difference between WireBytes and the payload
+	// bytes of the whole direction, i.e., (wire - payload) / payload, which
+	// quantifies the protocol overhead, if known.
+	Overhead float64 `json:"overhead,omitempty"`
+
+	// WireThroughput is Throughput including the Overhead in bit/s, which
+	// is closer to what the link carried, if known.
+	WireThroughput float64 `json:"wireThroughput,omitempty"`
+
+	// Flags contains the quality flags (e.g., [FlagHighVariance]) set
+	// by [*DirectionSummary.Assess] to mark unreliable measurements.
+	Flags []string `json:"flags,omitempty"`
+}
+
+// These are the values of [DirectionSummary.Convergence].
+const (
+	// ConvergenceConverged indicates that the throughput converged.
+	ConvergenceConverged = "converged"
+
+	// ConvergenceUnstable indicates that the throughput was still changing
+	// when the time budget expired.
+	ConvergenceUnstable = "unstable"
+)
+
+// Summarize computes the [*DirectionSummary] for the samples having the
+// given origin and direction, excluding the initial warmUp period, during
+// which slow start is still ramping up, from the throughput. The samples
+// are not modified, so the raw timeline still includes the warm-up. We
+// return nil if there are no samples for the given origin and direction.
+//
+// When the direction does not last longer than warmUp, we do not exclude
+// anything, since reporting nothing would be less useful.
+func Summarize(samples []Sample, origin, direction string, warmUp time.Duration) *DirectionSummary {
+	var selected []Sample
+	for _, s := range samples {
+		if s.Origin == origin && s.Direction == direction {
+			selected = append(selected, s)
+		}
+	}
+	if len(selected) <= 0 {
+		return nil
+	}
+	slices.SortStableFunc(selected, func(a, b Sample) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	// The direction starts when the first sampled transfer started.
+	start := selected[0].Time.Add(-selected[0].Elapsed)
+	end := selected[len(selected)-1].Time
+	if end.Sub(start) <= warmUp {
+		warmUp = 0
+	}
+	cutoff := start.Add(warmUp)
+
+	// Samples contain cumulative bytes per transfer, so we compute deltas
+	// and attribute each delta to the time the sample was collected. We tell
+	// transfers apart by chunk size and, since paced patterns repeat sizes,
+	// by when they started. Retransmissions count during the warm-up as well,
+	// since losses during slow start are as telling as the later ones.
+	var (
+		bytes       int64
+		acked       int64
+		retransmits int64
+		prev        = make(map[int64]Sample) // chunk size → last sample
+		rates       []float64
+		tprev       = start
+	)
+	for _, s := range selected {
+		p, found := prev[s.ChunkSize]
+		if found && !sameTransfer(p, s) {
+			p = Sample{}
+		}
+		prev[s.ChunkSize] = s
+		delta := s.Bytes - p.Bytes
+		deltaAcked := s.BytesAcked - p.BytesAcked
+		retransmits += s.Retransmits - p.Retransmits
+		if s.Time.After(cutoff) {
+			bytes += delta
+			acked += deltaAcked
+			if interval := s.Time.Sub(tprev); interval > 0 {
+				rates = append(rates, float64(delta*8)/interval.Seconds())
+			}
+		}
+		tprev = s.Time
+	}
+
+	summary := &DirectionSummary{
+		Bytes:     bytes,
+		Elapsed:   end.Sub(cutoff),
+		WarmUp:    warmUp,
+		Variation: variation(rates),
+	}
+	if len(rates) > 0 {
+		summary.MaxThroughput = slices.Max(rates)
+	}
+	if summary.Elapsed > 0 {
+		summary.Throughput = float64(bytes*8) / summary.Elapsed.Seconds()
+	}
+	if acked > 0 {
+		summary.Divergence = float64(bytes-acked) / float64(acked)
+	}
+	switch origin {
+	case OriginClient:
+		summary.ClientRetransmits = retransmits
+	case OriginServer:
+		summary.ServerRetransmits = retransmits
+	}
+	return summary
+}
+
+// sameTransferTolerance is how far apart the start times computed from two
+// samples of the same transfer may be, since Time is a wall clock reading,
+// while Elapsed comes from the monotonic clock.
+const sameTransferTolerance = time.Millisecond
+
+// sameTransfer returns whether the samples a and b, which have the same
+// chunk size, belong to the same transfer, i.e., they started together.
+func sameTransfer(a, b Sample) bool {
+	delta := b.Time.Add(-b.Elapsed).Sub(a.Time.Add(-a.Elapsed))
+	return delta.Abs() <= sameTransferTolerance
+}
+
+// variation returns the coefficient of variation of values or zero when
+// there are too few values to compute a meaningful figure.
+func variation(values []float64) float64 {
+	if len(values) < minVariationSamples {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if mean <= 0 {
+		return 0
+	}
+	var sqsum float64
+	for _, v := range values {
+		sqsum += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sqsum/float64(len(values))) / mean
+}
+
+// LatencyPercentile returns the p-th percentile, with p between 0 and 100,
+// of the RTT of the successful probes using the nearest-rank method. The
+// boolean is false when there are no successful probes.
+func LatencyPercentile(probes []Probe, p float64) (time.Duration, bool) {
+	var rtts []time.Duration
+	for _, probe := range probes {
+		if probe.Failure == "" {
+			rtts = append(rtts, probe.RTT)
+		}
+	}
+	return percentile(rtts, p)
+}
+
+// kernelLatencyPercentile is like [LatencyPercentile] but for the RTT of
+// the successful probes measured using kernel timestamps, if any.
+func kernelLatencyPercentile(probes []Probe, p float64) (time.Duration, bool) {
+	var rtts []time.Duration
+	for _, probe := range probes {
+		if probe.Failure == "" && probe.KernelRTT > 0 {
+			rtts = append(rtts, probe.KernelRTT)
+		}
+	}
+	return percentile(rtts, p)
+}
+
+// percentile returns the p-th percentile of rtts, which it sorts, using
+// the nearest-rank method. The boolean is false when rtts is empty.
+func percentile(rtts []time.Duration, p float64) (time.Duration, bool) {
+	if len(rtts) <= 0 {
+		return 0, false
+	}
+	slices.Sort(rtts)
+	rank := int(math.Ceil(p / 100 * float64(len(rtts))))
+	return rtts[min(max(rank, 1), len(rtts))-1], true
+}
+
+// SummarizeLatency sets the latency figures of summary, computing the idle
+// latency from the idle probes and the loaded latency of each direction
+// from the probes sent during it.
+func SummarizeLatency(summary *Summary, idle, probes []Probe) {
+	idleRTT, hasIdle := LatencyPercentile(idle, 50)
+	if hasIdle {
+		summary.IdleLatency = idleRTT
+	}
+	if kernelIdle, ok := kernelLatencyPercentile(idle, 50); ok {
+		summary.KernelIdleLatency = kernelIdle
+	}
+	for _, entry := range []struct {
+		direction string
+		ds        *DirectionSummary
+	}{
+		{"download", summary.Download},
+		{"upload", summary.Upload},
+	} {
+		ds := entry.ds
+		if ds == nil {
+			continue
+		}
+		var selected []Probe
+		for _, probe := range probes {
+			if probe.Direction == entry.direction {
+				selected = append(selected, probe)
+			}
+		}
+		loaded, ok := LatencyPercentile(selected, 95)
+		if !ok {
+			continue
+		}
+		ds.LoadedLatency = loaded
+		if hasIdle {
+			ds.LatencyIncrease = loaded - idleRTT
+		}
+		if median, _ := LatencyPercentile(selected, 50); median > 0 {
+			ds.RPM = time.Minute.Seconds() / median.Seconds()
+		}
+		if kernelLoaded, ok := kernelLatencyPercentile(selected, 95); ok {
+			ds.KernelLoadedLatency = kernelLoaded
+		}
+		summarizeBursts(ds, selected)
+	}
+}
+
+// summarizeBursts sets the burst figures of ds using the probes sent
+// during its direction, considering those belonging to a burst.
+func summarizeBursts(ds *DirectionSummary, probes []Probe) {
+	var (
+		bursts = make(map[int]bool)
+		ipdvs  []time.Duration
+		prev   *Probe
+	)
+	for idx := range probes {
+		probe := &probes[idx]
+		if probe.Burst <= 0 {
+			continue
+		}
+		bursts[probe.Burst] = true
+		if probe.Failure != "" {
+			prev = nil
+			continue
+		}
+		if prev != nil && prev.Burst == probe.Burst {
+			ipdvs = append(ipdvs, (probe.RTT - prev.RTT).Abs())
+		}
+		prev = probe
+	}
+	ds.Bursts = len(bursts)
+	if len(ipdvs) <= 0 {
+		return
+	}
+	var sum time.Duration
+	for _, ipdv := range ipdvs {
+		sum += ipdv
+	}
+	ds.Jitter = sum / time.Duration(len(ipdvs))
+	ds.MaxIPDV, _ = percentile(ipdvs, 95)
+}
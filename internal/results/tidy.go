@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package results
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// tidyColumns contains the CSV columns of [*TidyWriter], which extend
+// [summaryColumns] with the columns identifying the run and the sample.
+// The labels column contains the labels of the run (see [FormatLabels]).
+var tidyColumns = []string{
+	"run",
+	"protocol",
+	"status",
+	"labels",
+	"record",
+	"origin",
+	"direction",
+	"time",
+	"elapsed",
+	"chunkSize",
+	"bytes",
+	"bytesAcked",
+	"throughput",
+	"warmUp",
+	"variation",
+	"divergence",
+	"truncated",
+	"cpuUsage",
+	"retransmitRate",
+	"clientRetransmits",
+	"serverRetransmits",
+	"loadedLatency",
+	"latencyIncrease",
+	"rpm",
+	"overhead",
+	"wireThroughput",
+	"flags",
+}
+
+// TidyWriter writes result documents as tidy CSV with one row per sample
+// and one row per summary direction, which the "record" column tells apart,
+// so the rows of many runs can be loaded into a single data frame.
+//
+// Construct using [NewTidyWriter].
+type TidyWriter struct {
+	cw     *csv.Writer
+	header bool
+}
+
+// NewTidyWriter constructs a new [*TidyWriter] writing to w.
+func NewTidyWriter(w io.Writer) *TidyWriter {
+	return &TidyWriter{cw: csv.NewWriter(w)}
+}
+
+// Write writes the rows of doc, using run to identify it, and writes
+// the header row the first time it is called.
+func (tw *TidyWriter) Write(run string, doc *Document) error {
+	if !tw.header {
+		tw.cw.Write(tidyColumns)
+		tw.header = true
+	}
+	for _, s := range doc.Samples {
+		tw.cw.Write([]string{
+			run,
+			doc.Protocol,
+			doc.Status,
+			FormatLabels(doc.Labels),
+			"sample",
+			s.Origin,
+			s.Direction,
+			s.Time.Format(time.RFC3339Nano),
+			strconv.FormatFloat(s.Elapsed.Seconds(), 'f', -1, 64),
+			strconv.FormatInt(s.ChunkSize, 10),
+			strconv.FormatInt(s.Bytes, 10),
+			strconv.FormatInt(s.BytesAcked, 10),
+			"", "", "", "", "", "", "", "", "", "", "", "", "", "", "",
+		})
+	}
+	if doc.Summary != nil {
+		for _, row := range summaryRows(doc.Summary) {
+			// See summaryColumns for the order of the summary row
+			tw.cw.Write(append([]string{
+				run,
+				doc.Protocol,
+				doc.Status,
+				FormatLabels(doc.Labels),
+				"summary",
+				"",
+				row[0],
+				"",
+				row[2],
+				"",
+				row[1],
+				"",
+			}, row[3:]...))
+		}
+	}
+	return tw.cw.Error()
+}
+
+// Flush writes any buffered rows and returns the first write error.
+func (tw *TidyWriter) Flush() error {
+	tw.cw.Flush()
+	return tw.cw.Error()
+}
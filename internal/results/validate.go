@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+package results
+
+import (
+	"fmt"
+	"slices"
+)
+
+// protocols contains the protocols producing result documents.
+var protocols = []string{"ndt7", "ndt8"}
+
+// directions contains the valid sample and probe directions.
+var directions = []string{"download", "upload"}
+
+// Validate checks that doc is a well-formed result document, which
+// matters when we receive documents from untrusted clients.
+func (doc *Document) Validate() error {
+	if doc.SchemaVersion != SchemaVersion {
+		return fmt.Errorf("invalid schema version: %d (migrate first)", doc.SchemaVersion)
+	}
+	if !slices.Contains(protocols, doc.Protocol) {
+		return fmt.Errorf("invalid protocol: %q", doc.Protocol)
+	}
+	switch doc.Status {
+	case StatusComplete, StatusInterrupted, StatusFailed, StatusInvalid, StatusRunning:
+	default:
+		return fmt.Errorf("invalid status: %q", doc.Status)
+	}
+	for key, value := range doc.Labels {
+		if err := validateLabel(key, value); err != nil {
+			return err
+		}
+	}
+	for idx, s := range doc.Samples {
+		if s.Origin != OriginClient && s.Origin != OriginServer {
+			return fmt.Errorf("samples[%d]: invalid origin: %q", idx, s.Origin)
+		}
+		if !slices.Contains(directions, s.Direction) {
+			return fmt.Errorf("samples[%d]: invalid direction: %q", idx, s.Direction)
+		}
+		if s.ChunkSize < 0 || s.Bytes < 0 || s.BytesAcked < 0 || s.Retransmits < 0 || s.RTT < 0 || s.Cwnd < 0 || s.Elapsed < 0 {
+			return fmt.Errorf("samples[%d]: negative value", idx)
+		}
+		if s.Time.IsZero() {
+			return fmt.Errorf("samples[%d]: missing time", idx)
+		}
+	}
+	for idx, p := range doc.Probes {
+		if !slices.Contains(directions, p.Direction) {
+			return fmt.Errorf("probes[%d]: invalid direction: %q", idx, p.Direction)
+		}
+		if p.RTT < 0 || p.KernelRTT < 0 {
+			return fmt.Errorf("probes[%d]: negative RTT", idx)
+		}
+	}
+	for idx, p := range doc.IdleProbes {
+		if p.RTT < 0 || p.KernelRTT < 0 {
+			return fmt.Errorf("idleProbes[%d]: negative RTT", idx)
+		}
+	}
+	for idx, info := range doc.Connections {
+		if !slices.Contains(directions, info.Test) {
+			return fmt.Errorf("connections[%d]: invalid test: %q", idx, info.Test)
+		}
+	}
+	for idx, pl := range doc.PageLoads {
+		if !slices.Contains(directions, pl.Direction) {
+			return fmt.Errorf("pageLoads[%d]: invalid direction: %q", idx, pl.Direction)
+		}
+		if pl.Objects < 0 || pl.Bytes < 0 || pl.Elapsed < 0 {
+			return fmt.Errorf("pageLoads[%d]: negative value", idx)
+		}
+	}
+	for idx, v := range doc.Videos {
+		if !slices.Contains(directions, v.Direction) {
+			return fmt.Errorf("videos[%d]: invalid direction: %q", idx, v.Direction)
+		}
+		if v.Bitrate < 0 || v.StartupDelay < 0 {
+			return fmt.Errorf("videos[%d]: negative value", idx)
+		}
+	}
+	for idx, in := range doc.Interruptions {
+		if !slices.Contains(directions, in.Direction) {
+			return fmt.Errorf("interruptions[%d]: invalid direction: %q", idx, in.Direction)
+		}
+		if in.ChunkSize < 0 || in.Retries < 0 || in.Elapsed < 0 {
+			return fmt.Errorf("interruptions[%d]: negative value", idx)
+		}
+	}
+	return nil
+}
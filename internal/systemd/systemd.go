@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package systemd generates systemd service units for the servers.
+package systemd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Unit is a systemd service unit.
+//
+// Construct using [NewUnit].
+type Unit struct {
+	// Description is the unit description.
+	Description string
+
+	// Environment contains KEY=VALUE environment entries.
+	Environment []string
+
+	// ExecStart is the command to execute including its arguments.
+	ExecStart []string
+
+	// WorkingDirectory is the directory where to run the command.
+	WorkingDirectory string
+
+	// ReadWritePaths contains the paths the service may write to, which
+	// must exist because the rest of the filesystem is read-only.
+	ReadWritePaths []string
+}
+
+// NewUnit returns a [*Unit] running the current executable with the
+// given args and env inside the current working directory.
+func NewUnit(description string, args []string, env []string) (*Unit, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	unit := &Unit{
+		Description:      description,
+		Environment:      env,
+		ExecStart:        append([]string{exe}, args...),
+		WorkingDirectory: cwd,
+	}
+	return unit, nil
+}
+
+// String returns the content of the unit file.
+//
+// The service runs as root, since it needs to read the TLS key, but
+// without capabilities except for binding privileged ports, and with
+// a read-only view of the filesystem and of the kernel.
+func (u *Unit) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[Unit]\n")
+	fmt.Fprintf(&sb, "Description=%s\n", u.Description)
+	fmt.Fprintf(&sb, "After=network-online.target\n")
+	fmt.Fprintf(&sb, "Wants=network-online.target\n")
+	fmt.Fprintf(&sb, "\n")
+	fmt.Fprintf(&sb, "[Service]\n")
+	fmt.Fprintf(&sb, "Type=simple\n")
+	fmt.Fprintf(&sb, "ExecStart=%s\n", joinQuoted(u.ExecStart))
+	fmt.Fprintf(&sb, "WorkingDirectory=%s\n", u.WorkingDirectory)
+	for _, entry := range u.Environment {
+		fmt.Fprintf(&sb, "Environment=%s\n", quote(entry, false))
+	}
+	fmt.Fprintf(&sb, "Restart=on-failure\n")
+	fmt.Fprintf(&sb, "RestartSec=1s\n")
+	fmt.Fprintf(&sb, "\n")
+	fmt.Fprintf(&sb, "AmbientCapabilities=CAP_NET_BIND_SERVICE\n")
+	fmt.Fprintf(&sb, "CapabilityBoundingSet=CAP_NET_BIND_SERVICE\n")
+	fmt.Fprintf(&sb, "LockPersonality=yes\n")
+	fmt.Fprintf(&sb, "MemoryDenyWriteExecute=yes\n")
+	fmt.Fprintf(&sb, "NoNewPrivileges=yes\n")
+	fmt.Fprintf(&sb, "PrivateDevices=yes\n")
+	fmt.Fprintf(&sb, "PrivateTmp=yes\n")
+	fmt.Fprintf(&sb, "ProtectControlGroups=yes\n")
+	fmt.Fprintf(&sb, "ProtectHome=read-only\n")
+	fmt.Fprintf(&sb, "ProtectKernelModules=yes\n")
+	fmt.Fprintf(&sb, "ProtectKernelTunables=yes\n")
+	fmt.Fprintf(&sb, "ProtectSystem=strict\n")
+	for _, path := range u.ReadWritePaths {
+		fmt.Fprintf(&sb, "ReadWritePaths=%s\n", quote(path, false))
+	}
+	fmt.Fprintf(&sb, "RestrictAddressFamilies=AF_INET AF_INET6 AF_UNIX\n")
+	fmt.Fprintf(&sb, "RestrictNamespaces=yes\n")
+	fmt.Fprintf(&sb, "RestrictRealtime=yes\n")
+	fmt.Fprintf(&sb, "SystemCallArchitectures=native\n")
+	fmt.Fprintf(&sb, "\n")
+	fmt.Fprintf(&sb, "[Install]\n")
+	fmt.Fprintf(&sb, "WantedBy=multi-user.target\n")
+	return sb.String()
+}
+
+// joinQuoted joins args quoting the ones that need quoting.
+func joinQuoted(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, quote(arg, true))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// quote quotes value using double quotes when it is empty or contains
+// characters that systemd would otherwise interpret. Because systemd only
+// expands variables in ExecStart, we only escape $ when exec is true.
+func quote(value string, exec bool) string {
+	if value != "" && !strings.ContainsAny(value, " \t\"'\\$%;") {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, `%`, `%%`)
+	if exec {
+		value = strings.ReplaceAll(value, `$`, `$$`)
+	}
+	return `"` + value + `"`
+}
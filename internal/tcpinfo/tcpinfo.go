@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package tcpinfo reads the kernel TCP statistics of a connection.
+package tcpinfo
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Info contains the TCP_INFO statistics we use.
+type Info struct {
+	// BytesAcked is the number of bytes the peer acknowledged so far,
+	// or zero when the kernel is too old to report it.
+	BytesAcked int64
+
+	// BytesReceived is the number of bytes received so far, or zero
+	// when the kernel is too old to report it.
+	BytesReceived int64
+
+	// NotsentBytes is the data the application wrote that is still
+	// sitting in the socket buffer waiting to be sent, or zero when the
+	// kernel is too old to report it.
+	NotsentBytes int64
+
+	// MinRTT is the minimum RTT the kernel measured on the connection,
+	// or zero when the kernel is too old to report it.
+	MinRTT time.Duration
+
+	// RTT is the smoothed RTT the kernel measured on the connection.
+	RTT time.Duration
+
+	// RTTVar is the variation of the smoothed RTT.
+	RTTVar time.Duration
+
+	// SndCwnd is the congestion window in segments.
+	SndCwnd int64
+
+	// SndMSS is the sender maximum segment size.
+	SndMSS int64
+
+	// TotalRetrans is the number of segments retransmitted so far.
+	TotalRetrans int64
+}
+
+// RetransmittedBytes estimates the bytes retransmitted so far.
+func (info *Info) RetransmittedBytes() int64 {
+	return info.TotalRetrans * info.SndMSS
+}
+
+// netConner is implemented by connections wrapping a [net.Conn], such
+// as [*tls.Conn] and [*websocket.Conn].
+type netConner interface {
+	NetConn() net.Conn
+}
+
+// tcpConn unwraps conn until it finds the underlying [*net.TCPConn].
+func tcpConn(conn net.Conn) (*net.TCPConn, error) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, nil
+		case netConner:
+			conn = c.NetConn()
+		default:
+			return nil, errors.ErrUnsupported
+		}
+	}
+}
+
+// Get returns the statistics of conn, which must be a [*net.TCPConn]
+// or a connection wrapping it (e.g., a [*tls.Conn]). It fails with
+// [errors.ErrUnsupported] on systems where we cannot read TCP_INFO.
+func Get(conn net.Conn) (*Info, error) {
+	tc, err := tcpConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	return get(tc)
+}
+
+// EnableTTL tells the kernel to record the hop limit of the packets
+// conn receives, which [TTL] returns. It fails with [errors.ErrUnsupported]
+// for IPv4, since only the IPv6 stack records it for TCP, and on systems
+// other than Linux.
+func EnableTTL(conn net.Conn) error {
+	tc, err := tcpConn(conn)
+	if err != nil {
+		return err
+	}
+	return enableTTL(tc)
+}
+
+// TTL returns the hop limit of the last packet conn received in order,
+// which requires calling [EnableTTL] before receiving it.
+func TTL(conn net.Conn) (int, error) {
+	tc, err := tcpConn(conn)
+	if err != nil {
+		return 0, err
+	}
+	return ttl(tc)
+}
+
+// isIPv6 returns whether conn uses IPv6.
+func isIPv6(conn *net.TCPConn) bool {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	return ok && addr.IP.To4() == nil
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build linux
+
+package tcpinfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// kernelInfo mirrors the beginning of the Linux struct tcp_info, which
+// has grown well beyond [syscall.TCPInfo] over the years.
+type kernelInfo struct {
+	syscall.TCPInfo
+	PacingRate    uint64
+	MaxPacingRate uint64
+	BytesAcked    uint64
+	BytesReceived uint64
+	SegsOut       uint32
+	SegsIn        uint32
+	NotsentBytes  uint32
+	MinRTT        uint32
+}
+
+func get(conn *net.TCPConn) (*Info, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ki    kernelInfo
+		errno syscall.Errno
+	)
+	size := uint32(unsafe.Sizeof(ki))
+	err = rawConn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd,
+			syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&ki)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if errno != 0 {
+		return nil, errno
+	}
+
+	// Without RTT samples yet, the kernel reports ~0 as the minimum.
+	if ki.MinRTT == math.MaxUint32 {
+		ki.MinRTT = 0
+	}
+
+	// Older kernels fill a shorter struct and leave the rest zeroed.
+	return &Info{
+		BytesAcked:    int64(ki.BytesAcked),
+		BytesReceived: int64(ki.BytesReceived),
+		MinRTT:        time.Duration(ki.MinRTT) * time.Microsecond,
+		NotsentBytes:  int64(ki.NotsentBytes),
+		RTT:           time.Duration(ki.Rtt) * time.Microsecond,
+		RTTVar:        time.Duration(ki.Rttvar) * time.Microsecond,
+		SndCwnd:       int64(ki.Snd_cwnd),
+		SndMSS:        int64(ki.Snd_mss),
+		TotalRetrans:  int64(ki.Total_retrans),
+	}, nil
+}
+
+// enableTTL sets IPV6_RECVHOPLIMIT, which makes the kernel keep the options
+// of the last packet received in order. The IPv4 stack has no such feature
+// for TCP: its IP_PKTOPTIONS only reports the TTL of the packet completing
+// the handshake of the accepted connections.
+func enableTTL(conn *net.TCPConn) error {
+	if !isIPv6(conn) {
+		return errors.ErrUnsupported
+	}
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rawConn.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVHOPLIMIT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// ttl reads the hop limit from the IPV6_2292PKTOPTIONS control messages.
+func ttl(conn *net.TCPConn) (int, error) {
+	if !isIPv6(conn) {
+		return 0, errors.ErrUnsupported
+	}
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		buf   [64]byte
+		errno syscall.Errno
+	)
+	size := uint32(len(buf))
+	err = rawConn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd,
+			syscall.IPPROTO_IPV6, syscall.IPV6_2292PKTOPTIONS,
+			uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+	msgs, err := syscall.ParseSocketControlMessage(buf[:size])
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == syscall.IPPROTO_IPV6 && msg.Header.Type == syscall.IPV6_HOPLIMIT && len(msg.Data) >= 4 {
+			return int(int32(binary.NativeEndian.Uint32(msg.Data))), nil
+		}
+	}
+	return 0, errors.New("tcpinfo: no hop limit received yet")
+}
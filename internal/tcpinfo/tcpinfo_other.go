@@ -0,0 +1,25 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build !linux
+
+package tcpinfo
+
+import (
+	"errors"
+	"net"
+)
+
+// get fails, since we only know how to read TCP_INFO on Linux.
+func get(conn *net.TCPConn) (*Info, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// enableTTL fails, since we only know how to read the hop limit on Linux.
+func enableTTL(conn *net.TCPConn) error {
+	return errors.ErrUnsupported
+}
+
+// ttl fails, since we only know how to read the hop limit on Linux.
+func ttl(conn *net.TCPConn) (int, error) {
+	return 0, errors.ErrUnsupported
+}
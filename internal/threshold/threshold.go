@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package threshold evaluates results against user-provided thresholds.
+//
+// A specification is a comma-separated list of assertions, each consisting
+// of a metric, a comparison operator, and a value:
+//
+//	download>=80mbit,upload>=15mbit,p95_latency<=120ms
+//
+// The download and upload metrics are the headline throughputs and take
+// rates using the tc units (bit, kbit, mbit, gbit). The pNN_latency metrics
+// are the NN-th percentile of the responsiveness probe RTT and take values
+// parsed using [time.ParseDuration].
+package threshold
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bassosimone/2026-02-provlima/internal/humanize"
+	"github.com/bassosimone/2026-02-provlima/internal/results"
+)
+
+// operators contains the supported operators. The two-character operators
+// come first, so we do not mistake ">=" for ">".
+var operators = []string{">=", "<=", ">", "<"}
+
+// Assertion is a single parsed assertion.
+type Assertion struct {
+	// Spec is the original text of the assertion.
+	Spec string
+
+	// Metric is the metric name (e.g., "download").
+	Metric string
+
+	// Operator is one of ">=", "<=", ">", and "<".
+	Operator string
+
+	// Value is the threshold in bit/s for rates and in
+	// nanoseconds for latencies.
+	Value float64
+
+	// percentile is the percentile for latency metrics.
+	percentile float64
+}
+
+// Parse parses a comma-separated list of assertions.
+func Parse(spec string) ([]Assertion, error) {
+	var out []Assertion
+	for entry := range strings.SplitSeq(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		a, err := parseAssertion(entry)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+func parseAssertion(entry string) (Assertion, error) {
+	a := Assertion{Spec: entry}
+	for _, op := range operators {
+		metric, value, found := strings.Cut(entry, op)
+		if !found {
+			continue
+		}
+		a.Metric, a.Operator = strings.TrimSpace(metric), op
+		value = strings.TrimSpace(value)
+
+		switch {
+		case a.Metric == "download" || a.Metric == "upload":
+			bps, err := humanize.ParseRate(value)
+			if err != nil {
+				return a, fmt.Errorf("%s: %w", entry, err)
+			}
+			a.Value = bps
+
+		case strings.HasPrefix(a.Metric, "p") && strings.HasSuffix(a.Metric, "_latency"):
+			pstr := strings.TrimSuffix(strings.TrimPrefix(a.Metric, "p"), "_latency")
+			percentile, err := strconv.ParseFloat(pstr, 64)
+			if err != nil || percentile <= 0 || percentile > 100 {
+				return a, fmt.Errorf("%s: invalid percentile: %s", entry, pstr)
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return a, fmt.Errorf("%s: %w", entry, err)
+			}
+			a.percentile, a.Value = percentile, float64(d)
+
+		default:
+			return a, fmt.Errorf("%s: unknown metric: %s", entry, a.Metric)
+		}
+		return a, nil
+	}
+	return a, fmt.Errorf("%s: missing operator", entry)
+}
+
+// errNoData indicates that the document lacks the data for a metric.
+var errNoData = errors.New("no data")
+
+// measure returns the value of the assertion metric in doc.
+func (a Assertion) measure(doc *results.Document) (float64, error) {
+	var summary *results.DirectionSummary
+	if doc.Summary != nil {
+		switch a.Metric {
+		case "download":
+			summary = doc.Summary.Download
+		case "upload":
+			summary = doc.Summary.Upload
+		}
+	}
+	if a.percentile > 0 {
+		rtt, ok := results.LatencyPercentile(doc.Probes, a.percentile)
+		if !ok {
+			return 0, errNoData
+		}
+		return float64(rtt), nil
+	}
+	if summary == nil {
+		return 0, errNoData
+	}
+	return summary.Throughput, nil
+}
+
+// format formats a metric value for humans.
+func (a Assertion) format(value float64) string {
+	if a.percentile > 0 {
+		return time.Duration(value).String()
+	}
+	return humanize.SI(value, "bit/s")
+}
+
+// Check returns an error when doc violates the assertion or does not
+// contain the data required to evaluate it.
+func (a Assertion) Check(doc *results.Document) error {
+	value, err := a.measure(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.Spec, err)
+	}
+	var ok bool
+	switch a.Operator {
+	case ">=":
+		ok = value >= a.Value
+	case "<=":
+		ok = value <= a.Value
+	case ">":
+		ok = value > a.Value
+	case "<":
+		ok = value < a.Value
+	}
+	if !ok {
+		return fmt.Errorf("%s: violated: measured %s", a.Spec, a.format(value))
+	}
+	return nil
+}
+
+// CheckAll checks all the assertions and joins the errors.
+func CheckAll(assertions []Assertion, doc *results.Document) error {
+	var errs []error
+	for _, a := range assertions {
+		if err := a.Check(doc); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// Package timestamping reads the kernel timestamps of the data a TCP
+// connection sends and receives (see SO_TIMESTAMPING in the Linux
+// timestamping.rst), which tell when the data left and reached the host,
+// excluding the time the goroutines waited to be scheduled.
+//
+// Since the kernel only timestamps the data after we enable the feature,
+// clients wrap the connections using [Wrap] right after dialing, before
+// the TLS handshake, and read the timestamps of the last request and
+// response exchanged using [Last].
+package timestamping
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Conn is a [net.Conn] recording the kernel timestamps of the last exchange,
+// i.e., when the last byte of the last write left the host and when the
+// first data we read after the write reached the host.
+//
+// Construct using [Wrap].
+type Conn struct {
+	net.Conn
+	raw syscall.RawConn
+
+	mu      sync.Mutex
+	sent    uint32    // bytes written so far, wrapping like the IDs of the TX timestamps
+	lastID  uint32    // ID of the TX timestamp of the last byte of the last write
+	written time.Time // when we started the last write
+	tx      time.Time // kernel TX timestamp of the last write
+	rx      time.Time // kernel RX timestamp of the first data read after the last write
+}
+
+// Wrap enables the kernel timestamps on conn, which must be a [*net.TCPConn],
+// and returns a [*Conn] wrapping it. Since the timestamps are optional, we
+// return conn itself when we cannot enable them, e.g., on systems other than
+// Linux, so it suits the callers expecting a func(net.Conn) net.Conn.
+func Wrap(conn net.Conn) net.Conn {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return conn
+	}
+	if err := enable(raw); err != nil {
+		return conn
+	}
+	return &Conn{Conn: conn, raw: raw}
+}
+
+// Write implements [net.Conn].
+func (c *Conn) Write(data []byte) (int, error) {
+	// The kernel may timestamp the data before Write returns, so we must
+	// know which ID to expect before writing.
+	c.mu.Lock()
+	c.lastID = c.sent + uint32(len(data)) - 1
+	c.written = time.Now()
+	c.tx, c.rx = time.Time{}, time.Time{}
+	c.mu.Unlock()
+
+	count, err := c.Conn.Write(data)
+	c.mu.Lock()
+	c.sent += uint32(count)
+	c.mu.Unlock()
+	return count, err
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// onTX records the TX timestamp stamp having the given ID.
+func (c *Conn) onTX(id uint32, stamp time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id == c.lastID {
+		c.tx = stamp
+	}
+}
+
+// onRX records the RX timestamp stamp of the data we read.
+func (c *Conn) onRX(stamp time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Ignore the data that reached the host before the last write.
+	if c.rx.IsZero() && stamp.After(c.written) {
+		c.rx = stamp
+	}
+}
+
+// netConner is implemented by connections wrapping a [net.Conn], such
+// as [*tls.Conn] and the connections returned by [conncount.Counter.Wrap].
+type netConner interface {
+	NetConn() net.Conn
+}
+
+// Last returns the kernel timestamps of the last exchange on conn, which
+// must be a [*Conn] or a connection wrapping it (e.g., a [*tls.Conn]),
+// i.e., when the request left the host and when the response reached it,
+// or zero times when we do not know them. It fails with
+// [errors.ErrUnsupported] when conn does not wrap a [*Conn].
+func Last(conn net.Conn) (sent, received time.Time, err error) {
+	for {
+		switch c := conn.(type) {
+		case *Conn:
+			// Make sure we processed the TX timestamps queued so far.
+			if err := c.raw.Control(func(fd uintptr) { c.drain(int(fd)) }); err != nil {
+				return time.Time{}, time.Time{}, err
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.tx, c.rx, nil
+		case netConner:
+			conn = c.NetConn()
+		default:
+			return time.Time{}, time.Time{}, errors.ErrUnsupported
+		}
+	}
+}
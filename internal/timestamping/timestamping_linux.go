@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build linux
+
+package timestamping
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// These are the SO_TIMESTAMPING flags and the error queue constants,
+// which the syscall package lacks (see linux/net_tstamp.h and
+// linux/errqueue.h).
+const (
+	sofTimestampingTxSoftware = 1 << 1
+	sofTimestampingRxSoftware = 1 << 3
+	sofTimestampingSoftware   = 1 << 4
+	sofTimestampingOptID      = 1 << 7
+	sofTimestampingOptTSOnly  = 1 << 11
+
+	soEEOriginTimestamping = 4
+	scmTstampSnd           = 0
+)
+
+// enable asks the kernel to timestamp, in software, the data when it
+// leaves the host, queueing the timestamps in the error queue with the
+// offset of the last byte as the ID, and when it reaches the host.
+func enable(raw syscall.RawConn) error {
+	flags := sofTimestampingTxSoftware | sofTimestampingRxSoftware | sofTimestampingSoftware |
+		sofTimestampingOptID | sofTimestampingOptTSOnly
+	var serr error
+	err := raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMPING, flags)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// Read implements [net.Conn] using recvmsg, which returns the RX timestamp
+// in a control message, unlike read.
+func (c *Conn) Read(data []byte) (int, error) {
+	// With an empty buffer, recvmsg would read a byte into a dummy buffer.
+	if len(data) <= 0 {
+		return 0, nil
+	}
+	var (
+		count int
+		oob   [128]byte
+		oobn  int
+		serr  error
+	)
+	err := c.raw.Read(func(fd uintptr) bool {
+		// The TX timestamps wake us up as well, so process them here.
+		c.drain(int(fd))
+		for {
+			count, oobn, _, _, serr = syscall.Recvmsg(int(fd), data, oob[:], 0)
+			if serr != syscall.EINTR {
+				return serr != syscall.EAGAIN
+			}
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	if serr != nil {
+		return 0, os.NewSyscallError("recvmsg", serr)
+	}
+	if count <= 0 {
+		return 0, io.EOF
+	}
+	if stamp, ok := parseStamp(oob[:oobn]); ok {
+		c.onRX(stamp)
+	}
+	return count, nil
+}
+
+// drain processes the TX timestamps queued in the error queue of fd.
+func (c *Conn) drain(fd int) {
+	for {
+		var (
+			payload [64]byte
+			oob     [128]byte
+		)
+		_, oobn, _, _, err := syscall.Recvmsg(fd, payload[:], oob[:], syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case err != nil:
+			return
+		}
+		stamp, found := parseStamp(oob[:oobn])
+		id, ok := parseTXID(oob[:oobn])
+		if found && ok {
+			c.onTX(id, stamp)
+		}
+	}
+}
+
+// parseStamp returns the software timestamp in the SCM_TIMESTAMPING
+// control message contained by oob, if any.
+func parseStamp(oob []byte) (time.Time, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, msg := range msgs {
+		// The message contains three timestamps: software, deprecated,
+		// and hardware, in this order.
+		var ts syscall.Timespec
+		size := int(unsafe.Sizeof(ts))
+		if msg.Header.Level != syscall.SOL_SOCKET || msg.Header.Type != syscall.SO_TIMESTAMPING || len(msg.Data) < size {
+			continue
+		}
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(&ts)), size), msg.Data)
+		if ts.Sec == 0 && ts.Nsec == 0 {
+			continue
+		}
+		return time.Unix(ts.Unix()), true
+	}
+	return time.Time{}, false
+}
+
+// parseTXID returns the ID of the TX timestamp described by the IP_RECVERR
+// or IPV6_RECVERR control message contained by oob, if any.
+func parseTXID(oob []byte) (uint32, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, msg := range msgs {
+		ipv4 := msg.Header.Level == syscall.SOL_IP && msg.Header.Type == syscall.IP_RECVERR
+		ipv6 := msg.Header.Level == syscall.SOL_IPV6 && msg.Header.Type == syscall.IPV6_RECVERR
+		// The data starts with a struct sock_extended_err.
+		if (!ipv4 && !ipv6) || len(msg.Data) < 16 {
+			continue
+		}
+		origin := msg.Data[4]
+		info := binary.NativeEndian.Uint32(msg.Data[8:12])
+		if origin != soEEOriginTimestamping || info != scmTstampSnd {
+			continue
+		}
+		return binary.NativeEndian.Uint32(msg.Data[12:16]), true
+	}
+	return 0, false
+}
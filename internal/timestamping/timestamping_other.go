@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+//go:build !linux
+
+package timestamping
+
+import (
+	"errors"
+	"syscall"
+)
+
+// enable fails, since we only know how to timestamp on Linux.
+func enable(raw syscall.RawConn) error {
+	return errors.ErrUnsupported
+}
+
+// Read implements [net.Conn].
+func (c *Conn) Read(data []byte) (int, error) {
+	return c.Conn.Read(data)
+}
+
+// drain does nothing, since we never enable the timestamps.
+func (c *Conn) drain(fd int) {}
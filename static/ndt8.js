@@ -16,6 +16,7 @@ export class NDT8Client {
   static MAX_CHUNK_SIZE = 256 << 20; // 256 MiB
   static TIME_BUDGET_MS = 10_000;    // 10 seconds per direction
   static PROBE_INTERVAL_MS = 250;    // 250ms between probes
+  static PROTOCOL_VERSION = 1;       // wire protocol version we speak
 
   #baseURL;
   #sessionID = null;
@@ -52,9 +53,15 @@ export class NDT8Client {
 
   async #createSession() {
     const resp = await fetch(`${this.#baseURL}/ndt/v8/session`, { method: 'POST' });
-    if (!resp.ok) throw new Error(`create session: HTTP ${resp.status}`);
-    const { sessionID } = await resp.json();
+    if (!resp.ok) throw await NDT8Client.#responseError('create session', resp);
+    const { sessionID, version } = await resp.json();
     this.#sessionID = sessionID;
+    // Note: we do not send X-NDT8-Version, which would need a preflight for
+    // every request, so we check the version the server tells us instead.
+    if (version !== undefined && version !== NDT8Client.PROTOCOL_VERSION) {
+      await this.#deleteSession();
+      throw new Error(`create session: the server speaks version ${version}, not ${NDT8Client.PROTOCOL_VERSION}`);
+    }
     this.#emit('session:created', { sessionID });
   }
 
@@ -119,7 +126,13 @@ export class NDT8Client {
         }
       };
 
-      xhr.onload = () => {
+      xhr.onload = async () => {
+        if (xhr.status !== 200) {
+          const text = await xhr.response.text();
+          reject(NDT8Client.#problemError(`download chunk ${size}`, xhr.status,
+            xhr.getResponseHeader('Content-Type'), text));
+          return;
+        }
         const elapsed = performance.now() - t0;
         const bytes = xhr.response.size;
         this.#emit('download:chunk', {
@@ -145,6 +158,7 @@ export class NDT8Client {
     const t0 = performance.now();
     const resp = await fetch(url, { method: 'PUT', body: blob });
     const elapsed = performance.now() - t0;
+    if (!resp.ok) throw await NDT8Client.#responseError(`upload chunk ${size}`, resp);
 
     this.#emit('upload:chunk', {
       size,
@@ -172,6 +186,33 @@ export class NDT8Client {
     return new Blob(parts);
   }
 
+  // -- Errors --------------------------------------------------------------
+
+  /** Construct an Error describing a failed fetch response. */
+  static async #responseError(what, resp) {
+    const text = await resp.text();
+    return NDT8Client.#problemError(what, resp.status, resp.headers.get('Content-Type'), text);
+  }
+
+  /**
+   * Construct an Error describing a failed response, using the RFC 9457
+   * problem details in its body, when available, as the `problem` field.
+   */
+  static #problemError(what, status, contentType, text) {
+    if ((contentType || '').startsWith('application/problem+json')) {
+      try {
+        const problem = JSON.parse(text);
+        const detail = problem.detail ? `: ${problem.detail}` : '';
+        const err = new Error(`${what}: ${problem.status ?? status} ${problem.title}${detail}`);
+        err.problem = problem;
+        return err;
+      } catch {
+        // fall through to the generic error
+      }
+    }
+    return new Error(`${what}: HTTP ${status}`);
+  }
+
   // -- Probes --------------------------------------------------------------
 
   async #runProbes(signal) {